@@ -93,7 +93,8 @@ disk_format="${BASH_REMATCH[1]}"
 pushd ${release_dir} > /dev/null
   . .envrc
   # Make sure we've closed the manifest file before writing to it
-  go run src/light-stemcell-builder/main.go \
+  build_flags="-X main.gitSHA=$(git rev-parse HEAD 2>/dev/null || echo unknown) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+  go run -ldflags "${build_flags}" src/light-stemcell-builder/main.go \
     -c $CONFIG_PATH \
     --image ${stemcell_image} \
     --format ${disk_format} \
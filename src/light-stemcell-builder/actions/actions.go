@@ -0,0 +1,107 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"light-stemcell-builder/report"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Detect returns true when the builder is running as a step of a GitHub Actions workflow
+func Detect() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Outputs returns the step outputs describing a publish: its status, the stemcells as JSON, the AMIs of the first
+// stemcell as a JSON map from region to AMI, and the report location when one was written
+func Outputs(r *report.Report, reportPath string) map[string]string {
+	outputs := map[string]string{"status": r.Status}
+
+	stemcells, _ := json.Marshal(r.Stemcells)
+	outputs["stemcells"] = string(stemcells)
+
+	if len(r.Stemcells) > 0 {
+		amis, _ := json.Marshal(r.Stemcells[0].Amis)
+		outputs["amis"] = string(amis)
+	}
+
+	if reportPath != "" {
+		outputs["report"] = reportPath
+	}
+
+	return outputs
+}
+
+// WriteOutputs writes outputs in the name=value format of the file named by GITHUB_OUTPUT, sorted by name
+func WriteOutputs(w io.Writer, outputs map[string]string) error {
+	names := []string{}
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := outputs[name]
+		if strings.Contains(value, "\n") {
+			return fmt.Errorf("output %s must not span several lines", name)
+		}
+
+		_, err := fmt.Fprintf(w, "%s=%s\n", name, value)
+		if err != nil {
+			return fmt.Errorf("writing output %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// WriteSummary writes a Markdown summary of the publish, with a table of AMIs per stemcell, for the file named by
+// GITHUB_STEP_SUMMARY
+func WriteSummary(w io.Writer, r *report.Report) error {
+	fmt.Fprintf(w, "### Light stemcell publish %s\n\n", r.Status)
+
+	for _, stemcell := range r.Stemcells {
+		fmt.Fprintf(w, "#### %s %s\n\n", stemcell.Name, stemcell.Version)
+
+		regions := []string{}
+		for region := range stemcell.Amis {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+
+		if len(regions) > 0 {
+			fmt.Fprintln(w, "| Region | AMI |")
+			fmt.Fprintln(w, "| --- | --- |")
+			for _, region := range regions {
+				fmt.Fprintf(w, "| %s | `%s` |\n", region, stemcell.Amis[region])
+			}
+			fmt.Fprintln(w)
+		}
+
+		for _, failure := range stemcell.Failures {
+			fmt.Fprintf(w, "- :x: %s failed in the `%s` phase: %s\n", failure.Region, failure.Phase, failure.Error)
+		}
+		if len(stemcell.Failures) > 0 {
+			fmt.Fprintln(w)
+		}
+	}
+
+	if r.RetryCommand != "" {
+		_, err := fmt.Fprintf(w, "Retry with:\n\n```\n%s\n```\n", r.RetryCommand)
+		return err
+	}
+	return nil
+}
+
+// AppendFile appends what write writes to the file at path, as GitHub Actions expects for its output files
+func AppendFile(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %s", path, err)
+	}
+	defer f.Close()
+
+	return write(f)
+}
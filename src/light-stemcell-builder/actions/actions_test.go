@@ -0,0 +1,53 @@
+package actions_test
+
+import (
+	"bytes"
+	"light-stemcell-builder/actions"
+	"light-stemcell-builder/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Actions", func() {
+	r := &report.Report{
+		Status: report.FailedStatus,
+		Stemcells: []report.Stemcell{
+			{
+				Name:     "some-stemcell",
+				Version:  "3312",
+				Amis:     map[string]string{"us-west-1": "ami-west", "us-east-1": "ami-east"},
+				Failures: []report.Failure{{Region: "cn-north-1", Phase: "snapshot", Error: "some error"}},
+			},
+		},
+		RetryCommand: "light-stemcell-builder --regions cn-north-1",
+	}
+
+	It("writes the outputs of the step", func() {
+		buf := &bytes.Buffer{}
+		err := actions.WriteOutputs(buf, actions.Outputs(r, "report.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf.String()).To(Equal(
+			`amis={"us-east-1":"ami-east","us-west-1":"ami-west"}` + "\n" +
+				"report=report.json\n" +
+				"status=failed\n" +
+				`stemcells=[{"name":"some-stemcell","version":"3312","image":"","plan":"","amis":{"us-east-1":"ami-east","us-west-1":"ami-west"},"failures":[{"region":"cn-north-1","phase":"snapshot","error":"some error"}]}]` + "\n",
+		))
+	})
+
+	It("writes a Markdown summary of the publish", func() {
+		buf := &bytes.Buffer{}
+		err := actions.WriteSummary(buf, r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf.String()).To(Equal(
+			"### Light stemcell publish failed\n\n" +
+				"#### some-stemcell 3312\n\n" +
+				"| Region | AMI |\n" +
+				"| --- | --- |\n" +
+				"| us-east-1 | `ami-east` |\n" +
+				"| us-west-1 | `ami-west` |\n\n" +
+				"- :x: cn-north-1 failed in the `snapshot` phase: some error\n\n" +
+				"Retry with:\n\n```\nlight-stemcell-builder --regions cn-north-1\n```\n",
+		))
+	})
+})
@@ -0,0 +1,162 @@
+package breaker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"light-stemcell-builder/query"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// error codes with which AWS asks callers to slow down
+var throttlingCodes = []string{"<Code>RequestLimitExceeded</Code>", "<Code>Throttling</Code>", "<Code>ThrottlingException</Code>"}
+
+// Transport sends requests through Base, watching the Describe calls the builder polls with. Once Threshold of
+// them in a row fail against an endpoint, the circuit for that endpoint opens and its Describe calls wait for
+// Cooldown before being sent, so a degraded region is backed off as a whole rather than each waiter retrying it.
+// The circuit closes again as soon as a Describe call succeeds. Transitions are logged to Logger and passed to the
+// function set by Notify.
+type Transport struct {
+	Base      http.RoundTripper
+	Threshold int
+	Cooldown  time.Duration
+	Logger    *log.Logger
+
+	mutex        sync.Mutex
+	circuits     map[string]*circuit
+	onTransition func(Transition)
+}
+
+// Transition is an endpoint becoming degraded, each time its circuit opens, or recovering
+type Transition struct {
+	Endpoint string
+	Degraded bool
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// Enable puts a Transport with threshold and cooldown in front of http.DefaultTransport, logging to logger
+func Enable(logger *log.Logger, threshold int, cooldown time.Duration) *Transport {
+	t := &Transport{Base: http.DefaultTransport, Threshold: threshold, Cooldown: cooldown, Logger: logger}
+	http.DefaultTransport = t
+	return t
+}
+
+// Notify has onTransition called with every later transition, once the Describe call which caused it has returned
+func (t *Transport) Notify(onTransition func(Transition)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.onTransition = onTransition
+}
+
+// RoundTrip sends req through Base, first waiting for the circuit of its endpoint to close when req is a Describe call
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	action, err := query.Action(req)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(action, "Describe") {
+		return t.Base.RoundTrip(req)
+	}
+
+	endpoint := req.URL.Host
+	if wait := t.wait(endpoint); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	failed, checkErr := isFailure(resp, err)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	transition, changed := t.record(endpoint, failed)
+	if changed {
+		t.mutex.Lock()
+		onTransition := t.onTransition
+		t.mutex.Unlock()
+		if onTransition != nil {
+			onTransition(transition)
+		}
+	}
+
+	return resp, err
+}
+
+func (t *Transport) wait(endpoint string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c, found := t.circuits[endpoint]
+	if !found {
+		return 0
+	}
+	return time.Until(c.openUntil)
+}
+
+// record returns the transition of the endpoint, if its circuit opened or closed
+func (t *Transport) record(endpoint string, failed bool) (Transition, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.circuits == nil {
+		t.circuits = map[string]*circuit{}
+	}
+	c, found := t.circuits[endpoint]
+	if !found {
+		c = &circuit{}
+		t.circuits[endpoint] = c
+	}
+
+	if !failed {
+		delete(t.circuits, endpoint)
+		if c.failures >= t.Threshold {
+			t.Logger.Printf("%s recovered, resuming polling", endpoint)
+			return Transition{Endpoint: endpoint}, true
+		}
+		return Transition{}, false
+	}
+
+	c.failures++
+	if c.failures >= t.Threshold && !time.Now().Before(c.openUntil) {
+		c.openUntil = time.Now().Add(t.Cooldown)
+		t.Logger.Printf("%s is degraded after %d consecutive failed Describe calls, backing off for %s", endpoint, c.failures, t.Cooldown)
+		return Transition{Endpoint: endpoint, Degraded: true}, true
+	}
+	return Transition{}, false
+}
+
+// isFailure returns true when the request could not be sent, or failed on the server or by being throttled.
+// The response body is restored so the SDK can still read it.
+func isFailure(resp *http.Response, err error) (bool, error) {
+	if err != nil {
+		return true, nil
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return true, nil
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return false, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, fmt.Errorf("reading response body: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	for _, code := range throttlingCodes {
+		if bytes.Contains(body, []byte(code)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,112 @@
+package breaker_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"light-stemcell-builder/breaker"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeTransport struct {
+	statuses []int
+	requests int
+	bodies   []string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	f.bodies = append(f.bodies, string(body))
+
+	status := http.StatusOK
+	if f.requests < len(f.statuses) {
+		status = f.statuses[f.requests]
+	}
+	f.requests++
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader("<Response/>"))}, nil
+}
+
+func request(action string) *http.Request {
+	req, err := http.NewRequest("POST", "https://ec2.us-east-1.amazonaws.com/", strings.NewReader("Action="+action+"&Version=2016-11-15"))
+	Expect(err).ToNot(HaveOccurred())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	return req
+}
+
+var _ = Describe("Transport", func() {
+	var base *fakeTransport
+	var logs *bytes.Buffer
+	var transport *breaker.Transport
+
+	BeforeEach(func() {
+		base = &fakeTransport{}
+		logs = &bytes.Buffer{}
+		transport = &breaker.Transport{Base: base, Threshold: 2, Cooldown: 100 * time.Millisecond, Logger: log.New(logs, "", 0)}
+	})
+
+	It("backs off an endpoint after repeated failed Describe calls until one succeeds", func() {
+		base.statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+
+		for i := 0; i < 2; i++ {
+			_, err := transport.RoundTrip(request("DescribeImages"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(logs.String()).To(Equal("ec2.us-east-1.amazonaws.com is degraded after 2 consecutive failed Describe calls, backing off for 100ms\n"))
+
+		start := time.Now()
+		resp, err := transport.RoundTrip(request("DescribeImages"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(logs.String()).To(HaveSuffix("ec2.us-east-1.amazonaws.com recovered, resuming polling\n"))
+
+		start = time.Now()
+		_, err = transport.RoundTrip(request("DescribeImages"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+	})
+
+	It("notifies each transition of an endpoint", func() {
+		transitions := []breaker.Transition{}
+		transport.Notify(func(transition breaker.Transition) {
+			transitions = append(transitions, transition)
+		})
+		base.statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+
+		for i := 0; i < 2; i++ {
+			_, err := transport.RoundTrip(request("DescribeImages"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(transitions).To(Equal([]breaker.Transition{{Endpoint: "ec2.us-east-1.amazonaws.com", Degraded: true}}))
+
+		for i := 0; i < 2; i++ {
+			_, err := transport.RoundTrip(request("DescribeImages"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(transitions).To(Equal([]breaker.Transition{
+			{Endpoint: "ec2.us-east-1.amazonaws.com", Degraded: true},
+			{Endpoint: "ec2.us-east-1.amazonaws.com"},
+		}))
+	})
+
+	It("sends the body of the request unchanged", func() {
+		_, err := transport.RoundTrip(request("DescribeImages"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(base.bodies).To(Equal([]string{"Action=DescribeImages&Version=2016-11-15"}))
+	})
+
+	It("does not count failures of calls other than Describe", func() {
+		base.statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+
+		for i := 0; i < 2; i++ {
+			_, err := transport.RoundTrip(request("CopyImage"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(logs.String()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,260 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"light-stemcell-builder/collection"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/report"
+	"log"
+	"sync"
+)
+
+// Progress events
+const (
+	StartedEvent   = "started"
+	PublishedEvent = "published"
+	FailedEvent    = "failed"
+	// PriorityPublishedEvent is sent while a region is still copying, once its source AMI and the copies to its
+	// priority destinations are available
+	PriorityPublishedEvent = "priority_published"
+)
+
+// Progress reports a region publish starting, publishing its AMIs or failing. Amis is set once published, and
+// to the AMIs published so far for PriorityPublishedEvent, and Err for failures.
+type Progress struct {
+	Region string
+	Event  string
+	Amis   *collection.Ami
+	Err    error
+}
+
+// Options controls where a publish logs and how it is paced. The zero value discards logs and publishes every
+// region at once.
+type Options struct {
+	// LogDest receives the logs of the publishers, and DriverLogDest the detailed logs of the AWS drivers
+	LogDest       io.Writer
+	DriverLogDest io.Writer
+	// Limiter, when non-nil, bounds how many region publishes run at once; it may be shared by several publishes
+	Limiter chan struct{}
+	// Progress, when non-nil, receives an event as each region publish starts and finishes. It must be received
+	// from until Publish returns.
+	Progress chan<- Progress
+	// CanaryRegion names an ami_regions entry which is published, along with its destinations, before any other.
+	// The other entries are only published if the canary succeeds and CanaryGate, when non-nil, returns no error
+	// for its AMIs.
+	CanaryRegion string
+	CanaryGate   func(region string, amis *collection.Ami) error
+	// CanaryAmis holds the AMIs of a canary published by an earlier run, which are passed to CanaryGate instead
+	// when the canary entry is not among the regions of c
+	CanaryAmis *collection.Ami
+}
+
+// Result holds the AMIs which were published and a failure for each region which was not
+type Result struct {
+	Amis     *collection.Ami
+	Failures []report.Failure
+}
+
+// Publish publishes a single machine image to every region configured in c, using amiConfig in place of c's AMI
+// configuration. Publishes which are running when ctx is done stop before their next phase and clean up their
+// intermediate resources, since AWS imports and copies cannot be cancelled, while regions which have not started
+// are reported as not started. The returned error summarizes the failures of the result.
+//
+// Publish covers only the region fan-out of a single image: the canary, fallback regions and progress events. The
+// plan digests and --skip-published lookup, promotion, the sweep of leftover resources, the partial report and the
+// report.Report itself are done by the CLI, so an embedder which needs them builds them from the Result.
+func Publish(ctx context.Context, c config.Config, amiConfig config.AmiConfiguration, imageConfig publisher.MachineImageConfig, opts Options) (Result, error) {
+	if opts.CanaryRegion != "" {
+		return publishWithCanary(ctx, c, amiConfig, imageConfig, opts)
+	}
+
+	logDest := opts.LogDest
+	if logDest == nil {
+		logDest = ioutil.Discard
+	}
+	driverLogDest := opts.DriverLogDest
+	if driverLogDest == nil {
+		driverLogDest = ioutil.Discard
+	}
+
+	notify := func(progress Progress) {
+		if opts.Progress != nil {
+			opts.Progress <- progress
+		}
+	}
+
+	amiCollection := collection.Ami{}
+	errCollection := collection.Error{}
+
+	var failuresMutex sync.Mutex
+	failures := []report.Failure{}
+	addFailure := func(region string, err error) {
+		errCollection.Add(fmt.Errorf("Error publishing AMIs to %s: %s", region, err))
+
+		failure := report.Failure{Region: region, Error: err.Error()}
+		if publishErr, ok := err.(*publisher.PublishError); ok {
+			failure.Phase = publishErr.Phase
+			failure.Resources = publishErr.Resources
+		}
+
+		failuresMutex.Lock()
+		failures = append(failures, failure)
+		failuresMutex.Unlock()
+
+		notify(Progress{Region: region, Event: FailedEvent, Err: err})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(c.AmiRegions))
+
+	for i := range c.AmiRegions {
+		go func(regionConfig config.AmiRegion) {
+			defer wg.Done()
+
+			if opts.Limiter != nil {
+				opts.Limiter <- struct{}{}
+				defer func() { <-opts.Limiter }()
+			}
+
+			select {
+			case <-ctx.Done():
+				reason := "not started before the publish was cancelled"
+				if ctx.Err() == context.DeadlineExceeded {
+					reason = "not started before the timeout passed"
+				}
+				addFailure(regionConfig.RegionName, &publisher.PublishError{
+					Phase: publisher.NotStartedPhase,
+					Err:   errors.New(reason),
+				})
+				return
+			default:
+			}
+
+			notify(Progress{Region: regionConfig.RegionName, Event: StartedEvent})
+
+			var amis *collection.Ami
+			var err error
+			switch {
+			case regionConfig.IsolatedRegion:
+				ds := driverset.NewIsolatedRegionDriverSet(driverLogDest, regionConfig.Credentials, c.Retries, c.ManifestFetch)
+				p := publisher.NewIsolatedRegionPublisher(logDest, publisher.Config{
+					AmiRegion:        regionConfig,
+					AmiConfiguration: amiConfig,
+					Namespace:        c.Namespace,
+				})
+
+				amis, err = p.Publish(ctx, ds, imageConfig)
+			default:
+				ds := driverset.NewStandardRegionDriverSet(driverLogDest, regionConfig.Credentials, c.Retries)
+				p := publisher.NewStandardRegionPublisher(logDest, publisher.Config{
+					AmiRegion:        regionConfig,
+					AmiConfiguration: amiConfig,
+					Namespace:        c.Namespace,
+				})
+				priorityReady := func(amis *collection.Ami) {
+					notify(Progress{Region: regionConfig.RegionName, Event: PriorityPublishedEvent, Amis: amis})
+				}
+				p.PriorityReady = priorityReady
+
+				amis, err = p.Publish(ctx, ds, imageConfig)
+				// a publish which stopped because ctx is done is not started again in the fallback region
+				if fallback, ok := regionConfig.Fallback(); ok && importFailed(err) && ctx.Err() == nil {
+					log.New(logDest, "", log.LstdFlags).Printf("importing in %s failed, publishing from fallback region %s: %s", regionConfig.RegionName, fallback.RegionName, err)
+
+					ds = driverset.NewStandardRegionDriverSet(driverLogDest, fallback.Credentials, c.Retries)
+					p = publisher.NewStandardRegionPublisher(logDest, publisher.Config{
+						AmiRegion:        fallback,
+						AmiConfiguration: amiConfig,
+						Namespace:        c.Namespace,
+					})
+					p.PriorityReady = priorityReady
+					amis, err = p.Publish(ctx, ds, imageConfig)
+				}
+			}
+
+			if err != nil {
+				addFailure(regionConfig.RegionName, err)
+				return
+			}
+			amiCollection.Merge(amis)
+			notify(Progress{Region: regionConfig.RegionName, Event: PublishedEvent, Amis: amis})
+		}(c.AmiRegions[i])
+	}
+
+	wg.Wait()
+
+	return Result{Amis: &amiCollection, Failures: failures}, errCollection.Error()
+}
+
+// publishWithCanary publishes the canary entry, then the other entries once the canary has passed its gate
+func publishWithCanary(ctx context.Context, c config.Config, amiConfig config.AmiConfiguration, imageConfig publisher.MachineImageConfig, opts Options) (Result, error) {
+	canaryRegion := opts.CanaryRegion
+	opts.CanaryRegion = ""
+
+	canaryConfig := c
+	canaryConfig.AmiRegions = []config.AmiRegion{}
+	othersConfig := c
+	othersConfig.AmiRegions = []config.AmiRegion{}
+	for _, regionConfig := range c.AmiRegions {
+		if regionConfig.RegionName == canaryRegion {
+			canaryConfig.AmiRegions = append(canaryConfig.AmiRegions, regionConfig)
+		} else {
+			othersConfig.AmiRegions = append(othersConfig.AmiRegions, regionConfig)
+		}
+	}
+
+	canary := Result{Amis: &collection.Ami{}}
+	canaryAmis := opts.CanaryAmis
+	var err error
+	switch {
+	case len(canaryConfig.AmiRegions) > 0:
+		canary, err = Publish(ctx, canaryConfig, amiConfig, imageConfig, opts)
+		canaryAmis = canary.Amis
+	case canaryAmis == nil && len(othersConfig.AmiRegions) == 0:
+		return Publish(ctx, othersConfig, amiConfig, imageConfig, opts)
+	case canaryAmis == nil:
+		// the other entries are held back rather than published without the canary's gate
+		err = fmt.Errorf("canary %s is not being published and has no AMI published by an earlier run to gate on", canaryRegion)
+	}
+
+	if err == nil && opts.CanaryGate != nil {
+		err = opts.CanaryGate(canaryRegion, canaryAmis)
+		if err != nil {
+			err = fmt.Errorf("canary %s did not pass its gate: %s", canaryRegion, err)
+		}
+	}
+
+	if err != nil {
+		errCollection := collection.Error{}
+		errCollection.Add(err)
+		reason := fmt.Errorf("not started because the canary %s failed", canaryRegion)
+		for _, regionConfig := range othersConfig.AmiRegions {
+			errCollection.Add(fmt.Errorf("Error publishing AMIs to %s: %s", regionConfig.RegionName, reason))
+			canary.Failures = append(canary.Failures, report.Failure{
+				Region: regionConfig.RegionName,
+				Phase:  publisher.CanaryPhase,
+				Error:  reason.Error(),
+			})
+			if opts.Progress != nil {
+				opts.Progress <- Progress{Region: regionConfig.RegionName, Event: FailedEvent, Err: reason}
+			}
+		}
+		return canary, errCollection.Error()
+	}
+
+	others, err := Publish(ctx, othersConfig, amiConfig, imageConfig, opts)
+	others.Amis.Merge(canary.Amis)
+	return others, err
+}
+
+// importFailed returns true when err stopped a publish before any AMI was registered
+func importFailed(err error) bool {
+	publishErr, ok := err.(*publisher.PublishError)
+	return ok && (publishErr.Phase == publisher.MachineImagePhase || publishErr.Phase == publisher.SnapshotPhase)
+}
@@ -0,0 +1,120 @@
+package builder_test
+
+import (
+	"context"
+	"errors"
+	"light-stemcell-builder/builder"
+	"light-stemcell-builder/collection"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/report"
+	"light-stemcell-builder/resources"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Publish", func() {
+	c := config.Config{
+		AmiRegions: []config.AmiRegion{
+			{RegionName: "us-east-1"},
+			{RegionName: "cn-north-1", IsolatedRegion: true},
+		},
+	}
+
+	It("reports every region as not started once the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		progress := make(chan builder.Progress, len(c.AmiRegions))
+		result, err := builder.Publish(ctx, c, config.AmiConfiguration{}, publisher.MachineImageConfig{}, builder.Options{Progress: progress})
+		Expect(err).To(HaveOccurred())
+		Expect(result.Amis.GetAll()).To(BeEmpty())
+
+		Expect(result.Failures).To(HaveLen(2))
+		for _, failure := range result.Failures {
+			Expect(failure.Phase).To(Equal(publisher.NotStartedPhase))
+			Expect(failure.Error).To(Equal("not started before the publish was cancelled"))
+		}
+
+		close(progress)
+		regions := []string{}
+		for event := range progress {
+			Expect(event.Event).To(Equal(builder.FailedEvent))
+			regions = append(regions, event.Region)
+		}
+		Expect(regions).To(ConsistOf("us-east-1", "cn-north-1"))
+	})
+
+	It("holds back the other regions when the canary region fails", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		gated := false
+		progress := make(chan builder.Progress, len(c.AmiRegions))
+		result, err := builder.Publish(ctx, c, config.AmiConfiguration{}, publisher.MachineImageConfig{}, builder.Options{
+			Progress:     progress,
+			CanaryRegion: "us-east-1",
+			CanaryGate: func(string, *collection.Ami) error {
+				gated = true
+				return nil
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(gated).To(BeFalse())
+
+		phases := map[string]string{}
+		for _, failure := range result.Failures {
+			phases[failure.Region] = failure.Phase
+		}
+		Expect(phases).To(Equal(map[string]string{
+			"us-east-1":  publisher.NotStartedPhase,
+			"cn-north-1": publisher.CanaryPhase,
+		}))
+		close(progress)
+		Expect(progress).To(HaveLen(2))
+	})
+
+	It("gates the AMIs of a canary published earlier when the canary region is not being published", func() {
+		canaryAmis := &collection.Ami{}
+		canaryAmis.Add(resources.Ami{Region: "us-east-1", ID: "ami-canary"})
+
+		var gatedRegion string
+		var gatedAmis *collection.Ami
+		result, err := builder.Publish(context.Background(), config.Config{AmiRegions: c.AmiRegions[1:]}, config.AmiConfiguration{}, publisher.MachineImageConfig{}, builder.Options{
+			CanaryRegion: "us-east-1",
+			CanaryAmis:   canaryAmis,
+			CanaryGate: func(region string, amis *collection.Ami) error {
+				gatedRegion, gatedAmis = region, amis
+				return errors.New("smoke test failed")
+			},
+		})
+		Expect(err).To(MatchError(ContainSubstring("canary us-east-1 did not pass its gate: smoke test failed")))
+		Expect(gatedRegion).To(Equal("us-east-1"))
+		Expect(gatedAmis == canaryAmis).To(BeTrue())
+		Expect(result.Amis.GetAll()).To(BeEmpty())
+		Expect(result.Failures).To(ConsistOf(report.Failure{
+			Region: "cn-north-1",
+			Phase:  publisher.CanaryPhase,
+			Error:  "not started because the canary us-east-1 failed",
+		}))
+	})
+
+	It("holds back the other regions when the canary region is not being published and has no earlier AMIs", func() {
+		gated := false
+		result, err := builder.Publish(context.Background(), config.Config{AmiRegions: c.AmiRegions[1:]}, config.AmiConfiguration{}, publisher.MachineImageConfig{}, builder.Options{
+			CanaryRegion: "us-east-1",
+			CanaryGate: func(string, *collection.Ami) error {
+				gated = true
+				return nil
+			},
+		})
+		Expect(err).To(MatchError(ContainSubstring("canary us-east-1 is not being published and has no AMI published by an earlier run to gate on")))
+		Expect(gated).To(BeFalse())
+		Expect(result.Failures).To(ConsistOf(report.Failure{
+			Region: "cn-north-1",
+			Phase:  publisher.CanaryPhase,
+			Error:  "not started because the canary us-east-1 failed",
+		}))
+	})
+})
@@ -0,0 +1,219 @@
+package chaos
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config sets the fraction of requests, between 0 and 1, which fail with each kind of injected error
+type Config struct {
+	Throttle    float64
+	ServerError float64
+	Timeout     float64
+	// Seed makes the sequence of failures reproducible. Zero seeds from the clock.
+	Seed int64
+}
+
+// Parse parses a Config from comma-separated name=value pairs, e.g. "throttle=0.1,server-error=0.05,timeout=0.01,seed=42"
+func Parse(spec string) (Config, error) {
+	c := Config{}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return Config{}, fmt.Errorf("chaos setting %q must be name=value", pair)
+		}
+
+		name, value := parts[0], parts[1]
+		if name == "seed" {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return Config{}, fmt.Errorf("parsing chaos seed: %s", err)
+			}
+			c.Seed = seed
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Config{}, fmt.Errorf("chaos rate %s must be a number between 0 and 1", name)
+		}
+
+		switch name {
+		case "throttle":
+			c.Throttle = rate
+		case "server-error":
+			c.ServerError = rate
+		case "timeout":
+			c.Timeout = rate
+		default:
+			return Config{}, fmt.Errorf("unknown chaos setting %s, expected throttle, server-error, timeout or seed", name)
+		}
+	}
+
+	if c.Throttle+c.ServerError+c.Timeout > 1 {
+		return Config{}, fmt.Errorf("chaos rates must not add up to more than 1")
+	}
+	return c, nil
+}
+
+// Transport fails a random fraction of requests before they are sent, passing the others to Base. Throttling and
+// server errors receive a response in the error format of the service, which the SDK retries like the real ones;
+// timeouts fail the request as a network timeout would.
+type Transport struct {
+	Base   http.RoundTripper
+	Config Config
+	Logger *log.Logger
+
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// Enable injects errors into every HTTP client of the process which uses the default transport, including the AWS
+// SDK's, logging each injected error to logger
+func Enable(logger *log.Logger, c Config) {
+	http.DefaultTransport = &Transport{Base: http.DefaultTransport, Config: c, Logger: logger}
+}
+
+// RoundTrip sends req through Base unless it is picked to fail
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	draw := t.draw()
+
+	switch {
+	case draw < t.Config.Throttle:
+		t.logf("injecting throttling into %s %s", req.Method, req.URL.Host)
+		p := protocolOf(req)
+		return fail(req, http.StatusServiceUnavailable, p.throttleCode, p.format), nil
+	case draw < t.Config.Throttle+t.Config.ServerError:
+		t.logf("injecting a server error into %s %s", req.Method, req.URL.Host)
+		p := protocolOf(req)
+		return fail(req, http.StatusInternalServerError, p.serverErrorCode, p.format), nil
+	case draw < t.Config.Throttle+t.Config.ServerError+t.Config.Timeout:
+		t.logf("injecting a timeout into %s %s", req.Method, req.URL.Host)
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, timeoutError{}
+	}
+
+	return t.Base.RoundTrip(req)
+}
+
+func (t *Transport) draw() float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.random == nil {
+		seed := t.Config.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		t.random = rand.New(rand.NewSource(seed))
+	}
+	return t.random.Float64()
+}
+
+func (t *Transport) logf(format string, args ...interface{}) {
+	if t.Logger != nil {
+		t.Logger.Printf("chaos: "+format, args...)
+	}
+}
+
+// timeoutError is returned for injected timeouts, satisfying net.Error as the errors of a real timeout do
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "chaos: injected i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type errorFormat func(code string, message string) []byte
+
+// protocol holds the error format of a family of AWS services and the codes of its throttling and server errors
+type protocol struct {
+	format          errorFormat
+	throttleCode    string
+	serverErrorCode string
+}
+
+var (
+	jsonProtocol  = protocol{jsonError, "ThrottlingException", "InternalFailure"}
+	ec2Protocol   = protocol{ec2Error, "RequestLimitExceeded", "InternalError"}
+	queryProtocol = protocol{queryError, "Throttling", "InternalError"}
+	s3Protocol    = protocol{s3Error, "SlowDown", "InternalError"}
+)
+
+// protocolOf returns the protocol of the service req is sent to
+func protocolOf(req *http.Request) protocol {
+	switch {
+	case req.Header.Get("X-Amz-Target") != "":
+		return jsonProtocol
+	case strings.HasPrefix(req.URL.Host, "ec2."):
+		return ec2Protocol
+	case req.URL.Query().Get("Action") != "" || strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded"):
+		return queryProtocol
+	default:
+		return s3Protocol
+	}
+}
+
+func fail(req *http.Request, statusCode int, code string, format errorFormat) *http.Response {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	body := format(code, fmt.Sprintf("chaos: injected %s", code))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type xmlError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func ec2Error(code string, message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name   `xml:"Response"`
+		Errors  []xmlError `xml:"Errors>Error"`
+	}{Errors: []xmlError{{Code: code, Message: message}}})
+	return b
+}
+
+func queryError(code string, message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ErrorResponse"`
+		Error   xmlError `xml:"Error"`
+	}{Error: xmlError{Code: code, Message: message}})
+	return b
+}
+
+func s3Error(code string, message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		xmlError
+	}{xmlError: xmlError{Code: code, Message: message}})
+	return b
+}
+
+func jsonError(code string, message string) []byte {
+	b, _ := json.Marshal(map[string]string{"__type": code, "message": message})
+	return b
+}
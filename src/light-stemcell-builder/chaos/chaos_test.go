@@ -0,0 +1,101 @@
+package chaos_test
+
+import (
+	"errors"
+	"light-stemcell-builder/chaos"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var errSent = errors.New("sent")
+
+type fakeTransport struct {
+	requests int
+}
+
+func (f *fakeTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	f.requests++
+	return nil, errSent
+}
+
+var _ = Describe("Parse", func() {
+	It("parses the rate of each kind of error and the seed", func() {
+		c, err := chaos.Parse("throttle=0.1, server-error=0.05,timeout=0.01,seed=42")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c).To(Equal(chaos.Config{Throttle: 0.1, ServerError: 0.05, Timeout: 0.01, Seed: 42}))
+	})
+
+	It("rejects unknown settings and rates outside of 0 to 1", func() {
+		_, err := chaos.Parse("latency=0.1")
+		Expect(err).To(MatchError("unknown chaos setting latency, expected throttle, server-error, timeout or seed"))
+
+		_, err = chaos.Parse("throttle=1.5")
+		Expect(err).To(MatchError("chaos rate throttle must be a number between 0 and 1"))
+
+		_, err = chaos.Parse("throttle=0.6,timeout=0.6")
+		Expect(err).To(MatchError("chaos rates must not add up to more than 1"))
+	})
+})
+
+var _ = Describe("Transport", func() {
+	var base *fakeTransport
+
+	BeforeEach(func() {
+		base = &fakeTransport{}
+	})
+
+	sessionWith := func(c chaos.Config) *session.Session {
+		return session.New(aws.NewConfig().
+			WithHTTPClient(&http.Client{Transport: &chaos.Transport{Base: base, Config: c}}).
+			WithCredentials(credentials.NewStaticCredentials("some-key", "some-secret", "")).
+			WithRegion("us-east-1").
+			WithMaxRetries(0))
+	}
+
+	expectCode := func(err error, code string) {
+		Expect(err).To(HaveOccurred())
+		awsErr, ok := err.(awserr.Error)
+		Expect(ok).To(BeTrue(), "expected an AWS error, got %s", err)
+		Expect(awsErr.Code()).To(Equal(code))
+		Expect(base.requests).To(Equal(0))
+	}
+
+	It("throttles requests in the error format of each service", func() {
+		sess := sessionWith(chaos.Config{Throttle: 1})
+
+		_, err := ec2.New(sess).DescribeImages(&ec2.DescribeImagesInput{})
+		expectCode(err, "RequestLimitExceeded")
+
+		_, err = kms.New(sess).DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String("some-key")})
+		expectCode(err, "ThrottlingException")
+
+		_, err = s3.New(sess).HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("some-bucket")})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(awserr.RequestFailure).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("fails requests with server errors and timeouts", func() {
+		_, err := ec2.New(sessionWith(chaos.Config{ServerError: 1})).DescribeImages(&ec2.DescribeImagesInput{})
+		expectCode(err, "InternalError")
+
+		_, err = ec2.New(sessionWith(chaos.Config{Timeout: 1})).DescribeImages(&ec2.DescribeImagesInput{})
+		expectCode(err, "RequestError")
+		Expect(err).To(MatchError(ContainSubstring("injected i/o timeout")))
+	})
+
+	It("passes requests which are not picked to fail to the base transport", func() {
+		_, err := ec2.New(sessionWith(chaos.Config{Seed: 1})).DescribeImages(&ec2.DescribeImagesInput{})
+		Expect(err).To(MatchError(ContainSubstring("sent")))
+		Expect(base.requests).To(Equal(1))
+	})
+})
@@ -0,0 +1,73 @@
+package concourse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"light-stemcell-builder/report"
+	"sort"
+)
+
+// Version identifies a published light stemcell to Concourse by the digest of the plan which published it, so a
+// given stemcell, config and set of regions always map to the same version
+type Version struct {
+	Plan string `json:"plan"`
+}
+
+// MetadataField is a name and value shown alongside a version in the Concourse UI
+type MetadataField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Output is what the out script of a Concourse resource writes to stdout
+type Output struct {
+	Version  Version         `json:"version"`
+	Metadata []MetadataField `json:"metadata"`
+}
+
+// NewOutput describes a published stemcell, with a metadata field for each region's AMI
+func NewOutput(stemcell report.Stemcell) Output {
+	o := Output{
+		Version: Version{Plan: stemcell.Plan},
+		Metadata: []MetadataField{
+			{Name: "name", Value: stemcell.Name},
+			{Name: "version", Value: stemcell.Version},
+		},
+	}
+
+	regions := []string{}
+	for region := range stemcell.Amis {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		o.Metadata = append(o.Metadata, MetadataField{Name: region, Value: stemcell.Amis[region]})
+	}
+
+	return o
+}
+
+// Write writes the output as JSON
+func (o Output) Write(w io.Writer) error {
+	return writeJSON(w, o)
+}
+
+// WriteCheck writes the versions found by the check script of a Concourse resource as JSON
+func WriteCheck(w io.Writer, versions []Version) error {
+	return writeJSON(w, versions)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling Concourse output to JSON: %s", err)
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing Concourse output: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package concourse_test
+
+import (
+	"bytes"
+	"light-stemcell-builder/concourse"
+	"light-stemcell-builder/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concourse", func() {
+	It("writes the version and metadata of a published stemcell", func() {
+		o := concourse.NewOutput(report.Stemcell{
+			Name:    "some-stemcell",
+			Version: "3312",
+			Plan:    "0123abcd",
+			Amis:    map[string]string{"us-west-1": "ami-west", "us-east-1": "ami-east"},
+		})
+
+		buf := &bytes.Buffer{}
+		Expect(o.Write(buf)).To(Succeed())
+		Expect(buf.String()).To(MatchJSON(`{
+			"version": {"plan": "0123abcd"},
+			"metadata": [
+				{"name": "name", "value": "some-stemcell"},
+				{"name": "version", "value": "3312"},
+				{"name": "us-east-1", "value": "ami-east"},
+				{"name": "us-west-1", "value": "ami-west"}
+			]
+		}`))
+	})
+
+	It("writes an empty list when check finds no versions", func() {
+		buf := &bytes.Buffer{}
+		Expect(concourse.WriteCheck(buf, []concourse.Version{})).To(Succeed())
+		Expect(buf.String()).To(Equal("[]\n"))
+	})
+})
@@ -6,6 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"light-stemcell-builder/resources"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/satori/go.uuid"
 )
@@ -15,11 +20,30 @@ const (
 	PrivateVisibility = "private"
 )
 
+// Promotions publish public AMIs as private, making them public once every region has published, or when the
+// promote command is run
+const (
+	AfterPublishPromotion = "after_publish"
+	ManualPromotion       = "manual"
+)
+
+// Timings of the deletion of the volume an isolated region imports, once its snapshot has completed
+const (
+	ImmediateVolumeDeletion = "immediate"
+	DelayedVolumeDeletion   = "delayed"
+	RetainVolumeDeletion    = "retain"
+)
+
 const (
 	HardwareAssistedVirtualization = "hvm"
 	Paravirtualization             = "paravirtual"
 )
 
+// namespaces are used in S3 keys and EC2 tag values, so are limited to characters which are safe in both
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var validEphemeralName = regexp.MustCompile(`^ephemeral[0-9]+$`)
+
 var isolated = map[string]bool{
 	"cn-north-1":    true,
 	"us-gov-west-1": true,
@@ -30,32 +54,218 @@ var isolated = map[string]bool{
 // 2. optional, defaulted
 // 3. optional
 type AmiConfiguration struct {
-	AmiName            string `json:"name"`
-	Description        string `json:"description"`
-	VirtualizationType string `json:"virtualization_type"`
-	Encrypted          bool   `json:"encrypted"`
-	KmsKeyId           string `json:"kms_key_id"`
-	Visibility         string `json:"visibility"`
+	AmiName            string            `json:"name"`
+	Description        string            `json:"description"`
+	VirtualizationType string            `json:"virtualization_type"`
+	Encrypted          bool              `json:"encrypted"`
+	KmsKeyId           string            `json:"kms_key_id"`
+	Visibility         string            `json:"visibility"`
+	Tags               map[string]string `json:"tags"`
+	VerifyCopies       bool              `json:"verify_copies"`
+	RootDeviceNames    map[string]string `json:"root_device_names"`
+	EphemeralDevices   []EphemeralDevice `json:"ephemeral_devices"`
+	DataVolumes        []DataVolume      `json:"data_volumes"`
+	Promotion          string            `json:"promotion"`
+	// VirtualizationTypes publishes an AMI of each type, see Config.ForVirtualizationTypes. It may not be given
+	// along with VirtualizationType, which is set to the first of the types.
+	VirtualizationTypes []string `json:"virtualization_types"`
+	// ParavirtualRegions overrides DefaultParavirtualRegions, the regions paravirtual AMIs are published to
+	ParavirtualRegions []string `json:"paravirtual_regions"`
+	// SnapshotName is the Name tag of the snapshot of every AMI, and may use the placeholders of name
+	SnapshotName string `json:"snapshot_name"`
+	// SnapshotTags are applied to the snapshot of every AMI, along with the lineage tags of the resources package
+	SnapshotTags map[string]string `json:"snapshot_tags"`
+}
+
+// EphemeralDevice maps an instance store volume, such as ephemeral0, to a device name in the AMI
+type EphemeralDevice struct {
+	VirtualName string `json:"virtual_name"`
+	DeviceName  string `json:"device_name"`
+}
+
+// DataVolume declares an empty EBS volume in the AMI, created along with each instance launched from it.
+// VolumeType defaults to gp2, and Iops is required for io1 volumes.
+type DataVolume struct {
+	DeviceName string `json:"device_name"`
+	SizeGB     int64  `json:"size_gb"`
+	VolumeType string `json:"type"`
+	Iops       int64  `json:"iops"`
+	Encrypted  bool   `json:"encrypted"`
 }
 
 type AmiRegion struct {
-	RegionName           string      `json:"name"`
-	Credentials          Credentials `json:"credentials"`
-	BucketName           string      `json:"bucket_name"`
-	ServerSideEncryption string      `json:"server_side_encryption"`
-	Destinations         []string    `json:"destinations"`
-	IsolatedRegion       bool        `json:"-"`
+	RegionName            string         `json:"name"`
+	Credentials           Credentials    `json:"credentials"`
+	BucketName            string         `json:"bucket_name"`
+	ServerSideEncryption  string         `json:"server_side_encryption"`
+	Destinations          []string       `json:"destinations"`
+	CopyHubs              []string       `json:"copy_hubs"`
+	PriorityDestinations  []string       `json:"priority_destinations"`
+	ArchiveSnapshotCopies int            `json:"archive_snapshot_copies"`
+	RegionKmsKeyId        string         `json:"reencrypt_kms_key_id"`
+	ImportRegion          string         `json:"import_region"`
+	FallbackRegion        string         `json:"fallback_region"`
+	FallbackBucketName    string         `json:"fallback_bucket_name"`
+	VolumeDeletion        VolumeDeletion `json:"volume_deletion"`
+	IsolatedRegion        bool           `json:"-"`
+}
+
+// VolumeDeletion chooses when the intermediate volume of an isolated region is deleted. Delayed volumes are kept
+// for Hours for debugging, after which the cleanup-volumes command deletes them.
+type VolumeDeletion struct {
+	Timing string `json:"timing"`
+	Hours  int    `json:"hours"`
 }
 
 type Credentials struct {
 	AccessKey string `json:"access_key"`
 	SecretKey string `json:"secret_key"`
 	Region    string `json:"-"`
+	// DualStack is copied from the config's dual_stack, see package dualstack
+	DualStack bool `json:"-"`
+}
+
+// Stemcell describes a machine image and stemcell.MF to publish as part of a batch
+type Stemcell struct {
+	// TarballPath is a heavy stemcell tarball published instead of an image and manifest
+	TarballPath  string `json:"stemcell"`
+	ImagePath    string `json:"image"`
+	ManifestPath string `json:"manifest"`
+	OutputPath   string `json:"output"`
+	ImageFormat  string `json:"format"`
+	VolumeSizeGB int64  `json:"volume_size"`
+	AmiName      string `json:"ami_name"`
+	// Regions restricts the stemcell to some of the regions of the ami_regions entries, see Config.ForStemcell
+	Regions []string `json:"regions"`
+	// VirtualizationType is the type the entry is published as, set by Config.ForVirtualizationTypes
+	VirtualizationType string `json:"-"`
+	// Identity overrides the identity of the stemcell otherwise taken from its stemcell.MF or file name
+	Identity Identity `json:"identity"`
+	// InputURL is the URL the tarball or image was downloaded from, set by the builder once it has been
+	InputURL string `json:"-"`
+}
+
+// Identity overrides the name, version and operating system of a stemcell, which are otherwise taken from its
+// stemcell.MF, or inferred from the file name of its tarball or image
+type Identity struct {
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	OperatingSystem string `json:"operating_system"`
+}
+
+// DefaultParavirtualRegions are the regions AWS still launches paravirtual AMIs in. Paravirtual AMIs are being
+// sunset: they only run on previous generation instance families, and regions opened since do not support them.
+var DefaultParavirtualRegions = []string{
+	"ap-northeast-1",
+	"ap-southeast-1",
+	"ap-southeast-2",
+	"eu-central-1",
+	"eu-west-1",
+	"sa-east-1",
+	"us-east-1",
+	"us-west-1",
+	"us-west-2",
+}
+
+// MaxVolumeSizeGB is the size of the largest EBS volume, which bounds the volume a machine image is imported as
+const MaxVolumeSizeGB = 16384
+
+// maxVolumeSizeGB is the size of the largest volume of each EBS volume type
+var maxVolumeSizeGB = map[string]int64{"standard": 1024, "gp2": MaxVolumeSizeGB, "io1": MaxVolumeSizeGB, "st1": MaxVolumeSizeGB, "sc1": MaxVolumeSizeGB}
+
+// RetryPolicy overrides how failed AWS requests of a phase are retried. Zero values keep the builder's defaults.
+type RetryPolicy struct {
+	MaxRetries  int `json:"max_retries"`
+	BaseDelayMS int `json:"base_delay_ms"`
+}
+
+// Retries holds the RetryPolicy of each phase of a publish
+type Retries struct {
+	Upload     RetryPolicy `json:"upload"`
+	Import     RetryPolicy `json:"import"`
+	Register   RetryPolicy `json:"register"`
+	Copy       RetryPolicy `json:"copy"`
+	Tag        RetryPolicy `json:"tag"`
+	Permission RetryPolicy `json:"permission"`
+	// ManifestFetch retries the download of the import volume manifest by isolated regions
+	ManifestFetch RetryPolicy `json:"manifest_fetch"`
+}
+
+// ManifestFetch configures the HTTP client isolated regions download their import volume manifest with.
+// Zero values keep the builder's defaults, which use the proxy of the environment and the system's CAs.
+type ManifestFetch struct {
+	TimeoutSeconds int    `json:"timeout_seconds"`
+	ProxyURL       string `json:"proxy_url"`
+	CACertPath     string `json:"ca_cert_path"`
+}
+
+// Source authenticates the download of stemcell tarballs and images whose URL starts with URLPrefix. HTTP(S)
+// downloads use basic auth when Username is set, or BearerToken as an Authorization header. s3:// downloads use
+// Credentials, which may be of another account than the ami_regions, for buckets in Region.
+type Source struct {
+	URLPrefix   string      `json:"url_prefix"`
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	BearerToken string      `json:"bearer_token"`
+	Credentials Credentials `json:"credentials"`
+	Region      string      `json:"region"`
+}
+
+// ArtifactRepository is a generic HTTP artifact repository, such as Artifactory or Nexus, which the light stemcell
+// tarballs, their checksums and the report are uploaded to with PUT requests under URL. Requests use basic auth
+// when Username is set, or BearerToken as an Authorization header, and send any other Headers, e.g. X-JFrog-Art-Api.
+type ArtifactRepository struct {
+	URL         string            `json:"url"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	BearerToken string            `json:"bearer_token"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// Polling configures the circuit breaker which backs off a region after repeated failures of the Describe calls
+// used to wait for imports, snapshots and AMIs. Zero values keep the builder's defaults.
+type Polling struct {
+	FailureThreshold int `json:"failure_threshold"`
+	CooldownSeconds  int `json:"cooldown_seconds"`
+}
+
+// Estimates tunes the copy times and snapshot storage costs printed by a dry run. Zero values keep the builder's defaults.
+type Estimates struct {
+	ThroughputMBPerSecond   float64            `json:"throughput_mb_per_second"`
+	SnapshotPricePerGBMonth float64            `json:"snapshot_price_per_gb_month"`
+	RegionSnapshotPrices    map[string]float64 `json:"region_snapshot_prices"`
 }
 
 type Config struct {
-	AmiConfiguration AmiConfiguration `json:"ami_configuration"`
-	AmiRegions       []AmiRegion      `json:"ami_regions"`
+	AmiConfiguration       AmiConfiguration `json:"ami_configuration"`
+	AmiRegions             []AmiRegion      `json:"ami_regions"`
+	Stemcells              []Stemcell       `json:"stemcells"`
+	MaxConcurrentPublishes int              `json:"max_concurrent_publishes"`
+	ManifestApiVersion     int              `json:"manifest_api_version"`
+	Namespace              string           `json:"namespace"`
+	Retries                Retries          `json:"retries"`
+	Estimates              Estimates        `json:"estimates"`
+	Polling                Polling          `json:"polling"`
+	ManifestFetch          ManifestFetch    `json:"manifest_fetch"`
+	Canary                 Canary           `json:"canary"`
+	// Identity overrides the identity of the stemcell given by flags, stemcells entries have their own
+	Identity Identity `json:"identity"`
+	Sources  []Source `json:"sources"`
+	// ArtifactRepository, when its url is set, receives the outputs of a successful publish
+	ArtifactRepository ArtifactRepository `json:"artifact_repository"`
+	// DualStack reaches AWS over its dual-stack endpoints, for runners which only have IPv6 connectivity
+	DualStack bool `json:"dual_stack"`
+	// RegionAliases are names which may be given instead of a region anywhere in the config, see ResolveRegion
+	RegionAliases map[string]string `json:"region_aliases"`
+	// AdditionalRegions are accepted along with the regions known to the builder, for regions opened since
+	AdditionalRegions []string `json:"additional_regions"`
+}
+
+// Canary publishes to one ami_regions entry first, holding back the others until Command exits successfully.
+// Command is optional; without it the canary only has to publish.
+type Canary struct {
+	Region  string   `json:"region"`
+	Command []string `json:"command"`
 }
 
 func NewFromReader(r io.Reader) (Config, error) {
@@ -78,6 +288,13 @@ func NewFromReader(r io.Reader) (Config, error) {
 		c.AmiConfiguration.AmiName = fmt.Sprintf("BOSH-%s", uuid.NewV4().String())
 	}
 
+	if len(c.AmiConfiguration.VirtualizationTypes) > 0 {
+		if c.AmiConfiguration.VirtualizationType != "" {
+			return Config{}, errors.New("virtualization_type and virtualization_types cannot both be specified")
+		}
+		c.AmiConfiguration.VirtualizationType = c.AmiConfiguration.VirtualizationTypes[0]
+	}
+
 	if c.AmiConfiguration.VirtualizationType == "" {
 		c.AmiConfiguration.VirtualizationType = HardwareAssistedVirtualization
 	}
@@ -86,10 +303,38 @@ func NewFromReader(r io.Reader) (Config, error) {
 		c.AmiConfiguration.Visibility = PublicVisibility
 	}
 
+	c.resolveRegionAliases()
+
 	for i := range c.AmiRegions {
 		region := &c.AmiRegions[i]
-		region.Credentials.Region = region.RegionName
-		region.IsolatedRegion = isolated[region.RegionName]
+		if region.ImportRegion == region.RegionName {
+			region.ImportRegion = ""
+		}
+		if region.ImportRegion != "" {
+			// the import and registration happen in the pinned region, which copies to the named region, while
+			// the entry is still selected and reported by the region it names
+			region.Destinations = append([]string{region.RegionName}, region.Destinations...)
+			region.PriorityDestinations = append([]string{region.RegionName}, region.PriorityDestinations...)
+		}
+		region.Credentials.Region = region.PublishRegion()
+		region.Credentials.DualStack = c.DualStack
+		region.IsolatedRegion = isolated[region.PublishRegion()]
+	}
+
+	for i := range c.Sources {
+		c.Sources[i].Credentials.Region = c.Sources[i].Region
+		c.Sources[i].Credentials.DualStack = c.DualStack
+	}
+
+	for i := range c.Stemcells {
+		stemcell := &c.Stemcells[i]
+		if stemcell.ImageFormat == "" {
+			stemcell.ImageFormat = resources.VolumeRawFormat
+		}
+		if stemcell.AmiName == "" {
+			// AMI names must be unique within a region, so each stemcell in a batch gets its own
+			stemcell.AmiName = fmt.Sprintf("BOSH-%s", uuid.NewV4().String())
+		}
 	}
 
 	err = c.validate()
@@ -113,6 +358,69 @@ func (config *Config) validate() error {
 		return errors.New("virtualization_type must be one of: ['hvm', 'paravirtual']")
 	}
 
+	seenVirtualization := map[string]bool{}
+	for _, virtualizationType := range config.AmiConfiguration.VirtualizationTypes {
+		if !validVirtualization[virtualizationType] {
+			return errors.New("virtualization_types must only contain: ['hvm', 'paravirtual']")
+		}
+		if seenVirtualization[virtualizationType] {
+			return fmt.Errorf("virtualization_types lists %s more than once", virtualizationType)
+		}
+		seenVirtualization[virtualizationType] = true
+	}
+
+	for virtualizationType, deviceName := range config.AmiConfiguration.RootDeviceNames {
+		if !validVirtualization[virtualizationType] {
+			return fmt.Errorf("root_device_names may only be given for virtualization types: ['hvm', 'paravirtual'], not %s", virtualizationType)
+		}
+		if !strings.HasPrefix(deviceName, "/dev/") {
+			return fmt.Errorf("root device name %s for %s must start with /dev/", deviceName, virtualizationType)
+		}
+	}
+
+	mappedDeviceNames := map[string]bool{}
+	for _, device := range config.AmiConfiguration.EphemeralDevices {
+		if !validEphemeralName.MatchString(device.VirtualName) {
+			return fmt.Errorf("virtual_name %s of ephemeral_devices must be ephemeral0, ephemeral1 and so on", device.VirtualName)
+		}
+		if !strings.HasPrefix(device.DeviceName, "/dev/") {
+			return fmt.Errorf("device_name %s of ephemeral_devices must start with /dev/", device.DeviceName)
+		}
+		if mappedDeviceNames[device.DeviceName] {
+			return fmt.Errorf("device_name %s is mapped more than once in ephemeral_devices", device.DeviceName)
+		}
+		mappedDeviceNames[device.DeviceName] = true
+	}
+
+	validVolumeType := map[string]bool{"standard": true, "gp2": true, "io1": true, "st1": true, "sc1": true}
+	for i := range config.AmiConfiguration.DataVolumes {
+		volume := &config.AmiConfiguration.DataVolumes[i]
+		if volume.VolumeType == "" {
+			volume.VolumeType = "gp2"
+		}
+
+		if !strings.HasPrefix(volume.DeviceName, "/dev/") {
+			return fmt.Errorf("device_name %s of data_volumes must start with /dev/", volume.DeviceName)
+		}
+		if mappedDeviceNames[volume.DeviceName] {
+			return fmt.Errorf("device_name %s is mapped more than once in ephemeral_devices and data_volumes", volume.DeviceName)
+		}
+		mappedDeviceNames[volume.DeviceName] = true
+
+		if volume.SizeGB <= 0 {
+			return fmt.Errorf("size_gb must be positive for data volume %s", volume.DeviceName)
+		}
+		if !validVolumeType[volume.VolumeType] {
+			return fmt.Errorf("type of data volume %s must be one of: ['standard', 'gp2', 'io1', 'st1', 'sc1']", volume.DeviceName)
+		}
+		if max := maxVolumeSizeGB[volume.VolumeType]; volume.SizeGB > max {
+			return fmt.Errorf("size_gb of data volume %s must be at most %d for volume type %s", volume.DeviceName, max, volume.VolumeType)
+		}
+		if (volume.VolumeType == "io1") != (volume.Iops > 0) {
+			return fmt.Errorf("iops must be given for data volume %s if and only if its type is io1", volume.DeviceName)
+		}
+	}
+
 	validVisibility := map[string]bool{
 		PublicVisibility:  true,
 		PrivateVisibility: true,
@@ -121,6 +429,16 @@ func (config *Config) validate() error {
 		return errors.New("visibility must be one of: ['public', 'private']")
 	}
 
+	switch config.AmiConfiguration.Promotion {
+	case "":
+	case AfterPublishPromotion, ManualPromotion:
+		if config.AmiConfiguration.Visibility != PublicVisibility {
+			return errors.New("promotion may only be specified for public AMIs")
+		}
+	default:
+		return errors.New("promotion must be one of: ['after_publish', 'manual']")
+	}
+
 	regions := config.AmiRegions
 	if len(regions) == 0 {
 		return errors.New("ami_regions must be specified")
@@ -133,9 +451,166 @@ func (config *Config) validate() error {
 		}
 	}
 
+	if err := config.validateRegionNames(); err != nil {
+		return err
+	}
+
+	if config.Canary.Region != "" {
+		found := false
+		for _, r := range config.AmiRegions {
+			if r.RegionName == config.Canary.Region {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("canary region %s must be the region of one of the ami_regions", config.Canary.Region)
+		}
+	} else if len(config.Canary.Command) > 0 {
+		return errors.New("canary command may only be specified with a canary region")
+	}
+
+	configuredRegions := map[string]bool{}
+	for _, r := range config.AmiRegions {
+		configuredRegions[r.RegionName] = true
+		for _, destination := range r.Destinations {
+			configuredRegions[destination] = true
+		}
+	}
+
+	for i := range config.Stemcells {
+		err := config.Stemcells[i].validate()
+		if err != nil {
+			return err
+		}
+
+		listed := map[string]bool{}
+		for _, region := range config.Stemcells[i].Regions {
+			if !configuredRegions[region] {
+				return fmt.Errorf("%s is listed in the regions of stemcell %s but is not one of the ami_regions or their destinations", region, config.Stemcells[i].input())
+			}
+			listed[region] = true
+		}
+
+		// a stemcell whose regions leave out the canary would never have a canary AMI to gate its regions on
+		if len(listed) > 0 && config.Canary.Region != "" && !listed[config.Canary.Region] {
+			return fmt.Errorf("the regions of stemcell %s must list the canary region %s", config.Stemcells[i].input(), config.Canary.Region)
+		}
+
+		// the copies are made from the AMI of the entry's region, which would otherwise be published unlisted
+		for _, r := range config.AmiRegions {
+			if len(listed) == 0 || listed[r.RegionName] || listed[r.PublishRegion()] {
+				continue
+			}
+			for _, destination := range r.Destinations {
+				if listed[destination] {
+					return fmt.Errorf("%s is listed in the regions of stemcell %s without %s, which its AMI is copied from", destination, config.Stemcells[i].input(), r.RegionName)
+				}
+			}
+		}
+	}
+
+	if config.MaxConcurrentPublishes < 0 {
+		return errors.New("max_concurrent_publishes must not be negative")
+	}
+
+	if config.ManifestApiVersion != 0 && config.ManifestApiVersion != 3 {
+		return errors.New("manifest_api_version must be 3 when specified")
+	}
+
+	err := config.Retries.validate()
+	if err != nil {
+		return err
+	}
+
+	if config.Namespace != "" && !validNamespace.MatchString(config.Namespace) {
+		return errors.New("namespace may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+	}
+
+	if config.Polling.FailureThreshold < 0 || config.Polling.CooldownSeconds < 0 {
+		return errors.New("failure_threshold and cooldown_seconds must not be negative for polling")
+	}
+
+	if config.ManifestFetch.TimeoutSeconds < 0 {
+		return errors.New("timeout_seconds must not be negative for manifest_fetch")
+	}
+
+	if config.ManifestFetch.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ManifestFetch.ProxyURL)
+		if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https") || proxyURL.Host == "" {
+			return fmt.Errorf("proxy_url %s of manifest_fetch must be an http or https URL", config.ManifestFetch.ProxyURL)
+		}
+	}
+
+	for i := range config.Sources {
+		err := config.Sources[i].validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if repository := config.ArtifactRepository; repository.URL != "" {
+		repositoryURL, err := url.Parse(repository.URL)
+		if err != nil || (repositoryURL.Scheme != "http" && repositoryURL.Scheme != "https") || repositoryURL.Host == "" {
+			return fmt.Errorf("url %s of artifact_repository must be an http or https URL", repository.URL)
+		}
+		if repository.Username != "" && repository.BearerToken != "" {
+			return errors.New("username and bearer_token cannot both be specified for artifact_repository")
+		}
+	}
+
+	return config.Estimates.validate()
+}
+
+// SourceFor returns the sources entry with the longest url_prefix matching inputURL, if there is one
+func (c Config) SourceFor(inputURL string) (Source, bool) {
+	found := false
+	source := Source{}
+	for _, s := range c.Sources {
+		if strings.HasPrefix(inputURL, s.URLPrefix) && (!found || len(s.URLPrefix) > len(source.URLPrefix)) {
+			source, found = s, true
+		}
+	}
+	return source, found
+}
+
+func (s *Source) validate() error {
+	switch {
+	case strings.HasPrefix(s.URLPrefix, "s3://"):
+		if s.Credentials.AccessKey == "" || s.Credentials.SecretKey == "" {
+			return fmt.Errorf("credentials must be specified for the s3:// source %s", s.URLPrefix)
+		}
+		if s.Region == "" {
+			return fmt.Errorf("region must be specified for the s3:// source %s", s.URLPrefix)
+		}
+		if s.Username != "" || s.BearerToken != "" {
+			return fmt.Errorf("username and bearer_token may only be specified for http and https sources, not %s", s.URLPrefix)
+		}
+	case strings.HasPrefix(s.URLPrefix, "http://"), strings.HasPrefix(s.URLPrefix, "https://"):
+		if s.Username != "" && s.BearerToken != "" {
+			return fmt.Errorf("username and bearer_token cannot both be specified for the source %s", s.URLPrefix)
+		}
+		if s.Password != "" && s.Username == "" {
+			return fmt.Errorf("password may only be specified with a username for the source %s", s.URLPrefix)
+		}
+		if s.Credentials.AccessKey != "" {
+			return fmt.Errorf("credentials may only be specified for s3:// sources, not %s", s.URLPrefix)
+		}
+	default:
+		return fmt.Errorf("url_prefix %s of sources must start with http://, https:// or s3://", s.URLPrefix)
+	}
+
 	return nil
 }
 
+// PublishRegion returns the region the entry uploads, imports and registers its AMI in: its import_region when one
+// is set, otherwise the region it names
+func (r AmiRegion) PublishRegion() string {
+	if r.ImportRegion != "" {
+		return r.ImportRegion
+	}
+	return r.RegionName
+}
+
 func (r *AmiRegion) validate() error {
 	if r.RegionName == "" {
 		return errors.New("name must be specified for ami_regions entries")
@@ -162,13 +637,373 @@ func (r *AmiRegion) validate() error {
 			return fmt.Errorf("%s is an isolated region and cannot be specified as a copy destination", destinationRegion)
 		}
 
-		if r.RegionName == destinationRegion {
+		if r.PublishRegion() == destinationRegion {
 			return fmt.Errorf("%s specified as both a source and a copy destination", destinationRegion)
 		}
 	}
 
-	if isolated[r.RegionName] && len(r.Destinations) != 0 {
-		return fmt.Errorf("%s is an isolated region and cannot specify copy destinations", r.RegionName)
+	if isolated[r.PublishRegion()] && len(r.Destinations) != 0 {
+		return fmt.Errorf("%s is an isolated region and cannot specify copy destinations", r.PublishRegion())
+	}
+
+	for _, hub := range r.CopyHubs {
+		isDestination := false
+		for _, destinationRegion := range r.Destinations {
+			if hub == destinationRegion {
+				isDestination = true
+			}
+		}
+
+		if !isDestination {
+			return fmt.Errorf("%s is specified as a copy hub but is not one of the copy destinations", hub)
+		}
+	}
+
+	for _, priority := range r.PriorityDestinations {
+		isDestination := false
+		for _, destinationRegion := range r.Destinations {
+			if priority == destinationRegion {
+				isDestination = true
+			}
+		}
+
+		if !isDestination {
+			return fmt.Errorf("%s is specified as a priority destination but is not one of the copy destinations", priority)
+		}
+	}
+
+	if r.ArchiveSnapshotCopies < 0 {
+		return errors.New("archive_snapshot_copies must not be negative for ami_regions entries")
+	}
+
+	switch r.VolumeDeletion.Timing {
+	case "":
+		r.VolumeDeletion.Timing = ImmediateVolumeDeletion
+	case ImmediateVolumeDeletion, DelayedVolumeDeletion, RetainVolumeDeletion:
+	default:
+		return fmt.Errorf("timing of the volume_deletion of %s must be one of: ['immediate', 'delayed', 'retain']", r.RegionName)
+	}
+
+	if (r.VolumeDeletion.Timing == DelayedVolumeDeletion) != (r.VolumeDeletion.Hours > 0) {
+		return fmt.Errorf("hours of the volume_deletion of %s must be positive if and only if its timing is delayed", r.RegionName)
+	}
+
+	if (r.FallbackRegion == "") != (r.FallbackBucketName == "") {
+		return fmt.Errorf("fallback_region and fallback_bucket_name must be specified together for %s", r.RegionName)
+	}
+
+	if r.FallbackRegion != "" {
+		if isolated[r.PublishRegion()] || isolated[r.FallbackRegion] {
+			return fmt.Errorf("fallback_region %s of %s cannot involve an isolated region", r.FallbackRegion, r.RegionName)
+		}
+
+		if r.FallbackRegion == r.PublishRegion() {
+			return fmt.Errorf("%s specified as its own fallback_region", r.RegionName)
+		}
+	}
+
+	return nil
+}
+
+// ForVirtualizationTypes returns an entry of stemcells for each virtualization type to publish, grouped by stemcell.
+// The entries of the first type keep their AMI name and output path, while those of every other type have the type
+// appended to them, so that the AMIs and manifests of each type don't collide.
+func (c Config) ForVirtualizationTypes(stemcells []Stemcell) []Stemcell {
+	virtualizationTypes := c.AmiConfiguration.VirtualizationTypes
+	if len(virtualizationTypes) == 0 {
+		virtualizationTypes = []string{c.AmiConfiguration.VirtualizationType}
+	}
+
+	entries := []Stemcell{}
+	for _, stemcell := range stemcells {
+		for i, virtualizationType := range virtualizationTypes {
+			entry := stemcell
+			entry.VirtualizationType = virtualizationType
+			if i > 0 {
+				entry.AmiName = fmt.Sprintf("%s-%s", stemcell.AmiName, virtualizationType)
+				if stemcell.OutputPath != "" {
+					ext := filepath.Ext(stemcell.OutputPath)
+					entry.OutputPath = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(stemcell.OutputPath, ext), virtualizationType, ext)
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// SupportsParavirtual returns true when paravirtual AMIs are published to region
+func (c Config) SupportsParavirtual(region string) bool {
+	regions := c.AmiConfiguration.ParavirtualRegions
+	if len(regions) == 0 {
+		regions = DefaultParavirtualRegions
+	}
+
+	for _, supported := range regions {
+		if supported == region {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedParavirtualRegions returns the regions of the ami_regions entries, and their destinations, which
+// paravirtual AMIs are not published to
+func (c Config) UnsupportedParavirtualRegions() []string {
+	seen := map[string]bool{}
+	unsupported := []string{}
+	for _, r := range c.AmiRegions {
+		for _, region := range append([]string{r.PublishRegion()}, r.Destinations...) {
+			if !seen[region] && !c.SupportsParavirtual(region) {
+				unsupported = append(unsupported, region)
+			}
+			seen[region] = true
+		}
+	}
+	return unsupported
+}
+
+// SelectRegions returns the configured regions with the given names or aliases, in the order they were configured
+func (c Config) SelectRegions(names []string) ([]AmiRegion, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[c.ResolveRegion(strings.TrimSpace(name))] = true
+	}
+
+	regions := []AmiRegion{}
+	for _, regionConfig := range c.AmiRegions {
+		if selected[regionConfig.RegionName] {
+			regions = append(regions, regionConfig)
+			delete(selected, regionConfig.RegionName)
+		}
+	}
+
+	for name := range selected {
+		return nil, fmt.Errorf("--regions includes %s which is not one of the configured ami_regions", name)
+	}
+
+	return regions, nil
+}
+
+// EntryFor returns the ami_regions entry which publishes an AMI in region, either in the region it publishes in, as
+// one of its destinations or as its fallback region
+func (c Config) EntryFor(region string) (AmiRegion, bool) {
+	for _, regionConfig := range c.AmiRegions {
+		if regionConfig.PublishRegion() == region {
+			return regionConfig, true
+		}
+	}
+
+	for _, regionConfig := range c.AmiRegions {
+		for _, destination := range regionConfig.Destinations {
+			if destination == region {
+				return regionConfig, true
+			}
+		}
+	}
+
+	for _, regionConfig := range c.AmiRegions {
+		if regionConfig.FallbackRegion == region {
+			return regionConfig, true
+		}
+	}
+
+	return AmiRegion{}, false
+}
+
+// ForStemcell returns the config to publish stemcell with, as its VirtualizationType when it has one. Paravirtual
+// entries leave out the regions which don't support paravirtual AMIs, including the ami_regions entries of such
+// regions. When the stemcell lists regions, only the ami_regions entries with at least one of them in their region
+// or destinations are kept, and only the listed destinations of those entries. Validation requires the region of an
+// entry to be listed along with any of its destinations, since it holds the AMI the copies are made from.
+func (c Config) ForStemcell(stemcell Stemcell) Config {
+	if stemcell.VirtualizationType != "" {
+		c.AmiConfiguration.VirtualizationType = stemcell.VirtualizationType
+	}
+	if c.AmiConfiguration.VirtualizationType == Paravirtualization {
+		c.AmiRegions = c.paravirtualRegions()
+	}
+
+	if len(stemcell.Regions) == 0 {
+		return c
+	}
+
+	listed := map[string]bool{}
+	for _, region := range stemcell.Regions {
+		listed[region] = true
+	}
+	only := func(regions []string) []string {
+		result := []string{}
+		for _, region := range regions {
+			if listed[region] {
+				result = append(result, region)
+			}
+		}
+		return result
+	}
+
+	amiRegions := []AmiRegion{}
+	for _, r := range c.AmiRegions {
+		destinations := only(r.Destinations)
+		if !listed[r.PublishRegion()] && len(destinations) == 0 {
+			continue
+		}
+
+		r.Destinations = destinations
+		r.CopyHubs = only(r.CopyHubs)
+		r.PriorityDestinations = only(r.PriorityDestinations)
+		amiRegions = append(amiRegions, r)
+	}
+
+	c.AmiRegions = amiRegions
+	return c
+}
+
+// paravirtualRegions returns the ami_regions entries with only the regions which support paravirtual AMIs
+func (c Config) paravirtualRegions() []AmiRegion {
+	supported := func(regions []string) []string {
+		result := []string{}
+		for _, region := range regions {
+			if c.SupportsParavirtual(region) {
+				result = append(result, region)
+			}
+		}
+		return result
+	}
+
+	amiRegions := []AmiRegion{}
+	for _, r := range c.AmiRegions {
+		if !c.SupportsParavirtual(r.PublishRegion()) {
+			continue
+		}
+
+		r.Destinations = supported(r.Destinations)
+		r.CopyHubs = supported(r.CopyHubs)
+		r.PriorityDestinations = supported(r.PriorityDestinations)
+		if r.FallbackRegion != "" && !c.SupportsParavirtual(r.FallbackRegion) {
+			r.FallbackRegion = ""
+			r.FallbackBucketName = ""
+		}
+		amiRegions = append(amiRegions, r)
+	}
+	return amiRegions
+}
+
+// Fallback returns the entry to publish with when the import in r's region fails: the upload and import happen in
+// the fallback region, which then copies to the region r publishes in ahead of its other destinations
+func (r AmiRegion) Fallback() (AmiRegion, bool) {
+	if r.FallbackRegion == "" {
+		return AmiRegion{}, false
+	}
+
+	fallback := r
+	fallback.RegionName = r.FallbackRegion
+	fallback.BucketName = r.FallbackBucketName
+	fallback.ImportRegion = ""
+	fallback.Credentials.Region = r.FallbackRegion
+	fallback.FallbackRegion = ""
+	fallback.FallbackBucketName = ""
+
+	fallback.Destinations = []string{r.PublishRegion()}
+	for _, destination := range r.Destinations {
+		if destination != r.FallbackRegion {
+			fallback.Destinations = append(fallback.Destinations, destination)
+		}
+	}
+
+	fallback.CopyHubs = []string{}
+	for _, hub := range r.CopyHubs {
+		if hub != r.FallbackRegion {
+			fallback.CopyHubs = append(fallback.CopyHubs, hub)
+		}
+	}
+
+	fallback.PriorityDestinations = []string{}
+	for _, priority := range r.PriorityDestinations {
+		if priority != r.FallbackRegion {
+			fallback.PriorityDestinations = append(fallback.PriorityDestinations, priority)
+		}
+	}
+
+	return fallback, true
+}
+
+func (r *Retries) validate() error {
+	policies := []struct {
+		phase  string
+		policy RetryPolicy
+	}{
+		{"upload", r.Upload},
+		{"import", r.Import},
+		{"register", r.Register},
+		{"copy", r.Copy},
+		{"tag", r.Tag},
+		{"permission", r.Permission},
+		{"manifest_fetch", r.ManifestFetch},
+	}
+
+	for _, p := range policies {
+		if p.policy.MaxRetries < 0 {
+			return fmt.Errorf("max_retries must not be negative for retries.%s", p.phase)
+		}
+
+		if p.policy.BaseDelayMS < 0 {
+			return fmt.Errorf("base_delay_ms must not be negative for retries.%s", p.phase)
+		}
+	}
+
+	return nil
+}
+
+func (e *Estimates) validate() error {
+	if e.ThroughputMBPerSecond < 0 {
+		return errors.New("throughput_mb_per_second must not be negative for estimates")
+	}
+
+	if e.SnapshotPricePerGBMonth < 0 {
+		return errors.New("snapshot_price_per_gb_month must not be negative for estimates")
+	}
+
+	for region, price := range e.RegionSnapshotPrices {
+		if price < 0 {
+			return fmt.Errorf("the price for %s must not be negative for estimates.region_snapshot_prices", region)
+		}
+	}
+
+	return nil
+}
+
+// input names the stemcell in errors by the tarball or image it is given as
+func (s Stemcell) input() string {
+	if s.TarballPath != "" {
+		return s.TarballPath
+	}
+	return s.ImagePath
+}
+
+func (s *Stemcell) validate() error {
+	if s.TarballPath != "" && (s.ImagePath != "" || s.ManifestPath != "") {
+		return errors.New("stemcell cannot be specified with image or manifest for stemcells entries")
+	}
+
+	if s.ImagePath == "" && s.TarballPath == "" {
+		return errors.New("image must be specified for stemcells entries")
+	}
+
+	if s.ManifestPath == "" && s.TarballPath == "" {
+		return errors.New("manifest must be specified for stemcells entries")
+	}
+
+	if s.OutputPath == "" {
+		return errors.New("output must be specified for stemcells entries")
+	}
+
+	if s.VolumeSizeGB == 0 && s.ImageFormat != resources.VolumeRawFormat {
+		return errors.New("volume_size must be specified for stemcells entries with formats other than RAW")
+	}
+
+	if s.VolumeSizeGB < 0 || s.VolumeSizeGB > MaxVolumeSizeGB {
+		return fmt.Errorf("volume_size of stemcells entries must be between 1 and %d", MaxVolumeSizeGB)
 	}
 
 	return nil
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/report"
+	"light-stemcell-builder/resources"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -37,7 +40,7 @@ var _ = Describe("Config", func() {
       },
       "ami_regions": [
         {
-          "name": "ami-region",
+          "name": "us-east-1",
           "bucket_name": "ami-bucket",
           "credentials": {
             "access_key": "access-key",
@@ -80,6 +83,74 @@ var _ = Describe("Config", func() {
 				Expect(err).To(MatchError("virtualization_type must be one of: ['hvm', 'paravirtual']"))
 			})
 
+			It("returns an error when 'root_device_names' has an invalid virtualization type or device name", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.RootDeviceNames = map[string]string{"bogus": "/dev/sda1"}
+				})
+				Expect(err).To(MatchError("root_device_names may only be given for virtualization types: ['hvm', 'paravirtual'], not bogus"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.RootDeviceNames = map[string]string{"hvm": "sda1"}
+				})
+				Expect(err).To(MatchError("root device name sda1 for hvm must start with /dev/"))
+			})
+
+			It("returns an error when 'ephemeral_devices' has an invalid or duplicate mapping", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.EphemeralDevices = []config.EphemeralDevice{{VirtualName: "instance0", DeviceName: "/dev/sdb"}}
+				})
+				Expect(err).To(MatchError("virtual_name instance0 of ephemeral_devices must be ephemeral0, ephemeral1 and so on"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.EphemeralDevices = []config.EphemeralDevice{{VirtualName: "ephemeral0", DeviceName: "sdb"}}
+				})
+				Expect(err).To(MatchError("device_name sdb of ephemeral_devices must start with /dev/"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.EphemeralDevices = []config.EphemeralDevice{
+						{VirtualName: "ephemeral0", DeviceName: "/dev/sdb"},
+						{VirtualName: "ephemeral1", DeviceName: "/dev/sdb"},
+					}
+				})
+				Expect(err).To(MatchError("device_name /dev/sdb is mapped more than once in ephemeral_devices"))
+			})
+
+			It("defaults the type of 'data_volumes' to gp2", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf", SizeGB: 100}}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiConfiguration.DataVolumes[0].VolumeType).To(Equal("gp2"))
+			})
+
+			It("returns an error when 'data_volumes' has an invalid volume", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.EphemeralDevices = []config.EphemeralDevice{{VirtualName: "ephemeral0", DeviceName: "/dev/sdf"}}
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf", SizeGB: 100}}
+				})
+				Expect(err).To(MatchError("device_name /dev/sdf is mapped more than once in ephemeral_devices and data_volumes"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf"}}
+				})
+				Expect(err).To(MatchError("size_gb must be positive for data volume /dev/sdf"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf", SizeGB: 100, VolumeType: "gp3"}}
+				})
+				Expect(err).To(MatchError("type of data volume /dev/sdf must be one of: ['standard', 'gp2', 'io1', 'st1', 'sc1']"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf", SizeGB: 2048, VolumeType: "standard"}}
+				})
+				Expect(err).To(MatchError("size_gb of data volume /dev/sdf must be at most 1024 for volume type standard"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.DataVolumes = []config.DataVolume{{DeviceName: "/dev/sdf", SizeGB: 100, VolumeType: "io1"}}
+				})
+				Expect(err).To(MatchError("iops must be given for data volume /dev/sdf if and only if its type is io1"))
+			})
+
 			It("returns an error when 'visibility' is not valid", func() {
 				_, err := parseConfig(baseJSON, func(c *config.Config) {
 					c.AmiConfiguration.Visibility = "bogus"
@@ -87,6 +158,43 @@ var _ = Describe("Config", func() {
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(MatchError("visibility must be one of: ['public', 'private']"))
 			})
+
+			It("returns an error when 'promotion' is not valid or the AMIs are private", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.Promotion = "bogus"
+				})
+				Expect(err).To(MatchError("promotion must be one of: ['after_publish', 'manual']"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiConfiguration.Visibility = config.PrivateVisibility
+					c.AmiConfiguration.Promotion = config.ManualPromotion
+				})
+				Expect(err).To(MatchError("promotion may only be specified for public AMIs"))
+			})
+		})
+
+		Context("with a 'canary' specified", func() {
+			It("accepts the region of an ami_regions entry", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Canary = config.Canary{Region: "us-east-1", Command: []string{"smoke-test"}}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.Canary.Region).To(Equal("us-east-1"))
+			})
+
+			It("returns an error when the region is not one of the ami_regions", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Canary.Region = "other-region"
+				})
+				Expect(err).To(MatchError("canary region other-region must be the region of one of the ami_regions"))
+			})
+
+			It("returns an error when a command is given without a region", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Canary.Command = []string{"smoke-test"}
+				})
+				Expect(err).To(MatchError("canary command may only be specified with a canary region"))
+			})
 		})
 
 		Context("with an empty 'regions' specified", func() {
@@ -118,6 +226,61 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("given region names", func() {
+			It("returns an error suggesting the closest known region for a typo", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].Destinations = []string{"eu-west-1", "us-west2"}
+				})
+				Expect(err).To(MatchError("unknown region us-west2 in the destinations of us-east-1, did you mean us-west-2?"))
+			})
+
+			It("returns an error for unknown regions which are not close to a known one", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "moon-base-1"
+				})
+				Expect(err).To(MatchError("unknown region moon-base-1 in ami_regions, add it to additional_regions if it has opened since this builder was released"))
+			})
+
+			It("accepts additional_regions which are not known yet", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].Destinations = []string{"xx-east-9"}
+					c.AdditionalRegions = []string{"xx-east-9"}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiRegions[0].Destinations).To(Equal([]string{"xx-east-9"}))
+			})
+
+			It("replaces default and configured aliases with their regions", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "ireland"
+					c.AmiRegions[0].Destinations = []string{"frankfurt", "primary"}
+					c.AmiRegions[0].CopyHubs = []string{"frankfurt"}
+					c.RegionAliases = map[string]string{"primary": "us-east-1"}
+					c.Canary = config.Canary{Region: "ireland"}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiRegions[0].RegionName).To(Equal("eu-west-1"))
+				Expect(c.AmiRegions[0].Credentials.Region).To(Equal("eu-west-1"))
+				Expect(c.AmiRegions[0].Destinations).To(Equal([]string{"eu-central-1", "us-east-1"}))
+				Expect(c.AmiRegions[0].CopyHubs).To(Equal([]string{"eu-central-1"}))
+				Expect(c.Canary.Region).To(Equal("eu-west-1"))
+			})
+
+			It("returns an error for region_aliases which shadow a region", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.RegionAliases = map[string]string{"us-west-1": "us-west-2"}
+				})
+				Expect(err).To(MatchError("region_aliases us-west-1 is the name of a region and cannot be an alias"))
+			})
+
+			It("returns an error for copies to another partition", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].Destinations = []string{"cn-northwest-1"}
+				})
+				Expect(err).To(MatchError("us-east-1 cannot copy to cn-northwest-1, which is in the aws-cn partition rather than aws"))
+			})
+		})
+
 		Context("given a 'region' config with invalid 'credentials'", func() {
 			It("returns an error", func() {
 				_, err := parseConfig(baseJSON, func(c *config.Config) {
@@ -141,6 +304,411 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("given 'stemcells' to publish as a batch", func() {
+			var stemcell config.Stemcell
+
+			BeforeEach(func() {
+				stemcell = config.Stemcell{
+					ImagePath:    "root.img",
+					ManifestPath: "stemcell.MF",
+					OutputPath:   "light-stemcell.MF",
+				}
+			})
+
+			It("defaults the format to RAW", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.Stemcells[0].ImageFormat).To(Equal(resources.VolumeRawFormat))
+			})
+
+			It("defaults a distinct ami name for each stemcell", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{stemcell, stemcell}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.Stemcells[0].AmiName).To(MatchRegexp("BOSH-.+"))
+				Expect(c.Stemcells[1].AmiName).To(MatchRegexp("BOSH-.+"))
+				Expect(c.Stemcells[0].AmiName).ToNot(Equal(c.Stemcells[1].AmiName))
+			})
+
+			It("returns an error when 'image' is missing", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.ImagePath = ""
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("image must be specified for stemcells entries"))
+			})
+
+			It("returns an error when 'manifest' is missing", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.ManifestPath = ""
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("manifest must be specified for stemcells entries"))
+			})
+
+			It("accepts a heavy stemcell tarball in place of 'image' and 'manifest'", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{{TarballPath: "bosh-stemcell.tgz", OutputPath: "light-stemcell.MF"}}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.Stemcells[0].TarballPath).To(Equal("bosh-stemcell.tgz"))
+			})
+
+			It("returns an error when 'stemcell' is given with 'image' or 'manifest'", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.TarballPath = "bosh-stemcell.tgz"
+					stemcell.ImagePath = ""
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("stemcell cannot be specified with image or manifest for stemcells entries"))
+			})
+
+			It("returns an error when 'output' is missing", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.OutputPath = ""
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("output must be specified for stemcells entries"))
+			})
+
+			It("returns an error when 'volume_size' is missing for a non-RAW format", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.ImageFormat = resources.VolumeVMDKFormat
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("volume_size must be specified for stemcells entries with formats other than RAW"))
+			})
+
+			It("accepts a 'volume_size' above 1 TiB up to the largest EBS volume", func() {
+				stemcell.VolumeSizeGB = 2048
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.Stemcells[0].VolumeSizeGB).To(Equal(int64(2048)))
+
+				stemcell.VolumeSizeGB = 16385
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("volume_size of stemcells entries must be between 1 and 16384"))
+			})
+
+			It("returns an error when 'regions' lists a region which is not configured", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					stemcell.Regions = []string{"ap-south-1"}
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("ap-south-1 is listed in the regions of stemcell root.img but is not one of the ami_regions or their destinations"))
+			})
+
+			It("returns an error when 'regions' leaves out the canary region", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions = append(c.AmiRegions, c.AmiRegions[0])
+					c.AmiRegions[1].RegionName = "eu-west-1"
+					c.Canary.Region = "us-east-1"
+					stemcell.Regions = []string{"eu-west-1"}
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("the regions of stemcell root.img must list the canary region us-east-1"))
+			})
+
+			It("names stemcells given as a heavy stemcell tarball by the tarball in errors", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Stemcells = []config.Stemcell{{TarballPath: "bosh-stemcell.tgz", OutputPath: "stemcell.MF", ImageFormat: resources.VolumeRawFormat, Regions: []string{"ap-south-1"}}}
+				})
+				Expect(err).To(MatchError("ap-south-1 is listed in the regions of stemcell bosh-stemcell.tgz but is not one of the ami_regions or their destinations"))
+			})
+
+			It("returns an error when 'regions' lists a destination without the region it is copied from", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].Destinations = []string{"us-west-1", "eu-west-1"}
+					stemcell.Regions = []string{"eu-west-1"}
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).To(MatchError("eu-west-1 is listed in the regions of stemcell root.img without us-east-1, which its AMI is copied from"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].Destinations = []string{"us-west-1", "eu-west-1"}
+					stemcell.Regions = []string{"us-east-1", "eu-west-1"}
+					c.Stemcells = []config.Stemcell{stemcell}
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("returns an error when 'max_concurrent_publishes' is negative", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.MaxConcurrentPublishes = -1
+				})
+				Expect(err).To(MatchError("max_concurrent_publishes must not be negative"))
+			})
+
+			It("returns an error when 'manifest_api_version' is not supported", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.ManifestApiVersion = 2
+				})
+				Expect(err).To(MatchError("manifest_api_version must be 3 when specified"))
+			})
+
+			It("returns an error when 'namespace' contains characters unsafe for S3 keys or tags", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Namespace = "pipeline/1"
+				})
+				Expect(err).To(MatchError("namespace may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit"))
+			})
+
+			It("returns an error when a retry policy is negative", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.Retries.Copy.BaseDelayMS = -1
+				})
+				Expect(err).To(MatchError("base_delay_ms must not be negative for retries.copy"))
+			})
+
+			It("returns an error when a copy hub is not a copy destination", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].CopyHubs = []string{"eu-west-1"}
+				})
+				Expect(err).To(MatchError("eu-west-1 is specified as a copy hub but is not one of the copy destinations"))
+			})
+
+			It("returns an error when a priority destination is not one of the 'destinations'", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].PriorityDestinations = []string{"eu-west-1"}
+				})
+				Expect(err).To(MatchError("eu-west-1 is specified as a priority destination but is not one of the copy destinations"))
+			})
+
+			It("returns an error when 'archive_snapshot_copies' is negative", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].ArchiveSnapshotCopies = -1
+				})
+				Expect(err).To(MatchError("archive_snapshot_copies must not be negative for ami_regions entries"))
+			})
+		})
+
+		Context("when an 'import_region' is specified", func() {
+			It("imports in that region and copies to the named region before the other destinations", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "eu-west-1"
+					c.AmiRegions[0].ImportRegion = "us-east-1"
+					c.AmiRegions[0].Destinations = []string{"us-west-1"}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiRegions[0].RegionName).To(Equal("eu-west-1"))
+				Expect(c.AmiRegions[0].PublishRegion()).To(Equal("us-east-1"))
+				Expect(c.AmiRegions[0].Credentials.Region).To(Equal("us-east-1"))
+				Expect(c.AmiRegions[0].Destinations).To(Equal([]string{"eu-west-1", "us-west-1"}))
+				Expect(c.AmiRegions[0].PriorityDestinations).To(Equal([]string{"eu-west-1"}))
+			})
+
+			It("returns an error if the import region is also a copy destination", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "eu-west-1"
+					c.AmiRegions[0].ImportRegion = "us-east-1"
+					c.AmiRegions[0].Destinations = []string{"us-east-1"}
+				})
+				Expect(err).To(MatchError("us-east-1 specified as both a source and a copy destination"))
+			})
+
+			It("returns an error for an unknown import region", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "eu-west-1"
+					c.AmiRegions[0].ImportRegion = "us-east-7"
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix("unknown region us-east-7 in the import_region of eu-west-1"))
+			})
+
+			It("keeps the named region as the entry's identity when selecting regions for a stemcell", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "eu-west-1"
+					c.AmiRegions[0].ImportRegion = "us-east-1"
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				selected := c.ForStemcell(config.Stemcell{Regions: []string{"eu-west-1"}})
+				Expect(selected.AmiRegions).To(HaveLen(1))
+				Expect(selected.AmiRegions[0].RegionName).To(Equal("eu-west-1"))
+				Expect(selected.AmiRegions[0].Destinations).To(Equal([]string{"eu-west-1"}))
+			})
+		})
+
+		Context("when a 'fallback_region' is specified", func() {
+			It("returns an entry importing in the fallback region and copying to the configured one", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].RegionName = "us-east-1"
+					c.AmiRegions[0].FallbackRegion = "us-west-2"
+					c.AmiRegions[0].FallbackBucketName = "fallback-bucket"
+					c.AmiRegions[0].Destinations = []string{"us-west-1", "us-west-2"}
+					c.AmiRegions[0].CopyHubs = []string{"us-west-2"}
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				fallback, ok := c.AmiRegions[0].Fallback()
+				Expect(ok).To(BeTrue())
+				Expect(fallback.RegionName).To(Equal("us-west-2"))
+				Expect(fallback.BucketName).To(Equal("fallback-bucket"))
+				Expect(fallback.Credentials.Region).To(Equal("us-west-2"))
+				Expect(fallback.Destinations).To(Equal([]string{"us-east-1", "us-west-1"}))
+				Expect(fallback.CopyHubs).To(BeEmpty())
+				Expect(fallback.FallbackRegion).To(BeEmpty())
+			})
+
+			It("returns no fallback when none is configured", func() {
+				c, err := parseConfig(baseJSON, identityModifier)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, ok := c.AmiRegions[0].Fallback()
+				Expect(ok).To(BeFalse())
+			})
+
+			It("returns an error without a 'fallback_bucket_name'", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].FallbackRegion = "us-west-2"
+				})
+				Expect(err).To(MatchError("fallback_region and fallback_bucket_name must be specified together for us-east-1"))
+			})
+
+			It("returns an error when an isolated region is involved", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].FallbackRegion = "cn-north-1"
+					c.AmiRegions[0].FallbackBucketName = "fallback-bucket"
+				})
+				Expect(err).To(MatchError("fallback_region cn-north-1 of us-east-1 cannot involve an isolated region"))
+			})
+		})
+
+		It("publishes the first of the virtualization_types as the virtualization_type", func() {
+			c, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.AmiConfiguration.VirtualizationType = ""
+				c.AmiConfiguration.VirtualizationTypes = []string{"paravirtual", "hvm"}
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.AmiConfiguration.VirtualizationType).To(Equal("paravirtual"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.AmiConfiguration.VirtualizationType = "hvm"
+				c.AmiConfiguration.VirtualizationTypes = []string{"hvm"}
+			})
+			Expect(err).To(MatchError("virtualization_type and virtualization_types cannot both be specified"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.AmiConfiguration.VirtualizationType = ""
+				c.AmiConfiguration.VirtualizationTypes = []string{"hvm", "hvm"}
+			})
+			Expect(err).To(MatchError("virtualization_types lists hvm more than once"))
+		})
+
+		It("passes dual_stack on to the credentials of every region", func() {
+			c, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.DualStack = true
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.AmiRegions[0].Credentials.DualStack).To(BeTrue())
+		})
+
+		It("rejects a manifest_fetch with a negative timeout or a proxy_url which isn't http(s)", func() {
+			_, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.ManifestFetch.TimeoutSeconds = -1
+			})
+			Expect(err).To(MatchError("timeout_seconds must not be negative for manifest_fetch"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.ManifestFetch.ProxyURL = "socks5://proxy.example.com:1080"
+			})
+			Expect(err).To(MatchError("proxy_url socks5://proxy.example.com:1080 of manifest_fetch must be an http or https URL"))
+
+			c, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.ManifestFetch = config.ManifestFetch{TimeoutSeconds: 30, ProxyURL: "http://proxy.example.com:3128"}
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.ManifestFetch.TimeoutSeconds).To(Equal(30))
+		})
+
+		It("rejects sources which are not http(s) or s3, or which mix their kinds of authentication", func() {
+			_, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.Sources = []config.Source{{URLPrefix: "ftp://artifacts.example.com/"}}
+			})
+			Expect(err).To(MatchError("url_prefix ftp://artifacts.example.com/ of sources must start with http://, https:// or s3://"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.Sources = []config.Source{{URLPrefix: "https://artifacts.example.com/", Username: "some-user", BearerToken: "some-token"}}
+			})
+			Expect(err).To(MatchError("username and bearer_token cannot both be specified for the source https://artifacts.example.com/"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.Sources = []config.Source{{URLPrefix: "s3://some-bucket/", Region: "us-east-1"}}
+			})
+			Expect(err).To(MatchError("credentials must be specified for the s3:// source s3://some-bucket/"))
+		})
+
+		It("rejects an artifact_repository which isn't http(s) or mixes its kinds of authentication", func() {
+			_, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.ArtifactRepository.URL = "artifactory.example.com/stemcells"
+			})
+			Expect(err).To(MatchError("url artifactory.example.com/stemcells of artifact_repository must be an http or https URL"))
+
+			_, err = parseConfig(baseJSON, func(c *config.Config) {
+				c.ArtifactRepository = config.ArtifactRepository{URL: "https://artifactory.example.com/stemcells", Username: "some-user", BearerToken: "some-token"}
+			})
+			Expect(err).To(MatchError("username and bearer_token cannot both be specified for artifact_repository"))
+		})
+
+		It("gives s3 sources the region of their bucket", func() {
+			c, err := parseConfig(baseJSON, func(c *config.Config) {
+				c.Sources = []config.Source{{
+					URLPrefix:   "s3://some-bucket/",
+					Credentials: config.Credentials{AccessKey: "some-access-key", SecretKey: "some-secret-key"},
+					Region:      "eu-west-1",
+				}}
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Sources[0].Credentials.Region).To(Equal("eu-west-1"))
+
+			source, found := c.SourceFor("s3://some-bucket/stemcell.tgz")
+			Expect(found).To(BeTrue())
+			Expect(source.URLPrefix).To(Equal("s3://some-bucket/"))
+
+			_, found = c.SourceFor("s3://other-bucket/stemcell.tgz")
+			Expect(found).To(BeFalse())
+		})
+
+		Context("given a 'region' config with a 'volume_deletion'", func() {
+			It("deletes volumes immediately by default", func() {
+				c, err := parseConfig(baseJSON, identityModifier)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiRegions[0].VolumeDeletion).To(Equal(config.VolumeDeletion{Timing: config.ImmediateVolumeDeletion}))
+			})
+
+			It("requires hours for delayed deletion only", func() {
+				c, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].VolumeDeletion = config.VolumeDeletion{Timing: config.DelayedVolumeDeletion, Hours: 24}
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(c.AmiRegions[0].VolumeDeletion.Hours).To(Equal(24))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].VolumeDeletion = config.VolumeDeletion{Timing: config.DelayedVolumeDeletion}
+				})
+				Expect(err).To(MatchError("hours of the volume_deletion of us-east-1 must be positive if and only if its timing is delayed"))
+
+				_, err = parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].VolumeDeletion = config.VolumeDeletion{Timing: config.RetainVolumeDeletion, Hours: 24}
+				})
+				Expect(err).To(MatchError("hours of the volume_deletion of us-east-1 must be positive if and only if its timing is delayed"))
+			})
+
+			It("returns an error for an unknown timing", func() {
+				_, err := parseConfig(baseJSON, func(c *config.Config) {
+					c.AmiRegions[0].VolumeDeletion.Timing = "later"
+				})
+				Expect(err).To(MatchError("timing of the volume_deletion of us-east-1 must be one of: ['immediate', 'delayed', 'retain']"))
+			})
+		})
+
 		Context("when given a standard region", func() {
 			It("sets IsolatedRegion to false", func() {
 				standardRegions := []string{"us-east-1", "eu-central-1", "ap-northeast-1"}
@@ -182,4 +750,116 @@ var _ = Describe("Config", func() {
 			})
 		})
 	})
+
+	Describe("ForStemcell", func() {
+		c := config.Config{
+			AmiRegions: []config.AmiRegion{
+				{
+					RegionName:           "us-east-1",
+					Destinations:         []string{"us-west-1", "us-west-2", "eu-west-1"},
+					CopyHubs:             []string{"us-west-1"},
+					PriorityDestinations: []string{"eu-west-1"},
+				},
+				{RegionName: "cn-north-1"},
+			},
+		}
+
+		It("keeps every region when the stemcell does not list any", func() {
+			Expect(c.ForStemcell(config.Stemcell{})).To(Equal(c))
+		})
+
+		It("keeps only the listed destinations of entries with a listed region", func() {
+			stemcellConfig := c.ForStemcell(config.Stemcell{Regions: []string{"us-east-1", "us-west-2", "eu-west-1"}})
+			Expect(stemcellConfig.AmiRegions).To(HaveLen(1))
+			Expect(stemcellConfig.AmiRegions[0].RegionName).To(Equal("us-east-1"))
+			Expect(stemcellConfig.AmiRegions[0].Destinations).To(Equal([]string{"us-west-2", "eu-west-1"}))
+			Expect(stemcellConfig.AmiRegions[0].CopyHubs).To(BeEmpty())
+			Expect(stemcellConfig.AmiRegions[0].PriorityDestinations).To(Equal([]string{"eu-west-1"}))
+
+			Expect(c.AmiRegions[0].Destinations).To(HaveLen(3))
+		})
+
+		It("keeps entries whose own region is listed without any of their destinations", func() {
+			stemcellConfig := c.ForStemcell(config.Stemcell{Regions: []string{"cn-north-1"}})
+			Expect(stemcellConfig.AmiRegions).To(Equal([]config.AmiRegion{{RegionName: "cn-north-1", Destinations: []string{}, CopyHubs: []string{}, PriorityDestinations: []string{}}}))
+		})
+	})
+
+	Describe("EntryFor", func() {
+		c := config.Config{
+			AmiRegions: []config.AmiRegion{
+				{RegionName: "us-east-1", Destinations: []string{"us-west-1"}, FallbackRegion: "us-east-2"},
+				{RegionName: "eu-west-1", ImportRegion: "eu-central-1"},
+			},
+		}
+
+		It("finds the entry publishing an AMI in its own region, a destination or its fallback region", func() {
+			for region, name := range map[string]string{"us-east-1": "us-east-1", "us-west-1": "us-east-1", "us-east-2": "us-east-1", "eu-central-1": "eu-west-1"} {
+				regionConfig, ok := c.EntryFor(region)
+				Expect(ok).To(BeTrue())
+				Expect(regionConfig.RegionName).To(Equal(name))
+			}
+
+			_, ok := c.EntryFor("ap-south-1")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("names a region the retry command of a destination's failure can select", func() {
+			regionConfig, ok := c.EntryFor("us-west-1")
+			Expect(ok).To(BeTrue())
+
+			retry := report.RetryCommand([]string{"light-stemcell-builder", "-c", "config.json"}, []string{regionConfig.RegionName})
+			Expect(retry).To(HaveSuffix(" --regions us-east-1"))
+
+			regions, err := c.SelectRegions(strings.Split(strings.TrimPrefix(retry, "light-stemcell-builder -c config.json --regions "), ","))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(regions).To(Equal(c.AmiRegions[:1]))
+		})
+	})
+
+	Describe("SelectRegions", func() {
+		It("returns an error for regions which are only destinations", func() {
+			c := config.Config{AmiRegions: []config.AmiRegion{{RegionName: "us-east-1", Destinations: []string{"us-west-1"}}}}
+			_, err := c.SelectRegions([]string{"us-west-1"})
+			Expect(err).To(MatchError("--regions includes us-west-1 which is not one of the configured ami_regions"))
+		})
+	})
+
+	Describe("ForVirtualizationTypes", func() {
+		stemcells := []config.Stemcell{{AmiName: "some-ami", OutputPath: "out/stemcell.MF"}}
+
+		It("keeps the stemcells as they are for a single type", func() {
+			c := config.Config{AmiConfiguration: config.AmiConfiguration{VirtualizationType: config.HardwareAssistedVirtualization}}
+			Expect(c.ForVirtualizationTypes(stemcells)).To(Equal([]config.Stemcell{
+				{AmiName: "some-ami", OutputPath: "out/stemcell.MF", VirtualizationType: "hvm"},
+			}))
+		})
+
+		It("adds an entry for each further type, whose AMI name and output have the type appended", func() {
+			c := config.Config{AmiConfiguration: config.AmiConfiguration{VirtualizationTypes: []string{"hvm", "paravirtual"}}}
+			Expect(c.ForVirtualizationTypes(stemcells)).To(Equal([]config.Stemcell{
+				{AmiName: "some-ami", OutputPath: "out/stemcell.MF", VirtualizationType: "hvm"},
+				{AmiName: "some-ami-paravirtual", OutputPath: "out/stemcell-paravirtual.MF", VirtualizationType: "paravirtual"},
+			}))
+		})
+
+		It("publishes paravirtual entries only to the regions which support them", func() {
+			c := config.Config{
+				AmiRegions: []config.AmiRegion{
+					{RegionName: "us-east-1", Destinations: []string{"us-west-2", "ap-south-1"}, PriorityDestinations: []string{"ap-south-1"}},
+					{RegionName: "eu-west-2"},
+				},
+			}
+
+			stemcellConfig := c.ForStemcell(config.Stemcell{VirtualizationType: config.Paravirtualization})
+			Expect(stemcellConfig.AmiConfiguration.VirtualizationType).To(Equal("paravirtual"))
+			Expect(stemcellConfig.AmiRegions).To(HaveLen(1))
+			Expect(stemcellConfig.AmiRegions[0].Destinations).To(Equal([]string{"us-west-2"}))
+			Expect(stemcellConfig.AmiRegions[0].PriorityDestinations).To(BeEmpty())
+			Expect(c.UnsupportedParavirtualRegions()).To(Equal([]string{"ap-south-1", "eu-west-2"}))
+
+			c.AmiConfiguration.ParavirtualRegions = []string{"eu-west-2"}
+			Expect(c.ForStemcell(config.Stemcell{VirtualizationType: config.Paravirtualization}).AmiRegions).To(Equal([]config.AmiRegion{{RegionName: "eu-west-2", Destinations: []string{}, CopyHubs: []string{}, PriorityDestinations: []string{}}}))
+		})
+	})
 })
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Partitions of AWS, regions cannot copy AMIs to the regions of another partition
+const (
+	AwsPartition      = "aws"
+	AwsChinaPartition = "aws-cn"
+	AwsGovPartition   = "aws-us-gov"
+)
+
+// regionPartitions holds the partition of each region known to the builder. Regions opened since can be allowed
+// with additional_regions until they are added here.
+var regionPartitions = map[string]string{
+	"af-south-1":     AwsPartition,
+	"ap-east-1":      AwsPartition,
+	"ap-east-2":      AwsPartition,
+	"ap-northeast-1": AwsPartition,
+	"ap-northeast-2": AwsPartition,
+	"ap-northeast-3": AwsPartition,
+	"ap-south-1":     AwsPartition,
+	"ap-south-2":     AwsPartition,
+	"ap-southeast-1": AwsPartition,
+	"ap-southeast-2": AwsPartition,
+	"ap-southeast-3": AwsPartition,
+	"ap-southeast-4": AwsPartition,
+	"ap-southeast-5": AwsPartition,
+	"ap-southeast-7": AwsPartition,
+	"ca-central-1":   AwsPartition,
+	"ca-west-1":      AwsPartition,
+	"eu-central-1":   AwsPartition,
+	"eu-central-2":   AwsPartition,
+	"eu-north-1":     AwsPartition,
+	"eu-south-1":     AwsPartition,
+	"eu-south-2":     AwsPartition,
+	"eu-west-1":      AwsPartition,
+	"eu-west-2":      AwsPartition,
+	"eu-west-3":      AwsPartition,
+	"il-central-1":   AwsPartition,
+	"me-central-1":   AwsPartition,
+	"me-south-1":     AwsPartition,
+	"mx-central-1":   AwsPartition,
+	"sa-east-1":      AwsPartition,
+	"us-east-1":      AwsPartition,
+	"us-east-2":      AwsPartition,
+	"us-west-1":      AwsPartition,
+	"us-west-2":      AwsPartition,
+	"cn-north-1":     AwsChinaPartition,
+	"cn-northwest-1": AwsChinaPartition,
+	"us-gov-east-1":  AwsGovPartition,
+	"us-gov-west-1":  AwsGovPartition,
+}
+
+// defaultRegionAliases are the friendly names which may be given instead of a region, after the location AWS
+// names it for. The region_aliases of the config take precedence.
+var defaultRegionAliases = map[string]string{
+	"virginia":   "us-east-1",
+	"ohio":       "us-east-2",
+	"california": "us-west-1",
+	"oregon":     "us-west-2",
+	"canada":     "ca-central-1",
+	"calgary":    "ca-west-1",
+	"sao-paulo":  "sa-east-1",
+	"ireland":    "eu-west-1",
+	"london":     "eu-west-2",
+	"paris":      "eu-west-3",
+	"frankfurt":  "eu-central-1",
+	"zurich":     "eu-central-2",
+	"stockholm":  "eu-north-1",
+	"milan":      "eu-south-1",
+	"spain":      "eu-south-2",
+	"tokyo":      "ap-northeast-1",
+	"seoul":      "ap-northeast-2",
+	"osaka":      "ap-northeast-3",
+	"mumbai":     "ap-south-1",
+	"hyderabad":  "ap-south-2",
+	"singapore":  "ap-southeast-1",
+	"sydney":     "ap-southeast-2",
+	"jakarta":    "ap-southeast-3",
+	"melbourne":  "ap-southeast-4",
+	"hong-kong":  "ap-east-1",
+	"cape-town":  "af-south-1",
+	"bahrain":    "me-south-1",
+	"uae":        "me-central-1",
+	"tel-aviv":   "il-central-1",
+	"beijing":    "cn-north-1",
+	"ningxia":    "cn-northwest-1",
+}
+
+// maxSuggestionDistance is the largest number of edits between an unknown region and a suggested one
+const maxSuggestionDistance = 2
+
+// ResolveRegion returns the region name stands for when it is one of the region_aliases or a default alias,
+// otherwise name itself
+func (c Config) ResolveRegion(name string) string {
+	if region, ok := c.RegionAliases[name]; ok {
+		return region
+	}
+	if region, ok := defaultRegionAliases[name]; ok {
+		return region
+	}
+	return name
+}
+
+// resolveRegionAliases replaces every alias of the config with its region, before any defaults are derived from
+// the region names
+func (c *Config) resolveRegionAliases() {
+	resolveAll := func(names []string) {
+		for i := range names {
+			names[i] = c.ResolveRegion(names[i])
+		}
+	}
+
+	for i := range c.AmiRegions {
+		r := &c.AmiRegions[i]
+		r.RegionName = c.ResolveRegion(r.RegionName)
+		r.ImportRegion = c.ResolveRegion(r.ImportRegion)
+		r.FallbackRegion = c.ResolveRegion(r.FallbackRegion)
+		resolveAll(r.Destinations)
+		resolveAll(r.CopyHubs)
+		resolveAll(r.PriorityDestinations)
+	}
+
+	for i := range c.Stemcells {
+		resolveAll(c.Stemcells[i].Regions)
+	}
+
+	for i := range c.Sources {
+		c.Sources[i].Region = c.ResolveRegion(c.Sources[i].Region)
+	}
+
+	resolveAll(c.AmiConfiguration.ParavirtualRegions)
+	c.Canary.Region = c.ResolveRegion(c.Canary.Region)
+}
+
+// validateRegionNames checks that every region of the config is known, so a typo fails the config rather than
+// an endpoint lookup deep into the publish, and that regions only copy within their partition
+func (c Config) validateRegionNames() error {
+	for alias, region := range c.RegionAliases {
+		if _, ok := regionPartitions[alias]; ok {
+			return fmt.Errorf("region_aliases %s is the name of a region and cannot be an alias", alias)
+		}
+		if err := c.checkRegion(region, fmt.Sprintf("region_aliases %s", alias)); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range c.AmiRegions {
+		if err := c.checkRegion(r.RegionName, "ami_regions"); err != nil {
+			return err
+		}
+		if r.ImportRegion != "" {
+			if err := c.checkRegion(r.ImportRegion, fmt.Sprintf("the import_region of %s", r.RegionName)); err != nil {
+				return err
+			}
+		}
+
+		copied := append([]string{}, r.Destinations...)
+		if r.FallbackRegion != "" {
+			copied = append(copied, r.FallbackRegion)
+		}
+		for _, region := range copied {
+			if err := c.checkRegion(region, fmt.Sprintf("the destinations of %s", r.RegionName)); err != nil {
+				return err
+			}
+
+			source, sourceKnown := regionPartitions[r.PublishRegion()]
+			destination, destinationKnown := regionPartitions[region]
+			if sourceKnown && destinationKnown && source != destination {
+				return fmt.Errorf("%s cannot copy to %s, which is in the %s partition rather than %s", r.PublishRegion(), region, destination, source)
+			}
+		}
+	}
+
+	for _, s := range c.Sources {
+		if s.Region == "" {
+			continue
+		}
+		if err := c.checkRegion(s.Region, fmt.Sprintf("the source %s", s.URLPrefix)); err != nil {
+			return err
+		}
+	}
+
+	for _, region := range c.AmiConfiguration.ParavirtualRegions {
+		if err := c.checkRegion(region, "paravirtual_regions"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRegion returns an error naming where the region was configured if it is neither known nor one of the
+// additional_regions, suggesting the closest known region or alias
+func (c Config) checkRegion(region string, configuredIn string) error {
+	if _, ok := regionPartitions[region]; ok {
+		return nil
+	}
+	for _, additional := range c.AdditionalRegions {
+		if region == additional {
+			return nil
+		}
+	}
+
+	if suggestion := c.suggestRegion(region); suggestion != "" {
+		return fmt.Errorf("unknown region %s in %s, did you mean %s?", region, configuredIn, suggestion)
+	}
+	return fmt.Errorf("unknown region %s in %s, add it to additional_regions if it has opened since this builder was released", region, configuredIn)
+}
+
+// suggestRegion returns the known region or alias fewest edits away from name, or an empty string when none is
+// close enough to be a typo
+func (c Config) suggestRegion(name string) string {
+	candidates := []string{}
+	for region := range regionPartitions {
+		candidates = append(candidates, region)
+	}
+	for alias := range defaultRegionAliases {
+		candidates = append(candidates, alias)
+	}
+	for alias := range c.RegionAliases {
+		candidates = append(candidates, alias)
+	}
+	candidates = append(candidates, c.AdditionalRegions...)
+	// ties are broken alphabetically, so the suggestion does not depend on map order
+	sort.Strings(candidates)
+
+	suggestion := ""
+	best := maxSuggestionDistance + 1
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance < best {
+			suggestion, best = candidate, distance
+		}
+	}
+	return suggestion
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+			current[j] = substitution
+			if deletion := previous[j] + 1; deletion < current[j] {
+				current[j] = deletion
+			}
+			if insertion := current[j-1] + 1; insertion < current[j] {
+				current[j] = insertion
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// CopySource is the region a copy to destination is made from: the publish region for priority destinations, copy
+// hubs and every destination when there are no hubs, otherwise the destination's nearest hub
+func (r AmiRegion) CopySource(destination string) string {
+	if len(r.CopyHubs) == 0 {
+		return r.PublishRegion()
+	}
+
+	for _, region := range append(append([]string{}, r.CopyHubs...), r.PriorityDestinations...) {
+		if region == destination {
+			return r.PublishRegion()
+		}
+	}
+
+	return NearestHub(destination, r.CopyHubs)
+}
+
+// NearestHub picks the hub sharing the longest prefix with region, e.g. ap-southeast-2 prefers
+// ap-southeast-1 over ap-northeast-1, and either over eu-west-1. Ties go to the first hub listed.
+func NearestHub(region string, hubs []string) string {
+	regionParts := strings.Split(region, "-")
+
+	nearest := ""
+	nearestScore := -1
+	for _, hub := range hubs {
+		hubParts := strings.Split(hub, "-")
+
+		score := 0
+		for score < len(regionParts)-1 && score < len(hubParts)-1 && regionParts[score] == hubParts[score] {
+			score++
+		}
+
+		if score > nearestScore {
+			nearest = hub
+			nearestScore = score
+		}
+	}
+
+	return nearest
+}
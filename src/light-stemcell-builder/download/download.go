@@ -0,0 +1,143 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const s3Scheme = "s3://"
+
+// IsURL reports whether input is an http, https or s3 URL to download, rather than a local path
+func IsURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") || strings.HasPrefix(input, s3Scheme)
+}
+
+// Downloader downloads stemcell tarballs and images, authenticating with the sources entry of their URL
+type Downloader struct {
+	Config      config.Config
+	HTTPClient  *http.Client
+	S3ClientFor func(source config.Source) s3iface.S3API
+	// Digests is filled in with the hex SHA256 of each downloaded file by its path, hashed as it is written
+	Digests map[string]string
+}
+
+// NewDownloader creates a Downloader for the sources of c
+func NewDownloader(c config.Config) *Downloader {
+	return &Downloader{Config: c, HTTPClient: http.DefaultClient, S3ClientFor: newS3Client, Digests: map[string]string{}}
+}
+
+func newS3Client(source config.Source) s3iface.S3API {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(source.Credentials.AccessKey, source.Credentials.SecretKey, "")).
+		WithRegion(source.Region)
+
+	return s3.New(session.New(awsConfig), dualstack.Config("s3", source.Credentials))
+}
+
+// Download downloads inputURL into dir, keeping the file name of the URL, and returns the path it was written to.
+// HTTP(S) URLs without a sources entry are downloaded without authentication, while s3:// URLs need one for its
+// credentials.
+func (d *Downloader) Download(inputURL string, dir string) (string, error) {
+	source, found := d.Config.SourceFor(inputURL)
+
+	if strings.HasPrefix(inputURL, s3Scheme) {
+		if !found {
+			return "", fmt.Errorf("no sources entry has a url_prefix matching %s to provide credentials for it", inputURL)
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(inputURL, s3Scheme), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("%s must be of the form s3://bucket/key", inputURL)
+		}
+
+		destination := filepath.Join(dir, path.Base(parts[1]))
+		err := d.downloadObject(source, parts[0], parts[1], destination)
+		if err != nil {
+			return "", fmt.Errorf("downloading %s: %s", inputURL, err)
+		}
+		return destination, nil
+	}
+
+	parsed, err := url.Parse(inputURL)
+	if err != nil || parsed.Host == "" || path.Base(parsed.Path) == "/" || path.Base(parsed.Path) == "." {
+		return "", fmt.Errorf("%s must be an http or https URL of a file", inputURL)
+	}
+
+	destination := filepath.Join(dir, path.Base(parsed.Path))
+	err = d.downloadHTTP(source, inputURL, destination)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %s", inputURL, err)
+	}
+	return destination, nil
+}
+
+func (d *Downloader) downloadHTTP(source config.Source, inputURL string, destination string) error {
+	req, err := http.NewRequest("GET", inputURL, nil)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case source.Username != "":
+		req.SetBasicAuth(source.Username, source.Password)
+	case source.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+source.BearerToken)
+	}
+
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return d.writeFile(destination, resp.Body)
+}
+
+func (d *Downloader) downloadObject(source config.Source, bucket string, key string, destination string) error {
+	output, err := d.S3ClientFor(source).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+
+	return d.writeFile(destination, output.Body)
+}
+
+func (d *Downloader) writeFile(path string, r io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, h), r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	d.Digests[path] = hex.EncodeToString(h.Sum(nil))
+	return file.Close()
+}
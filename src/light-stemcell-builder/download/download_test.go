@@ -0,0 +1,143 @@
+package download_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/download"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeObjectS3 struct {
+	s3iface.S3API
+	input   *s3.GetObjectInput
+	content string
+	err     error
+}
+
+func (f *fakeObjectS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.input = input
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader([]byte(f.content)))}, nil
+}
+
+var _ = Describe("Downloader", func() {
+	var dir string
+	var server *httptest.Server
+	var authorization string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "download")
+		Expect(err).ToNot(HaveOccurred())
+
+		authorization = ""
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			if r.URL.Path == "/missing.tgz" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("some-stemcell"))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("recognizes http, https and s3 URLs", func() {
+		Expect(download.IsURL("https://artifactory.example.com/stemcell.tgz")).To(BeTrue())
+		Expect(download.IsURL("s3://some-bucket/stemcell.tgz")).To(BeTrue())
+		Expect(download.IsURL("stemcells/stemcell.tgz")).To(BeFalse())
+	})
+
+	It("downloads a URL without a sources entry unauthenticated, keeping its file name", func() {
+		downloader := download.NewDownloader(config.Config{})
+
+		path, err := downloader.Download(server.URL+"/stemcells/bosh-stemcell.tgz", dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(filepath.Join(dir, "bosh-stemcell.tgz")))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("some-stemcell")))
+		Expect(authorization).To(BeEmpty())
+	})
+
+	It("authenticates with basic auth for a sources entry with a username", func() {
+		downloader := download.NewDownloader(config.Config{Sources: []config.Source{
+			{URLPrefix: server.URL + "/stemcells/", Username: "some-user", Password: "some-password"},
+		}})
+
+		_, err := downloader.Download(server.URL+"/stemcells/bosh-stemcell.tgz", dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(Equal("Basic c29tZS11c2VyOnNvbWUtcGFzc3dvcmQ="))
+	})
+
+	It("authenticates with the bearer token of the sources entry with the longest matching prefix", func() {
+		downloader := download.NewDownloader(config.Config{Sources: []config.Source{
+			{URLPrefix: server.URL + "/", BearerToken: "some-token"},
+			{URLPrefix: server.URL + "/stemcells/", BearerToken: "other-token"},
+		}})
+
+		_, err := downloader.Download(server.URL+"/stemcells/bosh-stemcell.tgz", dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(Equal("Bearer other-token"))
+	})
+
+	It("returns an error for a response other than 200", func() {
+		downloader := download.NewDownloader(config.Config{})
+
+		_, err := downloader.Download(server.URL+"/missing.tgz", dir)
+		Expect(err).To(MatchError("downloading " + server.URL + "/missing.tgz: unexpected status 404 Not Found"))
+	})
+
+	Context("given an s3:// URL", func() {
+		source := config.Source{URLPrefix: "s3://some-bucket/", Region: "eu-west-1"}
+		var fakeS3 *fakeObjectS3
+		var downloader *download.Downloader
+
+		BeforeEach(func() {
+			fakeS3 = &fakeObjectS3{content: "some-image"}
+			downloader = download.NewDownloader(config.Config{Sources: []config.Source{source}})
+			downloader.S3ClientFor = func(s config.Source) s3iface.S3API {
+				Expect(s).To(Equal(source))
+				return fakeS3
+			}
+		})
+
+		It("downloads the object with the client of its sources entry", func() {
+			path, err := downloader.Download("s3://some-bucket/images/root.img", dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(dir, "root.img")))
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte("some-image")))
+			Expect(downloader.Digests).To(Equal(map[string]string{path: "68ed426e5de78f0133da9ade4963836d47b76ce83c3177e8c790282cf7a47c7a"}))
+			Expect(aws.StringValue(fakeS3.input.Bucket)).To(Equal("some-bucket"))
+			Expect(aws.StringValue(fakeS3.input.Key)).To(Equal("images/root.img"))
+		})
+
+		It("returns an error when the object cannot be downloaded", func() {
+			fakeS3.err = errors.New("AccessDenied")
+
+			_, err := downloader.Download("s3://some-bucket/images/root.img", dir)
+			Expect(err).To(MatchError("downloading s3://some-bucket/images/root.img: AccessDenied"))
+		})
+
+		It("returns an error when no sources entry provides credentials", func() {
+			_, err := downloader.Download("s3://other-bucket/root.img", dir)
+			Expect(err).To(MatchError("no sources entry has a url_prefix matching s3://other-bucket/root.img to provide credentials for it"))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"light-stemcell-builder/resources"
+)
+
+// ChecksumWriter computes the sha1, sha256 and md5 digests of everything written to it
+type ChecksumWriter struct {
+	writer io.Writer
+	sha1   hash.Hash
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+// NewChecksumWriter returns a ChecksumWriter with nothing written to it
+func NewChecksumWriter() *ChecksumWriter {
+	c := &ChecksumWriter{
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+		md5:    md5.New(),
+	}
+	c.writer = io.MultiWriter(c.sha1, c.sha256, c.md5)
+	return c
+}
+
+// Write updates the digests with p
+func (c *ChecksumWriter) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// Checksums returns the hex encoded digests of the bytes written so far
+func (c *ChecksumWriter) Checksums() resources.MachineImageChecksums {
+	return resources.MachineImageChecksums{
+		Sha1:   fmt.Sprintf("%x", c.sha1.Sum(nil)),
+		Sha256: fmt.Sprintf("%x", c.sha256.Sum(nil)),
+		MD5:    fmt.Sprintf("%x", c.md5.Sum(nil)),
+	}
+}
+
+// ChecksumReader computes the sha1, sha256 and md5 digests of everything read through it
+type ChecksumReader struct {
+	*ChecksumWriter
+	reader io.Reader
+}
+
+// NewChecksumReader wraps the provided reader in a ChecksumReader
+func NewChecksumReader(r io.Reader) *ChecksumReader {
+	w := NewChecksumWriter()
+	return &ChecksumReader{ChecksumWriter: w, reader: io.TeeReader(r, w)}
+}
+
+// Read reads from the underlying reader, updating the digests with the bytes read
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
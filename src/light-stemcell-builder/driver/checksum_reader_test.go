@@ -0,0 +1,38 @@
+package driver_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"light-stemcell-builder/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChecksumReader", func() {
+	It("passes through the bytes of the underlying reader", func() {
+		r := driver.NewChecksumReader(bytes.NewReader([]byte("some machine image")))
+
+		content, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("some machine image"))
+	})
+
+	It("computes the sha1, sha256 and md5 of the bytes read", func() {
+		r := driver.NewChecksumReader(bytes.NewReader([]byte("some machine image")))
+
+		_, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		checksums := r.Checksums()
+		Expect(checksums.Sha1).To(Equal("7c0dd030965206297ac0217dd81f597a9b443e5e"))
+		Expect(checksums.Sha256).To(Equal("856703f262c3ac23286d09c93e462c3519aaa6c66669b19ddd5ce81849407b96"))
+		Expect(checksums.MD5).To(Equal("0442d39c3b9dd0d577ac470d5b9e39f7"))
+	})
+
+	It("returns the digests of empty input when nothing has been read", func() {
+		r := driver.NewChecksumReader(bytes.NewReader([]byte{}))
+
+		Expect(r.Checksums().Sha1).To(Equal("da39a3ee5e6b4b0d3255bfef95601890afd80709"))
+	})
+})
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
 	"time"
@@ -14,31 +15,52 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/private/waiter"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 // SDKCopyAmiDriver uses the AWS SDK to register an AMI from an existing snapshot in EC2
 type SDKCopyAmiDriver struct {
-	creds  config.Credentials
-	logger *log.Logger
+	creds        config.Credentials
+	retries      config.Retries
+	ec2ClientFor func(region string) ec2iface.EC2API
+	logger       *log.Logger
 }
 
 // NewCopyAmiDriver creates a SDKCopyAmiDriver for copying AMIs in EC2
-func NewCopyAmiDriver(logDest io.Writer, creds config.Credentials) *SDKCopyAmiDriver {
+func NewCopyAmiDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKCopyAmiDriver {
 	logger := log.New(logDest, "SDKCopyAmiDriver ", log.LstdFlags)
-	return &SDKCopyAmiDriver{creds: creds, logger: logger}
+	d := &SDKCopyAmiDriver{creds: creds, retries: retries, logger: logger}
+	d.ec2ClientFor = d.newEC2Client
+	return d
 }
 
-// Create creates an AMI, copied from a source AMI, and optionally makes the AMI publically available
-func (d *SDKCopyAmiDriver) Create(driverConfig resources.AmiDriverConfig) (resources.Ami, error) {
-	srcRegion := d.creds.Region
-	dstRegion := driverConfig.DestinationRegion
+// NewCopyAmiDriverWithClients creates a SDKCopyAmiDriver which copies AMIs with the EC2 clients ec2ClientFor
+// returns for the source and destination regions, such as fakes when testing code which embeds the driver
+func NewCopyAmiDriverWithClients(logDest io.Writer, creds config.Credentials, retries config.Retries, ec2ClientFor func(region string) ec2iface.EC2API) *SDKCopyAmiDriver {
+	logger := log.New(logDest, "SDKCopyAmiDriver ", log.LstdFlags)
+	return &SDKCopyAmiDriver{creds: creds, retries: retries, ec2ClientFor: ec2ClientFor, logger: logger}
+}
 
+func (d *SDKCopyAmiDriver) newEC2Client(region string) ec2iface.EC2API {
 	awsConfig := aws.NewConfig().
 		WithCredentials(credentials.NewStaticCredentials(d.creds.AccessKey, d.creds.SecretKey, "")).
-		WithRegion(dstRegion).
+		WithRegion(region).
 		WithLogger(newDriverLogger(d.logger))
+	awsConfig.Retryer = NewPhaseRetryer(d.retries.Copy, defaultRetries)
 
-	ec2Client := ec2.New(session.New(), awsConfig)
+	regionCreds := d.creds
+	regionCreds.Region = region
+	return ec2.New(session.New(), awsConfig, dualstack.Config("ec2", regionCreds))
+}
+
+// Create creates an AMI, copied from a source AMI, and optionally makes the AMI publically available
+func (d *SDKCopyAmiDriver) Create(driverConfig resources.AmiDriverConfig) (resources.Ami, error) {
+	srcRegion := d.creds.Region
+	if driverConfig.SourceRegion != "" {
+		srcRegion = driverConfig.SourceRegion
+	}
+	dstRegion := driverConfig.DestinationRegion
+	ec2Client := d.ec2ClientFor(dstRegion)
 
 	createStartTime := time.Now()
 	defer func(startTime time.Time) {
@@ -74,9 +96,22 @@ func (d *SDKCopyAmiDriver) Create(driverConfig resources.AmiDriverConfig) (resou
 		return resources.Ami{}, fmt.Errorf("waiting for AMI %s to be available: %s", *amiIDptr, err)
 	}
 
+	if driverConfig.VerifyCopies {
+		d.logger.Printf("verifying snapshots of AMI: %s against source AMI: %s\n", *amiIDptr, driverConfig.ExistingAmiID)
+		err = VerifyImageCopy(d.ec2ClientFor(srcRegion), ec2Client, driverConfig.ExistingAmiID, *amiIDptr)
+		if err != nil {
+			return resources.Ami{}, fmt.Errorf("verifying copied AMI %s: %s", *amiIDptr, err)
+		}
+	}
+
+	err = createTags(ec2Client, d.retries.Tag, *amiIDptr, driverConfig.Tags)
+	if err != nil {
+		return resources.Ami{}, err
+	}
+
 	if driverConfig.Accessibility == resources.PublicAmiAccessibility {
 		d.logger.Printf("making AMI: %s public", *amiIDptr)
-		ec2Client.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+		modifyImageAttributeReq, _ := ec2Client.ModifyImageAttributeRequest(&ec2.ModifyImageAttributeInput{
 			ImageId: amiIDptr,
 			LaunchPermission: &ec2.LaunchPermissionModifications{
 				Add: []*ec2.LaunchPermission{
@@ -86,9 +121,12 @@ func (d *SDKCopyAmiDriver) Create(driverConfig resources.AmiDriverConfig) (resou
 				},
 			},
 		})
+		sendWithRetryer(modifyImageAttributeReq, NewPhaseRetryer(d.retries.Permission, defaultRetries))
 	}
 
-	if driverConfig.Encrypted {
+	// the snapshot of an encrypted or promoted copy is only looked up to be tagged or archived, since it is not made public here
+	privateSnapshot := driverConfig.Encrypted || driverConfig.PrivateSnapshots
+	if privateSnapshot && len(driverConfig.SnapshotTags) == 0 && !driverConfig.LookupSnapshot {
 		return resources.Ami{ID: *amiIDptr, Region: dstRegion}, nil
 	}
 
@@ -117,23 +155,38 @@ func (d *SDKCopyAmiDriver) Create(driverConfig resources.AmiDriverConfig) (resou
 
 	d.logger.Printf("snapshot %s for image %s found\n", *snapshotIDptr, *amiIDptr)
 
+	err = createTags(ec2Client, d.retries.Tag, *snapshotIDptr, driverConfig.SnapshotTags)
+	if err != nil {
+		return resources.Ami{}, err
+	}
+
+	copiedAmi := resources.Ami{ID: *amiIDptr, Region: dstRegion}
+	if driverConfig.LookupSnapshot {
+		copiedAmi.SnapshotID = *snapshotIDptr
+	}
+
+	if privateSnapshot {
+		return copiedAmi, nil
+	}
+
 	modifySnapshotAttributeInput := &ec2.ModifySnapshotAttributeInput{
 		SnapshotId:    snapshotIDptr,
 		Attribute:     aws.String("createVolumePermission"),
 		OperationType: aws.String("add"),
 		GroupNames:    []*string{aws.String("all")},
 	}
-	_, err = ec2Client.ModifySnapshotAttribute(modifySnapshotAttributeInput)
+	modifySnapshotAttributeReq, _ := ec2Client.ModifySnapshotAttributeRequest(modifySnapshotAttributeInput)
+	err = sendWithRetryer(modifySnapshotAttributeReq, NewPhaseRetryer(d.retries.Permission, defaultRetries))
 	if err != nil {
 		return resources.Ami{}, fmt.Errorf("making snapshot with id %s public: %s", *snapshotIDptr, err)
 	}
 
 	d.logger.Printf("snapshot %s is public\n", *snapshotIDptr)
 
-	return resources.Ami{ID: *amiIDptr, Region: dstRegion}, nil
+	return copiedAmi, nil
 }
 
-func (d *SDKCopyAmiDriver) waitUntilImageAvailable(input *ec2.DescribeImagesInput, c *ec2.EC2) error {
+func (d *SDKCopyAmiDriver) waitUntilImageAvailable(input *ec2.DescribeImagesInput, c ec2iface.EC2API) error {
 	waiterCfg := waiter.Config{
 		Operation:   "DescribeImages",
 		Delay:       15,
@@ -58,7 +58,7 @@ var _ = Describe("CopyAmiDriver", func() {
 		amiDriverConfig.Encrypted = encrypted
 		amiDriverConfig.KmsKeyId = kmsKey
 
-		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
 
 		amiCopyDriver := ds.CopyAmiDriver()
 		copiedAmi, err := amiCopyDriver.Create(amiDriverConfig)
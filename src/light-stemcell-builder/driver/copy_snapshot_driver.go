@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"light-stemcell-builder/resources"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/waiter"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+var _ resources.SnapshotDriver = &SDKCopySnapshotDriver{}
+
+// SDKCopySnapshotDriver creates a private copy of an existing snapshot in the same region
+type SDKCopySnapshotDriver struct {
+	ec2Client ec2iface.EC2API
+	retries   config.Retries
+	region    string
+	logger    *log.Logger
+}
+
+// NewCopySnapshotDriver creates a SDKCopySnapshotDriver for copying snapshots in EC2
+func NewCopySnapshotDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKCopySnapshotDriver {
+	logger := log.New(logDest, "SDKCopySnapshotDriver ", log.LstdFlags)
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region).
+		WithLogger(newDriverLogger(logger))
+	awsConfig.Retryer = NewPhaseRetryer(retries.Copy, defaultRetries)
+
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKCopySnapshotDriver{ec2Client: ec2Client, retries: retries, region: creds.Region, logger: logger}
+}
+
+// NewCopySnapshotDriverWithClient creates a SDKCopySnapshotDriver which copies snapshots with the provided EC2 client,
+// such as a fake when testing code which embeds the driver
+func NewCopySnapshotDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API, region string, retries config.Retries) *SDKCopySnapshotDriver {
+	logger := log.New(logDest, "SDKCopySnapshotDriver ", log.LstdFlags)
+	return &SDKCopySnapshotDriver{ec2Client: ec2Client, retries: retries, region: region, logger: logger}
+}
+
+// Create copies the snapshot identified by SnapshotID, waiting for the copy to be completed
+func (d *SDKCopySnapshotDriver) Create(driverConfig resources.SnapshotDriverConfig) (resources.Snapshot, error) {
+	createStartTime := time.Now()
+	defer func(startTime time.Time) {
+		d.logger.Printf("completed Create() in %f minutes\n", time.Since(startTime).Minutes())
+	}(createStartTime)
+
+	d.logger.Printf("copying snapshot %s\n", driverConfig.SnapshotID)
+	reqOutput, err := d.ec2Client.CopySnapshot(&ec2.CopySnapshotInput{
+		SourceSnapshotId: aws.String(driverConfig.SnapshotID),
+		SourceRegion:     aws.String(d.region),
+		Description:      aws.String(driverConfig.Description),
+	})
+	if err != nil {
+		return resources.Snapshot{}, fmt.Errorf("copying snapshot %s: %s", driverConfig.SnapshotID, err)
+	}
+
+	snapshotIDptr := reqOutput.SnapshotId
+	if snapshotIDptr == nil {
+		return resources.Snapshot{}, errors.New("snapshot id nil")
+	}
+
+	err = tagNamespace(d.ec2Client, d.retries.Tag, driverConfig.Namespace, *snapshotIDptr)
+	if err != nil {
+		return resources.Snapshot{}, err
+	}
+
+	d.logger.Printf("waiting on snapshot %s to be completed\n", *snapshotIDptr)
+	waitStartTime := time.Now()
+	err = d.waitUntilSnapshotCompleted(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{snapshotIDptr},
+	})
+	if err != nil {
+		return resources.Snapshot{}, fmt.Errorf("waiting for snapshot %s to complete: %s", *snapshotIDptr, err)
+	}
+
+	d.logger.Printf("waited for snapshot %s completion for %f minutes\n", *snapshotIDptr, time.Since(waitStartTime).Minutes())
+	d.logger.Printf("copied snapshot %s to %s\n", driverConfig.SnapshotID, *snapshotIDptr)
+
+	return resources.Snapshot{ID: *snapshotIDptr}, nil
+}
+
+func (d *SDKCopySnapshotDriver) waitUntilSnapshotCompleted(input *ec2.DescribeSnapshotsInput) error {
+	waiterCfg := waiter.Config{
+		Operation:   "DescribeSnapshots",
+		Delay:       15,
+		MaxAttempts: 240,
+		Acceptors: []waiter.WaitAcceptor{
+			{
+				State:    "success",
+				Matcher:  "pathAll",
+				Argument: "Snapshots[].State",
+				Expected: "completed",
+			},
+			{
+				State:    "failure",
+				Matcher:  "pathAny",
+				Argument: "Snapshots[].State",
+				Expected: "error",
+			},
+		},
+	}
+
+	w := waiter.Waiter{
+		Client: d.ec2Client,
+		Input:  input,
+		Config: waiterCfg,
+	}
+	return w.Wait()
+}
@@ -0,0 +1,65 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/resources"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CopySnapshotDriver", func() {
+	It("creates a private copy of an existing snapshot", func() {
+		accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+		Expect(accessKey).ToNot(BeEmpty(), "AWS_ACCESS_KEY_ID must be set")
+
+		secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		Expect(secretKey).ToNot(BeEmpty(), "AWS_SECRET_ACCESS_KEY must be set")
+
+		region := os.Getenv("AWS_REGION")
+		Expect(region).ToNot(BeEmpty(), "AWS_REGION must be set")
+
+		creds := config.Credentials{
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			Region:    region,
+		}
+
+		snapshotID := os.Getenv("EBS_SNAPSHOT_ID")
+		Expect(snapshotID).ToNot(BeEmpty(), "EBS_SNAPSHOT_ID must be set")
+
+		driverConfig := resources.SnapshotDriverConfig{
+			SnapshotID:  snapshotID,
+			Description: "light stemcell builder archival copy",
+		}
+
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
+		driver := ds.ArchiveSnapshotDriver()
+
+		snapshot, err := driver.Create(driverConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshot.ID).ToNot(Equal(snapshotID))
+
+		ec2Client := ec2.New(session.New(), &aws.Config{Region: aws.String(region)})
+		reqOutput, err := ec2Client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{SnapshotIds: []*string{&snapshot.ID}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(len(reqOutput.Snapshots)).To(Equal(1))
+		Expect(*reqOutput.Snapshots[0].Description).To(Equal("light stemcell builder archival copy"))
+
+		snapshotAttributes, err := ec2Client.DescribeSnapshotAttribute(&ec2.DescribeSnapshotAttributeInput{
+			SnapshotId: aws.String(snapshot.ID),
+			Attribute:  aws.String("createVolumePermission"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotAttributes.CreateVolumePermissions).To(BeEmpty())
+
+		_, err = ec2Client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapshot.ID)})
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"io"
 	"light-stemcell-builder/config"
 	"light-stemcell-builder/driver/reqinputs"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
 	"sort"
@@ -15,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 const (
@@ -26,21 +28,30 @@ const (
 
 // SDKCreateAmiDriver uses the AWS SDK to register an AMI from an existing snapshot in EC2
 type SDKCreateAmiDriver struct {
-	ec2Client *ec2.EC2
+	ec2Client ec2iface.EC2API
+	retries   config.Retries
 	region    string
 	logger    *log.Logger
 }
 
 // NewCreateAmiDriver creates a SDKCreateAmiDriver for an AMI from a snapshot in EC2
-func NewCreateAmiDriver(logDest io.Writer, creds config.Credentials) *SDKCreateAmiDriver {
+func NewCreateAmiDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKCreateAmiDriver {
 	logger := log.New(logDest, "SDKCreateAmiDriver ", log.LstdFlags)
 	awsConfig := aws.NewConfig().
 		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
+	awsConfig.Retryer = NewPhaseRetryer(retries.Register, defaultRetries)
 
-	ec2Client := ec2.New(session.New(), awsConfig)
-	return &SDKCreateAmiDriver{ec2Client: ec2Client, region: creds.Region, logger: logger}
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKCreateAmiDriver{ec2Client: ec2Client, retries: retries, region: creds.Region, logger: logger}
+}
+
+// NewCreateAmiDriverWithClient creates a SDKCreateAmiDriver which registers AMIs with the provided EC2 client,
+// such as a fake when testing code which embeds the driver
+func NewCreateAmiDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API, region string, retries config.Retries) *SDKCreateAmiDriver {
+	logger := log.New(logDest, "SDKCreateAmiDriver ", log.LstdFlags)
+	return &SDKCreateAmiDriver{ec2Client: ec2Client, retries: retries, region: region, logger: logger}
 }
 
 // Create registers an AMI from an existing snapshot and optionally makes the AMI publically available
@@ -63,10 +74,12 @@ func (d *SDKCreateAmiDriver) Create(driverConfig resources.AmiDriverConfig) (res
 			return resources.Ami{}, fmt.Errorf("generating register image request for PV AMI: %s", err)
 		}
 
-		reqInput = reqinputs.NewPVAmiRequest(amiName, driverConfig.Description, driverConfig.SnapshotID, kernelID)
+		reqInput = reqinputs.NewPVAmiRequest(amiName, driverConfig.Description, driverConfig.SnapshotID, kernelID, driverConfig.RootDeviceName)
 	case resources.HvmAmiVirtualization:
-		reqInput = reqinputs.NewHVMAmiRequestInput(amiName, driverConfig.Description, driverConfig.SnapshotID)
+		reqInput = reqinputs.NewHVMAmiRequestInput(amiName, driverConfig.Description, driverConfig.SnapshotID, driverConfig.RootDeviceName)
 	}
+	reqinputs.AddEphemeralDevices(reqInput, driverConfig.EphemeralDevices)
+	reqinputs.AddDataVolumes(reqInput, driverConfig.DataVolumes)
 
 	reqOutput, err := d.ec2Client.RegisterImage(reqInput)
 	if err != nil {
@@ -86,6 +99,19 @@ func (d *SDKCreateAmiDriver) Create(driverConfig resources.AmiDriverConfig) (res
 		return resources.Ami{}, fmt.Errorf("waiting for AMI %s to exist: %s", *amiIDptr, err)
 	}
 
+	err = createTags(d.ec2Client, d.retries.Tag, *amiIDptr, driverConfig.Tags)
+	if err != nil {
+		return resources.Ami{}, err
+	}
+
+	// the AMI is registered from the snapshot, so it is the source of its own lineage
+	if len(driverConfig.SnapshotTags) > 0 {
+		err = createTags(d.ec2Client, d.retries.Tag, driverConfig.SnapshotID, resources.LineageTags(driverConfig.SnapshotTags, *amiIDptr, driverConfig.SnapshotID))
+		if err != nil {
+			return resources.Ami{}, err
+		}
+	}
+
 	d.logger.Printf("waiting for AMI: %s to be available\n", *amiIDptr)
 	err = d.ec2Client.WaitUntilImageAvailable(&ec2.DescribeImagesInput{
 		ImageIds: []*string{amiIDptr},
@@ -96,7 +122,7 @@ func (d *SDKCreateAmiDriver) Create(driverConfig resources.AmiDriverConfig) (res
 
 	if driverConfig.Accessibility == resources.PublicAmiAccessibility {
 		d.logger.Printf("making AMI: %s public", *amiIDptr)
-		d.ec2Client.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+		modifyImageAttributeReq, _ := d.ec2Client.ModifyImageAttributeRequest(&ec2.ModifyImageAttributeInput{
 			ImageId: amiIDptr,
 			LaunchPermission: &ec2.LaunchPermissionModifications{
 				Add: []*ec2.LaunchPermission{
@@ -106,6 +132,7 @@ func (d *SDKCreateAmiDriver) Create(driverConfig resources.AmiDriverConfig) (res
 				},
 			},
 		})
+		sendWithRetryer(modifyImageAttributeReq, NewPhaseRetryer(d.retries.Permission, defaultRetries))
 	}
 
 	ami := resources.Ami{
@@ -50,7 +50,7 @@ var _ = Describe("CreateAmiDriver", func() {
 		amiDriverConfig.Accessibility = resources.PublicAmiAccessibility
 		amiDriverConfig.Description = "bosh cpi test ami"
 
-		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
 
 		amiDriver := ds.CreateAmiDriver()
 		ami, err := amiDriver.Create(amiDriverConfig)
@@ -139,7 +139,7 @@ var _ = Describe("CreateAmiDriver", func() {
 		amiDriverConfig.Name = amiName
 		amiDriverConfig.Description = "bosh cpi test ami"
 
-		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
 
 		amiDriver := ds.CreateAmiDriver()
 		ami, err := amiDriver.Create(amiDriverConfig)
@@ -4,26 +4,26 @@ import (
 	"fmt"
 	"io"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
-	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 // The SDKCreateMachineImageDriver uploads a machine image to S3 and creates a presigned URL for GET operations
 type SDKCreateMachineImageDriver struct {
-	s3Client *s3.S3
+	s3Client s3iface.S3API
 	logger   *log.Logger
 }
 
 // NewCreateMachineImageDriver creates a MachineImageDriver for S3 uploads
-func NewCreateMachineImageDriver(logDest io.Writer, creds config.Credentials) *SDKCreateMachineImageDriver {
+func NewCreateMachineImageDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKCreateMachineImageDriver {
 	logger := log.New(logDest, "SDKCreateMachineImageDriver ", log.LstdFlags)
 
 	awsConfig := aws.NewConfig().
@@ -31,13 +31,12 @@ func NewCreateMachineImageDriver(logDest io.Writer, creds config.Credentials) *S
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
 
-	s3Retryer := S3Retryer{}
-	s3Retryer.NumMaxRetries = 50
+	s3Retryer := S3Retryer{PhaseRetryer: NewPhaseRetryer(retries.Upload, defaultUploadRetries)}
 
 	awsConfig.Retryer = s3Retryer
 
 	s3Session := session.New(awsConfig)
-	s3Client := s3.New(s3Session)
+	s3Client := s3.New(s3Session, dualstack.Config("s3", creds))
 
 	return &SDKCreateMachineImageDriver{
 		s3Client: s3Client,
@@ -45,6 +44,16 @@ func NewCreateMachineImageDriver(logDest io.Writer, creds config.Credentials) *S
 	}
 }
 
+// NewCreateMachineImageDriverWithClient creates a SDKCreateMachineImageDriver which uploads machine images with the provided
+// S3 client, such as a fake when testing code which embeds the driver
+func NewCreateMachineImageDriverWithClient(logDest io.Writer, s3Client s3iface.S3API) *SDKCreateMachineImageDriver {
+	logger := log.New(logDest, "SDKCreateMachineImageDriver ", log.LstdFlags)
+	return &SDKCreateMachineImageDriver{
+		s3Client: s3Client,
+		logger:   logger,
+	}
+}
+
 // Create uploads a machine image to S3 and returns a presigned URL
 func (d *SDKCreateMachineImageDriver) Create(driverConfig resources.MachineImageDriverConfig) (resources.MachineImage, error) {
 	createStartTime := time.Now()
@@ -54,31 +63,17 @@ func (d *SDKCreateMachineImageDriver) Create(driverConfig resources.MachineImage
 
 	d.logger.Printf("opening image for upload to S3: %s\n", driverConfig.MachineImagePath)
 
-	f, err := os.Open(driverConfig.MachineImagePath)
-	if err != nil {
-		return resources.MachineImage{}, fmt.Errorf("opening machine image for upload: %s", err)
-	}
-
-	keyName := fmt.Sprintf("bosh-machine-image-%d", time.Now().UnixNano())
+	keyName := namespacedKey(driverConfig.Namespace, fmt.Sprintf("bosh-machine-image-%d", time.Now().UnixNano()))
 	d.logger.Printf("uploading image to s3://%s/%s\n", driverConfig.BucketName, keyName)
 
 	uploadStartTime := time.Now()
-	uploader := s3manager.NewUploaderWithClient(d.s3Client)
-	input := &s3manager.UploadInput{
-		Body:   f,
-		Bucket: aws.String(driverConfig.BucketName),
-		Key:    aws.String(keyName),
-	}
-	if driverConfig.ServerSideEncryption != "" {
-		input.ServerSideEncryption = aws.String(driverConfig.ServerSideEncryption)
-	}
-	_, err = uploader.Upload(input)
-
+	checksums, err := uploadMachineImage(d.s3Client, d.logger, driverConfig, keyName)
 	if err != nil {
-		return resources.MachineImage{}, fmt.Errorf("uploading machine image to S3: %s", err)
+		return resources.MachineImage{}, err
 	}
 
 	d.logger.Printf("finished uploaded image to s3 after %f minutes\n", time.Since(uploadStartTime).Minutes())
+	d.logger.Printf("uploaded image has sha1: %s, sha256: %s, md5: %s\n", checksums.Sha1, checksums.Sha256, checksums.MD5)
 
 	getReq, _ := d.s3Client.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(driverConfig.BucketName),
@@ -107,6 +102,7 @@ func (d *SDKCreateMachineImageDriver) Create(driverConfig resources.MachineImage
 	machineImage := resources.MachineImage{
 		GetURL:     machineImageGetURL,
 		DeleteURLs: []string{machineImageDeleteURL},
+		Checksums:  checksums,
 	}
 
 	return machineImage, nil
@@ -8,30 +8,28 @@ import (
 	"io"
 	"light-stemcell-builder/config"
 	"light-stemcell-builder/driver/manifests"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
-	"math"
-	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-const gbInBytes = 1 << 30
-
 // The SDKCreateMachineImageManifestDriver uploads a machine image to S3 and creates an import volume manifest
 type SDKCreateMachineImageManifestDriver struct {
-	s3Client    *s3.S3
+	s3Client    s3iface.S3API
 	logger      *log.Logger
 	genManifest bool
 }
 
 // NewCreateMachineImageManifestDriver creates a MachineImageDriver machine image manifest generation
-func NewCreateMachineImageManifestDriver(logDest io.Writer, creds config.Credentials) *SDKCreateMachineImageManifestDriver {
+func NewCreateMachineImageManifestDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKCreateMachineImageManifestDriver {
 	logger := log.New(logDest, "SDKCreateMachineImageManifestDriver ", log.LstdFlags)
 
 	awsConfig := aws.NewConfig().
@@ -39,13 +37,12 @@ func NewCreateMachineImageManifestDriver(logDest io.Writer, creds config.Credent
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
 
-	s3Retryer := S3Retryer{}
-	s3Retryer.NumMaxRetries = 50
+	s3Retryer := S3Retryer{PhaseRetryer: NewPhaseRetryer(retries.Upload, defaultUploadRetries)}
 
 	awsConfig.Retryer = s3Retryer
 
 	s3Session := session.New(awsConfig)
-	s3Client := s3.New(s3Session)
+	s3Client := s3.New(s3Session, dualstack.Config("s3", creds))
 
 	return &SDKCreateMachineImageManifestDriver{
 		s3Client: s3Client,
@@ -53,6 +50,16 @@ func NewCreateMachineImageManifestDriver(logDest io.Writer, creds config.Credent
 	}
 }
 
+// NewCreateMachineImageManifestDriverWithClient creates a SDKCreateMachineImageManifestDriver which uploads machine images
+// and their manifest with the provided S3 client, such as a fake when testing code which embeds the driver
+func NewCreateMachineImageManifestDriverWithClient(logDest io.Writer, s3Client s3iface.S3API) *SDKCreateMachineImageManifestDriver {
+	logger := log.New(logDest, "SDKCreateMachineImageManifestDriver ", log.LstdFlags)
+	return &SDKCreateMachineImageManifestDriver{
+		s3Client: s3Client,
+		logger:   logger,
+	}
+}
+
 // Create uploads a machine image to S3 and returns a presigned URL to an import volume manifest
 func (d *SDKCreateMachineImageManifestDriver) Create(driverConfig resources.MachineImageDriverConfig) (resources.MachineImage, error) {
 	createStartTime := time.Now()
@@ -62,31 +69,17 @@ func (d *SDKCreateMachineImageManifestDriver) Create(driverConfig resources.Mach
 
 	d.logger.Printf("opening image for upload to S3: %s\n", driverConfig.MachineImagePath)
 
-	f, err := os.Open(driverConfig.MachineImagePath)
-	if err != nil {
-		return resources.MachineImage{}, fmt.Errorf("opening machine image for upload: %s", err)
-	}
-
-	keyName := fmt.Sprintf("bosh-machine-image-%d", time.Now().UnixNano())
+	keyName := namespacedKey(driverConfig.Namespace, fmt.Sprintf("bosh-machine-image-%d", time.Now().UnixNano()))
 	d.logger.Printf("uploading image to s3://%s/%s\n", driverConfig.BucketName, keyName)
 
 	uploadStartTime := time.Now()
-	uploader := s3manager.NewUploaderWithClient(d.s3Client)
-	input := &s3manager.UploadInput{
-		Body:   f,
-		Bucket: aws.String(driverConfig.BucketName),
-		Key:    aws.String(keyName),
-	}
-	if driverConfig.ServerSideEncryption != "" {
-		input.ServerSideEncryption = aws.String(driverConfig.ServerSideEncryption)
-	}
-	_, err = uploader.Upload(input)
-
+	checksums, err := uploadMachineImage(d.s3Client, d.logger, driverConfig, keyName)
 	if err != nil {
-		return resources.MachineImage{}, fmt.Errorf("uploading machine image to S3: %s", err)
+		return resources.MachineImage{}, err
 	}
 
 	d.logger.Printf("finished uploaded image to s3 after %f minutes\n", time.Since(uploadStartTime).Minutes())
+	d.logger.Printf("uploaded image has sha1: %s, sha256: %s, md5: %s\n", checksums.Sha1, checksums.Sha256, checksums.MD5)
 
 	headReqOutput, err := d.s3Client.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(driverConfig.BucketName),
@@ -105,7 +98,12 @@ func (d *SDKCreateMachineImageManifestDriver) Create(driverConfig resources.Mach
 	volumeSizeGB := driverConfig.VolumeSizeGB
 	if volumeSizeGB == 0 {
 		// default to size of image if VolumeSize is not provided
-		volumeSizeGB = int64(math.Ceil(float64(*sizeInBytesPtr) / gbInBytes))
+		volumeSizeGB = VolumeSizeGB(*sizeInBytesPtr)
+	}
+
+	err = CheckVolumeSize(volumeSizeGB, *sizeInBytesPtr, driverConfig.FileFormat)
+	if err != nil {
+		return resources.MachineImage{}, err
 	}
 
 	m, err := d.generateManifest(driverConfig.BucketName, keyName, *sizeInBytesPtr, volumeSizeGB, driverConfig.FileFormat)
@@ -113,11 +111,12 @@ func (d *SDKCreateMachineImageManifestDriver) Create(driverConfig resources.Mach
 		return resources.MachineImage{}, fmt.Errorf("Failed to generate machine image manifest: %s", err)
 	}
 
-	manifestURL, err := d.uploadManifest(driverConfig.BucketName, driverConfig.ServerSideEncryption, m)
+	manifestURL, err := d.uploadManifest(driverConfig.BucketName, driverConfig.ServerSideEncryption, driverConfig.Namespace, m)
 
 	machineImage := resources.MachineImage{
 		GetURL:     manifestURL,
 		DeleteURLs: []string{m.SelfDestructURL, m.Parts.Part.DeleteURL},
+		Checksums:  checksums,
 	}
 
 	return machineImage, nil
@@ -176,9 +175,9 @@ func (d *SDKCreateMachineImageManifestDriver) generateManifest(bucketName string
 	return manifests.New(imageProps), nil
 }
 
-func (d *SDKCreateMachineImageManifestDriver) uploadManifest(bucketName, serverSideEncryption string, m *manifests.ImportVolumeManifest) (string, error) {
+func (d *SDKCreateMachineImageManifestDriver) uploadManifest(bucketName, serverSideEncryption, namespace string, m *manifests.ImportVolumeManifest) (string, error) {
 
-	manifestKey := fmt.Sprintf("bosh-machine-image-manifest-%d", time.Now().UnixNano())
+	manifestKey := namespacedKey(namespace, fmt.Sprintf("bosh-machine-image-manifest-%d", time.Now().UnixNano()))
 
 	// create presigned GET request for the manifest
 	getReq, _ := d.s3Client.GetObjectRequest(&s3.GetObjectInput{
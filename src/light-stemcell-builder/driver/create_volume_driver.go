@@ -4,12 +4,11 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"light-stemcell-builder/config"
 	"light-stemcell-builder/driver/manifests"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
-	"net/http"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -17,25 +16,37 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/private/waiter"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 // SDKCreateVolumeDriver is an implementation of the resources VolumeDriver that
 // handles creation of a volume from a machine image on AWS
 type SDKCreateVolumeDriver struct {
-	ec2Client *ec2.EC2
-	logger    *log.Logger
+	ec2Client     ec2iface.EC2API
+	retries       config.Retries
+	region        string
+	manifestFetch config.ManifestFetch
+	logger        *log.Logger
 }
 
 // NewCreateVolumeDriver creates a SDKCreateVolumeDriver for importing a volume from a machine image url
-func NewCreateVolumeDriver(logDest io.Writer, creds config.Credentials) *SDKCreateVolumeDriver {
+func NewCreateVolumeDriver(logDest io.Writer, creds config.Credentials, retries config.Retries, manifestFetch config.ManifestFetch) *SDKCreateVolumeDriver {
 	logger := log.New(logDest, "SDKCreateVolumeDriver ", log.LstdFlags)
 	awsConfig := aws.NewConfig().
 		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
+	awsConfig.Retryer = NewPhaseRetryer(retries.Import, defaultRetries)
 
-	ec2Client := ec2.New(session.New(), awsConfig)
-	return &SDKCreateVolumeDriver{ec2Client: ec2Client, logger: logger}
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKCreateVolumeDriver{ec2Client: ec2Client, retries: retries, region: creds.Region, manifestFetch: manifestFetch, logger: logger}
+}
+
+// NewCreateVolumeDriverWithClient creates a SDKCreateVolumeDriver which imports volumes with the provided EC2 client,
+// such as a fake when testing code which embeds the driver
+func NewCreateVolumeDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API, region string, retries config.Retries, manifestFetch config.ManifestFetch) *SDKCreateVolumeDriver {
+	logger := log.New(logDest, "SDKCreateVolumeDriver ", log.LstdFlags)
+	return &SDKCreateVolumeDriver{ec2Client: ec2Client, retries: retries, region: region, manifestFetch: manifestFetch, logger: logger}
 }
 
 // Create makes an EBS volume from a machine image URL in the first availability zone returned from DescribeAvailabilityZones
@@ -55,25 +66,18 @@ func (d *SDKCreateVolumeDriver) Create(driverConfig resources.VolumeDriverConfig
 	}
 
 	if len(availabilityZoneOutput.AvailabilityZones) == 0 {
-		return resources.Volume{}, fmt.Errorf("finding any available availability zones in region %s", *d.ec2Client.Config.Region)
+		return resources.Volume{}, fmt.Errorf("finding any available availability zones in region %s", d.region)
 	}
 
 	availabilityZone := availabilityZoneOutput.AvailabilityZones[0].ZoneName
-	fetchManifestResp, err := http.Get(driverConfig.MachineImageManifestURL)
+	manifestClient, err := NewManifestClient(d.manifestFetch)
 	if err != nil {
-		return resources.Volume{}, fmt.Errorf("fetching import volume manifest: %s", err)
+		return resources.Volume{}, err
 	}
 
-	defer fetchManifestResp.Body.Close()
-	manifestBytes, err := ioutil.ReadAll(fetchManifestResp.Body)
+	manifestBytes, err := FetchManifest(manifestClient, d.retries.ManifestFetch, driverConfig.MachineImageManifestURL)
 	if err != nil {
-		return resources.Volume{}, fmt.Errorf("reading import volume manifest from response: %s", err)
-	}
-	if fetchManifestResp.StatusCode < 200 || fetchManifestResp.StatusCode >= 300 {
-		return resources.Volume{}, fmt.Errorf("Received invalid response code '%d' fetching resource '%s': %s",
-			fetchManifestResp.StatusCode,
-			driverConfig.MachineImageManifestURL,
-			manifestBytes)
+		return resources.Volume{}, err
 	}
 
 	m := manifests.ImportVolumeManifest{}
@@ -83,17 +87,7 @@ func (d *SDKCreateVolumeDriver) Create(driverConfig resources.VolumeDriverConfig
 		return resources.Volume{}, fmt.Errorf("deserializing import volume manifest. Bytes:\n%s\nError: %s", manifestBytes, err)
 	}
 
-	reqOutput, err := d.ec2Client.ImportVolume(&ec2.ImportVolumeInput{
-		AvailabilityZone: availabilityZone,
-		Image: &ec2.DiskImageDetail{
-			ImportManifestUrl: aws.String(driverConfig.MachineImageManifestURL),
-			Format:            aws.String(m.FileFormat),
-			Bytes:             aws.Int64(m.VolumeSizeGB),
-		},
-		Volume: &ec2.VolumeDetail{
-			Size: aws.Int64(m.VolumeSizeGB),
-		},
-	})
+	reqOutput, err := d.ec2Client.ImportVolume(ImportVolumeInput(driverConfig.MachineImageManifestURL, availabilityZone, m))
 
 	if err != nil {
 		return resources.Volume{}, fmt.Errorf("creating import volume task: %s", err)
@@ -128,6 +122,19 @@ func (d *SDKCreateVolumeDriver) Create(driverConfig resources.VolumeDriverConfig
 		return resources.Volume{}, fmt.Errorf("volume ID nil")
 	}
 
+	err = tagNamespace(d.ec2Client, d.retries.Tag, driverConfig.Namespace, *volumeIDptr)
+	if err != nil {
+		return resources.Volume{}, err
+	}
+
+	if !driverConfig.DeleteAfter.IsZero() {
+		deleteAfter := driverConfig.DeleteAfter.UTC().Format(time.RFC3339)
+		err = createTags(d.ec2Client, d.retries.Tag, *volumeIDptr, map[string]string{resources.DeleteAfterTagKey: deleteAfter})
+		if err != nil {
+			return resources.Volume{}, err
+		}
+	}
+
 	d.logger.Printf("waiting for volume to be available: %s\n", *volumeIDptr)
 	waitStartTime = time.Now()
 	err = d.ec2Client.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{VolumeIds: []*string{volumeIDptr}})
@@ -136,6 +143,23 @@ func (d *SDKCreateVolumeDriver) Create(driverConfig resources.VolumeDriverConfig
 	return resources.Volume{ID: *volumeIDptr}, nil
 }
 
+// ImportVolumeInput builds the request importing the machine image described by the manifest m at manifestURL as a
+// volume in availabilityZone. EC2 takes the size of both the image and the volume in GiB, the exact size of the
+// image in bytes is only given by the manifest.
+func ImportVolumeInput(manifestURL string, availabilityZone *string, m manifests.ImportVolumeManifest) *ec2.ImportVolumeInput {
+	return &ec2.ImportVolumeInput{
+		AvailabilityZone: availabilityZone,
+		Image: &ec2.DiskImageDetail{
+			ImportManifestUrl: aws.String(manifestURL),
+			Format:            aws.String(m.FileFormat),
+			Bytes:             aws.Int64(m.VolumeSizeGB),
+		},
+		Volume: &ec2.VolumeDetail{
+			Size: aws.Int64(m.VolumeSizeGB),
+		},
+	}
+}
+
 func (d *SDKCreateVolumeDriver) waitUntilImageConversionTaskCompleted(input *ec2.DescribeConversionTasksInput) error {
 	waiterCfg := waiter.Config{
 		Operation:   "DescribeConversionTasks",
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
 	"net/http"
@@ -15,11 +16,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 // The SDKDeleteMachineImageDriver deletes a previously uploaded machine image and manifest from S3
 type SDKDeleteMachineImageDriver struct {
-	s3Client *s3.S3
+	s3Client s3iface.S3API
 	logger   *log.Logger
 }
 
@@ -33,7 +35,7 @@ func NewDeleteMachineImageDriver(logDest io.Writer, creds config.Credentials) *S
 		WithLogger(newDriverLogger(logger))
 
 	s3Session := session.New(awsConfig)
-	s3Client := s3.New(s3Session)
+	s3Client := s3.New(s3Session, dualstack.Config("s3", creds))
 
 	return &SDKDeleteMachineImageDriver{
 		s3Client: s3Client,
@@ -3,6 +3,7 @@ package driver
 import (
 	"io"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
 	"time"
@@ -11,11 +12,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 // SDKDeleteVolumeDriver handles deletion of a volume from a machine image on AWS
 type SDKDeleteVolumeDriver struct {
-	ec2Client *ec2.EC2
+	ec2Client ec2iface.EC2API
 	logger    *log.Logger
 }
 
@@ -27,7 +29,14 @@ func NewDeleteVolumeDriver(logDest io.Writer, creds config.Credentials) *SDKDele
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
 
-	ec2Client := ec2.New(session.New(), awsConfig)
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKDeleteVolumeDriver{ec2Client: ec2Client, logger: logger}
+}
+
+// NewDeleteVolumeDriverWithClient creates a SDKDeleteVolumeDriver which deletes volumes with the provided EC2 client,
+// such as a fake when testing code which embeds the driver
+func NewDeleteVolumeDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API) *SDKDeleteVolumeDriver {
+	logger := log.New(logDest, "SDKDeleteVolumeDriver ", log.LstdFlags)
 	return &SDKDeleteVolumeDriver{ec2Client: ec2Client, logger: logger}
 }
 
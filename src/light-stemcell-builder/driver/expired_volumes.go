@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/resources"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// DeleteExpiredVolumes deletes the available volumes whose resources.DeleteAfterTagKey is before now, as left by
+// a delayed volume_deletion, returning the IDs of the deleted volumes. Volumes which are attached, or whose tag
+// cannot be parsed, are left alone.
+func DeleteExpiredVolumes(ec2Client ec2iface.EC2API, now time.Time) ([]string, error) {
+	output, err := ec2Client.DescribeVolumes(&ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag-key"), Values: []*string{aws.String(resources.DeleteAfterTagKey)}},
+			{Name: aws.String("status"), Values: []*string{aws.String(ec2.VolumeStateAvailable)}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing volumes tagged with %s: %s", resources.DeleteAfterTagKey, err)
+	}
+
+	deleted := []string{}
+	for _, volume := range output.Volumes {
+		deleteAfter, ok := volumeDeleteAfter(volume)
+		if !ok || now.Before(deleteAfter) {
+			continue
+		}
+
+		_, err = ec2Client.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: volume.VolumeId})
+		if err != nil {
+			return deleted, fmt.Errorf("deleting volume %s: %s", aws.StringValue(volume.VolumeId), err)
+		}
+		deleted = append(deleted, aws.StringValue(volume.VolumeId))
+	}
+
+	return deleted, nil
+}
+
+func volumeDeleteAfter(volume *ec2.Volume) (time.Time, bool) {
+	for _, tag := range volume.Tags {
+		if aws.StringValue(tag.Key) != resources.DeleteAfterTagKey {
+			continue
+		}
+
+		deleteAfter, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		return deleteAfter, err == nil
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,50 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/driver"
+	"light-stemcell-builder/resources"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeVolumesEC2 struct {
+	ec2iface.EC2API
+	volumes []*ec2.Volume
+	deleted []string
+}
+
+func (f *fakeVolumesEC2) DescribeVolumes(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	return &ec2.DescribeVolumesOutput{Volumes: f.volumes}, nil
+}
+
+func (f *fakeVolumesEC2) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	f.deleted = append(f.deleted, aws.StringValue(input.VolumeId))
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+var _ = Describe("DeleteExpiredVolumes", func() {
+	volume := func(id string, deleteAfter string) *ec2.Volume {
+		return &ec2.Volume{
+			VolumeId: aws.String(id),
+			Tags:     []*ec2.Tag{{Key: aws.String(resources.DeleteAfterTagKey), Value: aws.String(deleteAfter)}},
+		}
+	}
+
+	It("deletes only the volumes whose time to be deleted has passed", func() {
+		ec2Client := &fakeVolumesEC2{volumes: []*ec2.Volume{
+			volume("vol-expired", "2026-01-01T00:00:00Z"),
+			volume("vol-kept", "2026-01-03T00:00:00Z"),
+			volume("vol-unparsable", "tomorrow"),
+		}}
+
+		deleted, err := driver.DeleteExpiredVolumes(ec2Client, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(Equal([]string{"vol-expired"}))
+		Expect(ec2Client.deleted).To(Equal([]string{"vol-expired"}))
+	})
+})
@@ -139,7 +139,7 @@ var _ = Describe("Machine Image Lifecycle", func() {
 	})
 
 	testMachineImageLifecycle = func(driverConfig resources.MachineImageDriverConfig, cb ...func(resources.MachineImage)) {
-		createDriver := driver.NewCreateMachineImageDriver(GinkgoWriter, creds)
+		createDriver := driver.NewCreateMachineImageDriver(GinkgoWriter, creds, config.Retries{})
 
 		machineImage, err := createDriver.Create(driverConfig)
 		Expect(err).ToNot(HaveOccurred())
@@ -165,7 +165,7 @@ var _ = Describe("Machine Image Lifecycle", func() {
 	}
 
 	testMachineImageManifestLifecycle = func(driverConfig resources.MachineImageDriverConfig, cb ...func(resources.MachineImage, manifests.ImportVolumeManifest)) {
-		createDriver := driver.NewCreateMachineImageManifestDriver(GinkgoWriter, creds)
+		createDriver := driver.NewCreateMachineImageManifestDriver(GinkgoWriter, creds, config.Retries{})
 
 		machineImage, err := createDriver.Create(driverConfig)
 		Expect(err).ToNot(HaveOccurred())
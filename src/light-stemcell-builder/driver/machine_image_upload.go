@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/resources"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const mbInBytes = 1 << 20
+
+// Content type and metadata key set on staged machine images, checked before they are imported
+const (
+	stagedImageContentType = "application/octet-stream"
+	imageFormatMetadataKey = "image-format"
+)
+
+// uploadMachineImage uploads the machine image to S3 under keyName, computing its checksums from the parts
+// as they are read for the upload
+func uploadMachineImage(s3Client s3iface.S3API, logger *log.Logger, driverConfig resources.MachineImageDriverConfig, keyName string) (resources.MachineImageChecksums, error) {
+	f, err := os.Open(driverConfig.MachineImagePath)
+	if err != nil {
+		return resources.MachineImageChecksums{}, fmt.Errorf("opening machine image for upload: %s", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return resources.MachineImageChecksums{}, fmt.Errorf("reading machine image size: %s", err)
+	}
+
+	partSize, concurrency, err := UploadPartSizing(info.Size(), driverConfig.MaxUploadMemoryMB)
+	if err != nil {
+		return resources.MachineImageChecksums{}, err
+	}
+
+	checksums := NewChecksumWriter()
+	uploader := PartUploader{
+		S3Client:       s3Client,
+		PartSize:       partSize,
+		Concurrency:    concurrency,
+		MaxPartRetries: defaultPartRetries,
+		RetryDelay:     defaultPartRetryDelay,
+		MaxUploadRate:  driverConfig.MaxUploadRate,
+		Hash:           checksums,
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(driverConfig.BucketName),
+		Key:         aws.String(keyName),
+		ContentType: aws.String(stagedImageContentType),
+		Metadata:    map[string]*string{imageFormatMetadataKey: aws.String(strings.ToLower(driverConfig.FileFormat))},
+	}
+	if driverConfig.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(driverConfig.ServerSideEncryption)
+	}
+	retries, err := uploader.Upload(input, f, info.Size())
+	logPartRetries(logger, retries, (info.Size()+partSize-1)/partSize)
+	if err != nil {
+		return resources.MachineImageChecksums{}, fmt.Errorf("uploading machine image to S3: %s", err)
+	}
+
+	// a truncated image only fails its import after a long conversion, so it is caught before the import starts
+	err = VerifyStagedImage(s3Client, driverConfig.BucketName, keyName, info.Size(), driverConfig.FileFormat)
+	if err != nil {
+		_, deleteErr := s3Client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(driverConfig.BucketName), Key: aws.String(keyName)})
+		if deleteErr != nil {
+			logger.Printf("deleting staged image s3://%s/%s: %s\n", driverConfig.BucketName, keyName, deleteErr)
+		}
+		return resources.MachineImageChecksums{}, err
+	}
+
+	return checksums.Checksums(), nil
+}
+
+// VerifyStagedImage checks the object S3 holds for a machine image of sizeBytes in format, returning an error
+// when the sizes differ or the object does not carry the content type and format it was uploaded with
+func VerifyStagedImage(s3Client s3iface.S3API, bucket string, key string, sizeBytes int64, format string) error {
+	output, err := s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("fetching properties of staged image s3://%s/%s: %s", bucket, key, err)
+	}
+
+	if aws.Int64Value(output.ContentLength) != sizeBytes {
+		return fmt.Errorf("staged image s3://%s/%s is %d bytes but the machine image is %d bytes, the upload is incomplete",
+			bucket, key, aws.Int64Value(output.ContentLength), sizeBytes)
+	}
+
+	if contentType := aws.StringValue(output.ContentType); contentType != stagedImageContentType {
+		return fmt.Errorf("staged image s3://%s/%s has content type %q rather than %q", bucket, key, contentType, stagedImageContentType)
+	}
+
+	// the SDK canonicalizes the case of metadata keys in responses
+	stagedFormat := ""
+	for metadataKey, value := range output.Metadata {
+		if strings.EqualFold(metadataKey, imageFormatMetadataKey) {
+			stagedFormat = aws.StringValue(value)
+		}
+	}
+	if !strings.EqualFold(stagedFormat, format) {
+		return fmt.Errorf("staged image s3://%s/%s is tagged with format %q rather than %q", bucket, key, stagedFormat, strings.ToLower(format))
+	}
+
+	return nil
+}
+
+// logPartRetries logs the number of times each part of the upload had to be sent again
+func logPartRetries(logger *log.Logger, retries PartRetries, partCount int64) {
+	if len(retries) == 0 {
+		return
+	}
+
+	partNumbers := []int64{}
+	for partNumber := range retries {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Slice(partNumbers, func(i, j int) bool { return partNumbers[i] < partNumbers[j] })
+
+	for _, partNumber := range partNumbers {
+		logger.Printf("part %d of %d was sent again %d times\n", partNumber, partCount, retries[partNumber])
+	}
+	logger.Printf("%d of %d parts were retried\n", len(retries), partCount)
+}
+
+// UploadPartSizing picks the part size and concurrency for uploading an image of imageSizeBytes.
+// Parts are buffered in memory, one per concurrent upload plus the one being read, so a non-zero
+// maxMemoryMB lowers the concurrency until those buffers fit within that budget.
+func UploadPartSizing(imageSizeBytes int64, maxMemoryMB int64) (int64, int, error) {
+	// S3 accepts at most MaxUploadParts parts, so larger images get larger parts
+	partSize := s3manager.DefaultUploadPartSize
+	if imageSizeBytes/partSize >= s3manager.MaxUploadParts {
+		partSize = imageSizeBytes/s3manager.MaxUploadParts + 1
+	}
+
+	concurrency := s3manager.DefaultUploadConcurrency
+	if maxMemoryMB <= 0 {
+		return partSize, concurrency, nil
+	}
+
+	maxBufferedParts := maxMemoryMB * mbInBytes / partSize
+	if maxBufferedParts < 2 {
+		return 0, 0, fmt.Errorf("max upload memory of %d MB is too small, at least %d MB is required to upload this image", maxMemoryMB, (2*partSize+mbInBytes-1)/mbInBytes)
+	}
+
+	if maxBufferedParts-1 < int64(concurrency) {
+		concurrency = int(maxBufferedParts - 1)
+	}
+
+	return partSize, concurrency, nil
+}
@@ -0,0 +1,95 @@
+package driver_test
+
+import (
+	"errors"
+	"light-stemcell-builder/driver"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UploadPartSizing", func() {
+	const mb = 1 << 20
+
+	It("uses the uploader defaults when no memory limit is given", func() {
+		partSize, concurrency, err := driver.UploadPartSizing(3*1024*mb, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(partSize).To(Equal(s3manager.DefaultUploadPartSize))
+		Expect(concurrency).To(Equal(s3manager.DefaultUploadConcurrency))
+	})
+
+	It("grows the part size so the image fits within the maximum number of parts", func() {
+		imageSize := int64(100 * 1024 * mb)
+		partSize, _, err := driver.UploadPartSizing(imageSize, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(partSize * s3manager.MaxUploadParts).To(BeNumerically(">=", imageSize))
+	})
+
+	It("lowers the concurrency to fit the buffered parts within the memory limit", func() {
+		partSize, concurrency, err := driver.UploadPartSizing(3*1024*mb, 15)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(partSize).To(Equal(s3manager.DefaultUploadPartSize))
+		Expect(concurrency).To(Equal(2))
+	})
+
+	It("does not raise the concurrency above the default for generous memory limits", func() {
+		_, concurrency, err := driver.UploadPartSizing(3*1024*mb, 4096)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(concurrency).To(Equal(s3manager.DefaultUploadConcurrency))
+	})
+
+	It("returns an error when the memory limit cannot hold two parts", func() {
+		_, _, err := driver.UploadPartSizing(3*1024*mb, 9)
+		Expect(err).To(MatchError("max upload memory of 9 MB is too small, at least 10 MB is required to upload this image"))
+	})
+})
+
+type fakeHeadObjectS3 struct {
+	s3iface.S3API
+	output *s3.HeadObjectOutput
+	err    error
+}
+
+func (f *fakeHeadObjectS3) HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return f.output, f.err
+}
+
+var _ = Describe("VerifyStagedImage", func() {
+	var fakeS3 *fakeHeadObjectS3
+
+	BeforeEach(func() {
+		fakeS3 = &fakeHeadObjectS3{output: &s3.HeadObjectOutput{
+			ContentLength: aws.Int64(1024),
+			ContentType:   aws.String("application/octet-stream"),
+			Metadata:      map[string]*string{"Image-Format": aws.String("raw")},
+		}}
+	})
+
+	It("accepts an object matching the size and format of the machine image", func() {
+		Expect(driver.VerifyStagedImage(fakeS3, "some-bucket", "some-key", 1024, "RAW")).To(Succeed())
+	})
+
+	It("rejects a truncated upload", func() {
+		err := driver.VerifyStagedImage(fakeS3, "some-bucket", "some-key", 2048, "RAW")
+		Expect(err).To(MatchError("staged image s3://some-bucket/some-key is 1024 bytes but the machine image is 2048 bytes, the upload is incomplete"))
+	})
+
+	It("rejects an object with another content type or format", func() {
+		err := driver.VerifyStagedImage(fakeS3, "some-bucket", "some-key", 1024, "vmdk")
+		Expect(err).To(MatchError(`staged image s3://some-bucket/some-key is tagged with format "raw" rather than "vmdk"`))
+
+		fakeS3.output.ContentType = aws.String("text/plain")
+		err = driver.VerifyStagedImage(fakeS3, "some-bucket", "some-key", 1024, "RAW")
+		Expect(err).To(MatchError(`staged image s3://some-bucket/some-key has content type "text/plain" rather than "application/octet-stream"`))
+	})
+
+	It("returns an error when the object cannot be found", func() {
+		fakeS3.err = errors.New("NotFound")
+		err := driver.VerifyStagedImage(fakeS3, "some-bucket", "some-key", 1024, "RAW")
+		Expect(err).To(MatchError("fetching properties of staged image s3://some-bucket/some-key: NotFound"))
+	})
+})
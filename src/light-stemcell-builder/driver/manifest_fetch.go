@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"light-stemcell-builder/config"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defaults of the HTTP client used to download import volume manifests, which are short documents behind a
+// presigned URL, so a fetch taking a minute is considered hung
+const (
+	defaultManifestFetchTimeout   = 60 * time.Second
+	defaultManifestFetchRetries   = 3
+	defaultManifestFetchBaseDelay = time.Second
+)
+
+// NewManifestClient creates the HTTP client with which import volume manifests are downloaded. Without a proxy_url
+// or ca_cert_path the client uses the default transport, and so the proxy of the environment and the system's CAs.
+func NewManifestClient(fetch config.ManifestFetch) (*http.Client, error) {
+	client := &http.Client{Timeout: defaultManifestFetchTimeout}
+	if fetch.TimeoutSeconds > 0 {
+		client.Timeout = time.Duration(fetch.TimeoutSeconds) * time.Second
+	}
+
+	if fetch.ProxyURL == "" && fetch.CACertPath == "" {
+		return client, nil
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if fetch.ProxyURL != "" {
+		proxyURL, err := url.Parse(fetch.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifest fetch proxy URL: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if fetch.CACertPath != "" {
+		caCerts, err := ioutil.ReadFile(fetch.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest fetch CA certificates: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in %s", fetch.CACertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client.Transport = transport
+	return client, nil
+}
+
+// FetchManifest downloads the document at manifestURL, retrying network errors, throttling and server errors
+// after a doubling delay as configured by policy. Other responses outside of 2xx fail straight away, as a
+// presigned URL which is expired or forbidden won't get any better.
+func FetchManifest(client *http.Client, policy config.RetryPolicy, manifestURL string) ([]byte, error) {
+	maxRetries := defaultManifestFetchRetries
+	if policy.MaxRetries > 0 {
+		maxRetries = policy.MaxRetries
+	}
+	delay := defaultManifestFetchBaseDelay
+	if policy.BaseDelayMS > 0 {
+		delay = time.Duration(policy.BaseDelayMS) * time.Millisecond
+	}
+
+	for retried := 0; ; retried++ {
+		manifestBytes, retryable, err := fetchManifestOnce(client, manifestURL)
+		if err == nil {
+			return manifestBytes, nil
+		}
+
+		if !retryable {
+			return nil, err
+		}
+		if retried >= maxRetries {
+			return nil, fmt.Errorf("%s after %d retries", err, retried)
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func fetchManifestOnce(client *http.Client, manifestURL string) ([]byte, bool, error) {
+	resp, err := client.Get(manifestURL)
+	if err != nil {
+		return nil, true, fmt.Errorf("fetching import volume manifest: %s", err)
+	}
+
+	defer resp.Body.Close()
+	manifestBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("reading import volume manifest from response: %s", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("Received invalid response code '%d' fetching resource '%s': %s",
+			resp.StatusCode,
+			manifestURL,
+			manifestBytes)
+	}
+
+	return manifestBytes, false, nil
+}
@@ -0,0 +1,79 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/driver"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FetchManifest", func() {
+	var requests int32
+	var failures int32
+	var failureCode int
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= failures {
+				w.WriteHeader(failureCode)
+				w.Write([]byte("try again"))
+				return
+			}
+			w.Write([]byte("<manifest/>"))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	policy := config.RetryPolicy{MaxRetries: 2, BaseDelayMS: 1}
+
+	It("retries server errors with backoff until the manifest is fetched", func() {
+		failures, failureCode = 2, http.StatusServiceUnavailable
+
+		manifest, err := driver.FetchManifest(server.Client(), policy, server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(manifest)).To(Equal("<manifest/>"))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(3)))
+	})
+
+	It("gives up once the retries are used up", func() {
+		failures, failureCode = 3, http.StatusTooManyRequests
+
+		_, err := driver.FetchManifest(server.Client(), policy, server.URL)
+		Expect(err).To(MatchError(ContainSubstring("Received invalid response code '429'")))
+		Expect(err).To(MatchError(ContainSubstring("after 2 retries")))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(3)))
+	})
+
+	It("does not retry a URL which is forbidden", func() {
+		failures, failureCode = 1, http.StatusForbidden
+
+		_, err := driver.FetchManifest(server.Client(), policy, server.URL)
+		Expect(err).To(MatchError(ContainSubstring("Received invalid response code '403'")))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+
+	It("times out a fetch which hangs", func() {
+		hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+		}))
+		defer hung.Close()
+
+		client, err := driver.NewManifestClient(config.ManifestFetch{})
+		Expect(err).ToNot(HaveOccurred())
+		client.Timeout = 10 * time.Millisecond
+
+		_, err = driver.FetchManifest(client, config.RetryPolicy{MaxRetries: 1, BaseDelayMS: 1}, hung.URL)
+		Expect(err).To(MatchError(ContainSubstring("fetching import volume manifest")))
+		Expect(err).To(MatchError(ContainSubstring("after 1 retries")))
+	})
+})
@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/resources"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// namespacedKey places an S3 object under the namespace of the build, if any
+func namespacedKey(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// tagNamespace tags the EC2 resource with the namespace of the build, if any, retrying with the tag policy
+func tagNamespace(ec2Client ec2iface.EC2API, tagRetries config.RetryPolicy, namespace string, resourceID string) error {
+	if namespace == "" {
+		return nil
+	}
+
+	err := createTags(ec2Client, tagRetries, resourceID, map[string]string{resources.NamespaceTagKey: namespace})
+	if err != nil {
+		return fmt.Errorf("tagging with namespace %s: %s", namespace, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Number of times a part is sent again once the SDK has exhausted its retries of the UploadPart request
+const (
+	defaultPartRetries    = 3
+	defaultPartRetryDelay = time.Second
+)
+
+// PartUploader uploads an image to S3 as a multipart upload. The image is read once, in order, a part at a time,
+// and each part is buffered until it has been sent, so a part which still fails after the SDK's retries is sent
+// again after a doubling delay, rather than the whole upload being aborted.
+type PartUploader struct {
+	S3Client       s3iface.S3API
+	PartSize       int64
+	Concurrency    int
+	MaxPartRetries int
+	RetryDelay     time.Duration
+	// MaxUploadRate limits the reads of all parts together to a number of bytes per second, when positive
+	MaxUploadRate int64
+	// Hash, when set, is written the bytes of every part in part order as the image is read, so the image can
+	// be hashed without reading it a second time
+	Hash io.Writer
+}
+
+// PartRetries counts the number of times each part had to be sent again, by part number. Parts which were sent
+// successfully the first time are left out.
+type PartRetries map[int64]int
+
+// Upload uploads size bytes of image to the bucket and key of input, aborting the multipart upload when a part
+// cannot be read or sent within MaxPartRetries. A part is read only once a worker is free to send it, so at most
+// Concurrency parts, plus the one being read, are held in memory.
+func (u PartUploader) Upload(input *s3.CreateMultipartUploadInput, image io.ReaderAt, size int64) (PartRetries, error) {
+	created, err := u.S3Client.CreateMultipartUpload(input)
+	if err != nil {
+		return nil, fmt.Errorf("creating multipart upload: %s", err)
+	}
+	uploadID := created.UploadId
+
+	var rate *throttle
+	if u.MaxUploadRate > 0 {
+		rate = newThrottle(u.MaxUploadRate)
+	}
+
+	partCount := (size + u.PartSize - 1) / u.PartSize
+	if partCount == 0 {
+		// an empty image is still uploaded as a single empty part
+		partCount = 1
+	}
+
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		parts    []*s3.CompletedPart
+		retries  = PartRetries{}
+	)
+
+	partBodies := make(chan partBody)
+	for i := 0; i < u.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for body := range partBodies {
+				part, retried, err := u.uploadPart(input, uploadID, body)
+
+				mutex.Lock()
+				if retried > 0 {
+					retries[body.partNumber] = retried
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					parts = append(parts, part)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for partNumber := int64(1); partNumber <= partCount; partNumber++ {
+		mutex.Lock()
+		failed := firstErr != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+
+		content, err := u.readPart(image, size, partNumber, rate)
+		if err != nil {
+			mutex.Lock()
+			firstErr = err
+			mutex.Unlock()
+			break
+		}
+		partBodies <- partBody{partNumber: partNumber, content: content}
+	}
+	close(partBodies)
+	wg.Wait()
+
+	if firstErr != nil {
+		u.abort(input, uploadID)
+		return retries, firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+	_, err = u.S3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		u.abort(input, uploadID)
+		return retries, fmt.Errorf("completing multipart upload: %s", err)
+	}
+
+	return retries, nil
+}
+
+// partBody is a part read from the image, waiting to be sent
+type partBody struct {
+	partNumber int64
+	content    []byte
+}
+
+// readPart reads the range of the image for partNumber, counting it towards the rate and writing it to Hash
+func (u PartUploader) readPart(image io.ReaderAt, size int64, partNumber int64, rate *throttle) ([]byte, error) {
+	offset := (partNumber - 1) * u.PartSize
+	length := u.PartSize
+	if offset+length > size {
+		length = size - offset
+	}
+
+	var reader io.Reader = io.NewSectionReader(image, offset, length)
+	if rate != nil {
+		reader = rate.wrap(reader)
+	}
+	if u.Hash != nil {
+		reader = io.TeeReader(reader, u.Hash)
+	}
+
+	content := make([]byte, length)
+	_, err := io.ReadFull(reader, content)
+	if err != nil {
+		return nil, fmt.Errorf("reading part %d: %s", partNumber, err)
+	}
+	return content, nil
+}
+
+// uploadPart sends a part, sending it again from its buffer for each retry, and returns the number of retries it took
+func (u PartUploader) uploadPart(input *s3.CreateMultipartUploadInput, uploadID *string, body partBody) (*s3.CompletedPart, int, error) {
+	delay := u.RetryDelay
+	for retried := 0; ; retried++ {
+		output, err := u.S3Client.UploadPart(&s3.UploadPartInput{
+			Bucket:     input.Bucket,
+			Key:        input.Key,
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(body.partNumber),
+			Body:       bytes.NewReader(body.content),
+		})
+		if err == nil {
+			return &s3.CompletedPart{ETag: output.ETag, PartNumber: aws.Int64(body.partNumber)}, retried, nil
+		}
+
+		if retried >= u.MaxPartRetries {
+			return nil, retried, fmt.Errorf("uploading part %d after %d retries: %s", body.partNumber, retried, err)
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (u PartUploader) abort(input *s3.CreateMultipartUploadInput, uploadID *string) {
+	// the upload has already failed, a failure to abort it only leaves parts for the bucket's lifecycle rules
+	u.S3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: uploadID,
+	})
+}
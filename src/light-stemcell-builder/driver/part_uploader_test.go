@@ -0,0 +1,120 @@
+package driver_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"light-stemcell-builder/driver"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeMultipartS3 fails the first failures[n] attempts to upload part n
+type fakeMultipartS3 struct {
+	s3iface.S3API
+	mutex    sync.Mutex
+	failures map[int64]int
+	parts    map[int64][]byte
+	complete *s3.CompleteMultipartUploadInput
+	aborted  bool
+}
+
+func (f *fakeMultipartS3) CreateMultipartUpload(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("some-upload")}, nil
+}
+
+func (f *fakeMultipartS3) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	partNumber := aws.Int64Value(input.PartNumber)
+	if f.failures[partNumber] > 0 {
+		f.failures[partNumber]--
+		return nil, errors.New("connection reset by peer")
+	}
+
+	f.parts[partNumber] = content
+	return &s3.UploadPartOutput{ETag: aws.String(string(content))}, nil
+}
+
+func (f *fakeMultipartS3) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	f.complete = input
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeMultipartS3) AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+var _ = Describe("PartUploader", func() {
+	image := []byte("aaaabbbbcc")
+	input := &s3.CreateMultipartUploadInput{Bucket: aws.String("some-bucket"), Key: aws.String("some-key")}
+
+	var fakeS3 *fakeMultipartS3
+	var uploader driver.PartUploader
+
+	BeforeEach(func() {
+		fakeS3 = &fakeMultipartS3{failures: map[int64]int{}, parts: map[int64][]byte{}}
+		uploader = driver.PartUploader{S3Client: fakeS3, PartSize: 4, Concurrency: 2, MaxPartRetries: 2}
+	})
+
+	It("uploads each range of the image as a part, completing them in order", func() {
+		retries, err := uploader.Upload(input, bytes.NewReader(image), int64(len(image)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(retries).To(BeEmpty())
+
+		Expect(fakeS3.parts).To(Equal(map[int64][]byte{1: []byte("aaaa"), 2: []byte("bbbb"), 3: []byte("cc")}))
+		Expect(fakeS3.complete.MultipartUpload.Parts).To(Equal([]*s3.CompletedPart{
+			{ETag: aws.String("aaaa"), PartNumber: aws.Int64(1)},
+			{ETag: aws.String("bbbb"), PartNumber: aws.Int64(2)},
+			{ETag: aws.String("cc"), PartNumber: aws.Int64(3)},
+		}))
+	})
+
+	It("writes the parts to Hash in part order as they are read", func() {
+		hashed := &bytes.Buffer{}
+		uploader.Hash = hashed
+
+		_, err := uploader.Upload(input, bytes.NewReader(image), int64(len(image)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hashed.String()).To(Equal("aaaabbbbcc"))
+	})
+
+	It("sends again only the parts which failed, counting their retries", func() {
+		fakeS3.failures[2] = 2
+		uploader.MaxUploadRate = 1 << 20
+
+		retries, err := uploader.Upload(input, bytes.NewReader(image), int64(len(image)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(retries).To(Equal(driver.PartRetries{2: 2}))
+		Expect(fakeS3.parts[2]).To(Equal([]byte("bbbb")))
+		Expect(fakeS3.aborted).To(BeFalse())
+	})
+
+	It("aborts the upload once a part has used up its retries", func() {
+		fakeS3.failures[3] = 3
+
+		retries, err := uploader.Upload(input, bytes.NewReader(image), int64(len(image)))
+		Expect(err).To(MatchError("uploading part 3 after 2 retries: connection reset by peer"))
+		Expect(retries).To(Equal(driver.PartRetries{3: 2}))
+		Expect(fakeS3.aborted).To(BeTrue())
+		Expect(fakeS3.complete).To(BeNil())
+	})
+
+	It("aborts the upload when the image is shorter than size", func() {
+		_, err := uploader.Upload(input, bytes.NewReader(image), int64(len(image))+4)
+		Expect(err).To(MatchError("reading part 3: unexpected EOF"))
+		Expect(fakeS3.aborted).To(BeTrue())
+	})
+})
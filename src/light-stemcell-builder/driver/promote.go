@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Number of times the promotion of a region is tried again once its client has exhausted its own retries
+const (
+	defaultPromoteRetries    = 3
+	defaultPromoteRetryDelay = time.Second
+)
+
+// PromoteImage makes an AMI which was published private public, after making the unencrypted snapshots it is
+// registered from public
+func PromoteImage(ec2Client ec2iface.EC2API, imageID string) error {
+	output, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}})
+	if err != nil {
+		return fmt.Errorf("describing AMI %s: %s", imageID, err)
+	}
+	if len(output.Images) == 0 {
+		return fmt.Errorf("AMI %s not found", imageID)
+	}
+
+	for _, mapping := range output.Images[0].BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil || aws.BoolValue(mapping.Ebs.Encrypted) {
+			continue
+		}
+
+		_, err = ec2Client.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    mapping.Ebs.SnapshotId,
+			Attribute:     aws.String("createVolumePermission"),
+			OperationType: aws.String("add"),
+			GroupNames:    []*string{aws.String(publicGroup)},
+		})
+		if err != nil {
+			return fmt.Errorf("making snapshot %s of AMI %s public: %s", aws.StringValue(mapping.Ebs.SnapshotId), imageID, err)
+		}
+	}
+
+	_, err = ec2Client.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+		ImageId: aws.String(imageID),
+		LaunchPermission: &ec2.LaunchPermissionModifications{
+			Add: []*ec2.LaunchPermission{{Group: aws.String(publicGroup)}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("making AMI %s public: %s", imageID, err)
+	}
+	return nil
+}
+
+// PromoteImages makes the AMI of every region public with the client of its region, promoting all the regions at
+// once so a wide fan-out takes about as long as a single region. A region which fails is tried again on its own,
+// after a doubling delay as configured by policy, without holding back the others. The error of each region which
+// still failed is returned by region.
+func PromoteImages(clients map[string]ec2iface.EC2API, amis map[string]string, policy config.RetryPolicy) map[string]error {
+	maxRetries := defaultPromoteRetries
+	if policy.MaxRetries > 0 {
+		maxRetries = policy.MaxRetries
+	}
+	baseDelay := defaultPromoteRetryDelay
+	if policy.BaseDelayMS > 0 {
+		baseDelay = time.Duration(policy.BaseDelayMS) * time.Millisecond
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	errs := map[string]error{}
+	for region, amiID := range amis {
+		ec2Client, ok := clients[region]
+		if !ok {
+			errs[region] = fmt.Errorf("no ami_regions entry provides credentials for %s", region)
+			continue
+		}
+
+		wg.Add(1)
+		go func(region string, amiID string, ec2Client ec2iface.EC2API) {
+			defer wg.Done()
+
+			delay := baseDelay
+			for retried := 0; ; retried++ {
+				err := PromoteImage(ec2Client, amiID)
+				if err == nil {
+					return
+				}
+
+				if retried >= maxRetries {
+					mutex.Lock()
+					errs[region] = fmt.Errorf("%s after %d retries", err, retried)
+					mutex.Unlock()
+					return
+				}
+
+				time.Sleep(delay)
+				delay *= 2
+			}
+		}(region, amiID, ec2Client)
+	}
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,162 @@
+package driver_test
+
+import (
+	"errors"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/driver"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLaunchPermissionEC2 struct {
+	ec2iface.EC2API
+	mappings       []*ec2.BlockDeviceMapping
+	snapshotInputs []*ec2.ModifySnapshotAttributeInput
+	input          *ec2.ModifyImageAttributeInput
+	err            error
+}
+
+func (f *fakeLaunchPermissionEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{{ImageId: input.ImageIds[0], BlockDeviceMappings: f.mappings}}}, nil
+}
+
+func (f *fakeLaunchPermissionEC2) ModifySnapshotAttribute(input *ec2.ModifySnapshotAttributeInput) (*ec2.ModifySnapshotAttributeOutput, error) {
+	f.snapshotInputs = append(f.snapshotInputs, input)
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+func (f *fakeLaunchPermissionEC2) ModifyImageAttribute(input *ec2.ModifyImageAttributeInput) (*ec2.ModifyImageAttributeOutput, error) {
+	f.input = input
+	return &ec2.ModifyImageAttributeOutput{}, f.err
+}
+
+var _ = Describe("PromoteImage", func() {
+	It("grants everyone permission to launch the AMI", func() {
+		ec2Client := &fakeLaunchPermissionEC2{}
+
+		err := driver.PromoteImage(ec2Client, "ami-1234")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aws.StringValue(ec2Client.input.ImageId)).To(Equal("ami-1234"))
+		Expect(ec2Client.input.LaunchPermission.Add).To(HaveLen(1))
+		Expect(aws.StringValue(ec2Client.input.LaunchPermission.Add[0].Group)).To(Equal("all"))
+	})
+
+	It("makes the unencrypted snapshots of the AMI public before the AMI", func() {
+		ec2Client := &fakeLaunchPermissionEC2{mappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-root")}},
+			{DeviceName: aws.String("/dev/sdb"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-data"), Encrypted: aws.Bool(true)}},
+			{DeviceName: aws.String("/dev/sdc"), VirtualName: aws.String("ephemeral0")},
+		}}
+
+		err := driver.PromoteImage(ec2Client, "ami-1234")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ec2Client.snapshotInputs).To(HaveLen(1))
+		Expect(aws.StringValue(ec2Client.snapshotInputs[0].SnapshotId)).To(Equal("snap-root"))
+		Expect(aws.StringValue(ec2Client.snapshotInputs[0].Attribute)).To(Equal("createVolumePermission"))
+		Expect(aws.StringValueSlice(ec2Client.snapshotInputs[0].GroupNames)).To(Equal([]string{"all"}))
+	})
+
+	It("returns an error when the launch permission cannot be modified", func() {
+		ec2Client := &fakeLaunchPermissionEC2{err: errors.New("some error")}
+
+		err := driver.PromoteImage(ec2Client, "ami-1234")
+		Expect(err).To(MatchError("making AMI ami-1234 public: some error"))
+	})
+})
+
+// fakeRegionPromotionEC2 fails the first failures attempts to promote, and only answers the first once every
+// region sharing started has begun promoting
+type fakeRegionPromotionEC2 struct {
+	ec2iface.EC2API
+	failures int
+	calls    int
+	started  *sync.WaitGroup
+	once     sync.Once
+}
+
+func (f *fakeRegionPromotionEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{{ImageId: input.ImageIds[0]}}}, nil
+}
+
+func (f *fakeRegionPromotionEC2) ModifyImageAttribute(*ec2.ModifyImageAttributeInput) (*ec2.ModifyImageAttributeOutput, error) {
+	f.once.Do(func() {
+		f.started.Done()
+		f.started.Wait()
+	})
+
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("RequestLimitExceeded")
+	}
+	return &ec2.ModifyImageAttributeOutput{}, nil
+}
+
+var _ = Describe("PromoteImages", func() {
+	policy := config.RetryPolicy{MaxRetries: 2, BaseDelayMS: 1}
+
+	var started *sync.WaitGroup
+	var clients map[string]*fakeRegionPromotionEC2
+
+	promote := func(amis map[string]string) map[string]error {
+		started = &sync.WaitGroup{}
+		started.Add(len(clients))
+
+		ec2Clients := map[string]ec2iface.EC2API{}
+		for region, client := range clients {
+			client.started = started
+			ec2Clients[region] = client
+		}
+
+		done := make(chan map[string]error)
+		go func() { done <- driver.PromoteImages(ec2Clients, amis, policy) }()
+
+		var errs map[string]error
+		Eventually(done, time.Second).Should(Receive(&errs), "every region should be promoted at once")
+		return errs
+	}
+
+	BeforeEach(func() {
+		clients = map[string]*fakeRegionPromotionEC2{
+			"us-east-1": {},
+			"eu-west-1": {},
+		}
+	})
+
+	It("promotes every region at once", func() {
+		errs := promote(map[string]string{"us-east-1": "ami-east", "eu-west-1": "ami-west"})
+		Expect(errs).To(BeEmpty())
+		Expect(clients["us-east-1"].calls).To(Equal(1))
+		Expect(clients["eu-west-1"].calls).To(Equal(1))
+	})
+
+	It("retries each region which fails on its own", func() {
+		clients["eu-west-1"].failures = 2
+
+		errs := promote(map[string]string{"us-east-1": "ami-east", "eu-west-1": "ami-west"})
+		Expect(errs).To(BeEmpty())
+		Expect(clients["us-east-1"].calls).To(Equal(1))
+		Expect(clients["eu-west-1"].calls).To(Equal(3))
+	})
+
+	It("returns the error of each region which failed after its retries", func() {
+		clients["eu-west-1"].failures = 3
+
+		errs := promote(map[string]string{"us-east-1": "ami-east", "eu-west-1": "ami-west"})
+		Expect(errs).To(HaveLen(1))
+		Expect(errs["eu-west-1"]).To(MatchError("making AMI ami-west public: RequestLimitExceeded after 2 retries"))
+	})
+
+	It("returns an error for a region without a client", func() {
+		delete(clients, "eu-west-1")
+
+		errs := promote(map[string]string{"us-east-1": "ami-east", "eu-west-1": "ami-west"})
+		Expect(errs).To(HaveLen(1))
+		Expect(errs["eu-west-1"]).To(MatchError("no ami_regions entry provides credentials for eu-west-1"))
+	})
+})
@@ -1,30 +1,36 @@
 package reqinputs
 
 import (
-	"fmt"
 	"light-stemcell-builder/resources"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
+// Root device names used when the config does not override them
 const (
-	firstDeviceNameHVMAmi = "/dev/xvda"
-	firstDeviceNamePVAmi  = "/dev/sda"
+	defaultRootDeviceNameHVMAmi = "/dev/xvda"
+	defaultRootDeviceNamePVAmi  = "/dev/sda1"
 )
 
-// NewHVMAmiRequestInput builds the required input to create an HVM AMI
-func NewHVMAmiRequestInput(amiName string, amiDescription string, snapshotID string) *ec2.RegisterImageInput {
+// NewHVMAmiRequestInput builds the required input to create an HVM AMI, whose root volume is attached as
+// rootDeviceName, or /dev/xvda when it is empty
+func NewHVMAmiRequestInput(amiName string, amiDescription string, snapshotID string, rootDeviceName string) *ec2.RegisterImageInput {
+	if rootDeviceName == "" {
+		rootDeviceName = defaultRootDeviceNameHVMAmi
+	}
+
 	return &ec2.RegisterImageInput{
 		SriovNetSupport:    aws.String("simple"),
 		Architecture:       aws.String(resources.AmiArchitecture),
 		Description:        aws.String(amiDescription),
 		VirtualizationType: aws.String(resources.HvmAmiVirtualization),
 		Name:               aws.String(amiName),
-		RootDeviceName:     aws.String(firstDeviceNameHVMAmi),
+		RootDeviceName:     aws.String(rootDeviceName),
 		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
 			&ec2.BlockDeviceMapping{
-				DeviceName: aws.String(firstDeviceNameHVMAmi),
+				DeviceName: aws.String(rootDeviceName),
 				Ebs: &ec2.EbsBlockDevice{
 					DeleteOnTermination: aws.Bool(true),
 					SnapshotId:          aws.String(snapshotID),
@@ -34,18 +40,23 @@ func NewHVMAmiRequestInput(amiName string, amiDescription string, snapshotID str
 	}
 }
 
-// NewPVAmiRequest builds the required input to create an PV AMI
-func NewPVAmiRequest(amiName string, amiDescription string, snapshotID string, kernelID string) *ec2.RegisterImageInput {
+// NewPVAmiRequest builds the required input to create an PV AMI, which boots from the partition rootDeviceName,
+// or /dev/sda1 when it is empty. The volume is attached as the disk holding that partition.
+func NewPVAmiRequest(amiName string, amiDescription string, snapshotID string, kernelID string, rootDeviceName string) *ec2.RegisterImageInput {
+	if rootDeviceName == "" {
+		rootDeviceName = defaultRootDeviceNamePVAmi
+	}
+
 	return &ec2.RegisterImageInput{
 		Architecture:       aws.String(resources.AmiArchitecture),
 		Description:        aws.String(amiDescription),
 		VirtualizationType: aws.String(resources.PvAmiVirtualization),
 		Name:               aws.String(amiName),
-		RootDeviceName:     aws.String(fmt.Sprintf("%s1", firstDeviceNamePVAmi)),
+		RootDeviceName:     aws.String(rootDeviceName),
 		KernelId:           aws.String(kernelID),
 		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
 			&ec2.BlockDeviceMapping{
-				DeviceName: aws.String(firstDeviceNamePVAmi),
+				DeviceName: aws.String(strings.TrimRight(rootDeviceName, "0123456789")),
 				Ebs: &ec2.EbsBlockDevice{
 					DeleteOnTermination: aws.Bool(true),
 					SnapshotId:          aws.String(snapshotID),
@@ -54,3 +65,33 @@ func NewPVAmiRequest(amiName string, amiDescription string, snapshotID string, k
 		},
 	}
 }
+
+// AddEphemeralDevices maps the instance store volumes of devices after the root volume of input
+func AddEphemeralDevices(input *ec2.RegisterImageInput, devices []resources.EphemeralDevice) {
+	for _, device := range devices {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName:  aws.String(device.DeviceName),
+			VirtualName: aws.String(device.VirtualName),
+		})
+	}
+}
+
+// AddDataVolumes maps an empty EBS volume for each of volumes after the existing mappings of input
+func AddDataVolumes(input *ec2.RegisterImageInput, volumes []resources.DataVolume) {
+	for _, volume := range volumes {
+		ebs := &ec2.EbsBlockDevice{
+			DeleteOnTermination: aws.Bool(true),
+			Encrypted:           aws.Bool(volume.Encrypted),
+			VolumeSize:          aws.Int64(volume.SizeGB),
+			VolumeType:          aws.String(volume.VolumeType),
+		}
+		if volume.Iops > 0 {
+			ebs.Iops = aws.Int64(volume.Iops)
+		}
+
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(volume.DeviceName),
+			Ebs:        ebs,
+		})
+	}
+}
@@ -12,7 +12,7 @@ import (
 var _ = Describe("building inputs for register image", func() {
 	Describe("NewHVMAmiRequestInput", func() {
 		It("builds valid request input for building an HVM AMI", func() {
-			input := reqinputs.NewHVMAmiRequestInput("some-ami-name", "some-ami-description", "some-snapshot-id")
+			input := reqinputs.NewHVMAmiRequestInput("some-ami-name", "some-ami-description", "some-snapshot-id", "")
 			Expect(input).To(BeAssignableToTypeOf(&ec2.RegisterImageInput{}))
 			Expect(*input.SriovNetSupport).To(Equal("simple"))
 			Expect(*input.Architecture).To(Equal(resources.AmiArchitecture))
@@ -25,11 +25,17 @@ var _ = Describe("building inputs for register image", func() {
 			Expect(*input.BlockDeviceMappings[0].Ebs.SnapshotId).To(Equal("some-snapshot-id"))
 			Expect(*input.BlockDeviceMappings[0].Ebs.DeleteOnTermination).To(BeTrue())
 		})
+
+		It("attaches the root volume as the given root device name", func() {
+			input := reqinputs.NewHVMAmiRequestInput("some-ami-name", "some-ami-description", "some-snapshot-id", "/dev/sda1")
+			Expect(*input.RootDeviceName).To(Equal("/dev/sda1"))
+			Expect(*input.BlockDeviceMappings[0].DeviceName).To(Equal("/dev/sda1"))
+		})
 	})
 
 	Describe("NewPVAmiRequest", func() {
 		It("builds valid request input for building an PV AMI", func() {
-			input := reqinputs.NewPVAmiRequest("some-ami-name", "some-ami-description", "some-snapshot-id", "some-kernel-id")
+			input := reqinputs.NewPVAmiRequest("some-ami-name", "some-ami-description", "some-snapshot-id", "some-kernel-id", "")
 			Expect(input).To(BeAssignableToTypeOf(&ec2.RegisterImageInput{}))
 			Expect(input.SriovNetSupport).To(BeNil())
 			Expect(*input.Architecture).To(Equal(resources.AmiArchitecture))
@@ -43,6 +49,55 @@ var _ = Describe("building inputs for register image", func() {
 			Expect(*input.BlockDeviceMappings[0].Ebs.SnapshotId).To(Equal("some-snapshot-id"))
 			Expect(*input.BlockDeviceMappings[0].Ebs.DeleteOnTermination).To(BeTrue())
 		})
+
+		It("attaches the root volume as the disk holding the given root partition", func() {
+			input := reqinputs.NewPVAmiRequest("some-ami-name", "some-ami-description", "some-snapshot-id", "some-kernel-id", "/dev/xvda1")
+			Expect(*input.RootDeviceName).To(Equal("/dev/xvda1"))
+			Expect(*input.BlockDeviceMappings[0].DeviceName).To(Equal("/dev/xvda"))
+		})
+	})
+
+	Describe("AddEphemeralDevices", func() {
+		It("maps each instance store volume after the root volume", func() {
+			input := reqinputs.NewHVMAmiRequestInput("some-ami-name", "some-ami-description", "some-snapshot-id", "")
+			reqinputs.AddEphemeralDevices(input, []resources.EphemeralDevice{
+				{VirtualName: "ephemeral0", DeviceName: "/dev/sdb"},
+				{VirtualName: "ephemeral1", DeviceName: "/dev/sdc"},
+			})
+
+			Expect(input.BlockDeviceMappings).To(HaveLen(3))
+			Expect(*input.BlockDeviceMappings[0].DeviceName).To(Equal("/dev/xvda"))
+			Expect(*input.BlockDeviceMappings[1].DeviceName).To(Equal("/dev/sdb"))
+			Expect(*input.BlockDeviceMappings[1].VirtualName).To(Equal("ephemeral0"))
+			Expect(input.BlockDeviceMappings[1].Ebs).To(BeNil())
+			Expect(*input.BlockDeviceMappings[2].DeviceName).To(Equal("/dev/sdc"))
+			Expect(*input.BlockDeviceMappings[2].VirtualName).To(Equal("ephemeral1"))
+		})
 	})
 
+	Describe("AddDataVolumes", func() {
+		It("maps an empty EBS volume for each data volume", func() {
+			input := reqinputs.NewHVMAmiRequestInput("some-ami-name", "some-ami-description", "some-snapshot-id", "")
+			reqinputs.AddDataVolumes(input, []resources.DataVolume{
+				{DeviceName: "/dev/sdf", SizeGB: 100, VolumeType: "gp2", Encrypted: true},
+				{DeviceName: "/dev/sdg", SizeGB: 50, VolumeType: "io1", Iops: 1000},
+			})
+
+			Expect(input.BlockDeviceMappings).To(HaveLen(3))
+
+			gp2 := input.BlockDeviceMappings[1]
+			Expect(*gp2.DeviceName).To(Equal("/dev/sdf"))
+			Expect(gp2.Ebs.SnapshotId).To(BeNil())
+			Expect(*gp2.Ebs.VolumeSize).To(Equal(int64(100)))
+			Expect(*gp2.Ebs.VolumeType).To(Equal("gp2"))
+			Expect(*gp2.Ebs.Encrypted).To(BeTrue())
+			Expect(*gp2.Ebs.DeleteOnTermination).To(BeTrue())
+			Expect(gp2.Ebs.Iops).To(BeNil())
+
+			io1 := input.BlockDeviceMappings[2]
+			Expect(*io1.DeviceName).To(Equal("/dev/sdg"))
+			Expect(*io1.Ebs.Iops).To(Equal(int64(1000)))
+			Expect(*io1.Ebs.Encrypted).To(BeFalse())
+		})
+	})
 })
@@ -1,15 +1,57 @@
 package driver
 
 import (
+	"light-stemcell-builder/config"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/request"
 )
 
+// Number of times failed requests are retried when the config does not override it
+const (
+	defaultUploadRetries = 50
+	defaultRetries       = 3
+)
+
+// maxBackoffDoublings caps the exponential backoff, matching the SDK's default retryer
+const maxBackoffDoublings = 13
+
+// PhaseRetryer retries failed requests up to NumMaxRetries times, doubling the delay from BaseDelay
+// after each attempt. Without a BaseDelay the SDK's default backoff is used.
+type PhaseRetryer struct {
+	client.DefaultRetryer
+	BaseDelay time.Duration
+}
+
+// NewPhaseRetryer creates a PhaseRetryer for the policy of a phase, retrying defaultMaxRetries times unless overridden
+func NewPhaseRetryer(policy config.RetryPolicy, defaultMaxRetries int) PhaseRetryer {
+	r := PhaseRetryer{BaseDelay: time.Duration(policy.BaseDelayMS) * time.Millisecond}
+	r.NumMaxRetries = defaultMaxRetries
+	if policy.MaxRetries > 0 {
+		r.NumMaxRetries = policy.MaxRetries
+	}
+	return r
+}
+
+// RetryRules returns the delay before retrying the request
+func (r PhaseRetryer) RetryRules(req *request.Request) time.Duration {
+	if r.BaseDelay <= 0 {
+		return r.DefaultRetryer.RetryRules(req)
+	}
+
+	retryCount := req.RetryCount
+	if retryCount > maxBackoffDoublings {
+		retryCount = maxBackoffDoublings
+	}
+	return (1 << uint(retryCount)) * r.BaseDelay
+}
+
 // S3Retryer handles more error conditions than the default retryer when
 // uploading chunks to S3
 type S3Retryer struct {
-	client.DefaultRetryer
+	PhaseRetryer
 }
 
 // MaxRetries returns the configured number of NumMaxRetries, defaults to 3
@@ -32,3 +74,9 @@ func (r S3Retryer) ShouldRetry(req *request.Request) bool {
 	}
 	return r.DefaultRetryer.ShouldRetry(req)
 }
+
+// sendWithRetryer sends req, retrying it with the policy of its own phase rather than that of the client
+func sendWithRetryer(req *request.Request, retryer request.Retryer) error {
+	req.Retryer = retryer
+	return req.Send()
+}
@@ -1,8 +1,10 @@
 package driver_test
 
 import (
+	"light-stemcell-builder/config"
 	"light-stemcell-builder/driver"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -27,4 +29,23 @@ var _ = Describe("Retryer", func() {
 		req.Error = awserr.New("SerializationError", "failed to decode S3 XML error response", nil)
 		Expect(r.ShouldRetry(req)).To(BeTrue())
 	})
+
+	Describe("PhaseRetryer", func() {
+		It("uses the default number of retries unless the policy overrides it", func() {
+			Expect(driver.NewPhaseRetryer(config.RetryPolicy{}, 3).MaxRetries()).To(Equal(3))
+			Expect(driver.NewPhaseRetryer(config.RetryPolicy{MaxRetries: 8}, 3).MaxRetries()).To(Equal(8))
+		})
+		It("doubles the base delay after each retry", func() {
+			r := driver.NewPhaseRetryer(config.RetryPolicy{BaseDelayMS: 100}, 3)
+			req := &request.Request{}
+			Expect(r.RetryRules(req)).To(Equal(100 * time.Millisecond))
+			req.RetryCount = 3
+			Expect(r.RetryRules(req)).To(Equal(800 * time.Millisecond))
+		})
+		It("keeps the SDK's backoff without a base delay", func() {
+			r := driver.NewPhaseRetryer(config.RetryPolicy{}, 3)
+			req := &request.Request{HTTPResponse: &http.Response{StatusCode: 500}}
+			Expect(r.RetryRules(req)).To(BeNumerically("<", 60*time.Millisecond))
+		})
+	})
 })
@@ -0,0 +1,90 @@
+package driver_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// scriptedResponse answers a single request: a failure when Code is set, otherwise a success whose output is
+// filled in by Output, if any
+type scriptedResponse struct {
+	StatusCode int
+	Code       string
+	Output     func(data interface{})
+}
+
+// scriptedEC2 is an EC2 client whose requests never leave the process. Each request is answered by the next
+// response scripted for its operation, the last of which keeps answering, so drivers, their retryers and waiters
+// can be run against sequences of API errors.
+type scriptedEC2 struct {
+	*ec2.EC2
+	mutex     sync.Mutex
+	responses map[string][]scriptedResponse
+	calls     []string
+}
+
+func newScriptedEC2(responses map[string][]scriptedResponse) *scriptedEC2 {
+	s := &scriptedEC2{responses: responses}
+	s.EC2 = ec2.New(session.New(), aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("some-access-key", "some-secret-key", "")).
+		WithMaxRetries(3))
+
+	s.Handlers.Send.Clear()
+	s.Handlers.Send.PushBack(s.send)
+	s.Handlers.UnmarshalMeta.Clear()
+	s.Handlers.ValidateResponse.Clear()
+	s.Handlers.UnmarshalError.Clear()
+	s.Handlers.Unmarshal.Clear()
+	return s
+}
+
+func (s *scriptedEC2) send(r *request.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls = append(s.calls, r.Operation.Name)
+	script := s.responses[r.Operation.Name]
+	if len(script) == 0 {
+		r.Error = fmt.Errorf("no response scripted for %s", r.Operation.Name)
+		return
+	}
+	response := script[0]
+	if len(script) > 1 {
+		s.responses[r.Operation.Name] = script[1:]
+	}
+
+	r.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}
+	if response.Code != "" {
+		r.HTTPResponse.StatusCode = response.StatusCode
+		r.Error = awserr.NewRequestFailure(awserr.New(response.Code, "scripted failure", nil), response.StatusCode, "some-request-id")
+		return
+	}
+	if response.Output != nil {
+		response.Output(r.Data)
+	}
+}
+
+// callCount returns the number of requests made for the operation
+func (s *scriptedEC2) callCount(operation string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, call := range s.calls {
+		if call == operation {
+			count++
+		}
+	}
+	return count
+}
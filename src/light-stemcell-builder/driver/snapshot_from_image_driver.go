@@ -4,88 +4,134 @@ import (
 	"fmt"
 	"io"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"light-stemcell-builder/heartbeat"
 	"light-stemcell-builder/resources"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/private/waiter"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 var _ resources.SnapshotDriver = &SDKSnapshotFromImageDriver{}
+var _ resources.InFlightImportFinder = &SDKSnapshotFromImageDriver{}
+
+// importDescriptionPrefix starts the description of imports of a machine image with a known digest, which other
+// builds look for to wait on an import already in flight rather than starting their own
+const importDescriptionPrefix = "bosh-light-stemcell-builder-import"
 
 // SDKSnapshotFromImageDriver creates an AMI directly from a machine image
 type SDKSnapshotFromImageDriver struct {
-	ec2Client *ec2.EC2
+	ec2Client ec2iface.EC2API
+	retries   config.Retries
 	logger    *log.Logger
+	// region reports the progress of imports to the heartbeat, when known
+	region string
 }
 
 // NewSnapshotFromImageDriver creates a SDKSnapshotFromImageDriver for creating snapshots in EC2
-func NewSnapshotFromImageDriver(logDest io.Writer, creds config.Credentials) *SDKSnapshotFromImageDriver {
+func NewSnapshotFromImageDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKSnapshotFromImageDriver {
 	logger := log.New(logDest, "SDKSnapshotFromImageDriver ", log.LstdFlags)
 	awsConfig := aws.NewConfig().
 		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
+	awsConfig.Retryer = NewPhaseRetryer(retries.Import, defaultRetries)
+
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKSnapshotFromImageDriver{ec2Client: ec2Client, retries: retries, logger: logger, region: creds.Region}
+}
 
-	ec2Client := ec2.New(session.New(), awsConfig)
-	return &SDKSnapshotFromImageDriver{ec2Client: ec2Client, logger: logger}
+// NewSnapshotFromImageDriverWithClient creates a SDKSnapshotFromImageDriver which imports snapshots with the provided EC2
+// client, such as a fake when testing code which embeds the driver
+func NewSnapshotFromImageDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API, retries config.Retries) *SDKSnapshotFromImageDriver {
+	logger := log.New(logDest, "SDKSnapshotFromImageDriver ", log.LstdFlags)
+	return &SDKSnapshotFromImageDriver{ec2Client: ec2Client, retries: retries, logger: logger}
 }
 
-// Create produces a snapshot in EC2 from a machine image previously uploaded to S3
+// Create produces a snapshot in EC2 from a machine image previously uploaded to S3, or from the import task of
+// driverConfig when one is already in flight
 func (d *SDKSnapshotFromImageDriver) Create(driverConfig resources.SnapshotDriverConfig) (resources.Snapshot, error) {
 	createStartTime := time.Now()
 	defer func(startTime time.Time) {
 		d.logger.Printf("completed Create() in %f minutes\n", time.Since(startTime).Minutes())
 	}(createStartTime)
 
-	d.logger.Printf("initiating ImportSnapshot task from image: %s\n", driverConfig.MachineImageURL)
-	reqOutput, err := d.ec2Client.ImportSnapshot(&ec2.ImportSnapshotInput{
-		DiskContainer: &ec2.SnapshotDiskContainer{
-			Url:    &driverConfig.MachineImageURL,
-			Format: aws.String(driverConfig.FileFormat),
-		},
-	})
-	if err != nil {
-		return resources.Snapshot{}, fmt.Errorf("creating import snapshot task: %s", err)
+	var importTaskID *string
+	if driverConfig.ImportTaskID != "" {
+		d.logger.Printf("reusing ImportSnapshot task %s of the same machine image, already started by another build\n", driverConfig.ImportTaskID)
+		importTaskID = aws.String(driverConfig.ImportTaskID)
+	} else {
+		description := importDescription(driverConfig)
+		d.logger.Printf("initiating ImportSnapshot task from image: %s\n", driverConfig.MachineImageURL)
+		importInput := &ec2.ImportSnapshotInput{
+			DiskContainer: &ec2.SnapshotDiskContainer{
+				Url:    &driverConfig.MachineImageURL,
+				Format: aws.String(driverConfig.FileFormat),
+			},
+		}
+		if description != "" {
+			importInput.Description = aws.String(description)
+		}
+
+		reqOutput, err := d.ec2Client.ImportSnapshot(importInput)
+		if err != nil {
+			return resources.Snapshot{}, fmt.Errorf("creating import snapshot task: %s", err)
+		}
+		importTaskID = reqOutput.ImportTaskId
 	}
 
-	d.logger.Printf("waiting on ImportSnapshot task %s\n", *reqOutput.ImportTaskId)
+	d.logger.Printf("waiting on ImportSnapshot task %s\n", *importTaskID)
 
 	taskFilter := &ec2.DescribeImportSnapshotTasksInput{
-		ImportTaskIds: []*string{reqOutput.ImportTaskId},
+		ImportTaskIds: []*string{importTaskID},
 	}
 
 	waitStartTime := time.Now()
-	err = d.waitUntilImportSnapshotTaskCompleted(taskFilter)
+	err := d.waitUntilImportSnapshotTaskCompleted(taskFilter)
 	if err != nil {
 		return resources.Snapshot{}, fmt.Errorf("waiting for snapshot to become available: %s", err)
 	}
 
-	d.logger.Printf("waited on import task %s for %f minutes\n", *reqOutput.ImportTaskId, time.Since(waitStartTime).Minutes())
+	d.logger.Printf("waited on import task %s for %f minutes\n", *importTaskID, time.Since(waitStartTime).Minutes())
 
 	describeOutput, err := d.ec2Client.DescribeImportSnapshotTasks(taskFilter)
 	if err != nil {
-		return resources.Snapshot{}, fmt.Errorf("describing snapshot from import snapshot task %s: %s", *reqOutput.ImportTaskId, err)
+		return resources.Snapshot{}, fmt.Errorf("describing snapshot from import snapshot task %s: %s", *importTaskID, err)
 	}
 
 	snapshotIDptr := describeOutput.ImportSnapshotTasks[0].SnapshotTaskDetail.SnapshotId
 	if snapshotIDptr == nil {
-		return resources.Snapshot{}, fmt.Errorf("snapshot ID empty for import task: %s", *reqOutput.ImportTaskId)
+		return resources.Snapshot{}, fmt.Errorf("snapshot ID empty for import task: %s", *importTaskID)
 	}
 
 	d.logger.Printf("created snapshot %s\n", *snapshotIDptr)
 
+	err = tagNamespace(d.ec2Client, d.retries.Tag, driverConfig.Namespace, *snapshotIDptr)
+	if err != nil {
+		return resources.Snapshot{}, err
+	}
+
+	if driverConfig.Private {
+		d.logger.Printf("created snapshot %s, which stays private until its AMI is promoted\n", *snapshotIDptr)
+		return resources.Snapshot{ID: *snapshotIDptr}, nil
+	}
+
 	modifySnapshotAttributeInput := &ec2.ModifySnapshotAttributeInput{
 		SnapshotId:    snapshotIDptr,
 		Attribute:     aws.String("createVolumePermission"),
 		OperationType: aws.String("add"),
 		GroupNames:    []*string{aws.String("all")},
 	}
-	_, err = d.ec2Client.ModifySnapshotAttribute(modifySnapshotAttributeInput)
+	modifySnapshotAttributeReq, _ := d.ec2Client.ModifySnapshotAttributeRequest(modifySnapshotAttributeInput)
+	err = sendWithRetryer(modifySnapshotAttributeReq, NewPhaseRetryer(d.retries.Permission, defaultRetries))
 	if err != nil {
 		return resources.Snapshot{}, fmt.Errorf("making snapshot with id %s public: %s", *snapshotIDptr, err)
 	}
@@ -95,6 +141,60 @@ func (d *SDKSnapshotFromImageDriver) Create(driverConfig resources.SnapshotDrive
 	return resources.Snapshot{ID: *snapshotIDptr}, nil
 }
 
+// FindInFlightImport returns the ID of an active import of the same machine image, format and namespace as
+// driverConfig, paging through every import task of the account and region. It returns an empty string when there is
+// none or the digest of the machine image is not known. EC2 cannot reserve a description, so two builds which look at
+// the same moment both find nothing and both import.
+func (d *SDKSnapshotFromImageDriver) FindInFlightImport(driverConfig resources.SnapshotDriverConfig) (string, error) {
+	description := importDescription(driverConfig)
+	if description == "" {
+		return "", nil
+	}
+
+	input := &ec2.DescribeImportSnapshotTasksInput{}
+	for {
+		output, err := d.ec2Client.DescribeImportSnapshotTasks(input)
+		if err != nil {
+			return "", fmt.Errorf("describing import snapshot tasks: %s", err)
+		}
+
+		if taskID := InFlightImport(output, description); taskID != "" {
+			return taskID, nil
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return "", nil
+		}
+		input = &ec2.DescribeImportSnapshotTasksInput{NextToken: output.NextToken}
+	}
+}
+
+// importDescription identifies imports of the same machine image and format in the same namespace, returning an
+// empty description when the digest of the machine image is not known
+func importDescription(driverConfig resources.SnapshotDriverConfig) string {
+	if driverConfig.ImageDigest == "" {
+		return ""
+	}
+
+	description := fmt.Sprintf("%s-%s-%s", importDescriptionPrefix, driverConfig.FileFormat, driverConfig.ImageDigest)
+	if driverConfig.Namespace != "" {
+		description = fmt.Sprintf("%s-%s", description, driverConfig.Namespace)
+	}
+	return description
+}
+
+// InFlightImport returns the ID of an active import task with the description, or an empty string if there is none
+func InFlightImport(output *ec2.DescribeImportSnapshotTasksOutput, description string) string {
+	for _, task := range output.ImportSnapshotTasks {
+		if aws.StringValue(task.Description) != description || task.SnapshotTaskDetail == nil {
+			continue
+		}
+		if aws.StringValue(task.SnapshotTaskDetail.Status) == "active" {
+			return aws.StringValue(task.ImportTaskId)
+		}
+	}
+	return ""
+}
+
 func (d *SDKSnapshotFromImageDriver) waitUntilImportSnapshotTaskCompleted(input *ec2.DescribeImportSnapshotTasksInput) error {
 	waiterCfg := waiter.Config{
 		Operation:   "DescribeImportSnapshotTasks",
@@ -123,9 +223,31 @@ func (d *SDKSnapshotFromImageDriver) waitUntilImportSnapshotTaskCompleted(input
 	}
 
 	w := waiter.Waiter{
-		Client: d.ec2Client,
+		Client: importProgressClient{EC2API: d.ec2Client, region: d.region},
 		Input:  input,
 		Config: waiterCfg,
 	}
 	return w.Wait()
 }
+
+// importProgressClient reports the progress of each import task polled by the waiter to the heartbeat
+type importProgressClient struct {
+	ec2iface.EC2API
+	region string
+}
+
+func (c importProgressClient) DescribeImportSnapshotTasksRequest(input *ec2.DescribeImportSnapshotTasksInput) (*request.Request, *ec2.DescribeImportSnapshotTasksOutput) {
+	req, output := c.EC2API.DescribeImportSnapshotTasksRequest(input)
+	req.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		if r.Error != nil || len(output.ImportSnapshotTasks) == 0 || output.ImportSnapshotTasks[0].SnapshotTaskDetail == nil {
+			return
+		}
+
+		// the progress of a task is only set while it is active
+		percent, err := strconv.Atoi(aws.StringValue(output.ImportSnapshotTasks[0].SnapshotTaskDetail.Progress))
+		if err == nil {
+			heartbeat.Percent(c.region, percent)
+		}
+	})
+	return req, output
+}
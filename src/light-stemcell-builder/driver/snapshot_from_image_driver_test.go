@@ -2,6 +2,7 @@ package driver_test
 
 import (
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/driver"
 	"light-stemcell-builder/driverset"
 	"light-stemcell-builder/resources"
 	"os"
@@ -41,7 +42,7 @@ var _ = Describe("SnapshotFromImageDriver", func() {
 			FileFormat:      imageFormat,
 		}
 
-		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
 		driver := ds.CreateSnapshotDriver()
 
 		snapshot, err := driver.Create(driverConfig)
@@ -62,3 +63,72 @@ var _ = Describe("SnapshotFromImageDriver", func() {
 		Expect(*snapshotAttributes.CreateVolumePermissions[0].Group).To(Equal("all"))
 	})
 })
+
+var _ = Describe("InFlightImport", func() {
+	task := func(id string, description string, status string) *ec2.ImportSnapshotTask {
+		return &ec2.ImportSnapshotTask{
+			ImportTaskId:       aws.String(id),
+			Description:        aws.String(description),
+			SnapshotTaskDetail: &ec2.SnapshotTaskDetail{Status: aws.String(status)},
+		}
+	}
+
+	It("returns the active import task with the description", func() {
+		output := &ec2.DescribeImportSnapshotTasksOutput{
+			ImportSnapshotTasks: []*ec2.ImportSnapshotTask{
+				task("import-snap-1", "some-other-import", "active"),
+				task("import-snap-2", "some-import", "completed"),
+				task("import-snap-3", "some-import", "active"),
+			},
+		}
+
+		Expect(driver.InFlightImport(output, "some-import")).To(Equal("import-snap-3"))
+	})
+
+	It("returns an empty string when no import with the description is active", func() {
+		output := &ec2.DescribeImportSnapshotTasksOutput{
+			ImportSnapshotTasks: []*ec2.ImportSnapshotTask{
+				task("import-snap-1", "some-import", "deleted"),
+				{ImportTaskId: aws.String("import-snap-2"), Description: aws.String("some-import")},
+			},
+		}
+
+		Expect(driver.InFlightImport(output, "some-import")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("FindInFlightImport", func() {
+	driverConfig := resources.SnapshotDriverConfig{FileFormat: "RAW", ImageDigest: "some-sha256"}
+	description := "bosh-light-stemcell-builder-import-RAW-some-sha256"
+
+	It("pages through the import tasks until it finds an active import of the machine image", func() {
+		ec2Client := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImportSnapshotTasks": {
+				{Output: func(data interface{}) {
+					output := data.(*ec2.DescribeImportSnapshotTasksOutput)
+					output.NextToken = aws.String("some-token")
+				}},
+				{Output: func(data interface{}) {
+					output := data.(*ec2.DescribeImportSnapshotTasksOutput)
+					output.ImportSnapshotTasks = []*ec2.ImportSnapshotTask{{
+						ImportTaskId:       aws.String("import-snap-1"),
+						Description:        aws.String(description),
+						SnapshotTaskDetail: &ec2.SnapshotTaskDetail{Status: aws.String("active")},
+					}}
+				}},
+			},
+		})
+		d := driver.NewSnapshotFromImageDriverWithClient(GinkgoWriter, ec2Client, config.Retries{})
+
+		Expect(d.FindInFlightImport(driverConfig)).To(Equal("import-snap-1"))
+		Expect(ec2Client.callCount("DescribeImportSnapshotTasks")).To(Equal(2))
+	})
+
+	It("does not look for an import when the digest of the machine image is not known", func() {
+		ec2Client := newScriptedEC2(map[string][]scriptedResponse{})
+		d := driver.NewSnapshotFromImageDriverWithClient(GinkgoWriter, ec2Client, config.Retries{})
+
+		Expect(d.FindInFlightImport(resources.SnapshotDriverConfig{FileFormat: "RAW"})).To(BeEmpty())
+		Expect(ec2Client.callCount("DescribeImportSnapshotTasks")).To(Equal(0))
+	})
+})
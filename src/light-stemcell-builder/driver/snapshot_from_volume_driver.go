@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
 	"light-stemcell-builder/resources"
 	"log"
 	"time"
@@ -13,26 +14,36 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/private/waiter"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 var _ resources.SnapshotDriver = &SDKSnapshotFromVolumeDriver{}
 
 // SDKSnapshotFromVolumeDriver creates an AMI from a previously created EBS volume
 type SDKSnapshotFromVolumeDriver struct {
-	ec2Client *ec2.EC2
+	ec2Client ec2iface.EC2API
+	retries   config.Retries
 	logger    *log.Logger
 }
 
 // NewSnapshotFromVolumeDriver creates a NewSnapshotFromVolumeDriver for creating snapshots in EC2
-func NewSnapshotFromVolumeDriver(logDest io.Writer, creds config.Credentials) *SDKSnapshotFromVolumeDriver {
+func NewSnapshotFromVolumeDriver(logDest io.Writer, creds config.Credentials, retries config.Retries) *SDKSnapshotFromVolumeDriver {
 	logger := log.New(logDest, "SDKSnapshotFromVolumeDriver ", log.LstdFlags)
 	awsConfig := aws.NewConfig().
 		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
 		WithRegion(creds.Region).
 		WithLogger(newDriverLogger(logger))
+	awsConfig.Retryer = NewPhaseRetryer(retries.Import, defaultRetries)
 
-	ec2Client := ec2.New(session.New(), awsConfig)
-	return &SDKSnapshotFromVolumeDriver{ec2Client: ec2Client, logger: logger}
+	ec2Client := ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+	return &SDKSnapshotFromVolumeDriver{ec2Client: ec2Client, retries: retries, logger: logger}
+}
+
+// NewSnapshotFromVolumeDriverWithClient creates a SDKSnapshotFromVolumeDriver which snapshots volumes with the provided EC2
+// client, such as a fake when testing code which embeds the driver
+func NewSnapshotFromVolumeDriverWithClient(logDest io.Writer, ec2Client ec2iface.EC2API, retries config.Retries) *SDKSnapshotFromVolumeDriver {
+	logger := log.New(logDest, "SDKSnapshotFromVolumeDriver ", log.LstdFlags)
+	return &SDKSnapshotFromVolumeDriver{ec2Client: ec2Client, retries: retries, logger: logger}
 }
 
 // Create produces a snapshot in EC2 from a previoulsy created EBS volume
@@ -51,15 +62,24 @@ func (d *SDKSnapshotFromVolumeDriver) Create(driverConfig resources.SnapshotDriv
 		return resources.Snapshot{}, fmt.Errorf("creating snapshot from EBS volume: %s: %s", driverConfig.VolumeID, err)
 	}
 
-	modifySnapshotAttributeInput := &ec2.ModifySnapshotAttributeInput{
-		SnapshotId:    reqOutput.SnapshotId,
-		Attribute:     aws.String("createVolumePermission"),
-		OperationType: aws.String("add"),
-		GroupNames:    []*string{aws.String("all")},
-	}
-	_, err = d.ec2Client.ModifySnapshotAttribute(modifySnapshotAttributeInput)
+	err = tagNamespace(d.ec2Client, d.retries.Tag, driverConfig.Namespace, *reqOutput.SnapshotId)
 	if err != nil {
-		return resources.Snapshot{}, fmt.Errorf("making snapshot with id %s public: %s", *reqOutput.SnapshotId, err)
+		return resources.Snapshot{}, err
+	}
+
+	// a private snapshot is made public along with its AMI when the AMI is promoted
+	if !driverConfig.Private {
+		modifySnapshotAttributeInput := &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    reqOutput.SnapshotId,
+			Attribute:     aws.String("createVolumePermission"),
+			OperationType: aws.String("add"),
+			GroupNames:    []*string{aws.String("all")},
+		}
+		modifySnapshotAttributeReq, _ := d.ec2Client.ModifySnapshotAttributeRequest(modifySnapshotAttributeInput)
+		err = sendWithRetryer(modifySnapshotAttributeReq, NewPhaseRetryer(d.retries.Permission, defaultRetries))
+		if err != nil {
+			return resources.Snapshot{}, fmt.Errorf("making snapshot with id %s public: %s", *reqOutput.SnapshotId, err)
+		}
 	}
 
 	d.logger.Printf("waiting on snapshot %s to be completed\n", *reqOutput.SnapshotId)
@@ -2,14 +2,17 @@ package driver_test
 
 import (
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/driver"
 	"light-stemcell-builder/driverset"
 	"light-stemcell-builder/resources"
+	"net/http"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -37,7 +40,7 @@ var _ = Describe("SnapshotFromVolumeDriver", func() {
 			VolumeID: volumeID,
 		}
 
-		ds := driverset.NewIsolatedRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewIsolatedRegionDriverSet(GinkgoWriter, creds, config.Retries{}, config.ManifestFetch{})
 		driver := ds.CreateSnapshotDriver()
 
 		snapshot, err := driver.Create(driverConfig)
@@ -58,3 +61,61 @@ var _ = Describe("SnapshotFromVolumeDriver", func() {
 		Expect(*snapshotAttributes.CreateVolumePermissions[0].Group).To(Equal("all"))
 	})
 })
+
+var _ = Describe("SnapshotFromVolumeDriver against a scripted EC2 API", func() {
+	created := scriptedResponse{Output: func(data interface{}) {
+		data.(*ec2.Snapshot).SnapshotId = aws.String("snap-1234")
+	}}
+	completed := scriptedResponse{Output: func(data interface{}) {
+		data.(*ec2.DescribeSnapshotsOutput).Snapshots = []*ec2.Snapshot{{State: aws.String(ec2.SnapshotStateCompleted)}}
+	}}
+	succeeded := scriptedResponse{}
+	throttled := scriptedResponse{StatusCode: http.StatusBadRequest, Code: "RequestLimitExceeded"}
+	unavailable := scriptedResponse{StatusCode: http.StatusServiceUnavailable, Code: "Unavailable"}
+	denied := scriptedResponse{StatusCode: http.StatusForbidden, Code: "UnauthorizedOperation"}
+
+	retries := config.Retries{
+		Tag:        config.RetryPolicy{MaxRetries: 2, BaseDelayMS: 1},
+		Permission: config.RetryPolicy{MaxRetries: 2, BaseDelayMS: 1},
+	}
+
+	DescribeTable("retrying the requests of a snapshot",
+		func(responses map[string][]scriptedResponse, expectedErr string, expectedCalls map[string]int) {
+			ec2Client := newScriptedEC2(responses)
+			snapshotDriver := driver.NewSnapshotFromVolumeDriverWithClient(GinkgoWriter, ec2Client, retries)
+
+			snapshot, err := snapshotDriver.Create(resources.SnapshotDriverConfig{VolumeID: "vol-1234", Namespace: "some-pipeline"})
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(snapshot.ID).To(Equal("snap-1234"))
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			}
+
+			for operation, count := range expectedCalls {
+				Expect(ec2Client.callCount(operation)).To(Equal(count), operation)
+			}
+		},
+		Entry("succeeds without retries", map[string][]scriptedResponse{
+			"CreateSnapshot":          {created},
+			"CreateTags":              {succeeded},
+			"ModifySnapshotAttribute": {succeeded},
+			"DescribeSnapshots":       {completed},
+		}, "", map[string]int{"CreateSnapshot": 1, "CreateTags": 1, "ModifySnapshotAttribute": 1, "DescribeSnapshots": 1}),
+		Entry("retries throttled and unavailable requests", map[string][]scriptedResponse{
+			"CreateSnapshot":          {unavailable, created},
+			"CreateTags":              {throttled, throttled, succeeded},
+			"ModifySnapshotAttribute": {unavailable, succeeded},
+			"DescribeSnapshots":       {completed},
+		}, "", map[string]int{"CreateSnapshot": 2, "CreateTags": 3, "ModifySnapshotAttribute": 2}),
+		Entry("fails once the tag policy's retries are used up", map[string][]scriptedResponse{
+			"CreateSnapshot": {created},
+			"CreateTags":     {throttled},
+		}, "tagging with namespace some-pipeline: tagging snap-1234: RequestLimitExceeded", map[string]int{"CreateTags": 3, "ModifySnapshotAttribute": 0}),
+		Entry("does not retry a request which is not permitted", map[string][]scriptedResponse{
+			"CreateSnapshot":          {created},
+			"CreateTags":              {succeeded},
+			"ModifySnapshotAttribute": {denied},
+		}, "making snapshot with id snap-1234 public: UnauthorizedOperation", map[string]int{"ModifySnapshotAttribute": 1, "DescribeSnapshots": 0}),
+	)
+})
@@ -0,0 +1,155 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/resources"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// ImageExpectation is the state a publish leaves its AMIs in, which SweepImage reconciles them with. Accessibility
+// is resources.PublicAmiAccessibility or resources.PrivateAmiAccessibility, or empty to leave the launch permission
+// alone, as for encrypted AMIs and AMIs whose publicity was decided by an earlier run. Tags are only compared for
+// their own keys, other tags of the AMI are left alone.
+type ImageExpectation struct {
+	Accessibility string
+	Tags          map[string]string
+}
+
+// MissingImageError is returned by SweepImage for an AMI which no longer exists, e.g. because it was deregistered
+// while the other regions were still publishing
+type MissingImageError struct {
+	ImageID string
+}
+
+func (e *MissingImageError) Error() string {
+	return fmt.Sprintf("AMI %s no longer exists", e.ImageID)
+}
+
+// ExpectedAccessibility returns the accessibility of the ImageExpectation of an AMI published with c. Encrypted AMIs
+// cannot be public, so their launch permission is left alone. AMIs are expected public once the publish has made them
+// so, and private only when the publish itself withheld their publicity: an AMI published by an earlier run may have
+// been promoted since.
+func ExpectedAccessibility(c config.AmiConfiguration, promoted bool, promotionFailed bool, publishedBefore bool) string {
+	if c.Encrypted {
+		return ""
+	}
+
+	public := c.Visibility == config.PublicVisibility
+	if public && (c.Promotion == "" || (promoted && !promotionFailed)) {
+		return resources.PublicAmiAccessibility
+	}
+	if publishedBefore {
+		return ""
+	}
+	return resources.PrivateAmiAccessibility
+}
+
+// SweepImage describes an AMI again once its publish has finished and repairs its launch permission and tags where
+// they have drifted from expected, returning a description of each repair. An AMI which no longer exists or is not
+// available cannot be repaired, and is returned as an error along with any repair which failed.
+func SweepImage(ec2Client ec2iface.EC2API, tagRetries config.RetryPolicy, imageID string, expected ImageExpectation) ([]string, error) {
+	output, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidAMIID.NotFound" {
+		return nil, &MissingImageError{ImageID: imageID}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("describing AMI %s: %s", imageID, err)
+	}
+	if len(output.Images) == 0 {
+		return nil, &MissingImageError{ImageID: imageID}
+	}
+
+	image := output.Images[0]
+	if state := aws.StringValue(image.State); state != ec2.ImageStateAvailable {
+		return nil, fmt.Errorf("AMI %s is %s rather than available", imageID, state)
+	}
+
+	repairs := []string{}
+	public := aws.BoolValue(image.Public)
+	if (expected.Accessibility == resources.PublicAmiAccessibility && !public) || (expected.Accessibility == resources.PrivateAmiAccessibility && public) {
+		visibility := expected.Accessibility
+		permission := []*ec2.LaunchPermission{{Group: aws.String(publicGroup)}}
+		modifications := &ec2.LaunchPermissionModifications{Remove: permission}
+		if visibility == resources.PublicAmiAccessibility {
+			modifications = &ec2.LaunchPermissionModifications{Add: permission}
+		}
+
+		_, err = ec2Client.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+			ImageId:          aws.String(imageID),
+			LaunchPermission: modifications,
+		})
+		if err != nil {
+			return repairs, fmt.Errorf("making AMI %s %s again: %s", imageID, visibility, err)
+		}
+		repairs = append(repairs, fmt.Sprintf("made AMI %s %s again", imageID, visibility))
+	}
+
+	actualTags := map[string]string{}
+	for _, tag := range image.Tags {
+		actualTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	driftedTags := map[string]string{}
+	driftedKeys := []string{}
+	for key, value := range expected.Tags {
+		if actual, ok := actualTags[key]; !ok || actual != value {
+			driftedTags[key] = value
+			driftedKeys = append(driftedKeys, key)
+		}
+	}
+	sort.Strings(driftedKeys)
+
+	if len(driftedTags) > 0 {
+		err = createTags(ec2Client, tagRetries, imageID, driftedTags)
+		if err != nil {
+			return repairs, err
+		}
+		repairs = append(repairs, fmt.Sprintf("restored tags %s of AMI %s", strings.Join(driftedKeys, ", "), imageID))
+	}
+
+	return repairs, nil
+}
+
+// SweepImages sweeps the AMI of every region at once with the client of its region and the expectation of its
+// region, see SweepImage. The repairs made are returned by region, along with the error of each region whose AMI
+// could not be repaired.
+func SweepImages(clients map[string]ec2iface.EC2API, tagRetries config.RetryPolicy, amis map[string]string, expected map[string]ImageExpectation) (map[string][]string, map[string]error) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	repairs := map[string][]string{}
+	errs := map[string]error{}
+	for region, amiID := range amis {
+		ec2Client, ok := clients[region]
+		if !ok {
+			errs[region] = fmt.Errorf("no ami_regions entry provides credentials for %s", region)
+			continue
+		}
+
+		wg.Add(1)
+		go func(region string, amiID string, ec2Client ec2iface.EC2API) {
+			defer wg.Done()
+
+			regionRepairs, err := SweepImage(ec2Client, tagRetries, amiID, expected[region])
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(regionRepairs) > 0 {
+				repairs[region] = regionRepairs
+			}
+			if err != nil {
+				errs[region] = err
+			}
+		}(region, amiID, ec2Client)
+	}
+	wg.Wait()
+
+	return repairs, errs
+}
@@ -0,0 +1,132 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/driver"
+	"light-stemcell-builder/resources"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SweepImages", func() {
+	described := func(state string, public bool, tags map[string]string) scriptedResponse {
+		return scriptedResponse{Output: func(data interface{}) {
+			image := &ec2.Image{State: aws.String(state), Public: aws.Bool(public)}
+			for key, value := range tags {
+				image.Tags = append(image.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+			}
+			data.(*ec2.DescribeImagesOutput).Images = []*ec2.Image{image}
+		}}
+	}
+	succeeded := scriptedResponse{}
+	notFound := scriptedResponse{StatusCode: http.StatusBadRequest, Code: "InvalidAMIID.NotFound"}
+
+	tags := map[string]string{"stemcell-version": "3312.1", "team": "bosh"}
+	public := driver.ImageExpectation{Accessibility: resources.PublicAmiAccessibility, Tags: tags}
+	tagRetries := config.RetryPolicy{MaxRetries: 2, BaseDelayMS: 1}
+
+	It("leaves AMIs which match their expectation alone", func() {
+		ec2Client := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImages": {described(ec2.ImageStateAvailable, true, map[string]string{"stemcell-version": "3312.1", "team": "bosh", "other": "tag"})},
+		})
+
+		repairs, errs := driver.SweepImages(map[string]ec2iface.EC2API{"us-east-1": ec2Client}, tagRetries, map[string]string{"us-east-1": "ami-1234"}, map[string]driver.ImageExpectation{"us-east-1": public})
+		Expect(errs).To(BeEmpty())
+		Expect(repairs).To(BeEmpty())
+		Expect(ec2Client.callCount("ModifyImageAttribute")).To(Equal(0))
+		Expect(ec2Client.callCount("CreateTags")).To(Equal(0))
+	})
+
+	It("repairs launch permissions and tags which drifted", func() {
+		ec2Client := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImages":       {described(ec2.ImageStateAvailable, false, map[string]string{"stemcell-version": "3312.0"})},
+			"ModifyImageAttribute": {succeeded},
+			"CreateTags":           {succeeded},
+		})
+
+		repairs, errs := driver.SweepImages(map[string]ec2iface.EC2API{"us-east-1": ec2Client}, tagRetries, map[string]string{"us-east-1": "ami-1234"}, map[string]driver.ImageExpectation{"us-east-1": public})
+		Expect(errs).To(BeEmpty())
+		Expect(repairs).To(Equal(map[string][]string{"us-east-1": {
+			"made AMI ami-1234 public again",
+			"restored tags stemcell-version, team of AMI ami-1234",
+		}}))
+		Expect(ec2Client.callCount("ModifyImageAttribute")).To(Equal(1))
+		Expect(ec2Client.callCount("CreateTags")).To(Equal(1))
+	})
+
+	It("only removes the public launch permission of AMIs expected private, leaving the others alone", func() {
+		withheldClient := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImages":       {described(ec2.ImageStateAvailable, true, tags)},
+			"ModifyImageAttribute": {succeeded},
+		})
+		promotedClient := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImages": {described(ec2.ImageStateAvailable, true, tags)},
+		})
+		encryptedClient := newScriptedEC2(map[string][]scriptedResponse{
+			"DescribeImages": {described(ec2.ImageStateAvailable, false, tags)},
+		})
+
+		repairs, errs := driver.SweepImages(
+			map[string]ec2iface.EC2API{"us-east-1": withheldClient, "eu-west-1": promotedClient, "ap-south-1": encryptedClient},
+			tagRetries,
+			map[string]string{"us-east-1": "ami-1234", "eu-west-1": "ami-5678", "ap-south-1": "ami-9012"},
+			map[string]driver.ImageExpectation{
+				"us-east-1":  {Accessibility: resources.PrivateAmiAccessibility, Tags: tags},
+				"eu-west-1":  {Tags: tags},
+				"ap-south-1": {Tags: tags},
+			},
+		)
+		Expect(errs).To(BeEmpty())
+		Expect(repairs).To(Equal(map[string][]string{"us-east-1": {"made AMI ami-1234 private again"}}))
+		Expect(promotedClient.callCount("ModifyImageAttribute")).To(Equal(0))
+		Expect(encryptedClient.callCount("ModifyImageAttribute")).To(Equal(0))
+	})
+
+	It("returns an error for AMIs which no longer exist or are not available", func() {
+		missingClient := newScriptedEC2(map[string][]scriptedResponse{"DescribeImages": {notFound}})
+		failedClient := newScriptedEC2(map[string][]scriptedResponse{"DescribeImages": {described(ec2.ImageStateFailed, true, nil)}})
+
+		repairs, errs := driver.SweepImages(
+			map[string]ec2iface.EC2API{"us-east-1": missingClient, "eu-west-1": failedClient},
+			tagRetries,
+			map[string]string{"us-east-1": "ami-1234", "eu-west-1": "ami-5678", "ap-south-1": "ami-9012"},
+			map[string]driver.ImageExpectation{"us-east-1": public, "eu-west-1": public, "ap-south-1": public},
+		)
+		Expect(repairs).To(BeEmpty())
+		Expect(errs).To(HaveLen(3))
+		Expect(errs["us-east-1"]).To(Equal(&driver.MissingImageError{ImageID: "ami-1234"}))
+		Expect(errs["eu-west-1"]).To(MatchError("AMI ami-5678 is failed rather than available"))
+		Expect(errs["ap-south-1"]).To(MatchError("no ami_regions entry provides credentials for ap-south-1"))
+	})
+})
+
+var _ = Describe("ExpectedAccessibility", func() {
+	publicConfig := config.AmiConfiguration{Visibility: config.PublicVisibility}
+
+	It("expects public AMIs to be public once they have been promoted, if they are promoted at all", func() {
+		Expect(driver.ExpectedAccessibility(publicConfig, false, false, false)).To(Equal(resources.PublicAmiAccessibility))
+
+		promotedConfig := publicConfig
+		promotedConfig.Promotion = config.AfterPublishPromotion
+		Expect(driver.ExpectedAccessibility(promotedConfig, true, false, false)).To(Equal(resources.PublicAmiAccessibility))
+		Expect(driver.ExpectedAccessibility(promotedConfig, true, true, false)).To(Equal(resources.PrivateAmiAccessibility))
+	})
+
+	It("leaves encrypted AMIs alone, since they cannot be public", func() {
+		encryptedConfig := publicConfig
+		encryptedConfig.Encrypted = true
+		Expect(driver.ExpectedAccessibility(encryptedConfig, false, false, false)).To(BeEmpty())
+	})
+
+	It("leaves AMIs an earlier run published alone while this one withholds publicity, since they may have been promoted", func() {
+		manualConfig := publicConfig
+		manualConfig.Promotion = config.ManualPromotion
+		Expect(driver.ExpectedAccessibility(manualConfig, false, false, true)).To(BeEmpty())
+		Expect(driver.ExpectedAccessibility(manualConfig, false, false, false)).To(Equal(resources.PrivateAmiAccessibility))
+	})
+})
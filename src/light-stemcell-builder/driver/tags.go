@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// createTags applies tags to the EC2 resource, retrying with the tag policy
+func createTags(ec2Client ec2iface.EC2API, tagRetries config.RetryPolicy, resourceID string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys := []string{}
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ec2Tags := []*ec2.Tag{}
+	for _, key := range keys {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+
+	req, _ := ec2Client.CreateTagsRequest(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(resourceID)},
+		Tags:      ec2Tags,
+	})
+	err := sendWithRetryer(req, NewPhaseRetryer(tagRetries, defaultRetries))
+	if err != nil {
+		return fmt.Errorf("tagging %s: %s", resourceID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ThrottledReader limits the average rate at which the underlying reader is read, which in turn bounds the rate
+// the uploader can send the machine image to S3
+type ThrottledReader struct {
+	reader   io.Reader
+	throttle *throttle
+}
+
+// throttle keeps count of the bytes read through every ThrottledReader sharing it, so that parts of an image read
+// concurrently are limited to bytesPerSecond between them
+type throttle struct {
+	mutex          sync.Mutex
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+// NewThrottledReader wraps the provided reader in a ThrottledReader allowing bytesPerSecond on average
+func NewThrottledReader(r io.Reader, bytesPerSecond int64) *ThrottledReader {
+	return newThrottle(bytesPerSecond).wrap(r)
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{bytesPerSecond: bytesPerSecond}
+}
+
+// wrap returns a ThrottledReader of r whose reads count towards the rate of t
+func (t *throttle) wrap(r io.Reader) *ThrottledReader {
+	return &ThrottledReader{reader: r, throttle: t}
+}
+
+// Read reads from the underlying reader, first sleeping for as long as the reads so far are ahead of the rate
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	// reads are capped at a tenth of a second's worth so the rate is smooth rather than bursty
+	if max := t.throttle.bytesPerSecond / 10; max > 0 && int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	if wait := t.throttle.reserve(int64(len(p))); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	n, err := t.reader.Read(p)
+	t.throttle.release(int64(len(p) - n))
+	return n, err
+}
+
+// reserve counts n bytes as read, returning how long to wait before reading them
+func (t *throttle) reserve(n int64) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// whole seconds are counted apart from the remainder, as the nanoseconds of t.read overflow past 8.6 GiB
+	secs := t.read / t.bytesPerSecond
+	rem := t.read % t.bytesPerSecond
+	due := t.start.Add(time.Duration(secs)*time.Second + time.Duration(rem*int64(time.Second)/t.bytesPerSecond))
+	t.read += n
+	return time.Until(due)
+}
+
+// release gives back reserved bytes which were not read
+func (t *throttle) release(n int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.read -= n
+}
@@ -0,0 +1,49 @@
+package driver_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"light-stemcell-builder/driver"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ThrottledReader", func() {
+	It("reads everything from the underlying reader", func() {
+		content := bytes.Repeat([]byte("a"), 1000)
+
+		read, err := ioutil.ReadAll(driver.NewThrottledReader(bytes.NewReader(content), 1<<20))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(content))
+	})
+
+	It("does not read faster than the configured rate", func() {
+		content := bytes.Repeat([]byte("a"), 3000)
+
+		start := time.Now()
+		_, err := ioutil.ReadAll(driver.NewThrottledReader(bytes.NewReader(content), 10000))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("keeps throttling once more than 10 GiB has been read", func() {
+		reader := driver.NewThrottledReader(endlessReader{}, 8<<30)
+		buffer := make([]byte, 8<<20)
+
+		start := time.Now()
+		for read := 0; read < 21<<29; read += len(buffer) {
+			_, err := reader.Read(buffer)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(time.Since(start)).To(BeNumerically(">=", 1250*time.Millisecond))
+	})
+})
+
+// endlessReader reads any amount without filling the buffer
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// VerifyImageCopy compares every EBS snapshot of the copied image with the snapshot on the same device of the
+// source image, returning an error when a device is missing, a snapshot is incomplete or the sizes differ.
+// The SDK offers no access to snapshot block checksums, so the comparison is limited to sizes.
+func VerifyImageCopy(source ec2iface.EC2API, destination ec2iface.EC2API, sourceImageID string, copiedImageID string) error {
+	sourceSnapshots, err := imageSnapshots(source, sourceImageID)
+	if err != nil {
+		return err
+	}
+
+	copiedSnapshots, err := imageSnapshots(destination, copiedImageID)
+	if err != nil {
+		return err
+	}
+
+	if len(copiedSnapshots) != len(sourceSnapshots) {
+		return fmt.Errorf("image %s has %d EBS snapshots but its source %s has %d", copiedImageID, len(copiedSnapshots), sourceImageID, len(sourceSnapshots))
+	}
+
+	for device, sourceSnapshot := range sourceSnapshots {
+		copiedSnapshot, found := copiedSnapshots[device]
+		if !found {
+			return fmt.Errorf("image %s has no snapshot for device %s of its source %s", copiedImageID, device, sourceImageID)
+		}
+
+		if aws.StringValue(copiedSnapshot.State) != ec2.SnapshotStateCompleted {
+			return fmt.Errorf("snapshot %s of image %s is %s rather than completed", aws.StringValue(copiedSnapshot.SnapshotId), copiedImageID, aws.StringValue(copiedSnapshot.State))
+		}
+
+		if aws.Int64Value(copiedSnapshot.VolumeSize) != aws.Int64Value(sourceSnapshot.VolumeSize) {
+			return fmt.Errorf("snapshot %s for device %s is %d GiB but source snapshot %s is %d GiB",
+				aws.StringValue(copiedSnapshot.SnapshotId), device, aws.Int64Value(copiedSnapshot.VolumeSize),
+				aws.StringValue(sourceSnapshot.SnapshotId), aws.Int64Value(sourceSnapshot.VolumeSize))
+		}
+	}
+
+	return nil
+}
+
+// imageSnapshots returns the EBS snapshots of the image by device name
+func imageSnapshots(ec2Client ec2iface.EC2API, imageID string) (map[string]*ec2.Snapshot, error) {
+	imagesOutput, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageID)}})
+	if err != nil {
+		return nil, fmt.Errorf("describing image %s: %s", imageID, err)
+	}
+	if len(imagesOutput.Images) != 1 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+
+	devices := map[string]string{}
+	snapshotIDs := []*string{}
+	for _, mapping := range imagesOutput.Images[0].BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+			continue
+		}
+		devices[*mapping.Ebs.SnapshotId] = aws.StringValue(mapping.DeviceName)
+		snapshotIDs = append(snapshotIDs, mapping.Ebs.SnapshotId)
+	}
+
+	snapshots := map[string]*ec2.Snapshot{}
+	if len(snapshotIDs) == 0 {
+		return snapshots, nil
+	}
+
+	snapshotsOutput, err := ec2Client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{SnapshotIds: snapshotIDs})
+	if err != nil {
+		return nil, fmt.Errorf("describing snapshots of image %s: %s", imageID, err)
+	}
+
+	for _, snapshot := range snapshotsOutput.Snapshots {
+		snapshots[devices[aws.StringValue(snapshot.SnapshotId)]] = snapshot
+	}
+	if len(snapshots) != len(snapshotIDs) {
+		return nil, fmt.Errorf("describing snapshots of image %s: found %d of %d snapshots", imageID, len(snapshots), len(snapshotIDs))
+	}
+
+	return snapshots, nil
+}
@@ -0,0 +1,64 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/driver"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeImageEC2 struct {
+	ec2iface.EC2API
+	snapshotID string
+	state      string
+	size       int64
+}
+
+func (f *fakeImageEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+		{
+			ImageId: input.ImageIds[0],
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String(f.snapshotID)}},
+				{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+			},
+		},
+	}}, nil
+}
+
+func (f *fakeImageEC2) DescribeSnapshots(input *ec2.DescribeSnapshotsInput) (*ec2.DescribeSnapshotsOutput, error) {
+	return &ec2.DescribeSnapshotsOutput{Snapshots: []*ec2.Snapshot{
+		{SnapshotId: input.SnapshotIds[0], State: aws.String(f.state), VolumeSize: aws.Int64(f.size)},
+	}}, nil
+}
+
+var _ = Describe("VerifyImageCopy", func() {
+	var source *fakeImageEC2
+	var copied *fakeImageEC2
+
+	BeforeEach(func() {
+		source = &fakeImageEC2{snapshotID: "snap-source", state: ec2.SnapshotStateCompleted, size: 3}
+		copied = &fakeImageEC2{snapshotID: "snap-copy", state: ec2.SnapshotStateCompleted, size: 3}
+	})
+
+	It("accepts a copy whose snapshots match the source", func() {
+		Expect(driver.VerifyImageCopy(source, copied, "ami-source", "ami-copy")).To(Succeed())
+	})
+
+	It("rejects a copy whose snapshot differs in size", func() {
+		copied.size = 2
+
+		err := driver.VerifyImageCopy(source, copied, "ami-source", "ami-copy")
+		Expect(err).To(MatchError("snapshot snap-copy for device /dev/xvda is 2 GiB but source snapshot snap-source is 3 GiB"))
+	})
+
+	It("rejects a copy whose snapshot is not completed", func() {
+		copied.state = ec2.SnapshotStatePending
+
+		err := driver.VerifyImageCopy(source, copied, "ami-source", "ami-copy")
+		Expect(err).To(MatchError("snapshot snap-copy of image ami-copy is pending rather than completed"))
+	})
+})
@@ -40,7 +40,7 @@ var _ = Describe("Volume Driver Lifecycle", func() {
 		bucketName := os.Getenv("AWS_BUCKET_NAME")
 		Expect(bucketName).ToNot(BeEmpty(), "AWS_BUCKET_NAME must be set")
 
-		createMachineImageDriver := driver.NewCreateMachineImageManifestDriver(GinkgoWriter, creds)
+		createMachineImageDriver := driver.NewCreateMachineImageManifestDriver(GinkgoWriter, creds, config.Retries{})
 		machineImageDriverConfig := resources.MachineImageDriverConfig{
 			MachineImagePath: machineImagePath,
 			FileFormat:       machineImageFormat,
@@ -55,7 +55,7 @@ var _ = Describe("Volume Driver Lifecycle", func() {
 			MachineImageManifestURL: machineImage.GetURL,
 		}
 
-		createVolumeDriver := driver.NewCreateVolumeDriver(GinkgoWriter, creds)
+		createVolumeDriver := driver.NewCreateVolumeDriver(GinkgoWriter, creds, config.Retries{}, config.ManifestFetch{})
 
 		volume, err := createVolumeDriver.Create(volumeDriverConfig)
 		Expect(err).ToNot(HaveOccurred())
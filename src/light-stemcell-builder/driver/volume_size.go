@@ -0,0 +1,28 @@
+package driver
+
+import (
+	"fmt"
+	"light-stemcell-builder/resources"
+)
+
+// gbInBytes is the size of the GiB which EBS volumes and snapshots are provisioned in
+const gbInBytes = 1 << 30
+
+// VolumeSizeGB returns the size in GiB of the smallest volume which holds sizeBytes, rounding up to a whole GiB
+// with integer arithmetic so that no size is rounded through a float
+func VolumeSizeGB(sizeBytes int64) int64 {
+	return (sizeBytes + gbInBytes - 1) / gbInBytes
+}
+
+// CheckVolumeSize returns an error when a volume of volumeSizeGB cannot hold a RAW machine image of sizeBytes.
+// Images in other formats are compressed or sparse, so their size says nothing about the volume they need.
+func CheckVolumeSize(volumeSizeGB int64, sizeBytes int64, format string) error {
+	if format != resources.VolumeRawFormat || volumeSizeGB == 0 {
+		return nil
+	}
+
+	if required := VolumeSizeGB(sizeBytes); volumeSizeGB < required {
+		return fmt.Errorf("volume size of %d GiB is too small for the %d byte RAW machine image, which needs at least %d GiB", volumeSizeGB, sizeBytes, required)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package driver_test
+
+import (
+	"light-stemcell-builder/driver"
+	"light-stemcell-builder/driver/manifests"
+	"light-stemcell-builder/resources"
+
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const tib = int64(1) << 40
+
+var _ = Describe("VolumeSizeGB", func() {
+	It("rounds up to a whole GiB, including for images larger than 1 TiB", func() {
+		Expect(driver.VolumeSizeGB(1)).To(Equal(int64(1)))
+		Expect(driver.VolumeSizeGB(1 << 30)).To(Equal(int64(1)))
+		Expect(driver.VolumeSizeGB(tib)).To(Equal(int64(1024)))
+		Expect(driver.VolumeSizeGB(3*tib/2 + 1)).To(Equal(int64(1537)))
+		Expect(driver.VolumeSizeGB(16 * tib)).To(Equal(int64(16384)))
+	})
+
+	It("rejects a volume size too small for a RAW image, ignoring other formats", func() {
+		Expect(driver.CheckVolumeSize(2048, 2*tib, resources.VolumeRawFormat)).To(Succeed())
+		Expect(driver.CheckVolumeSize(0, 2*tib, resources.VolumeRawFormat)).To(Succeed())
+		Expect(driver.CheckVolumeSize(1024, 2*tib, resources.VolumeVMDKFormat)).To(Succeed())
+
+		err := driver.CheckVolumeSize(2048, 2*tib+1, resources.VolumeRawFormat)
+		Expect(err).To(MatchError("volume size of 2048 GiB is too small for the 2199023255553 byte RAW machine image, which needs at least 2049 GiB"))
+	})
+})
+
+var _ = Describe("ImportVolumeInput", func() {
+	It("gives both the image and the volume in GiB, as the API documents its Bytes field", func() {
+		m := manifests.ImportVolumeManifest{FileFormat: resources.VolumeRawFormat, SizeBytes: 3*tib/2 + 1, VolumeSizeGB: 1537}
+
+		input := driver.ImportVolumeInput("https://example.com/manifest.xml", aws.String("us-east-1a"), m)
+		Expect(*input.Image.Bytes).To(Equal(int64(1537)))
+		Expect(*input.Image.ImportManifestUrl).To(Equal("https://example.com/manifest.xml"))
+		Expect(*input.Volume.Size).To(Equal(int64(1537)))
+		Expect(*input.AvailabilityZone).To(Equal("us-east-1a"))
+	})
+})
@@ -32,6 +32,12 @@ type FakeIsolatedRegionDriverSet struct {
 	createAmiDriverReturns     struct {
 		result1 resources.AmiDriver
 	}
+	ArchiveSnapshotDriverStub        func() resources.SnapshotDriver
+	archiveSnapshotDriverMutex       sync.RWMutex
+	archiveSnapshotDriverArgsForCall []struct{}
+	archiveSnapshotDriverReturns     struct {
+		result1 resources.SnapshotDriver
+	}
 }
 
 func (fake *FakeIsolatedRegionDriverSet) MachineImageDriver() resources.MachineImageDriver {
@@ -130,4 +136,28 @@ func (fake *FakeIsolatedRegionDriverSet) CreateAmiDriverReturns(result1 resource
 	}{result1}
 }
 
+func (fake *FakeIsolatedRegionDriverSet) ArchiveSnapshotDriver() resources.SnapshotDriver {
+	fake.archiveSnapshotDriverMutex.Lock()
+	fake.archiveSnapshotDriverArgsForCall = append(fake.archiveSnapshotDriverArgsForCall, struct{}{})
+	fake.archiveSnapshotDriverMutex.Unlock()
+	if fake.ArchiveSnapshotDriverStub != nil {
+		return fake.ArchiveSnapshotDriverStub()
+	} else {
+		return fake.archiveSnapshotDriverReturns.result1
+	}
+}
+
+func (fake *FakeIsolatedRegionDriverSet) ArchiveSnapshotDriverCallCount() int {
+	fake.archiveSnapshotDriverMutex.RLock()
+	defer fake.archiveSnapshotDriverMutex.RUnlock()
+	return len(fake.archiveSnapshotDriverArgsForCall)
+}
+
+func (fake *FakeIsolatedRegionDriverSet) ArchiveSnapshotDriverReturns(result1 resources.SnapshotDriver) {
+	fake.ArchiveSnapshotDriverStub = nil
+	fake.archiveSnapshotDriverReturns = struct {
+		result1 resources.SnapshotDriver
+	}{result1}
+}
+
 var _ driverset.IsolatedRegionDriverSet = new(FakeIsolatedRegionDriverSet)
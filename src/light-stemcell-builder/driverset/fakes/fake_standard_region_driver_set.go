@@ -32,6 +32,20 @@ type FakeStandardRegionDriverSet struct {
 	copyAmiDriverReturns     struct {
 		result1 resources.AmiDriver
 	}
+	ArchiveSnapshotDriverStub        func() resources.SnapshotDriver
+	archiveSnapshotDriverMutex       sync.RWMutex
+	archiveSnapshotDriverArgsForCall []struct{}
+	archiveSnapshotDriverReturns     struct {
+		result1 resources.SnapshotDriver
+	}
+	ArchiveSnapshotDriverForStub        func(region string) resources.SnapshotDriver
+	archiveSnapshotDriverForMutex       sync.RWMutex
+	archiveSnapshotDriverForArgsForCall []struct {
+		region string
+	}
+	archiveSnapshotDriverForReturns struct {
+		result1 resources.SnapshotDriver
+	}
 }
 
 func (fake *FakeStandardRegionDriverSet) MachineImageDriver() resources.MachineImageDriver {
@@ -130,4 +144,60 @@ func (fake *FakeStandardRegionDriverSet) CopyAmiDriverReturns(result1 resources.
 	}{result1}
 }
 
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriver() resources.SnapshotDriver {
+	fake.archiveSnapshotDriverMutex.Lock()
+	fake.archiveSnapshotDriverArgsForCall = append(fake.archiveSnapshotDriverArgsForCall, struct{}{})
+	fake.archiveSnapshotDriverMutex.Unlock()
+	if fake.ArchiveSnapshotDriverStub != nil {
+		return fake.ArchiveSnapshotDriverStub()
+	} else {
+		return fake.archiveSnapshotDriverReturns.result1
+	}
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverCallCount() int {
+	fake.archiveSnapshotDriverMutex.RLock()
+	defer fake.archiveSnapshotDriverMutex.RUnlock()
+	return len(fake.archiveSnapshotDriverArgsForCall)
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverReturns(result1 resources.SnapshotDriver) {
+	fake.ArchiveSnapshotDriverStub = nil
+	fake.archiveSnapshotDriverReturns = struct {
+		result1 resources.SnapshotDriver
+	}{result1}
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverFor(region string) resources.SnapshotDriver {
+	fake.archiveSnapshotDriverForMutex.Lock()
+	fake.archiveSnapshotDriverForArgsForCall = append(fake.archiveSnapshotDriverForArgsForCall, struct {
+		region string
+	}{region})
+	fake.archiveSnapshotDriverForMutex.Unlock()
+	if fake.ArchiveSnapshotDriverForStub != nil {
+		return fake.ArchiveSnapshotDriverForStub(region)
+	} else {
+		return fake.archiveSnapshotDriverForReturns.result1
+	}
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverForCallCount() int {
+	fake.archiveSnapshotDriverForMutex.RLock()
+	defer fake.archiveSnapshotDriverForMutex.RUnlock()
+	return len(fake.archiveSnapshotDriverForArgsForCall)
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverForArgsForCall(i int) string {
+	fake.archiveSnapshotDriverForMutex.RLock()
+	defer fake.archiveSnapshotDriverForMutex.RUnlock()
+	return fake.archiveSnapshotDriverForArgsForCall[i].region
+}
+
+func (fake *FakeStandardRegionDriverSet) ArchiveSnapshotDriverForReturns(result1 resources.SnapshotDriver) {
+	fake.ArchiveSnapshotDriverForStub = nil
+	fake.archiveSnapshotDriverForReturns = struct {
+		result1 resources.SnapshotDriver
+	}{result1}
+}
+
 var _ driverset.StandardRegionDriverSet = new(FakeStandardRegionDriverSet)
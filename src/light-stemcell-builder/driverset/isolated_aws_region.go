@@ -13,6 +13,7 @@ type IsolatedRegionDriverSet interface {
 	VolumeDriver() resources.VolumeDriver
 	CreateSnapshotDriver() resources.SnapshotDriver
 	CreateAmiDriver() resources.AmiDriver
+	ArchiveSnapshotDriver() resources.SnapshotDriver
 }
 
 type isolatedRegionDriverSet struct {
@@ -20,26 +21,28 @@ type isolatedRegionDriverSet struct {
 	volumeDriver       resources.VolumeDriver
 	snapshotDriver     *driver.SDKSnapshotFromVolumeDriver
 	createAmiDriver    *driver.SDKCreateAmiDriver
+	archiveDriver      *driver.SDKCopySnapshotDriver
 }
 
-func NewIsolatedRegionDriverSet(logDest io.Writer, creds config.Credentials) IsolatedRegionDriverSet {
+func NewIsolatedRegionDriverSet(logDest io.Writer, creds config.Credentials, retries config.Retries, manifestFetch config.ManifestFetch) IsolatedRegionDriverSet {
 	return &isolatedRegionDriverSet{
 		machineImageDriver: struct {
 			*driver.SDKCreateMachineImageManifestDriver
 			*driver.SDKDeleteMachineImageDriver
 		}{
-			driver.NewCreateMachineImageManifestDriver(logDest, creds),
+			driver.NewCreateMachineImageManifestDriver(logDest, creds, retries),
 			driver.NewDeleteMachineImageDriver(logDest, creds),
 		},
 		volumeDriver: struct {
 			*driver.SDKCreateVolumeDriver
 			*driver.SDKDeleteVolumeDriver
 		}{
-			driver.NewCreateVolumeDriver(logDest, creds),
+			driver.NewCreateVolumeDriver(logDest, creds, retries, manifestFetch),
 			driver.NewDeleteVolumeDriver(logDest, creds),
 		},
-		snapshotDriver:  driver.NewSnapshotFromVolumeDriver(logDest, creds),
-		createAmiDriver: driver.NewCreateAmiDriver(logDest, creds),
+		snapshotDriver:  driver.NewSnapshotFromVolumeDriver(logDest, creds, retries),
+		createAmiDriver: driver.NewCreateAmiDriver(logDest, creds, retries),
+		archiveDriver:   driver.NewCopySnapshotDriver(logDest, creds, retries),
 	}
 }
 
@@ -58,3 +61,7 @@ func (s *isolatedRegionDriverSet) CreateSnapshotDriver() resources.SnapshotDrive
 func (s *isolatedRegionDriverSet) CreateAmiDriver() resources.AmiDriver {
 	return s.createAmiDriver
 }
+
+func (s *isolatedRegionDriverSet) ArchiveSnapshotDriver() resources.SnapshotDriver {
+	return s.archiveDriver
+}
@@ -13,7 +13,7 @@ var _ = Describe("IsolatedAwsRegion", func() {
 	It("returns drivers of the correct type", func() {
 
 		creds := config.Credentials{}
-		ds := driverset.NewIsolatedRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewIsolatedRegionDriverSet(GinkgoWriter, creds, config.Retries{}, config.ManifestFetch{})
 
 		Expect(ds.MachineImageDriver()).To(BeAssignableToTypeOf(struct {
 			*driver.SDKCreateMachineImageManifestDriver
@@ -25,5 +25,6 @@ var _ = Describe("IsolatedAwsRegion", func() {
 		}{}))
 		Expect(ds.CreateSnapshotDriver()).To(BeAssignableToTypeOf(&driver.SDKSnapshotFromVolumeDriver{}))
 		Expect(ds.CreateAmiDriver()).To(BeAssignableToTypeOf(&driver.SDKCreateAmiDriver{}))
+		Expect(ds.ArchiveSnapshotDriver()).To(BeAssignableToTypeOf(&driver.SDKCopySnapshotDriver{}))
 	})
 })
@@ -13,27 +13,37 @@ type StandardRegionDriverSet interface {
 	CreateSnapshotDriver() resources.SnapshotDriver
 	CreateAmiDriver() resources.AmiDriver
 	CopyAmiDriver() resources.AmiDriver
+	ArchiveSnapshotDriver() resources.SnapshotDriver
+	ArchiveSnapshotDriverFor(region string) resources.SnapshotDriver
 }
 
 type standardRegionDriverSet struct {
+	logDest            io.Writer
+	creds              config.Credentials
+	retries            config.Retries
 	machineImageDriver resources.MachineImageDriver
 	snapshotDriver     *driver.SDKSnapshotFromImageDriver
 	amiDriver          *driver.SDKCreateAmiDriver
 	copyAmiDriver      *driver.SDKCopyAmiDriver
+	archiveDriver      *driver.SDKCopySnapshotDriver
 }
 
-func NewStandardRegionDriverSet(logDest io.Writer, creds config.Credentials) StandardRegionDriverSet {
+func NewStandardRegionDriverSet(logDest io.Writer, creds config.Credentials, retries config.Retries) StandardRegionDriverSet {
 	return &standardRegionDriverSet{
+		logDest: logDest,
+		creds:   creds,
+		retries: retries,
 		machineImageDriver: struct {
 			*driver.SDKCreateMachineImageDriver
 			*driver.SDKDeleteMachineImageDriver
 		}{
-			driver.NewCreateMachineImageDriver(logDest, creds),
+			driver.NewCreateMachineImageDriver(logDest, creds, retries),
 			driver.NewDeleteMachineImageDriver(logDest, creds),
 		},
-		snapshotDriver: driver.NewSnapshotFromImageDriver(logDest, creds),
-		amiDriver:      driver.NewCreateAmiDriver(logDest, creds),
-		copyAmiDriver:  driver.NewCopyAmiDriver(logDest, creds),
+		snapshotDriver: driver.NewSnapshotFromImageDriver(logDest, creds, retries),
+		amiDriver:      driver.NewCreateAmiDriver(logDest, creds, retries),
+		copyAmiDriver:  driver.NewCopyAmiDriver(logDest, creds, retries),
+		archiveDriver:  driver.NewCopySnapshotDriver(logDest, creds, retries),
 	}
 }
 
@@ -52,3 +62,14 @@ func (s *standardRegionDriverSet) CreateAmiDriver() resources.AmiDriver {
 func (s *standardRegionDriverSet) CopyAmiDriver() resources.AmiDriver {
 	return s.copyAmiDriver
 }
+
+func (s *standardRegionDriverSet) ArchiveSnapshotDriver() resources.SnapshotDriver {
+	return s.archiveDriver
+}
+
+// ArchiveSnapshotDriverFor returns a driver archiving snapshots in region, where an AMI was copied to
+func (s *standardRegionDriverSet) ArchiveSnapshotDriverFor(region string) resources.SnapshotDriver {
+	regionCreds := s.creds
+	regionCreds.Region = region
+	return driver.NewCopySnapshotDriver(s.logDest, regionCreds, s.retries)
+}
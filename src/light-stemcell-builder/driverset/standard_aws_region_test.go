@@ -13,7 +13,7 @@ var _ = Describe("StandardAwsRegion", func() {
 	It("returns drivers of the correct type", func() {
 
 		creds := config.Credentials{}
-		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds)
+		ds := driverset.NewStandardRegionDriverSet(GinkgoWriter, creds, config.Retries{})
 
 		Expect(ds.MachineImageDriver()).To(BeAssignableToTypeOf(struct {
 			*driver.SDKCreateMachineImageDriver
@@ -22,5 +22,7 @@ var _ = Describe("StandardAwsRegion", func() {
 		Expect(ds.CreateSnapshotDriver()).To(BeAssignableToTypeOf(&driver.SDKSnapshotFromImageDriver{}))
 		Expect(ds.CreateAmiDriver()).To(BeAssignableToTypeOf(&driver.SDKCreateAmiDriver{}))
 		Expect(ds.CopyAmiDriver()).To(BeAssignableToTypeOf(&driver.SDKCopyAmiDriver{}))
+		Expect(ds.ArchiveSnapshotDriver()).To(BeAssignableToTypeOf(&driver.SDKCopySnapshotDriver{}))
+		Expect(ds.ArchiveSnapshotDriverFor("eu-west-1")).To(BeAssignableToTypeOf(&driver.SDKCopySnapshotDriver{}))
 	})
 })
@@ -0,0 +1,245 @@
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"light-stemcell-builder/plan"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Actions of the mutating EC2 calls made while publishing
+const (
+	ImportSnapshotAction          = "ImportSnapshot"
+	ImportVolumeAction            = "ImportVolume"
+	CreateSnapshotAction          = "CreateSnapshot"
+	DeleteVolumeAction            = "DeleteVolume"
+	ModifySnapshotAttributeAction = "ModifySnapshotAttribute"
+	RegisterImageAction           = "RegisterImage"
+	ModifyImageAttributeAction    = "ModifyImageAttribute"
+	CopyImageAction               = "CopyImage"
+	CopySnapshotAction            = "CopySnapshot"
+	CreateTagsAction              = "CreateTags"
+)
+
+// Verification outcomes
+const (
+	AuthorizedStatus   = "authorized"
+	UnauthorizedStatus = "unauthorized"
+	// InconclusiveStatus is used when EC2 rejected the request for a reason other than authorization,
+	// typically because a call which acts on an existing resource was given a placeholder ID
+	InconclusiveStatus = "inconclusive"
+)
+
+// placeholder IDs for calls which act on resources that only exist part way through a publish
+const (
+	placeholderSnapshotID = "snap-00000000"
+	placeholderVolumeID   = "vol-00000000"
+	placeholderImageID    = "ami-00000000"
+)
+
+// Operation is a mutating EC2 call the publish will make in Region. SourceRegion is set for copies.
+type Operation struct {
+	Region       string
+	SourceRegion string
+	Action       string
+	Credentials  config.Credentials
+}
+
+// Result is the outcome of issuing an Operation with DryRun set
+type Result struct {
+	Operation
+	Status string
+	Err    error
+}
+
+// Operations lists the mutating EC2 calls publishing with c will make, in the order they are made per region
+func Operations(c config.Config) []Operation {
+	operations := []Operation{}
+	for _, regionConfig := range c.AmiRegions {
+		op := func(action string) Operation {
+			return Operation{Region: regionConfig.PublishRegion(), Action: action, Credentials: regionConfig.Credentials}
+		}
+
+		if regionConfig.IsolatedRegion {
+			operations = append(operations, op(ImportVolumeAction))
+			if regionConfig.VolumeDeletion.Timing == config.DelayedVolumeDeletion {
+				operations = append(operations, op(CreateTagsAction))
+			}
+			operations = append(operations, op(CreateSnapshotAction), op(ModifySnapshotAttributeAction))
+			if regionConfig.VolumeDeletion.Timing == "" || regionConfig.VolumeDeletion.Timing == config.ImmediateVolumeDeletion {
+				operations = append(operations, op(DeleteVolumeAction))
+			}
+		} else {
+			operations = append(operations,
+				op(ImportSnapshotAction),
+				op(ModifySnapshotAttributeAction),
+			)
+		}
+
+		operations = append(operations, op(RegisterImageAction), op(CreateTagsAction))
+		if c.AmiConfiguration.Visibility == config.PublicVisibility {
+			operations = append(operations, op(ModifyImageAttributeAction))
+		}
+
+		if regionConfig.ArchiveSnapshotCopies > 0 {
+			operations = append(operations, op(CopySnapshotAction))
+		}
+
+		for _, destination := range regionConfig.Destinations {
+			creds := regionConfig.Credentials
+			creds.Region = destination
+			operations = append(operations, Operation{
+				Region:       destination,
+				SourceRegion: regionConfig.CopySource(destination),
+				Action:       CopyImageAction,
+				Credentials:  creds,
+			})
+			if regionConfig.ArchiveSnapshotCopies > 0 {
+				operations = append(operations, Operation{Region: destination, Action: CopySnapshotAction, Credentials: creds})
+			}
+		}
+	}
+
+	return operations
+}
+
+// NewEC2Client creates an EC2 client for the region and credentials in creds
+func NewEC2Client(creds config.Credentials) ec2iface.EC2API {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region)
+
+	return ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+}
+
+// Verify issues op with DryRun set, reporting whether the caller is authorized to make it
+func Verify(ec2Client ec2iface.EC2API, op Operation) Result {
+	dryRun := aws.Bool(true)
+
+	var err error
+	switch op.Action {
+	case ImportSnapshotAction:
+		_, err = ec2Client.ImportSnapshot(&ec2.ImportSnapshotInput{DryRun: dryRun})
+	case ImportVolumeAction:
+		_, err = ec2Client.ImportVolume(&ec2.ImportVolumeInput{
+			DryRun:           dryRun,
+			AvailabilityZone: aws.String(op.Region + "a"),
+			Image: &ec2.DiskImageDetail{
+				Bytes:             aws.Int64(1),
+				Format:            aws.String(ec2.DiskImageFormatRaw),
+				ImportManifestUrl: aws.String("https://example.com/manifest.xml"),
+			},
+			Volume: &ec2.VolumeDetail{Size: aws.Int64(1)},
+		})
+	case CreateSnapshotAction:
+		_, err = ec2Client.CreateSnapshot(&ec2.CreateSnapshotInput{DryRun: dryRun, VolumeId: aws.String(placeholderVolumeID)})
+	case DeleteVolumeAction:
+		_, err = ec2Client.DeleteVolume(&ec2.DeleteVolumeInput{DryRun: dryRun, VolumeId: aws.String(placeholderVolumeID)})
+	case ModifySnapshotAttributeAction:
+		_, err = ec2Client.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+			DryRun:        dryRun,
+			SnapshotId:    aws.String(placeholderSnapshotID),
+			Attribute:     aws.String("createVolumePermission"),
+			OperationType: aws.String("add"),
+			GroupNames:    []*string{aws.String("all")},
+		})
+	case RegisterImageAction:
+		_, err = ec2Client.RegisterImage(&ec2.RegisterImageInput{
+			DryRun:         dryRun,
+			Name:           aws.String("light-stemcell-builder-dry-run"),
+			RootDeviceName: aws.String("/dev/xvda"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String(placeholderSnapshotID)},
+				},
+			},
+		})
+	case ModifyImageAttributeAction:
+		_, err = ec2Client.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+			DryRun:  dryRun,
+			ImageId: aws.String(placeholderImageID),
+			LaunchPermission: &ec2.LaunchPermissionModifications{
+				Add: []*ec2.LaunchPermission{{Group: aws.String("all")}},
+			},
+		})
+	case CopyImageAction:
+		_, err = ec2Client.CopyImage(&ec2.CopyImageInput{
+			DryRun:        dryRun,
+			Name:          aws.String("light-stemcell-builder-dry-run"),
+			SourceImageId: aws.String(placeholderImageID),
+			SourceRegion:  aws.String(op.SourceRegion),
+		})
+	case CopySnapshotAction:
+		_, err = ec2Client.CopySnapshot(&ec2.CopySnapshotInput{
+			DryRun:           dryRun,
+			SourceSnapshotId: aws.String(placeholderSnapshotID),
+			SourceRegion:     aws.String(op.Region),
+		})
+	case CreateTagsAction:
+		_, err = ec2Client.CreateTags(&ec2.CreateTagsInput{
+			DryRun:    dryRun,
+			Resources: []*string{aws.String(placeholderImageID)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String(plan.TagKey), Value: aws.String("light-stemcell-builder-dry-run")},
+			},
+		})
+	default:
+		return Result{Operation: op, Status: InconclusiveStatus, Err: fmt.Errorf("unknown action %s", op.Action)}
+	}
+
+	return Result{Operation: op, Status: status(err), Err: err}
+}
+
+func status(err error) string {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return InconclusiveStatus
+	}
+
+	switch awsErr.Code() {
+	case "DryRunOperation":
+		return AuthorizedStatus
+	case "UnauthorizedOperation":
+		return UnauthorizedStatus
+	default:
+		return InconclusiveStatus
+	}
+}
+
+// Unauthorized returns true when any of the results was denied
+func Unauthorized(results []Result) bool {
+	for _, result := range results {
+		if result.Status == UnauthorizedStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteResults writes a table with a row per operation, including the reason for results which were not authorized
+func WriteResults(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tACTION\tSTATUS\tDETAIL")
+	for _, result := range results {
+		detail := ""
+		if result.Status != AuthorizedStatus && result.Err != nil {
+			detail = result.Err.Error()
+			if awsErr, ok := result.Err.(awserr.Error); ok {
+				detail = fmt.Sprintf("%s: %s", awsErr.Code(), awsErr.Message())
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Region, result.Action, result.Status, detail)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,163 @@
+package dryrun_test
+
+import (
+	"bytes"
+	"errors"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dryrun"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeEC2 struct {
+	ec2iface.EC2API
+	err         error
+	copyRequest *ec2.CopyImageInput
+}
+
+func (f *fakeEC2) RegisterImage(input *ec2.RegisterImageInput) (*ec2.RegisterImageOutput, error) {
+	Expect(aws.BoolValue(input.DryRun)).To(BeTrue())
+	return nil, f.err
+}
+
+func (f *fakeEC2) CopyImage(input *ec2.CopyImageInput) (*ec2.CopyImageOutput, error) {
+	f.copyRequest = input
+	return nil, f.err
+}
+
+var _ = Describe("Dryrun", func() {
+	Describe("Operations", func() {
+		var c config.Config
+
+		BeforeEach(func() {
+			c = config.Config{
+				AmiConfiguration: config.AmiConfiguration{Visibility: config.PrivateVisibility},
+				AmiRegions: []config.AmiRegion{
+					{RegionName: "us-east-1", Destinations: []string{"us-west-1"}},
+					{RegionName: "cn-north-1", IsolatedRegion: true},
+				},
+			}
+		})
+
+		actions := func(operations []dryrun.Operation) []string {
+			result := []string{}
+			for _, op := range operations {
+				result = append(result, op.Region+" "+op.Action)
+			}
+			return result
+		}
+
+		It("lists the mutating calls made in each region", func() {
+			Expect(actions(dryrun.Operations(c))).To(Equal([]string{
+				"us-east-1 ImportSnapshot",
+				"us-east-1 ModifySnapshotAttribute",
+				"us-east-1 RegisterImage",
+				"us-east-1 CreateTags",
+				"us-west-1 CopyImage",
+				"cn-north-1 ImportVolume",
+				"cn-north-1 CreateSnapshot",
+				"cn-north-1 ModifySnapshotAttribute",
+				"cn-north-1 DeleteVolume",
+				"cn-north-1 RegisterImage",
+				"cn-north-1 CreateTags",
+			}))
+		})
+
+		It("includes archiving the snapshot when archive copies are configured", func() {
+			c.AmiRegions = c.AmiRegions[1:]
+			c.AmiRegions[0].ArchiveSnapshotCopies = 2
+
+			Expect(actions(dryrun.Operations(c))).To(ContainElement("cn-north-1 CopySnapshot"))
+		})
+
+		It("includes archiving the snapshots of copies in their destination regions", func() {
+			c.AmiRegions[0].ArchiveSnapshotCopies = 1
+
+			Expect(actions(dryrun.Operations(c))).To(ContainElement("us-west-1 CopySnapshot"))
+		})
+
+		It("includes making the AMI public when visibility is public", func() {
+			c.AmiConfiguration.Visibility = config.PublicVisibility
+			c.AmiRegions = c.AmiRegions[1:]
+
+			Expect(actions(dryrun.Operations(c))).To(ContainElement("cn-north-1 ModifyImageAttribute"))
+		})
+
+		It("copies using the source region's credentials in the destination region", func() {
+			c.AmiRegions[0].Credentials = config.Credentials{AccessKey: "some-key", Region: "us-east-1"}
+			copyOp := dryrun.Operations(c)[4]
+
+			Expect(copyOp.SourceRegion).To(Equal("us-east-1"))
+			Expect(copyOp.Credentials.AccessKey).To(Equal("some-key"))
+			Expect(copyOp.Credentials.Region).To(Equal("us-west-1"))
+		})
+
+		It("copies to destinations other than copy hubs from their nearest hub", func() {
+			c.AmiRegions = c.AmiRegions[:1]
+			c.AmiRegions[0].Destinations = []string{"eu-west-1", "eu-central-1", "ap-southeast-1", "ap-southeast-2"}
+			c.AmiRegions[0].CopyHubs = []string{"eu-west-1", "ap-southeast-1"}
+			c.AmiRegions[0].PriorityDestinations = []string{"eu-central-1"}
+
+			sources := []string{}
+			for _, op := range dryrun.Operations(c) {
+				if op.Action == dryrun.CopyImageAction {
+					sources = append(sources, op.SourceRegion+" -> "+op.Region)
+				}
+			}
+			Expect(sources).To(Equal([]string{
+				"us-east-1 -> eu-west-1",
+				"us-east-1 -> eu-central-1",
+				"us-east-1 -> ap-southeast-1",
+				"ap-southeast-1 -> ap-southeast-2",
+			}))
+		})
+	})
+
+	Describe("Verify", func() {
+		It("is authorized when EC2 reports the dry run would have succeeded", func() {
+			ec2Client := &fakeEC2{err: awserr.New("DryRunOperation", "Request would have succeeded", nil)}
+
+			result := dryrun.Verify(ec2Client, dryrun.Operation{Region: "us-east-1", Action: dryrun.RegisterImageAction})
+			Expect(result.Status).To(Equal(dryrun.AuthorizedStatus))
+			Expect(dryrun.Unauthorized([]dryrun.Result{result})).To(BeFalse())
+		})
+
+		It("is unauthorized when EC2 denies the call", func() {
+			ec2Client := &fakeEC2{err: awserr.New("UnauthorizedOperation", "You are not authorized", nil)}
+
+			result := dryrun.Verify(ec2Client, dryrun.Operation{Region: "us-west-1", SourceRegion: "us-east-1", Action: dryrun.CopyImageAction})
+			Expect(result.Status).To(Equal(dryrun.UnauthorizedStatus))
+			Expect(aws.StringValue(ec2Client.copyRequest.SourceRegion)).To(Equal("us-east-1"))
+			Expect(dryrun.Unauthorized([]dryrun.Result{result})).To(BeTrue())
+		})
+
+		It("is inconclusive when the call fails for another reason", func() {
+			ec2Client := &fakeEC2{err: errors.New("connection refused")}
+
+			result := dryrun.Verify(ec2Client, dryrun.Operation{Region: "us-east-1", Action: dryrun.RegisterImageAction})
+			Expect(result.Status).To(Equal(dryrun.InconclusiveStatus))
+		})
+	})
+
+	It("writes a row per result with the reason for failures", func() {
+		results := []dryrun.Result{
+			{Operation: dryrun.Operation{Region: "us-east-1", Action: dryrun.ImportSnapshotAction}, Status: dryrun.AuthorizedStatus, Err: awserr.New("DryRunOperation", "ok", nil)},
+			{Operation: dryrun.Operation{Region: "us-east-1", Action: dryrun.RegisterImageAction}, Status: dryrun.UnauthorizedStatus, Err: awserr.New("UnauthorizedOperation", "denied", nil)},
+		}
+
+		output := &bytes.Buffer{}
+		err := dryrun.WriteResults(output, results)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.String()).To(Equal(
+			"REGION     ACTION          STATUS        DETAIL\n" +
+				"us-east-1  ImportSnapshot  authorized    \n" +
+				"us-east-1  RegisterImage   unauthorized  UnauthorizedOperation: denied\n",
+		))
+	})
+})
@@ -0,0 +1,89 @@
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"text/tabwriter"
+	"time"
+)
+
+// Defaults used when the config does not set estimates. The price is that of standard EBS snapshot storage in
+// most commercial regions, and the throughput a conservative figure for cross-region snapshot copies.
+const (
+	defaultThroughputMBPerSecond   = 20
+	defaultSnapshotPricePerGBMonth = 0.05
+)
+
+// Estimate is the projected time until the snapshots of a publish have been transferred to Region, counting the
+// upload and every copy on the way, and the monthly cost of storing them there. SizeGB is the provisioned size of
+// those snapshots rather than the blocks in use, so both are upper bounds.
+type Estimate struct {
+	Region      string
+	SizeGB      int64
+	Transfer    time.Duration
+	MonthlyCost float64
+}
+
+// Estimates projects, for each region publishing with c produces AMIs in, the transfer time and storage cost of
+// snapshots totalling sizeGB. Source regions and destinations also store their archive copies, and destinations which are not copy
+// hubs are copied to through a hub when the entry has any.
+func Estimates(c config.Config, sizeGB int64) []Estimate {
+	throughput := c.Estimates.ThroughputMBPerSecond
+	if throughput <= 0 {
+		throughput = defaultThroughputMBPerSecond
+	}
+	transfer := time.Duration(float64(sizeGB*1024) / throughput * float64(time.Second))
+
+	estimate := func(region string, copies int, transfers int) Estimate {
+		price, found := c.Estimates.RegionSnapshotPrices[region]
+		if !found {
+			price = c.Estimates.SnapshotPricePerGBMonth
+		}
+		if price <= 0 {
+			price = defaultSnapshotPricePerGBMonth
+		}
+
+		return Estimate{
+			Region:      region,
+			SizeGB:      sizeGB * int64(copies),
+			Transfer:    transfer * time.Duration(transfers),
+			MonthlyCost: float64(sizeGB*int64(copies)) * price,
+		}
+	}
+
+	estimates := []Estimate{}
+	for _, regionConfig := range c.AmiRegions {
+		estimates = append(estimates, estimate(regionConfig.PublishRegion(), 1+regionConfig.ArchiveSnapshotCopies, 1))
+
+		hubs := map[string]bool{}
+		for _, hub := range regionConfig.CopyHubs {
+			hubs[hub] = true
+		}
+
+		for _, destination := range regionConfig.Destinations {
+			transfers := 2
+			if hubs[destination] || len(hubs) == 0 {
+				transfers = 1
+			}
+			estimates = append(estimates, estimate(destination, 1+regionConfig.ArchiveSnapshotCopies, 1+transfers))
+		}
+	}
+
+	return estimates
+}
+
+// WriteEstimates writes a table with a row per region and a final row totalling the storage cost
+func WriteEstimates(w io.Writer, estimates []Estimate) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tSNAPSHOT GB\tEST. TRANSFER\tEST. MONTHLY COST")
+
+	total := 0.0
+	for _, estimate := range estimates {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t$%.2f\n", estimate.Region, estimate.SizeGB, estimate.Transfer.Round(time.Second), estimate.MonthlyCost)
+		total += estimate.MonthlyCost
+	}
+	fmt.Fprintf(tw, "total\t\t\t$%.2f\n", total)
+
+	return tw.Flush()
+}
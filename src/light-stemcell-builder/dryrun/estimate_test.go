@@ -0,0 +1,64 @@
+package dryrun_test
+
+import (
+	"bytes"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dryrun"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Estimates", func() {
+	var c config.Config
+
+	BeforeEach(func() {
+		c = config.Config{
+			AmiRegions: []config.AmiRegion{
+				{
+					RegionName:            "us-east-1",
+					Destinations:          []string{"eu-west-1", "eu-central-1"},
+					CopyHubs:              []string{"eu-west-1"},
+					ArchiveSnapshotCopies: 1,
+				},
+			},
+			Estimates: config.Estimates{
+				ThroughputMBPerSecond: 64,
+				RegionSnapshotPrices:  map[string]float64{"eu-central-1": 0.1},
+			},
+		}
+	})
+
+	It("projects the transfer time and storage cost of each region", func() {
+		estimates := dryrun.Estimates(c, 3)
+		Expect(estimates).To(HaveLen(3))
+
+		Expect(estimates[0].Region).To(Equal("us-east-1"))
+		Expect(estimates[0].SizeGB).To(Equal(int64(6)))
+		Expect(estimates[0].Transfer).To(Equal(48 * time.Second))
+		Expect(estimates[0].MonthlyCost).To(BeNumerically("~", 0.30, 0.001))
+
+		Expect(estimates[1].Region).To(Equal("eu-west-1"))
+		Expect(estimates[1].SizeGB).To(Equal(int64(6)))
+		Expect(estimates[1].Transfer).To(Equal(96 * time.Second))
+		Expect(estimates[1].MonthlyCost).To(BeNumerically("~", 0.30, 0.001))
+
+		Expect(estimates[2].Region).To(Equal("eu-central-1"))
+		Expect(estimates[2].Transfer).To(Equal(144 * time.Second))
+		Expect(estimates[2].MonthlyCost).To(BeNumerically("~", 0.60, 0.001))
+	})
+
+	It("writes a table totalling the monthly cost", func() {
+		buf := &bytes.Buffer{}
+		err := dryrun.WriteEstimates(buf, dryrun.Estimates(c, 3))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf.String()).To(Equal(
+			"REGION        SNAPSHOT GB  EST. TRANSFER  EST. MONTHLY COST\n" +
+				"us-east-1     6            48s            $0.30\n" +
+				"eu-west-1     6            1m36s          $0.30\n" +
+				"eu-central-1  6            2m24s          $0.60\n" +
+				"total                                     $1.20\n",
+		))
+	})
+})
@@ -0,0 +1,45 @@
+package dualstack
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Config returns the config to layer over a client's own config so it reaches service in the region of creds over
+// the dual-stack endpoint, when creds ask for one. Services without a dual-stack endpoint, such as IAM, and
+// credentials which don't use dual-stack get an empty config, keeping the default endpoint.
+func Config(service string, creds config.Credentials) *aws.Config {
+	awsConfig := aws.NewConfig()
+	if !creds.DualStack {
+		return awsConfig
+	}
+
+	if endpoint := Endpoint(service, creds.Region); endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	return awsConfig
+}
+
+// Endpoint returns the dual-stack endpoint of service in region, or an empty string when it has none.
+// The vendored SDK only knows the S3 dual-stack endpoints of the aws partition, so every endpoint is given here.
+func Endpoint(service string, region string) string {
+	china := strings.HasPrefix(region, "cn-")
+
+	switch service {
+	case "s3":
+		if china {
+			return fmt.Sprintf("https://s3.dualstack.%s.amazonaws.com.cn", region)
+		}
+		return fmt.Sprintf("https://s3.dualstack.%s.amazonaws.com", region)
+	case "ec2", "sts", "kms":
+		if china {
+			return fmt.Sprintf("https://%s.%s.api.amazonwebservices.com.cn", service, region)
+		}
+		return fmt.Sprintf("https://%s.%s.api.aws", service, region)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,13 @@
+package dualstack_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDualstack(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dualstack Suite")
+}
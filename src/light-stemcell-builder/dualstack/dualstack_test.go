@@ -0,0 +1,32 @@
+package dualstack_test
+
+import (
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dualstack", func() {
+	It("keeps the default endpoints unless the credentials use dual-stack", func() {
+		awsConfig := dualstack.Config("ec2", config.Credentials{Region: "us-east-1"})
+		Expect(awsConfig.Endpoint).To(BeNil())
+	})
+
+	It("uses the dual-stack endpoint of each partition", func() {
+		awsConfig := dualstack.Config("ec2", config.Credentials{Region: "us-east-1", DualStack: true})
+		Expect(aws.StringValue(awsConfig.Endpoint)).To(Equal("https://ec2.us-east-1.api.aws"))
+
+		Expect(dualstack.Endpoint("s3", "eu-west-1")).To(Equal("https://s3.dualstack.eu-west-1.amazonaws.com"))
+		Expect(dualstack.Endpoint("s3", "cn-north-1")).To(Equal("https://s3.dualstack.cn-north-1.amazonaws.com.cn"))
+		Expect(dualstack.Endpoint("sts", "cn-north-1")).To(Equal("https://sts.cn-north-1.api.amazonwebservices.com.cn"))
+		Expect(dualstack.Endpoint("kms", "eu-west-1")).To(Equal("https://kms.eu-west-1.api.aws"))
+	})
+
+	It("keeps the default endpoint of services without a dual-stack one", func() {
+		awsConfig := dualstack.Config("iam", config.Credentials{Region: "us-east-1", DualStack: true})
+		Expect(awsConfig.Endpoint).To(BeNil())
+	})
+})
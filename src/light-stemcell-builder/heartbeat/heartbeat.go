@@ -0,0 +1,164 @@
+package heartbeat
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Heartbeat keeps the phase and percent complete of each region being published, so a line can be logged with
+// them when the console has been silent for a while, as it is during an import which takes over an hour
+type Heartbeat struct {
+	mutex      sync.Mutex
+	started    time.Time
+	lastOutput time.Time
+	regions    map[string]progress
+}
+
+type progress struct {
+	phase string
+	// percent is negative until the phase reports how far along it is
+	percent int
+}
+
+// New creates a Heartbeat for a publish started at now
+func New(now time.Time) *Heartbeat {
+	return &Heartbeat{started: now, lastOutput: now, regions: map[string]progress{}}
+}
+
+// Phase records that region has moved on to phase, whose percent complete is not known yet
+func (h *Heartbeat) Phase(region string, phase string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.regions[region] = progress{phase: phase, percent: -1}
+}
+
+// Percent records how far along the current phase of region is, ignoring regions without a phase
+func (h *Heartbeat) Percent(region string, percent int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if current, ok := h.regions[region]; ok {
+		current.percent = percent
+		h.regions[region] = current
+	}
+}
+
+// Done forgets region once it has finished publishing
+func (h *Heartbeat) Done(region string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.regions, region)
+}
+
+// Output records that the console was written to at now
+func (h *Heartbeat) Output(now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastOutput = now
+}
+
+// Line returns the heartbeat to log at now if the console has been silent for at least interval, or false
+func (h *Heartbeat) Line(now time.Time, interval time.Duration) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if now.Sub(h.lastOutput) < interval {
+		return "", false
+	}
+
+	regions := []string{}
+	for region := range h.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	parts := []string{fmt.Sprintf("heartbeat: publishing for %s", now.Sub(h.started).Truncate(time.Second))}
+	for _, region := range regions {
+		progress := h.regions[region]
+		if progress.percent < 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", region, progress.phase))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %s %d%%", region, progress.phase, progress.percent))
+		}
+	}
+	return strings.Join(parts, ", "), true
+}
+
+// Writer wraps console so that each write to it counts as output
+func (h *Heartbeat) Writer(console io.Writer) io.Writer {
+	return &outputWriter{heartbeat: h, writer: console}
+}
+
+type outputWriter struct {
+	heartbeat *Heartbeat
+	writer    io.Writer
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.heartbeat.Output(time.Now())
+	return w.writer.Write(p)
+}
+
+var (
+	activeMutex sync.Mutex
+	active      *Heartbeat
+)
+
+// Enable starts logging a heartbeat to logger whenever console has been silent for interval, returning console
+// wrapped so its output is noticed. The publishers and drivers report their progress through Phase, Percent and
+// Done, which do nothing until Enable has been called.
+func Enable(logger *log.Logger, interval time.Duration, console io.Writer) io.Writer {
+	h := New(time.Now())
+
+	activeMutex.Lock()
+	active = h
+	activeMutex.Unlock()
+
+	go func() {
+		// checking ten times an interval keeps the silence between two lines close to the interval
+		ticker := time.NewTicker(interval / 10)
+		for now := range ticker.C {
+			if line, ok := h.Line(now, interval); ok {
+				logger.Println(line)
+			}
+		}
+	}()
+
+	return h.Writer(console)
+}
+
+// Phase records the phase of region on the enabled heartbeat
+func Phase(region string, phase string) {
+	if h := enabled(); h != nil {
+		h.Phase(region, phase)
+	}
+}
+
+// Percent records how far along the phase of region is on the enabled heartbeat
+func Percent(region string, percent int) {
+	if h := enabled(); h != nil {
+		h.Percent(region, percent)
+	}
+}
+
+// Done forgets region on the enabled heartbeat
+func Done(region string) {
+	if h := enabled(); h != nil {
+		h.Done(region)
+	}
+}
+
+func enabled() *Heartbeat {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	return active
+}
@@ -0,0 +1,54 @@
+package heartbeat_test
+
+import (
+	"bytes"
+	"light-stemcell-builder/heartbeat"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Heartbeat", func() {
+	started := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var h *heartbeat.Heartbeat
+
+	BeforeEach(func() {
+		h = heartbeat.New(started)
+	})
+
+	It("stays quiet until the console has been silent for the interval", func() {
+		h.Output(started.Add(4 * time.Minute))
+
+		_, ok := h.Line(started.Add(8*time.Minute), 5*time.Minute)
+		Expect(ok).To(BeFalse())
+
+		line, ok := h.Line(started.Add(9*time.Minute), 5*time.Minute)
+		Expect(ok).To(BeTrue())
+		Expect(line).To(Equal("heartbeat: publishing for 9m0s"))
+	})
+
+	It("includes the phase of each region, with its percent once known", func() {
+		h.Phase("us-east-1", "snapshot")
+		h.Percent("us-east-1", 37)
+		h.Phase("eu-west-1", "copy")
+		h.Phase("ap-south-1", "ami")
+		h.Done("ap-south-1")
+		h.Percent("cn-north-1", 50)
+
+		line, ok := h.Line(started.Add(42*time.Minute+500*time.Millisecond), time.Minute)
+		Expect(ok).To(BeTrue())
+		Expect(line).To(Equal("heartbeat: publishing for 42m0s, eu-west-1 copy, us-east-1 snapshot 37%"))
+	})
+
+	It("counts writes to the console as output", func() {
+		console := &bytes.Buffer{}
+		_, err := h.Writer(console).Write([]byte("some output\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(console.String()).To(Equal("some output\n"))
+
+		_, ok := h.Line(time.Now(), time.Minute)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,117 @@
+package heavy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Names of the entries of a heavy stemcell tarball, and of root.img within its image entry
+const (
+	ManifestEntry = "stemcell.MF"
+	ImageEntry    = "image"
+	RootImage     = "root.img"
+)
+
+// Extract writes the stemcell.MF and the root.img of the heavy stemcell tarball at path into dir, returning
+// their paths. The root.img is streamed out of the gzipped image entry without an intermediate copy. The hex SHA256
+// of each file is added to digests by its path as it is written, so planning need not read the files again.
+func Extract(path string, dir string, digests map[string]string) (string, string, error) {
+	tarball, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("opening stemcell tarball: %s", err)
+	}
+	defer tarball.Close()
+
+	entries, err := gzipTar(tarball)
+	if err != nil {
+		return "", "", fmt.Errorf("reading stemcell tarball %s: %s", path, err)
+	}
+
+	imagePath := filepath.Join(dir, RootImage)
+	manifestPath := filepath.Join(dir, ManifestEntry)
+	foundImage, foundManifest := false, false
+	for {
+		header, err := entries.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("reading stemcell tarball %s: %s", path, err)
+		}
+
+		switch filepath.Clean(header.Name) {
+		case ManifestEntry:
+			err = writeFile(manifestPath, entries, digests)
+			if err != nil {
+				return "", "", fmt.Errorf("extracting %s: %s", ManifestEntry, err)
+			}
+			foundManifest = true
+		case ImageEntry:
+			err = extractRootImage(entries, imagePath, digests)
+			if err != nil {
+				return "", "", fmt.Errorf("extracting %s: %s", RootImage, err)
+			}
+			foundImage = true
+		}
+	}
+
+	if !foundManifest {
+		return "", "", fmt.Errorf("stemcell tarball %s has no %s", path, ManifestEntry)
+	}
+	if !foundImage {
+		return "", "", fmt.Errorf("stemcell tarball %s has no %s", path, ImageEntry)
+	}
+	return imagePath, manifestPath, nil
+}
+
+func extractRootImage(image io.Reader, imagePath string, digests map[string]string) error {
+	entries, err := gzipTar(image)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", ImageEntry, err)
+	}
+
+	for {
+		header, err := entries.Next()
+		if err == io.EOF {
+			return errors.New("the image has no " + RootImage)
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", ImageEntry, err)
+		}
+
+		if filepath.Clean(header.Name) == RootImage {
+			return writeFile(imagePath, entries, digests)
+		}
+	}
+}
+
+func gzipTar(r io.Reader) (*tar.Reader, error) {
+	decompressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return tar.NewReader(decompressed), nil
+}
+
+func writeFile(path string, r io.Reader, digests map[string]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, h), r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	digests[path] = hex.EncodeToString(h.Sum(nil))
+	return file.Close()
+}
@@ -0,0 +1,13 @@
+package heavy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHeavy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Heavy Suite")
+}
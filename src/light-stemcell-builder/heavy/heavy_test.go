@@ -0,0 +1,99 @@
+package heavy_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"light-stemcell-builder/heavy"
+	"light-stemcell-builder/plan"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// gzipTar returns a gzipped tarball of the files, in the order they are given
+func gzipTar(files ...[2]string) []byte {
+	buffer := &bytes.Buffer{}
+	compressed := gzip.NewWriter(buffer)
+	tarball := tar.NewWriter(compressed)
+	for _, file := range files {
+		err := tarball.WriteHeader(&tar.Header{Name: file[0], Mode: 0644, Size: int64(len(file[1]))})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = tarball.Write([]byte(file[1]))
+		Expect(err).ToNot(HaveOccurred())
+	}
+	Expect(tarball.Close()).To(Succeed())
+	Expect(compressed.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
+var _ = Describe("Extract", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "heavy")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeTarball := func(content []byte) string {
+		path := filepath.Join(dir, "bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.tgz")
+		Expect(ioutil.WriteFile(path, content, 0644)).To(Succeed())
+		return path
+	}
+
+	It("extracts the stemcell.MF and the root.img of the image", func() {
+		image := gzipTar([2]string{"root.img", "some-image"})
+		path := writeTarball(gzipTar(
+			[2]string{"./image", string(image)},
+			[2]string{"./packages.txt", "some-packages"},
+			[2]string{"./stemcell.MF", "name: some-stemcell"},
+		))
+
+		digests := map[string]string{}
+		imagePath, manifestPath, err := heavy.Extract(path, dir, digests)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imagePath).To(Equal(filepath.Join(dir, "root.img")))
+		Expect(manifestPath).To(Equal(filepath.Join(dir, "stemcell.MF")))
+
+		Expect(ioutil.ReadFile(imagePath)).To(Equal([]byte("some-image")))
+		Expect(ioutil.ReadFile(manifestPath)).To(Equal([]byte("name: some-stemcell")))
+
+		imageDigest, err := plan.FileDigest(imagePath)
+		Expect(err).ToNot(HaveOccurred())
+		manifestDigest, err := plan.FileDigest(manifestPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digests).To(Equal(map[string]string{imagePath: imageDigest, manifestPath: manifestDigest}))
+	})
+
+	It("returns an error when the tarball has no image", func() {
+		path := writeTarball(gzipTar([2]string{"stemcell.MF", "name: some-stemcell"}))
+
+		_, _, err := heavy.Extract(path, dir, map[string]string{})
+		Expect(err).To(MatchError("stemcell tarball " + path + " has no image"))
+	})
+
+	It("returns an error when the image has no root.img", func() {
+		path := writeTarball(gzipTar(
+			[2]string{"stemcell.MF", "name: some-stemcell"},
+			[2]string{"image", string(gzipTar([2]string{"disk.vmdk", "some-image"}))},
+		))
+
+		_, _, err := heavy.Extract(path, dir, map[string]string{})
+		Expect(err).To(MatchError("extracting root.img: the image has no root.img"))
+	})
+
+	It("returns an error when the file is not a gzipped tarball", func() {
+		path := writeTarball([]byte("not a tarball"))
+
+		_, _, err := heavy.Extract(path, dir, map[string]string{})
+		Expect(err).To(MatchError(ContainSubstring("reading stemcell tarball " + path)))
+	})
+})
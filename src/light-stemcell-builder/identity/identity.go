@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/manifest"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// conventionalName matches the base names stemcells are released under, e.g.
+// bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent, capturing the version, the stemcell name without its
+// bosh- prefix and the operating system
+var conventionalName = regexp.MustCompile(`^(?:light-)?bosh-stemcell-(\d+(?:\.\d+)*)-(aws-xen(?:-hvm)?-(.+)-go_agent)$`)
+
+// extensions are stripped from the file name before it is matched, longest first
+var extensions = []string{".tar.gz", ".tgz", ".img", ".raw", ".vmdk"}
+
+// Infer returns the name, version and operating system of the stemcell whose heavy stemcell tarball or machine
+// image is at path, when its file name follows the naming stemcells are released under. Other file names
+// return an empty Identity.
+func Infer(path string) config.Identity {
+	base := filepath.Base(path)
+	for _, extension := range extensions {
+		if strings.HasSuffix(base, extension) {
+			base = strings.TrimSuffix(base, extension)
+			break
+		}
+	}
+
+	match := conventionalName.FindStringSubmatch(base)
+	if match == nil {
+		return config.Identity{}
+	}
+	return config.Identity{Name: "bosh-" + match[2], Version: match[1], OperatingSystem: match[3]}
+}
+
+// Resolve sets the name, version and operating system of the manifest. Each is taken from override when set,
+// otherwise the stemcell.MF value is kept, falling back to the inferred one. When the stemcell.MF and the inferred
+// values disagree an error is returned instead, as the image was most likely paired with the wrong manifest.
+func Resolve(m *manifest.Manifest, inferred config.Identity, override config.Identity) error {
+	fields := []struct {
+		key      string
+		value    *string
+		inferred string
+		override string
+	}{
+		{"name", &m.Name, inferred.Name, override.Name},
+		{"version", &m.Version, inferred.Version, override.Version},
+		{"operating_system", &m.OperatingSystem, inferred.OperatingSystem, override.OperatingSystem},
+	}
+
+	for _, field := range fields {
+		switch {
+		case field.override != "":
+			*field.value = field.override
+		case *field.value == "":
+			*field.value = field.inferred
+		case field.inferred != "" && field.inferred != *field.value:
+			return fmt.Errorf("stemcell.MF has %s %s but the file name is for %s, set identity.%s to publish it anyway",
+				field.key, *field.value, field.inferred, field.key)
+		}
+	}
+
+	return nil
+}
+
+// Expand replaces the {name}, {version} and {os} placeholders of an AMI or snapshot name with those of the manifest
+func Expand(name string, m *manifest.Manifest) string {
+	return strings.NewReplacer("{name}", m.Name, "{version}", m.Version, "{os}", m.OperatingSystem).Replace(name)
+}
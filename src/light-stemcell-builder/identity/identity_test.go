@@ -0,0 +1,72 @@
+package identity_test
+
+import (
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/identity"
+	"light-stemcell-builder/manifest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Identity", func() {
+	xenial := config.Identity{Name: "bosh-aws-xen-hvm-ubuntu-xenial-go_agent", Version: "621.74", OperatingSystem: "ubuntu-xenial"}
+
+	DescribeTable("Infer",
+		func(path string, expected config.Identity) {
+			Expect(identity.Infer(path)).To(Equal(expected))
+		},
+		Entry("a heavy stemcell tarball", "/tmp/bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.tgz", xenial),
+		Entry("a light stemcell tarball", "light-bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.tgz", xenial),
+		Entry("a machine image", "bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.img", xenial),
+		Entry("a paravirtual stemcell", "bosh-stemcell-3586.100-aws-xen-centos-7-go_agent.tgz",
+			config.Identity{Name: "bosh-aws-xen-centos-7-go_agent", Version: "3586.100", OperatingSystem: "centos-7"}),
+		Entry("an unconventional name", "root.img", config.Identity{}),
+		Entry("another infrastructure", "bosh-stemcell-621.74-google-kvm-ubuntu-xenial-go_agent.tgz", config.Identity{}),
+	)
+
+	Describe("Resolve", func() {
+		var m *manifest.Manifest
+
+		BeforeEach(func() {
+			m = &manifest.Manifest{Name: xenial.Name, Version: xenial.Version, OperatingSystem: xenial.OperatingSystem}
+		})
+
+		It("keeps the stemcell.MF values which agree with the inferred ones", func() {
+			Expect(identity.Resolve(m, xenial, config.Identity{})).To(Succeed())
+			Expect(m.Version).To(Equal("621.74"))
+		})
+
+		It("fills the values missing from the stemcell.MF with the inferred ones", func() {
+			m.Version, m.OperatingSystem = "", ""
+
+			Expect(identity.Resolve(m, xenial, config.Identity{})).To(Succeed())
+			Expect(m.Version).To(Equal("621.74"))
+			Expect(m.OperatingSystem).To(Equal("ubuntu-xenial"))
+		})
+
+		It("returns an error when the stemcell.MF and the inferred values disagree", func() {
+			inferred := xenial
+			inferred.Version = "621.75"
+
+			err := identity.Resolve(m, inferred, config.Identity{})
+			Expect(err).To(MatchError("stemcell.MF has version 621.74 but the file name is for 621.75, set identity.version to publish it anyway"))
+		})
+
+		It("takes the overrides over both the stemcell.MF and the inferred values", func() {
+			inferred := xenial
+			inferred.Version = "621.75"
+
+			Expect(identity.Resolve(m, inferred, config.Identity{Version: "621.76"})).To(Succeed())
+			Expect(m.Version).To(Equal("621.76"))
+			Expect(m.Name).To(Equal(xenial.Name))
+		})
+	})
+
+	It("replaces the placeholders of an AMI name", func() {
+		m := &manifest.Manifest{Name: xenial.Name, Version: xenial.Version, OperatingSystem: xenial.OperatingSystem}
+		Expect(identity.Expand("BOSH-{os}-{version}", m)).To(Equal("BOSH-ubuntu-xenial-621.74"))
+		Expect(identity.Expand("BOSH-some-name", m)).To(Equal("BOSH-some-name"))
+	})
+})
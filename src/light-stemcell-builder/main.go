@@ -2,20 +2,66 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"light-stemcell-builder/actions"
+	"light-stemcell-builder/breaker"
+	"light-stemcell-builder/builder"
+	"light-stemcell-builder/chaos"
 	"light-stemcell-builder/collection"
+	"light-stemcell-builder/concourse"
 	"light-stemcell-builder/config"
+	"light-stemcell-builder/download"
+	"light-stemcell-builder/driver"
 	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/dryrun"
+	"light-stemcell-builder/heartbeat"
+	"light-stemcell-builder/heavy"
+	"light-stemcell-builder/identity"
 	"light-stemcell-builder/manifest"
+	"light-stemcell-builder/plan"
+	"light-stemcell-builder/policy"
 	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/readonly"
+	"light-stemcell-builder/report"
 	"light-stemcell-builder/resources"
+	"light-stemcell-builder/selftest"
+	"light-stemcell-builder/status"
+	"light-stemcell-builder/storage"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Build metadata, set at link time with -ldflags "-X main.version=... -X main.gitSHA=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	gitSHA    = "unknown"
+	buildDate = "unknown"
+)
+
+// timeoutExitCode is the exit status when the --timeout passed before the publishes finished
+const timeoutExitCode = 3
+
+// Describe calls in a row which may fail against a region before it is backed off, and for how long, unless the
+// config sets polling
+const (
+	defaultPollingFailureThreshold = 5
+	defaultPollingCooldown         = 30 * time.Second
 )
 
 func usage(message string) {
@@ -26,138 +72,1955 @@ func usage(message string) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "selftest":
+			runSelftest(os.Args[2:])
+			return
+		case "policy":
+			runPolicy(os.Args[2:])
+			return
+		case "credentials":
+			runCredentials(os.Args[2:])
+			return
+		case "reencrypt":
+			runReencrypt(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "convert-report":
+			runConvertReport(os.Args[2:])
+			return
+		case "concourse-check":
+			runConcourseCheck(os.Args[2:])
+			return
+		case "promote":
+			runPromote(os.Args[2:])
+			return
+		case "verify-report":
+			runVerifyReport(os.Args[2:])
+			return
+		case "cleanup-volumes":
+			runCleanupVolumes(os.Args[2:])
+			return
+		}
+	}
+
 	sharedWriter := &logWriter{
+		Mutex:  &sync.Mutex{},
 		writer: os.Stderr,
 	}
 
 	logger := log.New(sharedWriter, "", log.LstdFlags)
 
 	configPath := flag.String("c", "", "Path to the JSON configuration file")
-	machineImagePath := flag.String("image", "", "Path to the input machine image (root.img)")
+	machineImagePath := flag.String("image", "", "Path or http(s):// or s3:// URL of the input machine image (root.img)")
 	machineImageFormat := flag.String("format", resources.VolumeRawFormat, "Format of the input machine image (RAW or vmdk). Defaults to RAW.")
 	imageVolumeSize := flag.Int("volume-size", 0, "Block device size (in GB) of the input machine image")
+	maxUploadMemory := flag.Int("max-upload-memory", 0, "Upper bound (in MB) on memory used to buffer machine image parts, shared across all region uploads")
+	maxUploadRate := flag.Float64("max-upload-rate", 0, "Upper bound (in MB/s) on the rate machine images are uploaded to S3, shared across all region uploads")
 	manifestPath := flag.String("manifest", "", "Path to the input stemcell.MF")
+	stemcellTarballPath := flag.String("stemcell", "", "Path or http(s):// or s3:// URL of a heavy stemcell tarball, whose root.img and stemcell.MF are published instead of --image and --manifest")
+	regions := flag.String("regions", "", "Comma-separated names of the ami_regions to publish to. Defaults to all configured regions")
+	reportPath := flag.String("report", "", "Path or s3://bucket/key URL to write a JSON report of the publish, including the failed phase, created resources and a retry command on failure")
+	reportSigningKeyPath := flag.String("report-signing-key", "", "Path to a PEM encoded ed25519 private key used to sign the report, writing the signature alongside it with a .sig suffix")
+	logFilePath := flag.String("log-file", "", "Path to a file which receives the complete log output, in addition to the console")
+	quiet := flag.Bool("quiet", false, "Only log phase transitions, warnings and errors to the console")
+	preflight := flag.Bool("preflight", false, "Simulate the IAM policies of each region's credentials and fail before publishing if any required permission is missing")
+	dryRun := flag.Bool("dry-run", false, "Validate the config and inputs, then issue each mutating EC2 call with DryRun set to prove the credentials are authorized, without publishing")
+	timeout := flag.Duration("timeout", 0, "Maximum wall-clock duration of the publish (e.g. 90m). Once exceeded no further region publishes are started, the report is written and the builder exits with status 3")
+	skipPublished := flag.Bool("skip-published", false, "Skip publishing each ami_regions entry whose region and destinations already have AMIs tagged with the digest of an identical plan, writing the manifest with those AMIs")
+	readOnly := flag.Bool("read-only", false, "Refuse every AWS request which could modify resources, failing fast. Useful with --dry-run, --preflight and --skip-published")
+	chaosSpec := flag.String("chaos", "", "For resilience testing only: fail a fraction of AWS requests with injected errors, e.g. throttle=0.1,server-error=0.05,timeout=0.01,seed=42")
+	heartbeatInterval := flag.Duration("heartbeat", 0, "Log a line with the phase and percent complete of each region whenever the console has been silent for this long (e.g. 5m), for CI systems which kill jobs without output")
+	progressPort := flag.Int("progress-port", 0, "Serve the current state of the publish, per-region progress and recent events as JSON on http://127.0.0.1:PORT/ for dashboards to poll")
+	concourseOutputPath := flag.String("concourse-output", "", "Path to write the version and metadata of the published stemcell as the out script of a Concourse resource would. Not supported for batches of stemcells")
+	printVersion := flag.Bool("version", false, "Print the version, git SHA and build date of this builder and exit")
 
 	flag.Parse()
 
-	if *configPath == "" {
-		usage("-c flag is required")
+	if *printVersion {
+		fmt.Printf("light-stemcell-builder version %s (git sha %s, built %s)\n", version, gitSHA, buildDate)
+		return
+	}
+
+	if *readOnly {
+		readonly.Enable()
 	}
-	if *machineImagePath == "" {
-		usage("--image flag is required")
+
+	if *progressPort < 0 || *progressPort > 65535 {
+		usage("--progress-port flag must be between 1 and 65535")
 	}
 
-	if *manifestPath == "" {
-		usage("--manifest flag is required")
+	if *heartbeatInterval != 0 && *heartbeatInterval < time.Second {
+		usage("--heartbeat flag must be at least 1s")
 	}
 
-	if *imageVolumeSize == 0 && *machineImageFormat != resources.VolumeRawFormat {
-		usage("--volume-size flag is required for formats other than RAW")
+	// the heartbeat only counts output which reaches the console, since that is what CI systems watch
+	var console io.Writer = os.Stderr
+	if *heartbeatInterval > 0 {
+		console = heartbeat.Enable(logger, *heartbeatInterval, os.Stderr)
+		sharedWriter.writer = console
 	}
 
-	configFile, err := os.Open(*configPath)
-	if err != nil {
-		logger.Fatalf("Error opening config file: %s", err)
+	if *chaosSpec != "" {
+		chaosConfig, err := chaos.Parse(*chaosSpec)
+		if err != nil {
+			usage(err.Error())
+		}
+		logger.Printf("chaos mode: failing %.0f%% of requests with throttling, %.0f%% with server errors and %.0f%% with timeouts",
+			chaosConfig.Throttle*100, chaosConfig.ServerError*100, chaosConfig.Timeout*100)
+		chaos.Enable(logger, chaosConfig)
+	}
+
+	// driver output is only shown on the console when not running quietly, but always goes to the log file
+	detailWriter := &logWriter{
+		Mutex:  sharedWriter.Mutex,
+		writer: console,
+	}
+	if *quiet {
+		detailWriter.writer = ioutil.Discard
 	}
 
-	defer func() {
-		closeErr := configFile.Close()
-		if closeErr != nil {
-			logger.Fatalf("Error closing config file: %s", closeErr)
+	if *logFilePath != "" {
+		logFile, err := os.Create(*logFilePath)
+		if err != nil {
+			logger.Fatalf("Error creating log file: %s", err)
 		}
-	}()
+		defer logFile.Close()
+
+		sharedWriter.writer = io.MultiWriter(console, logFile)
+		if *quiet {
+			detailWriter.writer = logFile
+		} else {
+			detailWriter.writer = sharedWriter.writer
+		}
+	}
+
+	if *configPath == "" {
+		usage("-c flag is required")
+	}
 
+	c, err := loadConfig(*configPath, "")
 	if err != nil {
-		logger.Fatalf("Error opening config file: %s", err)
+		logger.Fatal(err)
 	}
 
-	c, err := config.NewFromReader(configFile)
+	failureThreshold := defaultPollingFailureThreshold
+	if c.Polling.FailureThreshold > 0 {
+		failureThreshold = c.Polling.FailureThreshold
+	}
+	cooldown := defaultPollingCooldown
+	if c.Polling.CooldownSeconds > 0 {
+		cooldown = time.Duration(c.Polling.CooldownSeconds) * time.Second
+	}
+	polling := breaker.Enable(logger, failureThreshold, cooldown)
+
+	// the report may be written to the bucket of a region excluded by --regions
+	reportStorage, reportKey, err := reportDestination(*reportPath, c.AmiRegions)
 	if err != nil {
-		logger.Fatalf("Error parsing config file: %s. Message: %s", *configPath, err)
+		logger.Fatal(err)
+	}
+
+	var signingKey ed25519.PrivateKey
+	if *reportSigningKeyPath != "" {
+		if *reportPath == "" {
+			usage("--report-signing-key flag requires the --report flag")
+		}
+
+		signingKey, err = loadSigningKey(*reportSigningKeyPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
 	}
 
-	if _, err := os.Stat(*machineImagePath); os.IsNotExist(err) {
-		logger.Fatalf("machine image not found at: %s", *machineImagePath)
+	// retries of a subset of regions publish the same plan as the original run
+	planConfig := c
+
+	if *regions != "" {
+		c.AmiRegions, err = c.SelectRegions(strings.Split(*regions, ","))
+		if err != nil {
+			usage(err.Error())
+		}
 	}
 
-	if _, err := os.Stat(*manifestPath); os.IsNotExist(err) {
-		logger.Fatalf("manifest not found at: %s", *manifestPath)
+	if *preflight {
+		err = checkPermissions(logger, c)
+		if err != nil {
+			logger.Fatal(err)
+		}
 	}
 
-	manifestBytes, err := ioutil.ReadFile(*manifestPath)
-	if err != nil {
-		logger.Fatalf("opening manifest: %s", err)
+	if *concourseOutputPath != "" && len(c.Stemcells) > 0 {
+		usage("--concourse-output flag cannot be used with a config specifying stemcells")
 	}
 
-	m, err := manifest.NewFromReader(bytes.NewReader(manifestBytes))
-	if err != nil {
-		logger.Fatalf("reading manifest: %s", err)
+	stemcells := c.Stemcells
+	if len(stemcells) == 0 {
+		if *stemcellTarballPath != "" && (*machineImagePath != "" || *manifestPath != "") {
+			usage("--stemcell flag cannot be used with the --image and --manifest flags")
+		}
+
+		if *machineImagePath == "" && *stemcellTarballPath == "" {
+			usage("--image flag is required")
+		}
+
+		if *manifestPath == "" && *stemcellTarballPath == "" {
+			usage("--manifest flag is required")
+		}
+
+		if *imageVolumeSize == 0 && *machineImageFormat != resources.VolumeRawFormat {
+			usage("--volume-size flag is required for formats other than RAW")
+		}
+
+		if *imageVolumeSize < 0 || *imageVolumeSize > config.MaxVolumeSizeGB {
+			usage(fmt.Sprintf("--volume-size flag must be between 1 and %d", config.MaxVolumeSizeGB))
+		}
+
+		stemcells = []config.Stemcell{
+			{
+				TarballPath:  *stemcellTarballPath,
+				ImagePath:    *machineImagePath,
+				ManifestPath: *manifestPath,
+				ImageFormat:  *machineImageFormat,
+				VolumeSizeGB: int64(*imageVolumeSize),
+				AmiName:      c.AmiConfiguration.AmiName,
+				Identity:     c.Identity,
+			},
+		}
+	} else if *machineImagePath != "" || *manifestPath != "" || *stemcellTarballPath != "" {
+		usage("--image, --manifest and --stemcell flags cannot be used with a config specifying stemcells")
 	}
 
-	amiCollection := collection.Ami{}
-	errCollection := collection.Error{}
+	// the fetched files are removed when the builder exits normally
+	fileDigests := map[string]string{}
+	stemcells, dirs := expandStemcells(logger, c, stemcells, fileDigests)
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(c.AmiRegions))
+	for _, stemcell := range stemcells {
+		if stemcell.VirtualizationType != config.Paravirtualization {
+			continue
+		}
 
-	imageConfig := publisher.MachineImageConfig{
-		LocalPath:    *machineImagePath,
-		FileFormat:   *machineImageFormat,
-		VolumeSizeGB: int64(*imageVolumeSize),
+		logger.Println("Warning: paravirtual AMIs are being sunset by AWS, they only run on previous generation instance families")
+		if unsupported := c.UnsupportedParavirtualRegions(); len(unsupported) > 0 {
+			logger.Printf("Warning: paravirtual AMIs will not be published to regions which do not support them: %s", strings.Join(unsupported, ", "))
+		}
+		break
 	}
 
-	for i := range c.AmiRegions {
-		go func(regionConfig config.AmiRegion) {
+	manifests := readManifests(logger, c, stemcells)
+
+	if *dryRun {
+		for i, stemcell := range stemcells {
+			logger.Printf("Would publish %s %s from %s as %s", manifests[i].Name, manifests[i].Version, stemcellInput(stemcell), stemcell.AmiName)
+		}
+
+		sizeGB, err := snapshotSizeGB(stemcells)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		err = dryrun.WriteEstimates(os.Stdout, dryrun.Estimates(c, sizeGB))
+		if err != nil {
+			logger.Fatalf("writing dry run estimates: %s", err)
+		}
+		fmt.Println()
+
+		results := []dryrun.Result{}
+		for _, op := range dryrun.Operations(c) {
+			results = append(results, dryrun.Verify(dryrun.NewEC2Client(op.Credentials), op))
+		}
+
+		err = dryrun.WriteResults(os.Stdout, results)
+		if err != nil {
+			logger.Fatalf("writing dry run results: %s", err)
+		}
+
+		if dryrun.Unauthorized(results) {
+			logger.Fatal("Dry run found operations the credentials are not authorized to perform")
+		}
+		logger.Println("Dry run finished successfully")
+		return
+	}
+
+	plans, imageDigests := planStemcells(logger, planConfig, stemcells, manifests, fileDigests)
+
+	published := make([]map[string]*collection.Ami, len(stemcells))
+	if *skipPublished {
+		published, err = findPublished(c, stemcells, plans)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	// a canary left out by --regions still gates the publish, on the AMIs an earlier run of the plan published in
+	// it. Without them the publish fails with a canary failure for every region rather than skipping the gate.
+	canaryPublished := make([]map[string]*collection.Ami, len(stemcells))
+	if canaryConfig, excluded := excludedCanary(planConfig, c); excluded {
+		logger.Printf("Canary %s is not being published, looking for the AMIs published for its plan to gate on", c.Canary.Region)
+		canaryPublished, err = findPublished(canaryConfig, stemcells, plans)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	// publishes across every stemcell in the batch share a single limit on how many run at once
+	concurrentPublishes := len(stemcells) * len(c.AmiRegions)
+	var publishLimiter chan struct{}
+	if c.MaxConcurrentPublishes > 0 && c.MaxConcurrentPublishes < concurrentPublishes {
+		concurrentPublishes = c.MaxConcurrentPublishes
+		publishLimiter = make(chan struct{}, concurrentPublishes)
+	}
+
+	uploadMemoryPerRegion := *maxUploadMemory / concurrentPublishes
+	if *maxUploadMemory > 0 && uploadMemoryPerRegion == 0 {
+		logger.Fatalf("max upload memory of %d MB cannot be shared across %d concurrent uploads", *maxUploadMemory, concurrentPublishes)
+	}
+
+	if *maxUploadRate < 0 {
+		usage("--max-upload-rate must not be negative")
+	}
+	uploadRatePerRegion := int64(*maxUploadRate*(1<<20)) / int64(concurrentPublishes)
+	if *maxUploadRate > 0 && uploadRatePerRegion == 0 {
+		logger.Fatalf("max upload rate of %g MB/s cannot be shared across %d concurrent uploads", *maxUploadRate, concurrentPublishes)
+	}
+
+	// the AWS calls of publishes which are already running when the timeout passes cannot be cancelled, so those
+	// publishes stop before their next phase and clean up their intermediate resources, while those still waiting
+	// to start are skipped
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+
+		go func() {
+			<-ctx.Done()
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.Printf("Timeout of %s exceeded, no further region publishes or phases will be started", *timeout)
+			}
+		}()
+	}
+
+	amiCollections := make([]*collection.Ami, len(stemcells))
+	// amiTags are the tags each stemcell's AMIs were published with, left nil for stemcells which were not
+	amiTags := make([]map[string]string, len(stemcells))
+	// publishedBefore are the AMIs of each stemcell which an earlier run of the same plan published
+	publishedBefore := make([]map[string]string, len(stemcells))
+	publishFailures := make([][]report.Failure, len(stemcells))
+	publishErrs := make([]error, len(stemcells))
+
+	regionNames := []string{}
+	for _, regionConfig := range c.AmiRegions {
+		regionNames = append(regionNames, regionConfig.RegionName)
+	}
+
+	// the report is rewritten as each region finishes, so a crashed run still leaves a record of what it published
+	var partial *partialReport
+	if reportStorage != nil {
+		partial = newPartialReport(logger, reportStorage, reportKey, signingKey, regionNames, len(stemcells))
+	}
+
+	var tracker *status.Tracker
+	if *progressPort > 0 {
+		tracker = status.NewTracker(len(stemcells))
+		err = status.Serve(tracker, *progressPort)
+		if err != nil {
+			logger.Fatalf("Error serving progress: %s", err)
+		}
+		logger.Printf("Serving progress on http://127.0.0.1:%d/", *progressPort)
+	}
+
+	// backed off endpoints are shown so a slow publish can be told apart from a hung one
+	if partial != nil || tracker != nil {
+		polling.Notify(func(transition breaker.Transition) {
+			if partial != nil {
+				partial.transition(transition)
+			}
+			if tracker != nil {
+				tracker.RecordTransition(transition)
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(stemcells))
+
+	for i := range stemcells {
+		go func(i int, stemcell config.Stemcell) {
 			defer wg.Done()
 
-			switch {
-			case regionConfig.IsolatedRegion:
-				ds := driverset.NewIsolatedRegionDriverSet(sharedWriter, regionConfig.Credentials)
-				p := publisher.NewIsolatedRegionPublisher(sharedWriter, publisher.Config{
-					AmiRegion:        regionConfig,
-					AmiConfiguration: c.AmiConfiguration,
-				})
-
-				amis, err := p.Publish(ds, imageConfig)
-				if err != nil {
-					errCollection.Add(fmt.Errorf("Error publishing AMIs to %s: %s", regionConfig.RegionName, err))
-				} else {
-					amiCollection.Merge(amis)
-				}
-			default:
-				ds := driverset.NewStandardRegionDriverSet(sharedWriter, regionConfig.Credentials)
-				p := publisher.NewStandardRegionPublisher(sharedWriter, publisher.Config{
-					AmiRegion:        regionConfig,
-					AmiConfiguration: c.AmiConfiguration,
-				})
-
-				amis, err := p.Publish(ds, imageConfig)
-				if err != nil {
-					errCollection.Add(fmt.Errorf("Error publishing AMIs to %s: %s", regionConfig.RegionName, err))
+			imageConfig := publisher.MachineImageConfig{
+				LocalPath:         stemcell.ImagePath,
+				FileFormat:        stemcell.ImageFormat,
+				VolumeSizeGB:      stemcell.VolumeSizeGB,
+				MaxUploadMemoryMB: int64(uploadMemoryPerRegion),
+				MaxUploadRate:     uploadRatePerRegion,
+				ImageDigest:       imageDigests[i],
+			}
+
+			// ami_regions entries already published by this plan are left out, so retrying a partly failed publish
+			// only repeats the entries which failed
+			stemcellConfig := c.ForStemcell(stemcell)
+			remaining := stemcellConfig
+			remaining.AmiRegions = []config.AmiRegion{}
+			done := &collection.Ami{VirtualizationType: stemcellConfig.AmiConfiguration.VirtualizationType}
+			canaryAmis := canaryPublished[i][c.Canary.Region]
+			pendingRegions, publishedRegions := []string{}, []string{}
+			for _, regionConfig := range stemcellConfig.AmiRegions {
+				if amis, found := published[i][regionConfig.RegionName]; found {
+					done.Merge(amis)
+					publishedRegions = append(publishedRegions, regionConfig.RegionName)
+					if regionConfig.RegionName == c.Canary.Region {
+						canaryAmis = amis
+					}
 				} else {
-					amiCollection.Merge(amis)
+					remaining.AmiRegions = append(remaining.AmiRegions, regionConfig)
+					pendingRegions = append(pendingRegions, regionConfig.RegionName)
 				}
 			}
-		}(c.AmiRegions[i])
+
+			if tracker != nil {
+				tracker.Begin(i, manifests[i].Name, manifests[i].Version, pendingRegions, publishedRegions)
+			}
+
+			publishedBefore[i] = amiMapping(done)
+
+			if partial != nil {
+				partial.begin(i, report.Stemcell{
+					Name:               manifests[i].Name,
+					Version:            manifests[i].Version,
+					Image:              stemcellInput(stemcell),
+					Plan:               plans[i],
+					Amis:               amiMapping(done),
+					VirtualizationType: reportVirtualizationType(c, stemcell),
+				}, remaining.AmiRegions)
+			}
+
+			if len(stemcellConfig.AmiRegions) == 0 {
+				logger.Printf("%s %s has none of its regions in the selected ami_regions, skipping", manifests[i].Name, manifests[i].Version)
+				amiCollections[i] = done
+				return
+			}
+
+			if len(remaining.AmiRegions) == 0 {
+				logger.Printf("%s %s is already published by plan %s, skipping", manifests[i].Name, manifests[i].Version, plans[i])
+				amiCollections[i] = done
+				return
+			}
+
+			if len(remaining.AmiRegions) < len(stemcellConfig.AmiRegions) {
+				logger.Printf("%s %s is already published by plan %s for %d of %d ami_regions entries, publishing the rest",
+					manifests[i].Name, manifests[i].Version, plans[i], len(stemcellConfig.AmiRegions)-len(remaining.AmiRegions), len(stemcellConfig.AmiRegions))
+			}
+
+			amiConfig := stemcellConfig.AmiConfiguration
+			amiConfig.AmiName = stemcell.AmiName
+			if amiConfig.Promotion != "" {
+				amiConfig.Visibility = config.PrivateVisibility
+			}
+			amiConfig.Tags = map[string]string{}
+			for key, value := range c.AmiConfiguration.Tags {
+				amiConfig.Tags[key] = value
+			}
+			amiConfig.Tags[plan.TagKey] = plans[i]
+			if manifests[i].Version != "" {
+				amiConfig.Tags[resources.StemcellVersionTagKey] = manifests[i].Version
+			}
+			if manifests[i].OperatingSystem != "" {
+				amiConfig.Tags[resources.StemcellOSTagKey] = manifests[i].OperatingSystem
+			}
+			amiTags[i] = amiConfig.Tags
+
+			// the snapshot of every AMI also records where it came from, see resources.LineageTags
+			amiConfig.SnapshotTags = map[string]string{}
+			for key, value := range c.AmiConfiguration.SnapshotTags {
+				amiConfig.SnapshotTags[key] = value
+			}
+			amiConfig.SnapshotTags[resources.ImageDigestTagKey] = imageDigests[i]
+			amiConfig.SnapshotTags[resources.BuilderVersionTagKey] = version
+			if c.AmiConfiguration.SnapshotName != "" {
+				amiConfig.SnapshotTags[resources.NameTagKey] = identity.Expand(c.AmiConfiguration.SnapshotName, manifests[i])
+			}
+
+			var progress chan builder.Progress
+			drained := make(chan struct{})
+			if partial != nil || tracker != nil {
+				progress = make(chan builder.Progress)
+				go func() {
+					for event := range progress {
+						if partial != nil {
+							partial.update(i, event)
+						}
+						if tracker != nil {
+							tracker.Record(i, event)
+						}
+					}
+					close(drained)
+				}()
+			}
+
+			result, err := builder.Publish(ctx, remaining, amiConfig, imageConfig, builder.Options{
+				LogDest:       sharedWriter,
+				DriverLogDest: detailWriter,
+				Limiter:       publishLimiter,
+				Progress:      progress,
+				CanaryRegion:  c.Canary.Region,
+				CanaryGate:    canaryGate(logger, c.Canary, manifests[i]),
+				CanaryAmis:    canaryAmis,
+			})
+			if progress != nil {
+				close(progress)
+				<-drained
+			}
+			amiCollections[i], publishFailures[i], publishErrs[i] = result.Amis, result.Failures, err
+			amiCollections[i].Merge(done)
+		}(i, stemcells[i])
 	}
 
 	logger.Println("Waiting for publishers to finish...")
 	wg.Wait()
+	deadlinePassed := ctx.Err() == context.DeadlineExceeded
+
+	// AMIs published private are only made public once every region of every stemcell has published
+	promoted := c.AmiConfiguration.Promotion == config.AfterPublishPromotion && allPublished(publishErrs)
+	promotionFailures := make([]map[string]bool, len(stemcells))
+	switch {
+	case promoted:
+		clients := promotionClients(c)
+		for i := range stemcells {
+			promotionFailures[i] = map[string]bool{}
+			for region, err := range driver.PromoteImages(clients, amiMapping(amiCollections[i]), c.Retries.Permission) {
+				publishFailures[i] = append(publishFailures[i], report.Failure{Region: region, Phase: publisher.PromotePhase, Error: err.Error()})
+				publishErrs[i] = fmt.Errorf("Error promoting AMIs to public: %s", err)
+				promotionFailures[i][region] = true
+			}
+		}
+	case c.AmiConfiguration.Promotion != "":
+		logger.Printf("AMIs were published private, make them public with: %s promote -c %s --report REPORT", os.Args[0], *configPath)
+	}
+
+	// every AMI is described again so the report reflects what is actually in each region, repairing the launch
+	// permissions and tags which drifted and reporting a sweep failure for the AMIs which could not be
+	sweepClients := promotionClients(c)
+	for i := range stemcells {
+		amis := amiMapping(amiCollections[i])
+		expected := map[string]driver.ImageExpectation{}
+		for region := range amis {
+			expected[region] = driver.ImageExpectation{
+				Accessibility: driver.ExpectedAccessibility(c.AmiConfiguration, promoted, promotionFailures[i][region], publishedBefore[i][region] != ""),
+				Tags:          amiTags[i],
+			}
+		}
+
+		repairs, errs := driver.SweepImages(sweepClients, c.Retries.Tag, amis, expected)
+		for region, regionRepairs := range repairs {
+			for _, repair := range regionRepairs {
+				logger.Printf("%s %s: sweep of %s %s", manifests[i].Name, manifests[i].Version, region, repair)
+			}
+		}
+		for region, err := range errs {
+			// the failure is reported against the entry which published the AMI, so the retry command selects it
+			failure := report.Failure{Region: region, Phase: publisher.SweepPhase, Error: err.Error()}
+			if regionConfig, ok := c.EntryFor(region); ok && regionConfig.RegionName != region {
+				failure.Region = regionConfig.RegionName
+				failure.Error = fmt.Sprintf("AMI in %s: %s", region, err)
+			}
+			publishFailures[i] = append(publishFailures[i], failure)
+			publishErrs[i] = fmt.Errorf("Error sweeping AMIs: %s", err)
+			if _, missing := err.(*driver.MissingImageError); missing {
+				amiCollections[i] = withoutRegion(amiCollections[i], region)
+			}
+		}
+	}
+
+	if tracker != nil {
+		tracker.Finish(allPublished(publishErrs))
+	}
+
+	reportStemcells := []report.Stemcell{}
+	for i, stemcell := range stemcells {
+		// AMIs are registered for x86_64 with legacy BIOS boot and without ENA support
+		compatibility := report.NewCompatibility(resources.AmiArchitecture, c.ForStemcell(stemcell).AmiConfiguration.VirtualizationType, report.LegacyBIOSBootMode, false)
+
+		reportStemcells = append(reportStemcells, report.Stemcell{
+			Name:               manifests[i].Name,
+			Version:            manifests[i].Version,
+			Image:              stemcellInput(stemcell),
+			Plan:               plans[i],
+			Amis:               amiMapping(amiCollections[i]),
+			Failures:           publishFailures[i],
+			VirtualizationType: reportVirtualizationType(c, stemcell),
+			Compatibility:      &compatibility,
+		})
+	}
+
+	publishReport := newReport(regionNames, reportStemcells)
+
+	var repository storage.Storage
+	if c.ArtifactRepository.URL != "" {
+		repository = storage.NewHTTPStorage(c.ArtifactRepository)
+	}
+
+	if *reportPath != "" {
+		err = writeReport(publishReport, reportStorage, reportKey, signingKey)
+		if err != nil {
+			logger.Printf("writing report: %s", err)
+		} else {
+			logger.Printf("Report written to %s", *reportPath)
+		}
+
+		if repository != nil {
+			err = writeReport(publishReport, repository, filepath.Base(reportKey), signingKey)
+			if err != nil {
+				logger.Printf("uploading report to artifact_repository: %s", err)
+			} else {
+				logger.Printf("Report uploaded to %s", c.ArtifactRepository.URL)
+			}
+		}
+	}
+
+	if actions.Detect() {
+		err = writeActionsOutputs(publishReport, *reportPath)
+		if err != nil {
+			logger.Printf("writing GitHub Actions outputs: %s", err)
+		}
+	}
+
+	exitCode := 1
+	if deadlinePassed {
+		exitCode = timeoutExitCode
+	}
+	// a publish which only finished after the timeout passed still exits with the timeout's status
+	finished := func() {
+		if deadlinePassed {
+			logger.Printf("Publishing finished, but only after the timeout of %s passed", *timeout)
+			os.Exit(timeoutExitCode)
+		}
+		logger.Println("Publishing finished successfully")
+	}
+
+	if len(c.Stemcells) == 0 {
+		// the manifest is written for the first virtualization type, the AMIs of any others are only in the report
+		for _, publishErr := range publishErrs {
+			if publishErr != nil {
+				logger.Println(publishErr)
+				os.Exit(exitCode)
+			}
+		}
+
+		err = writeManifest(manifests[0], amiCollections[0], os.Stdout)
+		if err != nil {
+			logger.Fatalf("writing manifest: %s", err)
+		}
+
+		if repository != nil {
+			err = uploadLightStemcell(repository, manifests[0])
+			if err != nil {
+				logger.Fatalf("uploading light stemcell to artifact_repository: %s", err)
+			}
+			logger.Printf("Uploaded %s to %s", manifests[0].TarballName(), c.ArtifactRepository.URL)
+		}
+
+		if *concourseOutputPath != "" {
+			err = writeConcourseOutput(reportStemcells[0], *concourseOutputPath)
+			if err != nil {
+				logger.Fatalf("writing Concourse output: %s", err)
+			}
+		}
+		finished()
+		return
+	}
+
+	errCollection := collection.Error{}
+	for i, stemcell := range stemcells {
+		if publishErrs[i] != nil {
+			logger.Printf("FAILED %s %s: %s", manifests[i].Name, manifests[i].Version, publishErrs[i])
+			errCollection.Add(fmt.Errorf("Error publishing stemcell %s: %s", stemcellInput(stemcell), publishErrs[i]))
+			continue
+		}
+
+		err := writeManifestFile(manifests[i], amiCollections[i], stemcell.OutputPath)
+		if err != nil {
+			logger.Printf("FAILED %s %s: %s", manifests[i].Name, manifests[i].Version, err)
+			errCollection.Add(fmt.Errorf("Error writing manifest for stemcell %s: %s", stemcellInput(stemcell), err))
+			continue
+		}
+
+		if repository != nil {
+			err = uploadLightStemcell(repository, manifests[i])
+			if err != nil {
+				logger.Printf("FAILED %s %s: %s", manifests[i].Name, manifests[i].Version, err)
+				errCollection.Add(fmt.Errorf("Error uploading light stemcell for stemcell %s: %s", stemcellInput(stemcell), err))
+				continue
+			}
+		}
+
+		logger.Printf("PUBLISHED %s %s: %d AMIs, manifest written to %s", manifests[i].Name, manifests[i].Version, len(amiCollections[i].GetAll()), stemcell.OutputPath)
+	}
 
 	combinedErr := errCollection.Error()
 	if combinedErr != nil {
-		logger.Fatal(combinedErr)
+		logger.Println(combinedErr)
+		os.Exit(exitCode)
 	}
+	finished()
+}
 
-	m.PublishedAmis = amiCollection.GetAll()
+// stemcellInput returns the path or URL the stemcell was given as, which is its tarball when it was extracted
+// from one
+func stemcellInput(stemcell config.Stemcell) string {
+	if stemcell.InputURL != "" {
+		return stemcell.InputURL
+	}
+	if stemcell.TarballPath != "" {
+		return stemcell.TarballPath
+	}
+	return stemcell.ImagePath
+}
 
-	m.Sha1 = shasum([]byte{})
+// expandStemcells downloads the inputs and extracts the heavy stemcells before expanding them into an entry per
+// virtualization type, so each is only fetched once, returning the entries and the directories holding the fetched
+// files. Files are hashed into fileDigests as they are written, so only images given as local files are read an
+// extra time to plan them.
+func expandStemcells(logger *log.Logger, c config.Config, stemcells []config.Stemcell, fileDigests map[string]string) ([]config.Stemcell, []string) {
+	dirs := []string{}
+	downloader := download.NewDownloader(c)
+	downloader.Digests = fileDigests
+	for i := range stemcells {
+		stemcell := &stemcells[i]
+		input := &stemcell.ImagePath
+		if stemcell.TarballPath != "" {
+			input = &stemcell.TarballPath
+		}
+		if stemcell.TarballPath == "" && !download.IsURL(*input) {
+			continue
+		}
+
+		dir, err := ioutil.TempDir("", "light-stemcell-builder")
+		if err != nil {
+			logger.Fatalf("creating a directory to fetch the stemcell into: %s", err)
+		}
+		dirs = append(dirs, dir)
+
+		if download.IsURL(*input) {
+			logger.Printf("Downloading %s", *input)
+			stemcell.InputURL = *input
+			*input, err = downloader.Download(*input, dir)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		if stemcell.TarballPath != "" {
+			logger.Printf("Extracting %s", stemcellInput(*stemcell))
+			stemcell.ImagePath, stemcell.ManifestPath, err = heavy.Extract(stemcell.TarballPath, dir, fileDigests)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+	}
+
+	return c.ForVirtualizationTypes(stemcells), dirs
+}
+
+// readManifests reads the stemcell.MF of each stemcell, checking it names the same stemcell as its input and
+// expanding the stemcell's AMI name from it
+func readManifests(logger *log.Logger, c config.Config, stemcells []config.Stemcell) []*manifest.Manifest {
+	manifests := make([]*manifest.Manifest, len(stemcells))
+	for i, stemcell := range stemcells {
+		imageInfo, err := os.Stat(stemcell.ImagePath)
+		if os.IsNotExist(err) {
+			logger.Fatalf("machine image not found at: %s", stemcell.ImagePath)
+		}
+
+		// checked before the upload, which can take hours for the largest images
+		if err == nil {
+			err = driver.CheckVolumeSize(stemcell.VolumeSizeGB, imageInfo.Size(), stemcell.ImageFormat)
+			if err != nil {
+				logger.Fatalf("%s: %s", stemcell.ImagePath, err)
+			}
+		}
+
+		if _, err := os.Stat(stemcell.ManifestPath); os.IsNotExist(err) {
+			logger.Fatalf("manifest not found at: %s", stemcell.ManifestPath)
+		}
+
+		manifestBytes, err := ioutil.ReadFile(stemcell.ManifestPath)
+		if err != nil {
+			logger.Fatalf("opening manifest: %s", err)
+		}
+
+		manifests[i], err = manifest.NewFromReader(bytes.NewReader(manifestBytes))
+		if err != nil {
+			logger.Fatalf("reading manifest: %s", err)
+		}
+
+		// naming the image after one stemcell and the manifest after another is caught here, before publishing
+		err = identity.Resolve(manifests[i], identity.Infer(stemcellInput(stemcell)), stemcell.Identity)
+		if err != nil {
+			logger.Fatalf("%s: %s", stemcellInput(stemcell), err)
+		}
+		stemcells[i].AmiName = identity.Expand(stemcell.AmiName, manifests[i])
+
+		if c.ManifestApiVersion == manifest.ApiVersion3 {
+			manifests[i].UseApiVersion3()
+		}
+
+		if c.AmiConfiguration.Encrypted {
+			manifests[i].CloudProperties.Encrypted = true
+			manifests[i].CloudProperties.KmsKeyId = c.AmiConfiguration.KmsKeyId
+		}
+	}
+
+	return manifests
+}
 
-	err = m.Write(os.Stdout)
+// planStemcells logs and returns the plan digest of each stemcell along with the digest of its machine image
+func planStemcells(logger *log.Logger, c config.Config, stemcells []config.Stemcell, manifests []*manifest.Manifest, fileDigests map[string]string) ([]string, []string) {
+	plans := make([]string, len(stemcells))
+	imageDigests := make([]string, len(stemcells))
+	// the virtualization types of a stemcell usually share an image, which is only hashed once
+	for i, stemcell := range stemcells {
+		var err error
+		plans[i], imageDigests[i], err = stemcellPlan(c.ForStemcell(stemcell), stemcell, fileDigests)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("Plan for %s %s: %s", manifests[i].Name, manifests[i].Version, plans[i])
+	}
+	return plans, imageDigests
+}
+
+// stemcellPlan returns the digest of the plan for publishing stemcell with c, along with the digest of its machine
+// image. fileDigests holds the digests of the files already hashed, by path, and is filled in with the new ones.
+// Files missing from it are read in full here, ahead of the upload, since --skip-published and shared imports look
+// for the plan before anything is uploaded. The upload then checks its own checksums against the image digest
+// instead of reading the image again.
+func stemcellPlan(c config.Config, stemcell config.Stemcell, fileDigests map[string]string) (string, string, error) {
+	imageDigest, err := cachedFileDigest(fileDigests, stemcell.ImagePath)
 	if err != nil {
-		logger.Fatalf("writing manifest: %s", err)
+		return "", "", fmt.Errorf("computing plan digest: %s", err)
+	}
+
+	manifestDigest, err := cachedFileDigest(fileDigests, stemcell.ManifestPath)
+	if err != nil {
+		return "", "", fmt.Errorf("computing plan digest: %s", err)
+	}
+
+	return plan.Digest(c, stemcell, imageDigest, manifestDigest), imageDigest, nil
+}
+
+func cachedFileDigest(fileDigests map[string]string, path string) (string, error) {
+	if digest, ok := fileDigests[path]; ok {
+		return digest, nil
+	}
+
+	digest, err := plan.FileDigest(path)
+	if err != nil {
+		return "", err
+	}
+	fileDigests[path] = digest
+	return digest, nil
+}
+
+// findPublished looks up the AMIs tagged with the plan of each stemcell. For each stemcell it returns the AMIs of
+// every ami_regions entry which has one in its region and all of its destinations, keyed by the entry's region.
+// Entries use their own credentials, so an entry which failed in one account does not hold back the others.
+func findPublished(c config.Config, stemcells []config.Stemcell, plans []string) ([]map[string]*collection.Ami, error) {
+	clients := map[string]ec2iface.EC2API{}
+	for region, creds := range plan.Regions(c) {
+		clients[region] = plan.NewEC2Client(creds)
+	}
+
+	published := make([]map[string]*collection.Ami, len(plans))
+	for i, digest := range plans {
+		amis, err := plan.FindPublished(clients, digest)
+		if err != nil {
+			return nil, fmt.Errorf("Error finding published AMIs: %s", err)
+		}
+
+		published[i] = map[string]*collection.Ami{}
+		stemcellConfig := c.ForStemcell(stemcells[i])
+		virtualizationType := stemcellConfig.AmiConfiguration.VirtualizationType
+		for _, regionConfig := range stemcellConfig.AmiRegions {
+			entryAmis := &collection.Ami{VirtualizationType: virtualizationType}
+			complete := true
+			for _, region := range append([]string{regionConfig.PublishRegion()}, regionConfig.Destinations...) {
+				id, found := amis[region]
+				if !found {
+					complete = false
+					break
+				}
+				entryAmis.Add(resources.Ami{ID: id, Region: region, VirtualizationType: virtualizationType})
+			}
+
+			if complete {
+				published[i][regionConfig.RegionName] = entryAmis
+			}
+		}
+	}
+
+	return published, nil
+}
+
+// reportVirtualizationType returns the virtualization type to record for the report entry of stemcell, which is
+// only needed to tell entries apart when the config publishes several types
+func reportVirtualizationType(c config.Config, stemcell config.Stemcell) string {
+	if len(c.AmiConfiguration.VirtualizationTypes) < 2 {
+		return ""
+	}
+	return stemcell.VirtualizationType
+}
+
+// snapshotSizeGB returns the total size of the snapshots the stemcells will be imported as: the configured
+// volume size, or else the size of the machine image rounded up to a whole GiB
+func snapshotSizeGB(stemcells []config.Stemcell) (int64, error) {
+	total := int64(0)
+	for _, stemcell := range stemcells {
+		if stemcell.VolumeSizeGB > 0 {
+			total += stemcell.VolumeSizeGB
+			continue
+		}
+
+		info, err := os.Stat(stemcell.ImagePath)
+		if err != nil {
+			return 0, fmt.Errorf("reading machine image size: %s", err)
+		}
+		total += driver.VolumeSizeGB(info.Size())
+	}
+	return total, nil
+}
+
+// allPublished returns true when every stemcell was published without errors
+func allPublished(publishErrs []error) bool {
+	for _, err := range publishErrs {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// promotionClients creates an EC2 client for every region which a publish with c produces an AMI in
+func promotionClients(c config.Config) map[string]ec2iface.EC2API {
+	clients := map[string]ec2iface.EC2API{}
+	for region, creds := range plan.Regions(c) {
+		clients[region] = plan.NewEC2Client(creds)
+	}
+	return clients
+}
+
+// excludedCanary returns the config of only the canary entry of planConfig when a canary is configured but c, the
+// config of the regions being published, leaves it out
+func excludedCanary(planConfig config.Config, c config.Config) (config.Config, bool) {
+	if c.Canary.Region == "" {
+		return config.Config{}, false
+	}
+
+	for _, regionConfig := range c.AmiRegions {
+		if regionConfig.RegionName == c.Canary.Region {
+			return config.Config{}, false
+		}
+	}
+
+	canaryConfig := planConfig
+	canaryConfig.AmiRegions = []config.AmiRegion{}
+	for _, regionConfig := range planConfig.AmiRegions {
+		if regionConfig.RegionName == c.Canary.Region {
+			canaryConfig.AmiRegions = append(canaryConfig.AmiRegions, regionConfig)
+		}
+	}
+	return canaryConfig, true
+}
+
+// canaryGate runs the canary command of c with the AMIs of the canary region, passing when it exits successfully.
+// A command which waits for a person to approve the canary holds back the other regions until they do.
+func canaryGate(logger *log.Logger, c config.Canary, stemcellManifest *manifest.Manifest) func(string, *collection.Ami) error {
+	if len(c.Command) == 0 {
+		return nil
+	}
+
+	return func(region string, amis *collection.Ami) error {
+		amisJSON, err := json.Marshal(amiMapping(amis))
+		if err != nil {
+			return fmt.Errorf("encoding canary AMIs: %s", err)
+		}
+
+		logger.Printf("%s %s: waiting on the canary gate for %s", stemcellManifest.Name, stemcellManifest.Version, region)
+		cmd := exec.Command(c.Command[0], c.Command[1:]...)
+		cmd.Env = append(os.Environ(),
+			"LSB_CANARY_REGION="+region,
+			"LSB_CANARY_AMIS="+string(amisJSON),
+			"LSB_STEMCELL_NAME="+stemcellManifest.Name,
+			"LSB_STEMCELL_VERSION="+stemcellManifest.Version,
+		)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("running %s: %s", c.Command[0], err)
+		}
+
+		logger.Printf("%s %s: canary %s passed its gate", stemcellManifest.Name, stemcellManifest.Version, region)
+		return nil
+	}
+}
+
+// runReencrypt makes an encrypted copy of every AMI in a previously published light stemcell.MF,
+// writing a stemcell.MF which lists the encrypted AMIs
+func runReencrypt(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file, providing credentials and KMS keys for each region")
+	manifestPath := flags.String("manifest", "", "Path to the published light stemcell.MF whose AMIs should be encrypted")
+	outputPath := flags.String("output", "", "Path to write the stemcell.MF listing the encrypted AMIs. Defaults to stdout")
+	regions := flags.String("regions", "", "Comma-separated regions of the manifest's AMIs to encrypt. Defaults to every region in the manifest")
+	reportPath := flags.String("report", "", "Path or s3://bucket/key URL to write a JSON report of the encrypted AMIs and any failures")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	if *manifestPath == "" {
+		subcommandUsage(flags, "--manifest flag is required")
+	}
+
+	c, err := loadConfig(*configPath, "")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	reportStorage, reportKey, err := reportDestination(*reportPath, c.AmiRegions)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	manifestBytes, err := ioutil.ReadFile(*manifestPath)
+	if err != nil {
+		logger.Fatalf("opening manifest: %s", err)
+	}
+
+	m, err := manifest.NewFromReader(bytes.NewReader(manifestBytes))
+	if err != nil {
+		logger.Fatalf("reading manifest: %s", err)
+	}
+
+	if c.ManifestApiVersion == manifest.ApiVersion3 {
+		m.UseApiVersion3()
+	}
+
+	// a single key can only be recorded when no region is encrypted with its own key
+	m.CloudProperties.Encrypted = true
+	m.CloudProperties.KmsKeyId = c.AmiConfiguration.KmsKeyId
+	for _, regionConfig := range c.AmiRegions {
+		if regionConfig.RegionKmsKeyId != "" {
+			m.CloudProperties.KmsKeyId = ""
+		}
+	}
+
+	sourceAmis := m.CloudProperties.Amis
+	if *regions != "" {
+		sourceAmis = manifest.RegionToAmiMapping{}
+		for _, region := range strings.Split(*regions, ",") {
+			region = c.ResolveRegion(strings.TrimSpace(region))
+			amiID, ok := m.CloudProperties.Amis[region]
+			if !ok {
+				subcommandUsage(flags, fmt.Sprintf("--regions includes %s which has no AMI in the manifest", region))
+			}
+			sourceAmis[region] = amiID
+		}
+	}
+
+	regionNames := []string{}
+	for region := range sourceAmis {
+		regionNames = append(regionNames, region)
+	}
+	sort.Strings(regionNames)
+
+	regionConfigs := []config.AmiRegion{}
+	for _, region := range regionNames {
+		regionConfig, ok := regionForAmi(c, region)
+		if !ok {
+			logger.Fatalf("no ami_regions entry provides credentials for %s", region)
+		}
+		regionConfigs = append(regionConfigs, regionConfig)
+	}
+
+	amiCollection := collection.Ami{}
+	errCollection := collection.Error{}
+
+	var failuresMutex sync.Mutex
+	failures := []report.Failure{}
+
+	var wg sync.WaitGroup
+	wg.Add(len(regionConfigs))
+
+	for i := range regionConfigs {
+		go func(regionConfig config.AmiRegion) {
+			defer wg.Done()
+
+			ds := driverset.NewStandardRegionDriverSet(os.Stderr, regionConfig.Credentials, c.Retries)
+			p := publisher.NewReencryptPublisher(os.Stderr, publisher.Config{
+				AmiRegion:        regionConfig,
+				AmiConfiguration: c.AmiConfiguration,
+			})
+
+			amis, err := p.Publish(ds, sourceAmis[regionConfig.RegionName])
+			if err != nil {
+				errCollection.Add(fmt.Errorf("Error encrypting AMI in %s: %s", regionConfig.RegionName, err))
+
+				failuresMutex.Lock()
+				defer failuresMutex.Unlock()
+				failures = append(failures, report.Failure{Region: regionConfig.RegionName, Phase: publisher.CopyPhase, Error: err.Error()})
+				return
+			}
+			amiCollection.Merge(amis)
+		}(regionConfigs[i])
+	}
+
+	logger.Println("Waiting for AMIs to be encrypted...")
+	wg.Wait()
+
+	if *reportPath != "" {
+		reportStemcells := []report.Stemcell{
+			{
+				Name:     m.Name,
+				Version:  m.Version,
+				Amis:     amiMapping(&amiCollection),
+				Failures: failures,
+			},
+		}
+
+		err = writeReport(newReport(regionNames, reportStemcells), reportStorage, reportKey, nil)
+		if err != nil {
+			logger.Printf("writing report: %s", err)
+		} else {
+			logger.Printf("Report written to %s", *reportPath)
+		}
+	}
+
+	combinedErr := errCollection.Error()
+	if combinedErr != nil {
+		logger.Fatal(combinedErr)
+	}
+
+	if *outputPath != "" {
+		err = writeManifestFile(m, &amiCollection, *outputPath)
+	} else {
+		err = writeManifest(m, &amiCollection, os.Stdout)
+	}
+	if err != nil {
+		logger.Fatalf("writing manifest: %s", err)
+	}
+	logger.Println("Encryption finished successfully")
+}
+
+// runExport writes the AMIs of a published light stemcell manifest as bosh-aws-cpi cloud_properties, or patches them
+// into an existing ops file or cloud-config
+func runExport(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	manifestPath := flags.String("manifest", "", "Path to the published light stemcell.MF whose AMIs should be exported")
+	opsFilePath := flags.String("ops-file", "", "Path to a BOSH ops file to update with a replace operation setting the AMIs at --path")
+	cloudConfigPath := flags.String("cloud-config", "", "Path to a cloud-config to update by setting the AMIs at --path")
+	path := flags.String("path", "", "Ops file path, e.g. /vm_extensions/name=light-stemcell/cloud_properties/ami, at which to set the AMIs")
+	outputPath := flags.String("output", "", "Path to write the result to. Defaults to updating --ops-file or --cloud-config in place, or stdout")
+	flags.Parse(args)
+
+	if *manifestPath == "" {
+		subcommandUsage(flags, "--manifest flag is required")
+	}
+
+	if *opsFilePath != "" && *cloudConfigPath != "" {
+		subcommandUsage(flags, "only one of --ops-file and --cloud-config may be given")
+	}
+
+	target := *opsFilePath + *cloudConfigPath
+	if target != "" && *path == "" {
+		subcommandUsage(flags, "--path flag is required with --ops-file or --cloud-config")
+	}
+
+	manifestBytes, err := ioutil.ReadFile(*manifestPath)
+	if err != nil {
+		logger.Fatalf("opening manifest: %s", err)
+	}
+
+	m, err := manifest.NewFromReader(bytes.NewReader(manifestBytes))
+	if err != nil {
+		logger.Fatalf("reading manifest: %s", err)
+	}
+
+	if len(m.CloudProperties.Amis) == 0 {
+		logger.Fatalf("the manifest %s does not list any AMIs", *manifestPath)
+	}
+
+	var output []byte
+	if target == "" {
+		output, err = m.ExportCloudProperties()
+	} else {
+		var document []byte
+		document, err = ioutil.ReadFile(target)
+		if err != nil {
+			logger.Fatalf("opening %s: %s", target, err)
+		}
+
+		if *opsFilePath != "" {
+			output, err = manifest.PatchOpsFile(document, *path, m.CloudProperties.Amis)
+		} else {
+			output, err = manifest.PatchDocument(document, *path, m.CloudProperties.Amis)
+		}
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	destination := *outputPath
+	if destination == "" {
+		destination = target
+	}
+	if destination == "" {
+		_, err = os.Stdout.Write(output)
+	} else {
+		err = ioutil.WriteFile(destination, output, 0644)
+	}
+	if err != nil {
+		logger.Fatalf("writing export: %s", err)
+	}
+}
+
+// regionForAmi returns the ami_regions entry whose credentials can copy AMIs in region, which is either
+// the entry for that region or the entry which lists it as a copy destination
+func regionForAmi(c config.Config, region string) (config.AmiRegion, bool) {
+	regionConfig, ok := c.EntryFor(region)
+	if !ok {
+		return config.AmiRegion{}, false
+	}
+
+	if regionConfig.PublishRegion() != region {
+		// KMS keys are regional, so the source region's key cannot be used for its destinations
+		regionConfig.Credentials.Region = region
+		regionConfig.RegionKmsKeyId = ""
+		regionConfig.Destinations = nil
+	}
+
+	// the entry is used for its AMI in region, rather than the region it names
+	regionConfig.RegionName = region
+	regionConfig.ImportRegion = ""
+	return regionConfig, true
+}
+
+// writeActionsOutputs writes the step outputs and summary of the publish to the files GitHub Actions provides
+func writeActionsOutputs(r *report.Report, reportPath string) error {
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		err := actions.AppendFile(path, func(w io.Writer) error {
+			return actions.WriteOutputs(w, actions.Outputs(r, reportPath))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		return actions.AppendFile(path, func(w io.Writer) error {
+			return actions.WriteSummary(w, r)
+		})
+	}
+
+	return nil
+}
+
+// writeConcourseOutput writes the Concourse version and metadata of the published stemcell to path
+func writeConcourseOutput(stemcell report.Stemcell, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return concourse.NewOutput(stemcell).Write(f)
+}
+
+// runConcourseCheck prints the version of the stemcell, as the check script of a Concourse resource would, when
+// every region and destination it would be published to, for each of its virtualization types, already has an AMI
+// published for its plan, and no versions otherwise. The stemcell is fetched and planned as a publish would, so the
+// version is the one --concourse-output writes. Checking is idempotent, since the AMIs are found by their plan tags
+// rather than from the state of a previous run.
+func runConcourseCheck(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("concourse-check", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file")
+	machineImagePath := flags.String("image", "", "Path or http(s):// or s3:// URL of the input machine image (root.img)")
+	machineImageFormat := flags.String("format", resources.VolumeRawFormat, "Format of the input machine image (RAW or vmdk). Defaults to RAW.")
+	imageVolumeSize := flags.Int("volume-size", 0, "Block device size (in GB) of the input machine image")
+	manifestPath := flags.String("manifest", "", "Path to the input stemcell.MF")
+	stemcellTarballPath := flags.String("stemcell", "", "Path or http(s):// or s3:// URL of a heavy stemcell tarball, whose root.img and stemcell.MF are checked instead of --image and --manifest")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	if *stemcellTarballPath != "" && (*machineImagePath != "" || *manifestPath != "") {
+		subcommandUsage(flags, "--stemcell flag cannot be used with the --image and --manifest flags")
+	}
+
+	if *stemcellTarballPath == "" && (*machineImagePath == "" || *manifestPath == "") {
+		subcommandUsage(flags, "--image and --manifest flags are required")
+	}
+
+	c, err := loadConfig(*configPath, "")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	stemcells := []config.Stemcell{
+		{
+			TarballPath:  *stemcellTarballPath,
+			ImagePath:    *machineImagePath,
+			ManifestPath: *manifestPath,
+			ImageFormat:  *machineImageFormat,
+			VolumeSizeGB: int64(*imageVolumeSize),
+			AmiName:      c.AmiConfiguration.AmiName,
+			Identity:     c.Identity,
+		},
+	}
+
+	fileDigests := map[string]string{}
+	stemcells, dirs := expandStemcells(logger, c, stemcells, fileDigests)
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+
+	manifests := readManifests(logger, c, stemcells)
+	plans, _ := planStemcells(logger, c, stemcells, manifests, fileDigests)
+
+	published, err := findPublished(c, stemcells, plans)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// findPublished only counts an entry once its region and every destination have an AMI
+	complete := true
+	for i, stemcell := range stemcells {
+		if len(published[i]) != len(c.ForStemcell(stemcell).AmiRegions) {
+			complete = false
+		}
+	}
+
+	versions := []concourse.Version{}
+	if complete {
+		versions = append(versions, concourse.Version{Plan: plans[0]})
+	}
+
+	err = concourse.WriteCheck(os.Stdout, versions)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// runConvertReport validates a publish report, converting reports of earlier schema versions to the current one
+func runConvertReport(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("convert-report", flag.ExitOnError)
+	inputPath := flags.String("input", "", "Path to the publish report to convert")
+	outputPath := flags.String("output", "", "Path to write the converted report to. Defaults to stdout")
+	flags.Parse(args)
+
+	if *inputPath == "" {
+		subcommandUsage(flags, "--input flag is required")
+	}
+
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		logger.Fatalf("opening report: %s", err)
+	}
+	defer input.Close()
+
+	r, err := report.Read(input)
+	if err != nil {
+		logger.Fatalf("reading %s: %s", *inputPath, err)
+	}
+
+	output := io.Writer(os.Stdout)
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			logger.Fatalf("creating %s: %s", *outputPath, err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	err = r.Write(output)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// runVerifyReport checks the signature of a publish report, so the AMIs it lists can be trusted to come from the
+// publisher holding the signing key
+func runVerifyReport(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("verify-report", flag.ExitOnError)
+	reportPath := flags.String("report", "", "Path to the publish report to verify")
+	signaturePath := flags.String("signature", "", "Path to the signature of the report. Defaults to the report path with a .sig suffix")
+	publicKeyPath := flags.String("public-key", "", "Path to the PEM encoded ed25519 public key of the publisher")
+	flags.Parse(args)
+
+	if *reportPath == "" {
+		subcommandUsage(flags, "--report flag is required")
+	}
+
+	if *publicKeyPath == "" {
+		subcommandUsage(flags, "--public-key flag is required")
+	}
+
+	if *signaturePath == "" {
+		*signaturePath = *reportPath + report.SignatureSuffix
+	}
+
+	pemBytes, err := ioutil.ReadFile(*publicKeyPath)
+	if err != nil {
+		logger.Fatalf("reading public key: %s", err)
+	}
+
+	publicKey, err := report.ParsePublicKey(pemBytes)
+	if err != nil {
+		logger.Fatalf("loading public key %s: %s", *publicKeyPath, err)
+	}
+
+	content, err := ioutil.ReadFile(*reportPath)
+	if err != nil {
+		logger.Fatalf("reading report: %s", err)
+	}
+
+	signature, err := ioutil.ReadFile(*signaturePath)
+	if err != nil {
+		logger.Fatalf("reading signature: %s", err)
+	}
+
+	err = report.VerifySignature(content, signature, publicKey)
+	if err != nil {
+		logger.Fatalf("verifying %s: %s", *reportPath, err)
+	}
+
+	r, err := report.Read(bytes.NewReader(content))
+	if err != nil {
+		logger.Fatalf("reading %s: %s", *reportPath, err)
+	}
+
+	logger.Printf("%s is signed by the given key: %s publish of %d stemcells", *reportPath, r.Status, len(r.Stemcells))
+}
+
+// runCleanupVolumes deletes the intermediate volumes kept by a delayed volume_deletion once their time is up
+func runCleanupVolumes(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("cleanup-volumes", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file, providing credentials for each isolated region")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	c, err := loadConfig(*configPath, "")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// only isolated regions import volumes
+	errCollection := collection.Error{}
+	for _, regionConfig := range c.AmiRegions {
+		if !regionConfig.IsolatedRegion {
+			continue
+		}
+
+		deleted, err := driver.DeleteExpiredVolumes(plan.NewEC2Client(regionConfig.Credentials), time.Now())
+		for _, volumeID := range deleted {
+			logger.Printf("%s: deleted expired volume %s", regionConfig.RegionName, volumeID)
+		}
+		if err != nil {
+			errCollection.Add(fmt.Errorf("Error cleaning up volumes in %s: %s", regionConfig.RegionName, err))
+		}
+	}
+
+	combinedErr := errCollection.Error()
+	if combinedErr != nil {
+		logger.Fatal(combinedErr)
+	}
+}
+
+// runPromote makes the AMIs of a report public, for publishes which left them private until they were verified
+func runPromote(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file, providing credentials for each region")
+	reportPath := flags.String("report", "", "Path to the report of the publish whose AMIs should be made public")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	if *reportPath == "" {
+		subcommandUsage(flags, "--report flag is required")
+	}
+
+	c, err := loadConfig(*configPath, "")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	input, err := os.Open(*reportPath)
+	if err != nil {
+		logger.Fatalf("opening report: %s", err)
+	}
+	defer input.Close()
+
+	r, err := report.Read(input)
+	if err != nil {
+		logger.Fatalf("reading %s: %s", *reportPath, err)
+	}
+
+	// a publish which only failed to promote is retried by promoting its AMIs again
+	promotionFailed := r.Status == report.FailedStatus && len(r.FailedRegions(publisher.PromotePhase)) == 0
+	if r.Status != report.SucceededStatus && !promotionFailed {
+		logger.Fatalf("%s is the report of a publish which is %s, only AMIs of succeeded publishes can be promoted", *reportPath, r.Status)
+	}
+
+	clients := promotionClients(c)
+	errCollection := collection.Error{}
+	for _, stemcell := range r.Stemcells {
+		errs := driver.PromoteImages(clients, stemcell.Amis, c.Retries.Permission)
+		for region, err := range errs {
+			errCollection.Add(fmt.Errorf("Error promoting %s %s in %s: %s", stemcell.Name, stemcell.Version, region, err))
+		}
+		if len(errs) == 0 {
+			logger.Printf("Promoted the %d AMIs of %s %s", len(stemcell.Amis), stemcell.Name, stemcell.Version)
+		}
+	}
+
+	combinedErr := errCollection.Error()
+	if combinedErr != nil {
+		logger.Fatal(combinedErr)
+	}
+}
+
+func subcommandUsage(flags *flag.FlagSet, message string) {
+	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintf(os.Stderr, "Usage of light-stemcell-builder/main.go %s\n", flags.Name())
+	flags.PrintDefaults()
+	os.Exit(1)
+}
+
+// runSelftest confirms the credentials of each configured region are usable by making read-only calls,
+// printing a capability matrix to stdout and exiting non-zero when any check fails
+func runSelftest(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file")
+	regions := flags.String("regions", "", "Comma-separated names of the ami_regions to check. Defaults to all configured regions")
+	readOnly := flags.Bool("read-only", false, "Refuse every AWS request which could modify resources, guaranteeing the checks change nothing")
+	flags.Parse(args)
+
+	if *readOnly {
+		readonly.Enable()
+	}
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	c, err := loadConfig(*configPath, *regions)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	checks := []selftest.Check{}
+	for _, regionConfig := range c.AmiRegions {
+		checks = append(checks, selftest.Run(selftest.NewClients(regionConfig.Credentials), regionConfig, c.AmiConfiguration)...)
+	}
+
+	err = selftest.WriteMatrix(os.Stdout, checks)
+	if err != nil {
+		logger.Fatalf("writing capability matrix: %s", err)
+	}
+
+	if selftest.Failed(checks) {
+		os.Exit(1)
+	}
+}
+
+// runCredentials resolves the principal of every credentials block in the config, printing the account, partition
+// and expiry of each to stdout and exiting non-zero when any of them cannot be used in its region
+func runCredentials(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("credentials", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file")
+	regions := flags.String("regions", "", "Comma-separated names of the ami_regions to check. Defaults to all configured regions")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	c, err := loadConfig(*configPath, *regions)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	identities := []selftest.Identity{}
+	for _, regionConfig := range c.AmiRegions {
+		identities = append(identities, selftest.ResolveIdentity(selftest.NewClients(regionConfig.Credentials).STS, regionConfig))
+	}
+
+	err = selftest.WriteIdentities(os.Stdout, identities)
+	if err != nil {
+		logger.Fatalf("writing identities: %s", err)
+	}
+
+	if selftest.IdentitiesFailed(identities) {
+		os.Exit(1)
+	}
+}
+
+// runPolicy prints the minimal IAM policy needed to publish with the features enabled in the config
+func runPolicy(args []string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	flags := flag.NewFlagSet("policy", flag.ExitOnError)
+	configPath := flags.String("c", "", "Path to the JSON configuration file")
+	regions := flags.String("regions", "", "Comma-separated names of the ami_regions to include. Defaults to all configured regions")
+	flags.Parse(args)
+
+	if *configPath == "" {
+		subcommandUsage(flags, "-c flag is required")
+	}
+
+	c, err := loadConfig(*configPath, *regions)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	err = policy.ForConfig(c).Write(os.Stdout)
+	if err != nil {
+		logger.Fatalf("writing policy: %s", err)
+	}
+}
+
+// checkPermissions simulates the policy required by each region against the IAM policies of that region's credentials
+func checkPermissions(logger *log.Logger, c config.Config) error {
+	errCollection := collection.Error{}
+	for _, regionConfig := range c.AmiRegions {
+		regionOnly := c
+		regionOnly.AmiRegions = []config.AmiRegion{regionConfig}
+
+		missing, err := policy.NewSimulator(regionConfig.Credentials).MissingActions(policy.ForConfig(regionOnly))
+		if err != nil {
+			errCollection.Add(fmt.Errorf("Error checking permissions for %s: %s", regionConfig.RegionName, err))
+			continue
+		}
+
+		if len(missing) > 0 {
+			errCollection.Add(fmt.Errorf("Credentials for %s are missing permissions: %s", regionConfig.RegionName, strings.Join(missing, ", ")))
+			continue
+		}
+
+		logger.Printf("%s: credentials have every required permission", regionConfig.RegionName)
+	}
+
+	return errCollection.Error()
+}
+
+// loadConfig parses the config file at path, keeping only the ami_regions named in the comma-separated regions when non-empty
+func loadConfig(path string, regions string) (config.Config, error) {
+	configFile, err := os.Open(path)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("Error opening config file: %s", err)
+	}
+	defer configFile.Close()
+
+	c, err := config.NewFromReader(configFile)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("Error parsing config file: %s. Message: %s", path, err)
+	}
+
+	if regions != "" {
+		c.AmiRegions, err = c.SelectRegions(strings.Split(regions, ","))
+		if err != nil {
+			return config.Config{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// withoutRegion returns the AMIs of amis other than the one of region
+func withoutRegion(amis *collection.Ami, region string) *collection.Ami {
+	remaining := &collection.Ami{VirtualizationType: amis.VirtualizationType}
+	for _, ami := range amis.GetAll() {
+		if ami.Region != region {
+			remaining.Add(ami)
+		}
+	}
+	return remaining
+}
+
+func amiMapping(amis *collection.Ami) map[string]string {
+	mapping := map[string]string{}
+	for _, ami := range amis.GetAll() {
+		mapping[ami.Region] = ami.ID
+	}
+	return mapping
+}
+
+// newReport builds a report for the given regions and stemcells, with a retry command for any regions which failed
+func newReport(regions []string, stemcells []report.Stemcell) *report.Report {
+	r := &report.Report{
+		SchemaVersion: report.SchemaVersion,
+		Status:        report.SucceededStatus,
+		Builder: report.Builder{
+			Version:   version,
+			GitSHA:    gitSHA,
+			BuildDate: buildDate,
+		},
+		Regions:   regions,
+		Stemcells: stemcells,
+	}
+
+	// promotion only fails once every region has published, so it is retried with promote rather than a publish
+	if failedRegions := r.FailedRegions(publisher.PromotePhase); len(failedRegions) > 0 {
+		r.Status = report.FailedStatus
+		r.RetryCommand = report.RetryCommand(os.Args, failedRegions)
+	} else if len(r.FailedRegions()) > 0 {
+		r.Status = report.FailedStatus
+		r.RetryCommand = report.PromoteCommand(os.Args)
+	}
+
+	return r
+}
+
+// partialReport keeps the report of a publish which is still running, rewriting it as each region finishes.
+// Regions which have not finished are listed as pending in their stemcell.
+type partialReport struct {
+	mutex      sync.Mutex
+	logger     *log.Logger
+	storage    storage.Storage
+	key        string
+	signingKey ed25519.PrivateKey
+	regions    []string
+	stemcells  []report.Stemcell
+	degraded   []string
+}
+
+func newPartialReport(logger *log.Logger, s storage.Storage, key string, signingKey ed25519.PrivateKey, regions []string, stemcellCount int) *partialReport {
+	return &partialReport{
+		logger:     logger,
+		storage:    s,
+		key:        key,
+		signingKey: signingKey,
+		regions:    regions,
+		stemcells:  make([]report.Stemcell, stemcellCount),
+	}
+}
+
+// begin records the i-th stemcell along with the AMIs it already has, marking the entries left to publish as pending
+func (p *partialReport) begin(i int, stemcell report.Stemcell, remaining []config.AmiRegion) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, regionConfig := range remaining {
+		stemcell.Pending = append(stemcell.Pending, regionConfig.RegionName)
+	}
+	p.stemcells[i] = stemcell
+	p.write()
+}
+
+// update records a region of the i-th stemcell as published or failed, ignoring other progress events
+func (p *partialReport) update(i int, progress builder.Progress) {
+	if progress.Event != builder.PublishedEvent && progress.Event != builder.FailedEvent && progress.Event != builder.PriorityPublishedEvent {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stemcell := &p.stemcells[i]
+	// the AMIs of priority destinations are listed while the rest of the region is still pending
+	if progress.Event == builder.PriorityPublishedEvent {
+		for region, ami := range amiMapping(progress.Amis) {
+			stemcell.Amis[region] = ami
+		}
+		p.write()
+		return
+	}
+
+	pending := []string{}
+	for _, region := range stemcell.Pending {
+		if region != progress.Region {
+			pending = append(pending, region)
+		}
+	}
+	stemcell.Pending = pending
+
+	if progress.Event == builder.PublishedEvent {
+		for region, ami := range amiMapping(progress.Amis) {
+			stemcell.Amis[region] = ami
+		}
+	} else {
+		failure := report.Failure{Region: progress.Region, Error: progress.Err.Error()}
+		if publishErr, ok := progress.Err.(*publisher.PublishError); ok {
+			failure.Phase = publishErr.Phase
+			failure.Resources = publishErr.Resources
+		}
+		stemcell.Failures = append(stemcell.Failures, failure)
+	}
+
+	p.write()
+}
+
+// transition records an endpoint as degraded or recovered
+func (p *partialReport) transition(transition breaker.Transition) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	degraded := []string{}
+	for _, endpoint := range p.degraded {
+		if endpoint != transition.Endpoint {
+			degraded = append(degraded, endpoint)
+		}
+	}
+	if transition.Degraded {
+		degraded = append(degraded, transition.Endpoint)
+	}
+	p.degraded = degraded
+	p.write()
+}
+
+// write must be called with the mutex held. Stemcells which have not begun are left out. Failing to write a partial
+// report is not fatal, since the final report is written once every publish has finished.
+func (p *partialReport) write() {
+	stemcells := []report.Stemcell{}
+	for _, stemcell := range p.stemcells {
+		if stemcell.Name != "" {
+			stemcells = append(stemcells, stemcell)
+		}
+	}
+
+	r := newReport(p.regions, stemcells)
+	r.Status = report.InProgressStatus
+	r.RetryCommand = ""
+	r.DegradedEndpoints = p.degraded
+
+	err := writeReport(r, p.storage, p.key, p.signingKey)
+	if err != nil {
+		p.logger.Printf("writing in progress report: %s", err)
+	}
+}
+
+// reportDestination resolves where a --report location is written, returning a nil Storage when no report was requested
+func reportDestination(location string, amiRegions []config.AmiRegion) (storage.Storage, string, error) {
+	if location == "" {
+		return nil, "", nil
+	}
+
+	s, key, err := storage.ForLocation(location, amiRegions)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error resolving report location: %s", err)
+	}
+	return s, key, nil
+}
+
+// writeReport stores r at key, followed by its signature when signingKey is set
+func writeReport(r *report.Report, s storage.Storage, key string, signingKey ed25519.PrivateKey) error {
+	content := &bytes.Buffer{}
+	err := r.Write(content)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(key, content.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if signingKey == nil {
+		return nil
+	}
+	return s.Put(key+report.SignatureSuffix, report.Sign(content.Bytes(), signingKey))
+}
+
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading report signing key: %s", err)
+	}
+
+	key, err := report.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("loading report signing key %s: %s", path, err)
+	}
+	return key, nil
+}
+
+func writeManifest(m *manifest.Manifest, amis *collection.Ami, writer io.Writer) error {
+	m.PublishedAmis = amis.GetAll()
+
+	m.Sha1 = shasum([]byte{})
+
+	return m.Write(writer)
+}
+
+func writeManifestFile(m *manifest.Manifest, amis *collection.Ami, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeManifest(m, amis, f)
+}
+
+// uploadLightStemcell uploads the light stemcell tarball of m, whose AMIs must already have been written, to the
+// repository along with its .sha1 and .sha256 checksums
+func uploadLightStemcell(repository storage.Storage, m *manifest.Manifest) error {
+	tarball := &bytes.Buffer{}
+	err := m.WriteTarball(tarball)
+	if err != nil {
+		return err
+	}
+
+	name := m.TarballName()
+	artifacts := []struct {
+		key     string
+		content []byte
+	}{
+		{name, tarball.Bytes()},
+		{name + ".sha1", []byte(shasum(tarball.Bytes()))},
+		{name + ".sha256", []byte(fmt.Sprintf("%x", sha256.Sum256(tarball.Bytes())))},
+	}
+	for _, artifact := range artifacts {
+		err = repository.Put(artifact.key, artifact.content)
+		if err != nil {
+			return err
+		}
 	}
-	logger.Println("Publishing finished successfully")
+	return nil
 }
 
 func shasum(content []byte) string {
@@ -168,7 +2031,7 @@ func shasum(content []byte) string {
 }
 
 type logWriter struct {
-	sync.Mutex
+	*sync.Mutex
 	writer io.Writer
 }
 
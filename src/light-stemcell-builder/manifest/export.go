@@ -0,0 +1,142 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExportCloudProperties returns the cloud_properties of a published manifest in the format bosh-aws-cpi consumes
+func (m *Manifest) ExportCloudProperties() ([]byte, error) {
+	if len(m.CloudProperties.Amis) == 0 {
+		return nil, errors.New("the manifest does not list any AMIs")
+	}
+
+	output, err := yaml.Marshal(struct {
+		CloudProperties CloudProperties `yaml:"cloud_properties"`
+	}{m.CloudProperties})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling cloud properties to YAML: %s", err)
+	}
+	return output, nil
+}
+
+// PatchOpsFile sets the AMIs as the value of the replace operation at path in the BOSH ops file,
+// appending such an operation when the ops file has none. Other operations are kept as they are.
+func PatchOpsFile(opsFile []byte, path string, amis RegionToAmiMapping) ([]byte, error) {
+	ops := []yaml.MapSlice{}
+	err := yaml.Unmarshal(opsFile, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling ops file: %s", err)
+	}
+
+	found := false
+	for _, op := range ops {
+		if lookup(op, "path") != path {
+			continue
+		}
+
+		found = true
+		for i := range op {
+			if op[i].Key == "value" {
+				op[i].Value = amis
+			}
+		}
+		if lookup(op, "value") == nil {
+			return nil, fmt.Errorf("the operation at %s has no value", path)
+		}
+	}
+
+	if !found {
+		ops = append(ops, yaml.MapSlice{
+			{Key: "type", Value: "replace"},
+			{Key: "path", Value: path},
+			{Key: "value", Value: amis},
+		})
+	}
+
+	output, err := yaml.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling ops file: %s", err)
+	}
+	return output, nil
+}
+
+// PatchDocument sets the AMIs at path in a YAML document such as a cloud-config. Paths use the syntax of BOSH ops
+// files, e.g. /vm_extensions/name=light-stemcell/cloud_properties/ami, and missing map keys are created.
+func PatchDocument(document []byte, path string, amis RegionToAmiMapping) ([]byte, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("path %s must start with / and name at least one key", path)
+	}
+
+	root := yaml.MapSlice{}
+	err := yaml.Unmarshal(document, &root)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling document: %s", err)
+	}
+
+	patched, err := patch(root, strings.Split(path[1:], "/"), amis)
+	if err != nil {
+		return nil, fmt.Errorf("patching %s: %s", path, err)
+	}
+
+	output, err := yaml.Marshal(patched)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling document: %s", err)
+	}
+	return output, nil
+}
+
+func patch(node interface{}, segments []string, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	segment, rest := segments[0], segments[1:]
+
+	if selector := strings.SplitN(segment, "=", 2); len(selector) == 2 {
+		list, ok := node.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s selects from something which is not a list", segment)
+		}
+
+		for i, item := range list {
+			element, ok := item.(yaml.MapSlice)
+			if ok && fmt.Sprint(lookup(element, selector[0])) == selector[1] {
+				patched, err := patch(element, rest, value)
+				list[i] = patched
+				return list, err
+			}
+		}
+		return nil, fmt.Errorf("no list element matches %s", segment)
+	}
+
+	if node == nil {
+		node = yaml.MapSlice{}
+	}
+	m, ok := node.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("%s is a key of something which is not a map", segment)
+	}
+
+	for i := range m {
+		if m[i].Key == segment {
+			patched, err := patch(m[i].Value, rest, value)
+			m[i].Value = patched
+			return m, err
+		}
+	}
+
+	child, err := patch(nil, rest, value)
+	return append(m, yaml.MapItem{Key: segment, Value: child}), err
+}
+
+func lookup(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package manifest_test
+
+import (
+	"light-stemcell-builder/manifest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Export", func() {
+	amis := manifest.RegionToAmiMapping{"us-west-1": "ami-west", "us-east-1": "ami-east"}
+
+	It("exports the cloud properties in the format of the CPI", func() {
+		m := &manifest.Manifest{CloudProperties: manifest.CloudProperties{Amis: amis, Architecture: "x86_64"}}
+
+		output, err := m.ExportCloudProperties()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(output)).To(Equal(
+			"cloud_properties:\n" +
+				"  ami:\n" +
+				"    us-east-1: ami-east\n" +
+				"    us-west-1: ami-west\n" +
+				"  architecture: x86_64\n",
+		))
+	})
+
+	It("returns an error when the manifest has no AMIs", func() {
+		_, err := (&manifest.Manifest{}).ExportCloudProperties()
+		Expect(err).To(MatchError("the manifest does not list any AMIs"))
+	})
+
+	Describe("PatchOpsFile", func() {
+		It("replaces the value of the operation at the path, keeping the others", func() {
+			opsFile := []byte(`
+- type: remove
+  path: /instance_groups/name=web
+- type: replace
+  path: /amis
+  value: {us-east-1: ami-old}
+`)
+
+			output, err := manifest.PatchOpsFile(opsFile, "/amis", amis)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(output)).To(Equal(
+				"- type: remove\n" +
+					"  path: /instance_groups/name=web\n" +
+					"- type: replace\n" +
+					"  path: /amis\n" +
+					"  value:\n" +
+					"    us-east-1: ami-east\n" +
+					"    us-west-1: ami-west\n",
+			))
+		})
+
+		It("appends an operation when there is none for the path", func() {
+			output, err := manifest.PatchOpsFile([]byte{}, "/amis", manifest.RegionToAmiMapping{"us-east-1": "ami-east"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(output)).To(Equal("- type: replace\n  path: /amis\n  value:\n    us-east-1: ami-east\n"))
+		})
+	})
+
+	Describe("PatchDocument", func() {
+		cloudConfig := []byte(`
+vm_extensions:
+- name: other
+- name: light-stemcell
+  cloud_properties:
+    ami: {us-east-1: ami-old}
+    instance_type: m4.large
+`)
+
+		It("sets the AMIs at a path selecting list elements by name", func() {
+			output, err := manifest.PatchDocument(cloudConfig, "/vm_extensions/name=light-stemcell/cloud_properties/ami", amis)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(output)).To(Equal(
+				"vm_extensions:\n" +
+					"- name: other\n" +
+					"- name: light-stemcell\n" +
+					"  cloud_properties:\n" +
+					"    ami:\n" +
+					"      us-east-1: ami-east\n" +
+					"      us-west-1: ami-west\n" +
+					"    instance_type: m4.large\n",
+			))
+		})
+
+		It("creates missing map keys", func() {
+			output, err := manifest.PatchDocument([]byte("name: some-config\n"), "/stemcell/cloud_properties/ami", manifest.RegionToAmiMapping{"us-east-1": "ami-east"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(output)).To(Equal("name: some-config\nstemcell:\n  cloud_properties:\n    ami:\n      us-east-1: ami-east\n"))
+		})
+
+		It("returns an error when no list element matches", func() {
+			_, err := manifest.PatchDocument(cloudConfig, "/vm_extensions/name=missing/cloud_properties/ami", amis)
+			Expect(err).To(MatchError("patching /vm_extensions/name=missing/cloud_properties/ami: no list element matches name=missing"))
+		})
+	})
+})
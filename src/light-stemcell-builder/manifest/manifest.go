@@ -11,6 +11,12 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// ApiVersion3 is the stemcell API version whose manifests list the stemcell formats they provide
+const ApiVersion3 = 3
+
+// LightStemcellFormat identifies a light stemcell referencing published AMIs
+const LightStemcellFormat = "aws-light"
+
 // Manifest represents the stemcell manifest. We don't care about anything
 // other than cloud_properties and the name
 type Manifest struct {
@@ -19,6 +25,8 @@ type Manifest struct {
 	BoshProtocol    string          `yaml:"bosh_protocol"`
 	Sha1            string          `yaml:"sha1"`
 	OperatingSystem string          `yaml:"operating_system"`
+	ApiVersion      int             `yaml:"api_version,omitempty"`
+	StemcellFormats []string        `yaml:"stemcell_formats,omitempty"`
 	CloudProperties CloudProperties `yaml:"cloud_properties"`
 	PublishedAmis   []resources.Ami `yaml:"-"`
 }
@@ -26,9 +34,12 @@ type Manifest struct {
 // RegionToAmiMapping is a simple map of AWS region to AMI ID in that region
 type RegionToAmiMapping map[string]string
 
-// CloudProperties contains only our region to AMI ID mapping
+// CloudProperties contains our region to AMI ID mapping and the properties of the published AMIs
 type CloudProperties struct {
-	Amis RegionToAmiMapping `yaml:"ami"`
+	Amis         RegionToAmiMapping `yaml:"ami"`
+	Architecture string             `yaml:"architecture,omitempty"`
+	Encrypted    bool               `yaml:"encrypted,omitempty"`
+	KmsKeyId     string             `yaml:"kms_key_id,omitempty"`
 }
 
 // NewFromReader creates a new manifest from the YAML stored in the reader
@@ -47,6 +58,12 @@ func NewFromReader(reader io.Reader) (*Manifest, error) {
 	return m, nil
 }
 
+// UseApiVersion3 marks the manifest as an api_version 3 light stemcell
+func (m *Manifest) UseApiVersion3() {
+	m.ApiVersion = ApiVersion3
+	m.StemcellFormats = []string{LightStemcellFormat}
+}
+
 // Write writes the YAML representation of this manifest to the io.Writer
 func (m *Manifest) Write(writer io.Writer) error {
 	if len(m.PublishedAmis) == 0 {
@@ -60,6 +77,9 @@ func (m *Manifest) Write(writer io.Writer) error {
 		m.CloudProperties.Amis[ami.Region] = ami.ID
 	}
 
+	// every AMI is registered with the same architecture, regardless of the input stemcell
+	m.CloudProperties.Architecture = resources.AmiArchitecture
+
 	virtualizationType := m.PublishedAmis[0].VirtualizationType
 	if virtualizationType == resources.HvmAmiVirtualization && !strings.Contains(m.Name, "-hvm") {
 		m.Name = strings.Replace(m.Name, "xen", "xen-hvm", 1)
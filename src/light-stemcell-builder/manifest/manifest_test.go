@@ -63,6 +63,65 @@ cloud_properties:
 			Expect(resultManifest.OperatingSystem).To(Equal("ubuntu-trusty"))
 			Expect(resultManifest.CloudProperties.Amis).To(HaveLen(1))
 			Expect(resultManifest.CloudProperties.Amis["fake-region"]).To(Equal("fake-ami-id"))
+			Expect(resultManifest.CloudProperties.Architecture).To(Equal(resources.AmiArchitecture))
+			Expect(resultManifest.CloudProperties.Encrypted).To(BeFalse())
+			Expect(writer.String()).ToNot(ContainSubstring("api_version"))
+			Expect(writer.String()).ToNot(ContainSubstring("kms_key_id"))
+		})
+
+		It("includes the api_version of the input manifest and the encryption of the AMIs", func() {
+			manifestReader := bytes.NewReader(append(manifestBytes, []byte("\napi_version: 2")...))
+			m, err := manifest.NewFromReader(manifestReader)
+			Expect(err).ToNot(HaveOccurred())
+
+			m.CloudProperties.Encrypted = true
+			m.CloudProperties.KmsKeyId = "alias/some-key"
+			m.PublishedAmis = []resources.Ami{
+				resources.Ami{
+					Region:             "fake-region",
+					ID:                 "fake-ami-id",
+					VirtualizationType: resources.HvmAmiVirtualization,
+				},
+			}
+
+			writer := &bytes.Buffer{}
+			err = m.Write(writer)
+			Expect(err).ToNot(HaveOccurred())
+
+			resultManifest := &manifest.Manifest{}
+			err = yaml.Unmarshal(writer.Bytes(), resultManifest)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resultManifest.ApiVersion).To(Equal(2))
+			Expect(resultManifest.CloudProperties.Encrypted).To(BeTrue())
+			Expect(resultManifest.CloudProperties.KmsKeyId).To(Equal("alias/some-key"))
+		})
+
+		It("writes api_version 3 manifests with the light stemcell format", func() {
+			manifestReader := bytes.NewReader(manifestBytes)
+			m, err := manifest.NewFromReader(manifestReader)
+			Expect(err).ToNot(HaveOccurred())
+
+			m.UseApiVersion3()
+			m.PublishedAmis = []resources.Ami{
+				resources.Ami{
+					Region:             "fake-region",
+					ID:                 "fake-ami-id",
+					VirtualizationType: resources.HvmAmiVirtualization,
+				},
+			}
+
+			writer := &bytes.Buffer{}
+			err = m.Write(writer)
+			Expect(err).ToNot(HaveOccurred())
+
+			resultManifest := &manifest.Manifest{}
+			err = yaml.Unmarshal(writer.Bytes(), resultManifest)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resultManifest.ApiVersion).To(Equal(3))
+			Expect(resultManifest.StemcellFormats).To(Equal([]string{"aws-light"}))
+			Expect(resultManifest.CloudProperties.Amis).To(Equal(manifest.RegionToAmiMapping{"fake-region": "fake-ami-id"}))
 		})
 
 		Context("When it's a stemcell with the HVM virtualization type", func() {
@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TarballName is the file name light stemcells are released under, e.g.
+// light-bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.tgz
+func (m *Manifest) TarballName() string {
+	return fmt.Sprintf("light-bosh-stemcell-%s-%s.tgz", m.Version, strings.TrimPrefix(m.Name, "bosh-"))
+}
+
+// WriteTarball writes the light stemcell tarball of the manifest, holding its stemcell.MF and an empty image
+func (m *Manifest) WriteTarball(writer io.Writer) error {
+	manifestBytes := &bytes.Buffer{}
+	err := m.Write(manifestBytes)
+	if err != nil {
+		return err
+	}
+
+	compressed := gzip.NewWriter(writer)
+	tarball := tar.NewWriter(compressed)
+	for _, entry := range []struct {
+		name    string
+		content []byte
+	}{
+		{"stemcell.MF", manifestBytes.Bytes()},
+		{"image", []byte{}},
+	} {
+		err = tarball.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content))})
+		if err != nil {
+			return fmt.Errorf("writing %s to tarball: %s", entry.name, err)
+		}
+		_, err = tarball.Write(entry.content)
+		if err != nil {
+			return fmt.Errorf("writing %s to tarball: %s", entry.name, err)
+		}
+	}
+
+	err = tarball.Close()
+	if err != nil {
+		return fmt.Errorf("closing tarball: %s", err)
+	}
+	return compressed.Close()
+}
@@ -0,0 +1,64 @@
+package manifest_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"light-stemcell-builder/manifest"
+	"light-stemcell-builder/resources"
+
+	"gopkg.in/yaml.v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tarball", func() {
+	var m *manifest.Manifest
+
+	BeforeEach(func() {
+		m = &manifest.Manifest{
+			Name:            "bosh-aws-xen-ubuntu-xenial-go_agent",
+			Version:         "621.74",
+			OperatingSystem: "ubuntu-xenial",
+			PublishedAmis: []resources.Ami{
+				{Region: "us-east-1", ID: "ami-1234", VirtualizationType: resources.HvmAmiVirtualization},
+			},
+		}
+	})
+
+	It("writes a tarball of the stemcell.MF and an empty image, named after the written manifest", func() {
+		tarball := &bytes.Buffer{}
+		Expect(m.WriteTarball(tarball)).To(Succeed())
+		Expect(m.TarballName()).To(Equal("light-bosh-stemcell-621.74-aws-xen-hvm-ubuntu-xenial-go_agent.tgz"))
+
+		decompressed, err := gzip.NewReader(tarball)
+		Expect(err).ToNot(HaveOccurred())
+		entries := tar.NewReader(decompressed)
+
+		contents := map[string][]byte{}
+		for {
+			header, err := entries.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			contents[header.Name], err = ioutil.ReadAll(entries)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(contents).To(HaveKey("image"))
+		Expect(contents["image"]).To(BeEmpty())
+
+		written := &manifest.Manifest{}
+		Expect(yaml.Unmarshal(contents["stemcell.MF"], written)).To(Succeed())
+		Expect(written.CloudProperties.Amis).To(Equal(manifest.RegionToAmiMapping{"us-east-1": "ami-1234"}))
+	})
+
+	It("returns an error when no AMIs have been added to the manifest", func() {
+		m.PublishedAmis = nil
+		Expect(m.WriteTarball(&bytes.Buffer{})).To(MatchError("no Amis have been added to the manifest"))
+	})
+})
@@ -0,0 +1,162 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// TagKey is the tag recording the plan digest on every AMI published for a stemcell
+const TagKey = "light-stemcell-builder-plan"
+
+// inputs is everything which determines the AMIs a publish produces. Credentials are left out so the digest
+// does not change when keys are rotated, as is the AMI name, which is generated when it is not configured.
+type inputs struct {
+	Description        string   `json:"description"`
+	VirtualizationType string   `json:"virtualization_type"`
+	Encrypted          bool     `json:"encrypted"`
+	KmsKeyId           string   `json:"kms_key_id"`
+	Visibility         string   `json:"visibility"`
+	Regions            []region `json:"regions"`
+	ManifestApiVersion int      `json:"manifest_api_version"`
+	Namespace          string   `json:"namespace"`
+	ImageDigest        string   `json:"image_digest"`
+	ImageFormat        string   `json:"image_format"`
+	VolumeSizeGB       int64    `json:"volume_size"`
+	ManifestDigest     string   `json:"manifest_digest"`
+	// the block device mappings are left out of the digest when they are not configured, so the digests of
+	// existing plans are kept
+	RootDeviceName   string                   `json:"root_device_name,omitempty"`
+	EphemeralDevices []config.EphemeralDevice `json:"ephemeral_devices,omitempty"`
+	DataVolumes      []config.DataVolume      `json:"data_volumes,omitempty"`
+}
+
+type region struct {
+	Name                  string   `json:"name"`
+	BucketName            string   `json:"bucket_name"`
+	ServerSideEncryption  string   `json:"server_side_encryption"`
+	Destinations          []string `json:"destinations"`
+	CopyHubs              []string `json:"copy_hubs"`
+	ArchiveSnapshotCopies int      `json:"archive_snapshot_copies"`
+}
+
+// Digest returns a hex SHA256 of the resolved plan for publishing stemcell with c. imageDigest and manifestDigest
+// are the hex SHA256 of the machine image and the stemcell.MF, see FileDigest.
+func Digest(c config.Config, stemcell config.Stemcell, imageDigest string, manifestDigest string) string {
+	p := inputs{
+		Description:        c.AmiConfiguration.Description,
+		VirtualizationType: c.AmiConfiguration.VirtualizationType,
+		Encrypted:          c.AmiConfiguration.Encrypted,
+		KmsKeyId:           c.AmiConfiguration.KmsKeyId,
+		Visibility:         c.AmiConfiguration.Visibility,
+		Regions:            []region{},
+		ManifestApiVersion: c.ManifestApiVersion,
+		Namespace:          c.Namespace,
+		ImageDigest:        imageDigest,
+		ImageFormat:        stemcell.ImageFormat,
+		VolumeSizeGB:       stemcell.VolumeSizeGB,
+		ManifestDigest:     manifestDigest,
+		RootDeviceName:     c.AmiConfiguration.RootDeviceNames[c.AmiConfiguration.VirtualizationType],
+		EphemeralDevices:   c.AmiConfiguration.EphemeralDevices,
+		DataVolumes:        c.AmiConfiguration.DataVolumes,
+	}
+
+	for _, r := range c.AmiRegions {
+		p.Regions = append(p.Regions, region{
+			Name:                  r.PublishRegion(),
+			BucketName:            r.BucketName,
+			ServerSideEncryption:  r.ServerSideEncryption,
+			Destinations:          sorted(r.Destinations),
+			CopyHubs:              sorted(r.CopyHubs),
+			ArchiveSnapshotCopies: r.ArchiveSnapshotCopies,
+		})
+	}
+	sort.Slice(p.Regions, func(i, j int) bool { return p.Regions[i].Name < p.Regions[j].Name })
+
+	// marshaling a struct of strings, numbers and slices cannot fail
+	b, _ := json.Marshal(p)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileDigest returns the hex SHA256 of the file at path
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %s", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %s", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewEC2Client creates an EC2 client for the region and credentials in creds
+func NewEC2Client(creds config.Credentials) ec2iface.EC2API {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region)
+
+	return ec2.New(session.New(), awsConfig, dualstack.Config("ec2", creds))
+}
+
+// FindPublished looks for an available AMI of the caller tagged with digest in each region of clients,
+// returning the AMI ID by region for the regions where one was found
+func FindPublished(clients map[string]ec2iface.EC2API, digest string) (map[string]string, error) {
+	amis := map[string]string{}
+	for region, ec2Client := range clients {
+		output, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{
+			Owners: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:" + TagKey), Values: []*string{aws.String(digest)}},
+				{Name: aws.String("state"), Values: []*string{aws.String(ec2.ImageStateAvailable)}},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describing images tagged with plan %s in %s: %s", digest, region, err)
+		}
+
+		if len(output.Images) > 0 {
+			amis[region] = aws.StringValue(output.Images[0].ImageId)
+		}
+	}
+
+	return amis, nil
+}
+
+// Regions returns every region a publish with c produces an AMI in, along with the credentials used there
+func Regions(c config.Config) map[string]config.Credentials {
+	regions := map[string]config.Credentials{}
+	for _, r := range c.AmiRegions {
+		regions[r.PublishRegion()] = r.Credentials
+		for _, destination := range r.Destinations {
+			creds := r.Credentials
+			creds.Region = destination
+			regions[destination] = creds
+		}
+	}
+	return regions
+}
+
+func sorted(values []string) []string {
+	result := append([]string{}, values...)
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,120 @@
+package plan_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/plan"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeEC2 struct {
+	ec2iface.EC2API
+	imageID string
+	err     error
+	input   *ec2.DescribeImagesInput
+}
+
+func (f *fakeEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.input = input
+	output := &ec2.DescribeImagesOutput{}
+	if f.imageID != "" {
+		output.Images = []*ec2.Image{{ImageId: aws.String(f.imageID)}}
+	}
+	return output, f.err
+}
+
+var _ = Describe("Plan", func() {
+	var c config.Config
+	var stemcell config.Stemcell
+
+	BeforeEach(func() {
+		c = config.Config{
+			AmiConfiguration: config.AmiConfiguration{AmiName: "BOSH-1234", Description: "some stemcell"},
+			AmiRegions: []config.AmiRegion{
+				{
+					RegionName:   "us-east-1",
+					BucketName:   "some-bucket",
+					Credentials:  config.Credentials{AccessKey: "some-key", Region: "us-east-1"},
+					Destinations: []string{"us-west-1", "us-west-2"},
+				},
+				{RegionName: "cn-north-1", BucketName: "cn-bucket"},
+			},
+		}
+		stemcell = config.Stemcell{ImageFormat: "RAW"}
+	})
+
+	Describe("Digest", func() {
+		It("is the same for the same plan, ignoring credentials, AMI names and ordering", func() {
+			digest := plan.Digest(c, stemcell, "image-sum", "manifest-sum")
+
+			c.AmiConfiguration.AmiName = "BOSH-5678"
+			c.AmiRegions[0].Credentials.AccessKey = "rotated-key"
+			c.AmiRegions[0].Destinations = []string{"us-west-2", "us-west-1"}
+			c.AmiRegions[0], c.AmiRegions[1] = c.AmiRegions[1], c.AmiRegions[0]
+
+			Expect(plan.Digest(c, stemcell, "image-sum", "manifest-sum")).To(Equal(digest))
+			Expect(digest).To(HaveLen(64))
+		})
+
+		It("changes when the inputs, options or regions change", func() {
+			digest := plan.Digest(c, stemcell, "image-sum", "manifest-sum")
+
+			Expect(plan.Digest(c, stemcell, "other-image-sum", "manifest-sum")).ToNot(Equal(digest))
+			Expect(plan.Digest(c, stemcell, "image-sum", "other-manifest-sum")).ToNot(Equal(digest))
+
+			c.AmiConfiguration.Visibility = config.PrivateVisibility
+			Expect(plan.Digest(c, stemcell, "image-sum", "manifest-sum")).ToNot(Equal(digest))
+
+			c.AmiRegions = c.AmiRegions[:1]
+			Expect(plan.Digest(c, stemcell, "image-sum", "manifest-sum")).ToNot(Equal(digest))
+		})
+	})
+
+	It("computes the SHA256 of a file", func() {
+		f, err := ioutil.TempFile("", "plan")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		f.WriteString("some machine image")
+		f.Close()
+
+		digest, err := plan.FileDigest(f.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest).To(Equal("856703f262c3ac23286d09c93e462c3519aaa6c66669b19ddd5ce81849407b96"))
+	})
+
+	It("lists every region an AMI is published in with the credentials used there", func() {
+		regions := plan.Regions(c)
+
+		Expect(regions).To(HaveLen(4))
+		Expect(regions["us-west-2"].AccessKey).To(Equal("some-key"))
+		Expect(regions["us-west-2"].Region).To(Equal("us-west-2"))
+	})
+
+	Describe("FindPublished", func() {
+		It("returns the AMIs tagged with the plan in the regions where they exist", func() {
+			east := &fakeEC2{imageID: "ami-east"}
+			clients := map[string]ec2iface.EC2API{"us-east-1": east, "us-west-1": &fakeEC2{}}
+
+			amis, err := plan.FindPublished(clients, "some-digest")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(amis).To(Equal(map[string]string{"us-east-1": "ami-east"}))
+			Expect(aws.StringValue(east.input.Filters[0].Name)).To(Equal("tag:light-stemcell-builder-plan"))
+			Expect(aws.StringValue(east.input.Filters[0].Values[0])).To(Equal("some-digest"))
+		})
+
+		It("returns an error when the images cannot be described", func() {
+			clients := map[string]ec2iface.EC2API{"us-east-1": &fakeEC2{err: errors.New("some error")}}
+
+			_, err := plan.FindPublished(clients, "some-digest")
+			Expect(err).To(MatchError("describing images tagged with plan some-digest in us-east-1: some error"))
+		})
+	})
+})
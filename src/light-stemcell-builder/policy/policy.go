@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"sort"
+	"strings"
+)
+
+const policyVersion = "2012-10-17"
+
+// Document is an IAM policy document
+type Document struct {
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement grants the Actions on the Resources of a single feature of the builder
+type Statement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// ForConfig returns the minimal policy required to publish with the features enabled in c.
+// The same credentials may be used for every configured region, so the policy covers all of them.
+func ForConfig(c config.Config) Document {
+	doc := Document{Version: policyVersion}
+
+	buckets := []string{}
+	standardRegions := false
+	isolatedRegions := false
+	copies := false
+	archives := false
+	kmsUploads := false
+	delayedVolumes := false
+	for _, region := range c.AmiRegions {
+		buckets = append(buckets, fmt.Sprintf("arn:%s:s3:::%s/*", Partition(region.PublishRegion()), region.BucketName))
+		if region.FallbackBucketName != "" {
+			buckets = append(buckets, fmt.Sprintf("arn:%s:s3:::%s/*", Partition(region.FallbackRegion), region.FallbackBucketName))
+			copies = true
+		}
+		if region.IsolatedRegion {
+			isolatedRegions = true
+			delayedVolumes = delayedVolumes || region.VolumeDeletion.Timing == config.DelayedVolumeDeletion
+		} else {
+			standardRegions = true
+		}
+		if len(region.Destinations) > 0 {
+			copies = true
+		}
+		if region.ArchiveSnapshotCopies > 0 {
+			archives = true
+		}
+		if region.ServerSideEncryption == "aws:kms" {
+			kmsUploads = true
+		}
+	}
+
+	doc.Statement = append(doc.Statement, Statement{
+		Sid:      "UploadMachineImages",
+		Action:   []string{"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:GetObject", "s3:PutObject"},
+		Resource: unique(buckets),
+	})
+
+	if standardRegions {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "ImportSnapshots",
+			Action:   []string{"ec2:DescribeImportSnapshotTasks", "ec2:ImportSnapshot", "ec2:ModifySnapshotAttribute"},
+			Resource: []string{"*"},
+		})
+	}
+
+	if isolatedRegions {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid: "ImportVolumes",
+			Action: []string{
+				"ec2:CreateSnapshot",
+				"ec2:DeleteVolume",
+				"ec2:DescribeAvailabilityZones",
+				"ec2:DescribeConversionTasks",
+				"ec2:DescribeSnapshots",
+				"ec2:DescribeVolumes",
+				"ec2:ImportVolume",
+				"ec2:ModifySnapshotAttribute",
+			},
+			Resource: []string{"*"},
+		})
+	}
+
+	if c.Namespace != "" || delayedVolumes {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "TagIntermediateResources",
+			Action:   []string{"ec2:CreateTags"},
+			Resource: []string{"*"},
+		})
+	}
+
+	doc.Statement = append(doc.Statement, Statement{
+		Sid:      "RegisterAmis",
+		Action:   []string{"ec2:CreateTags", "ec2:DescribeImages", "ec2:RegisterImage"},
+		Resource: []string{"*"},
+	})
+
+	if c.AmiConfiguration.Visibility == config.PublicVisibility {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "PublishAmis",
+			Action:   []string{"ec2:ModifyImageAttribute"},
+			Resource: []string{"*"},
+		})
+	}
+
+	if copies {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "CopyAmis",
+			Action:   []string{"ec2:CopyImage", "ec2:CreateTags", "ec2:DescribeImages", "ec2:ModifySnapshotAttribute"},
+			Resource: []string{"*"},
+		})
+	}
+
+	// copies are verified by describing the snapshots of both the source and the copy, so the credentials of
+	// the source region and of every destination need the call
+	if copies && c.AmiConfiguration.VerifyCopies {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "VerifyCopies",
+			Action:   []string{"ec2:DescribeImages", "ec2:DescribeSnapshots"},
+			Resource: []string{"*"},
+		})
+	}
+
+	if archives {
+		doc.Statement = append(doc.Statement, Statement{
+			Sid:      "ArchiveSnapshots",
+			Action:   []string{"ec2:CopySnapshot", "ec2:DescribeSnapshots"},
+			Resource: []string{"*"},
+		})
+	}
+
+	if c.AmiConfiguration.Encrypted || kmsUploads {
+		keys := []string{"*"}
+		if strings.HasPrefix(c.AmiConfiguration.KmsKeyId, "arn:") && !kmsUploads {
+			keys = []string{c.AmiConfiguration.KmsKeyId}
+		}
+		doc.Statement = append(doc.Statement, Statement{
+			Sid: "EncryptWithKms",
+			Action: []string{
+				"kms:CreateGrant",
+				"kms:Decrypt",
+				"kms:DescribeKey",
+				"kms:Encrypt",
+				"kms:GenerateDataKey",
+				"kms:GenerateDataKeyWithoutPlaintext",
+				"kms:ReEncryptFrom",
+				"kms:ReEncryptTo",
+			},
+			Resource: keys,
+		})
+	}
+
+	for i := range doc.Statement {
+		doc.Statement[i].Effect = "Allow"
+	}
+
+	return doc
+}
+
+// Write writes the policy document as indented JSON
+func (d Document) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Partition returns the AWS partition which region belongs to
+func Partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+func unique(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
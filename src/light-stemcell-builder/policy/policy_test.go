@@ -0,0 +1,107 @@
+package policy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/policy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func statement(doc policy.Document, sid string) *policy.Statement {
+	for i := range doc.Statement {
+		if doc.Statement[i].Sid == sid {
+			return &doc.Statement[i]
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Policy", func() {
+	var c config.Config
+
+	BeforeEach(func() {
+		c = config.Config{
+			AmiConfiguration: config.AmiConfiguration{Visibility: config.PrivateVisibility},
+			AmiRegions: []config.AmiRegion{
+				{RegionName: "us-east-1", BucketName: "some-bucket"},
+			},
+		}
+	})
+
+	It("only grants the calls made by a private publish to a standard region", func() {
+		doc := policy.ForConfig(c)
+
+		Expect(doc.Version).To(Equal("2012-10-17"))
+		sids := []string{}
+		for _, s := range doc.Statement {
+			Expect(s.Effect).To(Equal("Allow"))
+			sids = append(sids, s.Sid)
+		}
+		Expect(sids).To(Equal([]string{"UploadMachineImages", "ImportSnapshots", "RegisterAmis"}))
+		Expect(statement(doc, "UploadMachineImages").Resource).To(Equal([]string{"arn:aws:s3:::some-bucket/*"}))
+	})
+
+	It("grants volume imports and uses the partition of isolated regions", func() {
+		c.AmiRegions = []config.AmiRegion{{RegionName: "cn-north-1", BucketName: "cn-bucket", IsolatedRegion: true}}
+		doc := policy.ForConfig(c)
+
+		Expect(statement(doc, "ImportSnapshots")).To(BeNil())
+		Expect(statement(doc, "ImportVolumes").Action).To(ContainElement("ec2:ImportVolume"))
+		Expect(statement(doc, "UploadMachineImages").Resource).To(Equal([]string{"arn:aws-cn:s3:::cn-bucket/*"}))
+	})
+
+	It("grants publishing, copies and encryption when they are enabled", func() {
+		c.AmiConfiguration.Visibility = config.PublicVisibility
+		c.AmiConfiguration.Encrypted = true
+		c.AmiConfiguration.KmsKeyId = "arn:aws:kms:us-east-1:123456789012:key/some-key"
+		c.AmiRegions[0].Destinations = []string{"us-west-1"}
+		c.AmiRegions[0].ArchiveSnapshotCopies = 1
+		doc := policy.ForConfig(c)
+
+		Expect(statement(doc, "PublishAmis").Action).To(Equal([]string{"ec2:ModifyImageAttribute"}))
+		Expect(statement(doc, "CopyAmis").Action).To(ContainElement("ec2:CopyImage"))
+		Expect(statement(doc, "ArchiveSnapshots").Action).To(ContainElement("ec2:CopySnapshot"))
+		Expect(statement(doc, "EncryptWithKms").Resource).To(Equal([]string{c.AmiConfiguration.KmsKeyId}))
+	})
+
+	It("grants describing snapshots when copies are verified", func() {
+		c.AmiConfiguration.VerifyCopies = true
+		Expect(statement(policy.ForConfig(c), "VerifyCopies")).To(BeNil(), "there are no copies to verify")
+
+		c.AmiRegions[0].Destinations = []string{"us-west-1"}
+		doc := policy.ForConfig(c)
+
+		Expect(statement(doc, "VerifyCopies").Action).To(Equal([]string{"ec2:DescribeImages", "ec2:DescribeSnapshots"}))
+		Expect(statement(doc, "VerifyCopies").Resource).To(Equal([]string{"*"}))
+	})
+
+	It("grants tagging when a namespace is configured", func() {
+		c.Namespace = "some-pipeline"
+		doc := policy.ForConfig(c)
+
+		Expect(statement(doc, "TagIntermediateResources").Action).To(Equal([]string{"ec2:CreateTags"}))
+	})
+
+	It("grants KMS on any key when uploads use SSE-KMS", func() {
+		c.AmiRegions[0].ServerSideEncryption = "aws:kms"
+		doc := policy.ForConfig(c)
+
+		Expect(statement(doc, "EncryptWithKms").Resource).To(Equal([]string{"*"}))
+	})
+
+	It("writes the policy as JSON", func() {
+		doc := policy.ForConfig(c)
+
+		output := &bytes.Buffer{}
+		err := doc.Write(output)
+		Expect(err).ToNot(HaveOccurred())
+
+		result := policy.Document{}
+		err = json.Unmarshal(output.Bytes(), &result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(doc))
+	})
+})
@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"fmt"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+var assumedRoleArn = regexp.MustCompile(`^arn:([^:]+):sts::(\d+):assumed-role/([^/]+)/.+$`)
+
+// Simulator checks a policy document against the IAM policies of the caller whose credentials it was created with
+type Simulator struct {
+	stsClient stsiface.STSAPI
+	iamClient iamiface.IAMAPI
+}
+
+// NewSimulator creates a Simulator for the principal identified by creds
+func NewSimulator(creds config.Credentials) *Simulator {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region)
+
+	sess := session.New(awsConfig)
+	// IAM has no dual-stack endpoint
+	return NewSimulatorWithClients(sts.New(sess, dualstack.Config("sts", creds)), iam.New(sess))
+}
+
+// NewSimulatorWithClients creates a Simulator which uses the provided API clients
+func NewSimulatorWithClients(stsClient stsiface.STSAPI, iamClient iamiface.IAMAPI) *Simulator {
+	return &Simulator{stsClient: stsClient, iamClient: iamClient}
+}
+
+// MissingActions runs iam:SimulatePrincipalPolicy for every statement in doc, returning the actions which the caller would be denied
+func (s *Simulator) MissingActions(doc Document) ([]string, error) {
+	identity, err := s.stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("getting caller identity: %s", err)
+	}
+	principalArn := PrincipalArn(aws.StringValue(identity.Arn))
+
+	missing := []string{}
+	for _, statement := range doc.Statement {
+		input := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(principalArn),
+			ActionNames:     aws.StringSlice(statement.Action),
+		}
+		if !(len(statement.Resource) == 1 && statement.Resource[0] == "*") {
+			input.ResourceArns = aws.StringSlice(statement.Resource)
+		}
+
+		for {
+			output, err := s.iamClient.SimulatePrincipalPolicy(input)
+			if err != nil {
+				return nil, fmt.Errorf("simulating policy for %s: %s", principalArn, err)
+			}
+
+			for _, result := range output.EvaluationResults {
+				if aws.StringValue(result.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+					missing = append(missing, aws.StringValue(result.EvalActionName))
+				}
+			}
+
+			if !aws.BoolValue(output.IsTruncated) {
+				break
+			}
+			input.Marker = output.Marker
+		}
+	}
+
+	return unique(missing), nil
+}
+
+// PrincipalArn returns the IAM ARN whose policies apply to callerArn. Callers using assumed
+// role credentials are identified by their STS session, which IAM cannot simulate directly.
+func PrincipalArn(callerArn string) string {
+	matches := assumedRoleArn.FindStringSubmatch(callerArn)
+	if matches == nil {
+		return callerArn
+	}
+
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", matches[1], matches[2], matches[3])
+}
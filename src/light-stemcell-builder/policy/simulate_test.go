@@ -0,0 +1,88 @@
+package policy_test
+
+import (
+	"light-stemcell-builder/policy"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSTS struct {
+	stsiface.STSAPI
+	arn string
+}
+
+func (f *fakeSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(f.arn)}, nil
+}
+
+type fakeIAM struct {
+	iamiface.IAMAPI
+	denied []string
+	inputs []*iam.SimulatePrincipalPolicyInput
+}
+
+func (f *fakeIAM) SimulatePrincipalPolicy(input *iam.SimulatePrincipalPolicyInput) (*iam.SimulatePolicyResponse, error) {
+	f.inputs = append(f.inputs, input)
+
+	output := &iam.SimulatePolicyResponse{}
+	for _, action := range aws.StringValueSlice(input.ActionNames) {
+		decision := iam.PolicyEvaluationDecisionTypeAllowed
+		for _, denied := range f.denied {
+			if action == denied {
+				decision = iam.PolicyEvaluationDecisionTypeImplicitDeny
+			}
+		}
+		output.EvaluationResults = append(output.EvaluationResults, &iam.EvaluationResult{
+			EvalActionName: aws.String(action),
+			EvalDecision:   aws.String(decision),
+		})
+	}
+	return output, nil
+}
+
+var _ = Describe("Simulator", func() {
+	var (
+		iamClient *fakeIAM
+		doc       policy.Document
+	)
+
+	BeforeEach(func() {
+		iamClient = &fakeIAM{}
+		doc = policy.Document{
+			Statement: []policy.Statement{
+				{Sid: "UploadMachineImages", Action: []string{"s3:GetObject", "s3:PutObject"}, Resource: []string{"arn:aws:s3:::some-bucket/*"}},
+				{Sid: "RegisterAmis", Action: []string{"ec2:DescribeImages", "ec2:RegisterImage"}, Resource: []string{"*"}},
+			},
+		}
+	})
+
+	It("returns the actions the caller would be denied", func() {
+		iamClient.denied = []string{"ec2:RegisterImage", "s3:PutObject"}
+		simulator := policy.NewSimulatorWithClients(&fakeSTS{arn: "arn:aws:iam::123456789012:user/builder"}, iamClient)
+
+		missing, err := simulator.MissingActions(doc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(Equal([]string{"ec2:RegisterImage", "s3:PutObject"}))
+
+		Expect(iamClient.inputs).To(HaveLen(2))
+		Expect(aws.StringValue(iamClient.inputs[0].PolicySourceArn)).To(Equal("arn:aws:iam::123456789012:user/builder"))
+		Expect(aws.StringValueSlice(iamClient.inputs[0].ResourceArns)).To(Equal([]string{"arn:aws:s3:::some-bucket/*"}))
+		Expect(iamClient.inputs[1].ResourceArns).To(BeNil())
+	})
+
+	It("simulates the role of callers using assumed role credentials", func() {
+		simulator := policy.NewSimulatorWithClients(&fakeSTS{arn: "arn:aws:sts::123456789012:assumed-role/builder/session"}, iamClient)
+
+		missing, err := simulator.MissingActions(doc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(BeEmpty())
+		Expect(aws.StringValue(iamClient.inputs[0].PolicySourceArn)).To(Equal("arn:aws:iam::123456789012:role/builder"))
+	})
+})
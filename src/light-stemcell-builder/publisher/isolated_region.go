@@ -1,10 +1,14 @@
 package publisher
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"light-stemcell-builder/collection"
+	"light-stemcell-builder/config"
 	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/heartbeat"
+	"light-stemcell-builder/report"
 	"light-stemcell-builder/resources"
 	"log"
 	"time"
@@ -15,12 +19,15 @@ type IsolatedRegionPublisher struct {
 	BucketName           string
 	ServerSideEncryption string
 	AmiProperties        resources.AmiProperties
+	ArchiveCopies        int
+	Namespace            string
+	VolumeDeletion       config.VolumeDeletion
 	logger               *log.Logger
 }
 
 func NewIsolatedRegionPublisher(logDest io.Writer, c Config) *IsolatedRegionPublisher {
 	return &IsolatedRegionPublisher{
-		Region:               c.RegionName,
+		Region:               c.PublishRegion(),
 		BucketName:           c.BucketName,
 		ServerSideEncryption: c.ServerSideEncryption,
 		AmiProperties: resources.AmiProperties{
@@ -28,16 +35,28 @@ func NewIsolatedRegionPublisher(logDest io.Writer, c Config) *IsolatedRegionPubl
 			Description:        c.Description,
 			Accessibility:      c.Visibility,
 			VirtualizationType: c.VirtualizationType,
+			Tags:               c.Tags,
+			VerifyCopies:       c.VerifyCopies,
+			RootDeviceName:     c.RootDeviceNames[c.VirtualizationType],
+			EphemeralDevices:   ephemeralDevices(c.EphemeralDevices),
+			DataVolumes:        dataVolumes(c.DataVolumes),
+			SnapshotTags:       c.SnapshotTags,
+			PrivateSnapshots:   c.Promotion != "",
 		},
-		logger: log.New(logDest, "IsolatedRegionPublisher ", log.LstdFlags),
+		ArchiveCopies:  c.ArchiveSnapshotCopies,
+		Namespace:      c.Namespace,
+		VolumeDeletion: c.VolumeDeletion,
+		logger:         log.New(logDest, "IsolatedRegionPublisher ", log.LstdFlags),
 	}
 }
 
-func (p *IsolatedRegionPublisher) Publish(ds driverset.IsolatedRegionDriverSet, machineImageConfig MachineImageConfig) (*collection.Ami, error) {
+// Publish publishes the machine image to the region, stopping before the next phase once ctx is done
+func (p *IsolatedRegionPublisher) Publish(ctx context.Context, ds driverset.IsolatedRegionDriverSet, machineImageConfig MachineImageConfig) (*collection.Ami, error) {
 	createStartTime := time.Now()
 	defer func(startTime time.Time) {
 		p.logger.Printf("completed Publish() in %f minutes\n", time.Since(startTime).Minutes())
 	}(createStartTime)
+	defer heartbeat.Done(p.Region)
 
 	machineImageDriverConfig := resources.MachineImageDriverConfig{
 		MachineImagePath:     machineImageConfig.LocalPath,
@@ -45,12 +64,17 @@ func (p *IsolatedRegionPublisher) Publish(ds driverset.IsolatedRegionDriverSet,
 		ServerSideEncryption: p.ServerSideEncryption,
 		FileFormat:           machineImageConfig.FileFormat,
 		VolumeSizeGB:         machineImageConfig.VolumeSizeGB,
+		MaxUploadMemoryMB:    machineImageConfig.MaxUploadMemoryMB,
+		MaxUploadRate:        machineImageConfig.MaxUploadRate,
+		Namespace:            p.Namespace,
 	}
 
+	p.logger.Printf("%s: uploading machine image to bucket %s\n", p.Region, p.BucketName)
+	heartbeat.Phase(p.Region, MachineImagePhase)
 	machineImageDriver := ds.MachineImageDriver()
 	machineImage, err := machineImageDriver.Create(machineImageDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating machine image: %s", err)
+		return nil, &PublishError{Phase: MachineImagePhase, Err: fmt.Errorf("creating machine image: %s", err)}
 	}
 
 	defer func() {
@@ -60,33 +84,78 @@ func (p *IsolatedRegionPublisher) Publish(ds driverset.IsolatedRegionDriverSet,
 		}
 	}()
 
+	err = verifyImageDigest(machineImageConfig, machineImage)
+	if err != nil {
+		return nil, &PublishError{Phase: MachineImagePhase, Err: err}
+	}
+
 	volumeDriverConfig := resources.VolumeDriverConfig{
 		MachineImageManifestURL: machineImage.GetURL,
+		Namespace:               p.Namespace,
+	}
+	if p.VolumeDeletion.Timing == config.DelayedVolumeDeletion {
+		volumeDriverConfig.DeleteAfter = time.Now().Add(time.Duration(p.VolumeDeletion.Hours) * time.Hour)
+	}
+
+	if err := stopIfDone(ctx, VolumePhase, nil); err != nil {
+		return nil, err
 	}
 
+	p.logger.Printf("%s: creating volume from machine image\n", p.Region)
+	heartbeat.Phase(p.Region, VolumePhase)
 	volumeDriver := ds.VolumeDriver()
 	volume, err := volumeDriver.Create(volumeDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating volume: %s", err)
+		return nil, &PublishError{Phase: VolumePhase, Err: fmt.Errorf("creating volume: %s", err)}
 	}
 
-	defer func() {
+	// delayed and retained volumes are kept even when the publish fails, since that is when they are most useful
+	deleteVolume := p.VolumeDeletion.Timing == "" || p.VolumeDeletion.Timing == config.ImmediateVolumeDeletion
+	volumeDeleted := false
+	deleteVolumeOnce := func() {
+		if !deleteVolume || volumeDeleted {
+			return
+		}
+		volumeDeleted = true
 		err := volumeDriver.Delete(volume)
 		if err != nil {
 			p.logger.Printf("Failed to delete volume %s: %s", volume.ID, err)
 		}
-	}()
+	}
+	defer deleteVolumeOnce()
+	if !deleteVolume {
+		p.logger.Printf("%s: keeping volume %s, its volume_deletion timing is %s\n", p.Region, volume.ID, p.VolumeDeletion.Timing)
+	}
 
 	snapshotDriverConfig := resources.SnapshotDriverConfig{
-		VolumeID: volume.ID,
+		VolumeID:  volume.ID,
+		Namespace: p.Namespace,
+		Private:   p.AmiProperties.PrivateSnapshots,
 	}
 
+	if err := stopIfDone(ctx, SnapshotPhase, nil); err != nil {
+		return nil, err
+	}
+
+	p.logger.Printf("%s: creating snapshot\n", p.Region)
+	heartbeat.Phase(p.Region, SnapshotPhase)
 	snapshotDriver := ds.CreateSnapshotDriver()
 	snapshot, err := snapshotDriver.Create(snapshotDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating snapshot: %s", err)
+		return nil, &PublishError{Phase: SnapshotPhase, Err: fmt.Errorf("creating snapshot: %s", err)}
+	}
+
+	// the volume is no longer needed once its snapshot has completed
+	deleteVolumeOnce()
+
+	created := []report.Resource{{Type: SnapshotResource, ID: snapshot.ID, Region: p.Region}}
+
+	if err := stopIfDone(ctx, AmiPhase, created); err != nil {
+		return nil, err
 	}
 
+	p.logger.Printf("%s: creating AMI from snapshot %s\n", p.Region, snapshot.ID)
+	heartbeat.Phase(p.Region, AmiPhase)
 	createAmiDriver := ds.CreateAmiDriver()
 	createAmiDriverConfig := resources.AmiDriverConfig{
 		SnapshotID:    snapshot.ID,
@@ -95,14 +164,24 @@ func (p *IsolatedRegionPublisher) Publish(ds driverset.IsolatedRegionDriverSet,
 
 	sourceAmi, err := createAmiDriver.Create(createAmiDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating ami: %s", err)
+		return nil, &PublishError{Phase: AmiPhase, Resources: created, Err: fmt.Errorf("creating ami: %s", err)}
 	}
 
+	p.logger.Printf("%s: created AMI %s\n", p.Region, sourceAmi.ID)
+
 	amis := collection.Ami{
 		VirtualizationType: p.AmiProperties.VirtualizationType,
 	}
 	amis.Add(sourceAmi)
 
+	if p.ArchiveCopies > 0 {
+		archived, err := archiveSnapshot(p.logger, ds.ArchiveSnapshotDriver(), p.Region, snapshot.ID, sourceAmi.ID, p.ArchiveCopies, p.Namespace)
+		if err != nil {
+			created = append(created, report.Resource{Type: AmiResource, ID: sourceAmi.ID, Region: p.Region})
+			return &amis, &PublishError{Phase: ArchivePhase, Resources: append(created, archived...), Err: err}
+		}
+	}
+
 	// TODO: cleanup machine images and volumes
 
 	return &amis, nil
@@ -1,12 +1,15 @@
 package publisher_test
 
 import (
+	"context"
 	"errors"
 	"light-stemcell-builder/config"
 	fakeDriverset "light-stemcell-builder/driverset/fakes"
 	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/report"
 	"light-stemcell-builder/resources"
 	fakeResources "light-stemcell-builder/resources/fakes"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -14,14 +17,15 @@ import (
 
 var _ = Describe("IsolatedRegionPublisher", func() {
 	const (
-		fakeMachineImageURL  = "fake machine image url"
-		fakeVolumeID         = "fake volume id"
-		fakeSnapshotID       = "fake snapshot id"
-		fakeAmiID            = "fake AMI id"
-		fakeBucketName       = "fake bucket name"
-		fakeRegion           = "fake region"
-		fakeMachineImagePath = "fake machine image path"
-		fakeVolumeSizeGB     = 3
+		fakeMachineImageURL   = "fake machine image url"
+		fakeVolumeID          = "fake volume id"
+		fakeSnapshotID        = "fake snapshot id"
+		fakeAmiID             = "fake AMI id"
+		fakeBucketName        = "fake bucket name"
+		fakeRegion            = "fake region"
+		fakeMachineImagePath  = "fake machine image path"
+		fakeVolumeSizeGB      = 3
+		fakeMaxUploadMemoryMB = 64
 	)
 
 	var fakeAmiConfig = config.AmiConfiguration{
@@ -46,9 +50,10 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 			AmiConfiguration: fakeAmiConfig,
 		}
 		machineImageConfig := publisher.MachineImageConfig{
-			LocalPath:    fakeMachineImagePath,
-			FileFormat:   resources.VolumeRawFormat,
-			VolumeSizeGB: fakeVolumeSizeGB,
+			LocalPath:         fakeMachineImagePath,
+			FileFormat:        resources.VolumeRawFormat,
+			VolumeSizeGB:      fakeVolumeSizeGB,
+			MaxUploadMemoryMB: fakeMaxUploadMemoryMB,
 		}
 
 		fakeDs := &fakeDriverset.FakeIsolatedRegionDriverSet{}
@@ -88,16 +93,17 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
 
 		p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisherConfig)
-		amiCollection, err := p.Publish(fakeDs, machineImageConfig)
+		amiCollection, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(fakeDs.MachineImageDriverCallCount()).To(Equal(1), "Expected Driverset.MachineImageDriver to be called once")
 		Expect(fakeMachineImageDriver.CreateCallCount()).To(Equal(1), "Expected MachineImageDriver.Create to be called once")
 		Expect(fakeMachineImageDriver.CreateArgsForCall(0)).To(Equal(resources.MachineImageDriverConfig{
-			MachineImagePath: fakeMachineImagePath,
-			BucketName:       fakeBucketName,
-			FileFormat:       machineImageConfig.FileFormat,
-			VolumeSizeGB:     fakeVolumeSizeGB,
+			MachineImagePath:  fakeMachineImagePath,
+			BucketName:        fakeBucketName,
+			FileFormat:        machineImageConfig.FileFormat,
+			VolumeSizeGB:      fakeVolumeSizeGB,
+			MaxUploadMemoryMB: fakeMaxUploadMemoryMB,
 		}))
 
 		Expect(fakeDs.VolumeDriverCallCount()).To(Equal(1), "Expected Driverset.VolumeDriver to be called once")
@@ -129,6 +135,69 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		Expect(amiCollection.VirtualizationType).To(Equal(fakeAmiConfig.VirtualizationType))
 	})
 
+	Describe("volume_deletion", func() {
+		var (
+			fakeDs           *fakeDriverset.FakeIsolatedRegionDriverSet
+			fakeVolumeDriver *fakeResources.FakeVolumeDriver
+			fakeAmiDriver    *fakeResources.FakeAmiDriver
+		)
+
+		BeforeEach(func() {
+			fakeDs = &fakeDriverset.FakeIsolatedRegionDriverSet{}
+
+			fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+			fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+			fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+			fakeVolumeDriver = &fakeResources.FakeVolumeDriver{}
+			fakeVolumeDriver.CreateReturns(resources.Volume{ID: fakeVolumeID}, nil)
+			fakeDs.VolumeDriverReturns(fakeVolumeDriver)
+
+			fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+			fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+			fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+			fakeAmiDriver = &fakeResources.FakeAmiDriver{}
+			fakeAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+			fakeDs.CreateAmiDriverReturns(fakeAmiDriver)
+		})
+
+		It("deletes the volume as soon as its snapshot has completed by default", func() {
+			fakeAmiDriver.CreateStub = func(resources.AmiDriverConfig) (resources.Ami, error) {
+				Expect(fakeVolumeDriver.DeleteCallCount()).To(Equal(1))
+				return resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil
+			}
+
+			p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisher.Config{})
+			_, err := p.Publish(context.Background(), fakeDs, publisher.MachineImageConfig{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeVolumeDriver.DeleteCallCount()).To(Equal(1))
+			Expect(fakeVolumeDriver.CreateArgsForCall(0).DeleteAfter.IsZero()).To(BeTrue())
+		})
+
+		It("keeps a delayed volume, tagged with when it may be deleted, even when the publish fails", func() {
+			fakeAmiDriver.CreateReturns(resources.Ami{}, errors.New("error in create ami driver"))
+
+			p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisher.Config{
+				AmiRegion: config.AmiRegion{VolumeDeletion: config.VolumeDeletion{Timing: config.DelayedVolumeDeletion, Hours: 6}},
+			})
+			_, err := p.Publish(context.Background(), fakeDs, publisher.MachineImageConfig{})
+			Expect(err).To(HaveOccurred())
+			Expect(fakeVolumeDriver.DeleteCallCount()).To(Equal(0))
+			Expect(fakeVolumeDriver.CreateArgsForCall(0).DeleteAfter).To(BeTemporally("~", time.Now().Add(6*time.Hour), time.Minute))
+		})
+
+		It("keeps a retained volume without a time it may be deleted", func() {
+			p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisher.Config{
+				AmiRegion: config.AmiRegion{VolumeDeletion: config.VolumeDeletion{Timing: config.RetainVolumeDeletion}},
+			})
+			_, err := p.Publish(context.Background(), fakeDs, publisher.MachineImageConfig{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeVolumeDriver.DeleteCallCount()).To(Equal(0))
+			Expect(fakeVolumeDriver.CreateArgsForCall(0).DeleteAfter.IsZero()).To(BeTrue())
+		})
+	})
+
 	It("returns a machine image driver error if one was returned", func() {
 		publisherConfig := publisher.Config{}
 		machineImageConfig := publisher.MachineImageConfig{}
@@ -140,7 +209,7 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
 
 		p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
@@ -161,10 +230,11 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		fakeDs.VolumeDriverReturns(fakeVolumeDriver)
 
 		p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.VolumePhase))
 	})
 
 	It("returns a snapshot driver error if one was returned", func() {
@@ -186,7 +256,7 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
 
 		p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
@@ -215,9 +285,15 @@ var _ = Describe("IsolatedRegionPublisher", func() {
 		fakeDs.CreateAmiDriverReturns(fakeAmiDriver)
 
 		p := publisher.NewIsolatedRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err).To(BeAssignableToTypeOf(&publisher.PublishError{}))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.AmiPhase))
+		Expect(err.(*publisher.PublishError).Resources).To(ConsistOf(report.Resource{
+			Type: publisher.SnapshotResource,
+			ID:   fakeSnapshotID,
+		}))
 	})
 })
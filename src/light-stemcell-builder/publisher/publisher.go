@@ -1,14 +1,153 @@
 package publisher
 
-import "light-stemcell-builder/config"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"light-stemcell-builder/collection"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/heartbeat"
+	"light-stemcell-builder/report"
+	"light-stemcell-builder/resources"
+	"log"
+	"sync"
+)
+
+// Phases of a publish, used to report which one failed
+const (
+	MachineImagePhase = "machine_image"
+	VolumePhase       = "volume"
+	SnapshotPhase     = "snapshot"
+	AmiPhase          = "ami"
+	CopyPhase         = "copy"
+	ArchivePhase      = "archive"
+	PromotePhase      = "promote"
+	// CanaryPhase is reported for publishes which were held back because the canary region failed
+	CanaryPhase = "canary"
+	// NotStartedPhase is reported for publishes which were never started because the build ran out of time
+	NotStartedPhase = "not_started"
+	// SweepPhase is reported for AMIs which the sweep after a publish found missing or could not repair
+	SweepPhase = "sweep"
+)
+
+// Resource types reported as created during a publish
+const (
+	SnapshotResource = "snapshot"
+	AmiResource      = "ami"
+)
 
 type Config struct {
 	config.AmiRegion
 	config.AmiConfiguration
+	Namespace string
+}
+
+func ephemeralDevices(devices []config.EphemeralDevice) []resources.EphemeralDevice {
+	var result []resources.EphemeralDevice
+	for _, device := range devices {
+		result = append(result, resources.EphemeralDevice{VirtualName: device.VirtualName, DeviceName: device.DeviceName})
+	}
+	return result
+}
+
+func dataVolumes(volumes []config.DataVolume) []resources.DataVolume {
+	var result []resources.DataVolume
+	for _, volume := range volumes {
+		result = append(result, resources.DataVolume{
+			DeviceName: volume.DeviceName,
+			SizeGB:     volume.SizeGB,
+			VolumeType: volume.VolumeType,
+			Iops:       volume.Iops,
+			Encrypted:  volume.Encrypted,
+		})
+	}
+	return result
 }
 
 type MachineImageConfig struct {
-	LocalPath    string
-	FileFormat   string
-	VolumeSizeGB int64
+	LocalPath         string
+	FileFormat        string
+	VolumeSizeGB      int64
+	MaxUploadMemoryMB int64
+	// MaxUploadRate is in bytes per second, zero leaves uploads unthrottled
+	MaxUploadRate int64
+	// ImageDigest is the hex SHA256 of the machine image, which lets concurrent builds share an import of it
+	ImageDigest string
+}
+
+// stopIfDone returns a PublishError for phase, carrying the resources created so far, once ctx is done. Publishes
+// check it before each phase, since the imports and copies of a phase cannot be cancelled once they are started.
+func stopIfDone(ctx context.Context, phase string, created []report.Resource) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return &PublishError{Phase: phase, Resources: created, Err: errors.New("not started before the timeout passed")}
+	default:
+		return &PublishError{Phase: phase, Resources: created, Err: errors.New("not started before the publish was cancelled")}
+	}
+}
+
+// verifyImageDigest compares the checksums taken while image was uploaded with the ImageDigest its plan was made
+// with, so an image which was replaced after planning is not published under that plan
+func verifyImageDigest(machineImageConfig MachineImageConfig, image resources.MachineImage) error {
+	if machineImageConfig.ImageDigest == "" || image.Checksums.Sha256 == machineImageConfig.ImageDigest {
+		return nil
+	}
+	return fmt.Errorf("machine image %s changed after it was planned: uploaded with sha256 %s rather than %s",
+		machineImageConfig.LocalPath, image.Checksums.Sha256, machineImageConfig.ImageDigest)
+}
+
+// PublishError is returned when a phase of a publish fails, along with the
+// resources which were created (and not cleaned up) before the failure
+type PublishError struct {
+	Phase     string
+	Resources []report.Resource
+	Err       error
+}
+
+func (e *PublishError) Error() string {
+	return e.Err.Error()
+}
+
+// archiveSnapshot makes the requested number of private copies of the snapshot backing amiID, which are kept
+// for retention independently of the AMI. It returns the copies which were created, even when some failed.
+func archiveSnapshot(logger *log.Logger, snapshotDriver resources.SnapshotDriver, region string, snapshotID string, amiID string, copies int, namespace string) ([]report.Resource, error) {
+	logger.Printf("%s: archiving %d copies of snapshot %s\n", region, copies, snapshotID)
+	heartbeat.Phase(region, ArchivePhase)
+
+	var archivedMutex sync.Mutex
+	archived := []report.Resource{}
+	errCol := collection.Error{}
+
+	procGroup := sync.WaitGroup{}
+	procGroup.Add(copies)
+
+	for i := 1; i <= copies; i++ {
+		go func(copyNumber int) {
+			defer procGroup.Done()
+
+			snapshotDriverConfig := resources.SnapshotDriverConfig{
+				SnapshotID:  snapshotID,
+				Description: fmt.Sprintf("archival copy %d of %s for %s", copyNumber, snapshotID, amiID),
+				Namespace:   namespace,
+			}
+
+			archive, err := snapshotDriver.Create(snapshotDriverConfig)
+			if err != nil {
+				errCol.Add(fmt.Errorf("archiving snapshot: %s: %s", snapshotID, err))
+				return
+			}
+
+			logger.Printf("%s: archived snapshot %s as %s\n", region, snapshotID, archive.ID)
+
+			archivedMutex.Lock()
+			defer archivedMutex.Unlock()
+			archived = append(archived, report.Resource{Type: SnapshotResource, ID: archive.ID, Region: region})
+		}(i)
+	}
+
+	procGroup.Wait()
+
+	return archived, errCol.Error()
 }
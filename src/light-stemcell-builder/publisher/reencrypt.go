@@ -0,0 +1,71 @@
+package publisher
+
+import (
+	"fmt"
+	"io"
+	"light-stemcell-builder/collection"
+	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/resources"
+	"log"
+	"time"
+)
+
+// ReencryptPublisher makes an encrypted copy of a previously published AMI within its own region
+type ReencryptPublisher struct {
+	Region        string
+	AmiProperties resources.AmiProperties
+	logger        *log.Logger
+}
+
+// NewReencryptPublisher creates a ReencryptPublisher which encrypts with the region's reencrypt_kms_key_id,
+// falling back to the ami_configuration kms_key_id and then the default EBS key
+func NewReencryptPublisher(logDest io.Writer, c Config) *ReencryptPublisher {
+	kmsKeyID := c.RegionKmsKeyId
+	if kmsKeyID == "" {
+		kmsKeyID = c.AmiConfiguration.KmsKeyId
+	}
+
+	return &ReencryptPublisher{
+		Region: c.RegionName,
+		AmiProperties: resources.AmiProperties{
+			Name:        c.AmiName,
+			Description: c.Description,
+			// encrypted AMIs cannot be made public
+			Accessibility:      resources.PrivateAmiAccessibility,
+			VirtualizationType: c.VirtualizationType,
+			Encrypted:          true,
+			KmsKeyId:           kmsKeyID,
+			Tags:               c.Tags,
+			VerifyCopies:       c.VerifyCopies,
+		},
+		logger: log.New(logDest, "ReencryptPublisher ", log.LstdFlags),
+	}
+}
+
+func (p *ReencryptPublisher) Publish(ds driverset.StandardRegionDriverSet, sourceAmiID string) (*collection.Ami, error) {
+	createStartTime := time.Now()
+	defer func(startTime time.Time) {
+		p.logger.Printf("completed Publish() in %f minutes\n", time.Since(startTime).Minutes())
+	}(createStartTime)
+
+	p.logger.Printf("%s: copying AMI %s as an encrypted AMI\n", p.Region, sourceAmiID)
+	copyAmiDriverConfig := resources.AmiDriverConfig{
+		ExistingAmiID:     sourceAmiID,
+		DestinationRegion: p.Region,
+		AmiProperties:     p.AmiProperties,
+	}
+
+	encryptedAmi, err := ds.CopyAmiDriver().Create(copyAmiDriverConfig)
+	if err != nil {
+		return nil, &PublishError{Phase: CopyPhase, Err: fmt.Errorf("encrypting ami: %s in region: %s: %s", sourceAmiID, p.Region, err)}
+	}
+
+	p.logger.Printf("%s: encrypted AMI %s as %s\n", p.Region, sourceAmiID, encryptedAmi.ID)
+
+	amis := collection.Ami{
+		VirtualizationType: p.AmiProperties.VirtualizationType,
+	}
+	amis.Add(encryptedAmi)
+
+	return &amis, nil
+}
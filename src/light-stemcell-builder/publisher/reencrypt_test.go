@@ -0,0 +1,98 @@
+package publisher_test
+
+import (
+	"errors"
+	"light-stemcell-builder/config"
+	fakeDriverset "light-stemcell-builder/driverset/fakes"
+	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/resources"
+	fakeResources "light-stemcell-builder/resources/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReencryptPublisher", func() {
+
+	const (
+		fakeRegion       = "fake region"
+		fakeSourceAmiID  = "fake source AMI id"
+		fakeEncryptedID  = "fake encrypted AMI id"
+		fakeRegionKeyID  = "fake region kms key id"
+		fakeDefaultKeyID = "fake default kms key id"
+	)
+
+	var fakeAmiConfig = config.AmiConfiguration{
+		Visibility:         "public",
+		Description:        "fake ami description",
+		AmiName:            "fake ami name",
+		VirtualizationType: "fake virtualization type",
+		KmsKeyId:           fakeDefaultKeyID,
+	}
+
+	It("copies the AMI within its region as a private, encrypted AMI", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:     fakeRegion,
+				RegionKmsKeyId: fakeRegionKeyID,
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		fakeEncryptedAmi := resources.Ami{ID: fakeEncryptedID, Region: fakeRegion}
+
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateReturns(fakeEncryptedAmi, nil)
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		p := publisher.NewReencryptPublisher(GinkgoWriter, publisherConfig)
+		amiCollection, err := p.Publish(fakeDs, fakeSourceAmiID)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeCopyAmiDriver.CreateCallCount()).To(Equal(1), "Expected CopyAmiDriver.Create to be called once")
+		Expect(fakeCopyAmiDriver.CreateArgsForCall(0)).To(Equal(resources.AmiDriverConfig{
+			ExistingAmiID:     fakeSourceAmiID,
+			DestinationRegion: fakeRegion,
+			AmiProperties: resources.AmiProperties{
+				Name:               fakeAmiConfig.AmiName,
+				Description:        fakeAmiConfig.Description,
+				Accessibility:      resources.PrivateAmiAccessibility,
+				VirtualizationType: fakeAmiConfig.VirtualizationType,
+				Encrypted:          true,
+				KmsKeyId:           fakeRegionKeyID,
+			},
+		}))
+
+		Expect(amiCollection.GetAll()).To(ConsistOf(fakeEncryptedAmi))
+		Expect(amiCollection.VirtualizationType).To(Equal(fakeAmiConfig.VirtualizationType))
+	})
+
+	It("uses the ami_configuration KMS key when the region does not configure one", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion:        config.AmiRegion{RegionName: fakeRegion},
+			AmiConfiguration: fakeAmiConfig,
+		}
+
+		p := publisher.NewReencryptPublisher(GinkgoWriter, publisherConfig)
+		Expect(p.AmiProperties.KmsKeyId).To(Equal(fakeDefaultKeyID))
+	})
+
+	It("returns a copy ami driver error if one was returned", func() {
+		publisherConfig := publisher.Config{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		driverErr := errors.New("error in copy ami driver")
+
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateReturns(resources.Ami{}, driverErr)
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		p := publisher.NewReencryptPublisher(GinkgoWriter, publisherConfig)
+		_, err := p.Publish(fakeDs, fakeSourceAmiID)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.CopyPhase))
+	})
+})
@@ -1,10 +1,14 @@
 package publisher
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"light-stemcell-builder/collection"
+	"light-stemcell-builder/config"
 	"light-stemcell-builder/driverset"
+	"light-stemcell-builder/heartbeat"
+	"light-stemcell-builder/report"
 	"light-stemcell-builder/resources"
 	"log"
 	"sync"
@@ -17,20 +21,38 @@ type StandardRegionPublisher struct {
 	ServerSideEncryption string
 	AmiProperties        resources.AmiProperties
 	CopyDestinations     []string
-	logger               *log.Logger
+	CopyHubs             []string
+	PriorityDestinations []string
+	ArchiveCopies        int
+	Namespace            string
+	// PriorityReady, when non-nil, is given the source AMI and the copies to priority destinations as soon as they
+	// are available, before the other destinations have been copied to
+	PriorityReady func(amis *collection.Ami)
+	logger        *log.Logger
 }
 
 func NewStandardRegionPublisher(logDest io.Writer, c Config) *StandardRegionPublisher {
 	return &StandardRegionPublisher{
-		Region:               c.RegionName,
+		Region:               c.PublishRegion(),
 		BucketName:           c.BucketName,
 		ServerSideEncryption: c.ServerSideEncryption,
 		CopyDestinations:     c.Destinations,
+		CopyHubs:             c.CopyHubs,
+		PriorityDestinations: c.PriorityDestinations,
+		ArchiveCopies:        c.ArchiveSnapshotCopies,
+		Namespace:            c.Namespace,
 		AmiProperties: resources.AmiProperties{
 			Name:               c.AmiName,
 			Description:        c.Description,
 			Accessibility:      c.Visibility,
 			VirtualizationType: c.VirtualizationType,
+			Tags:               c.Tags,
+			VerifyCopies:       c.VerifyCopies,
+			RootDeviceName:     c.RootDeviceNames[c.VirtualizationType],
+			EphemeralDevices:   ephemeralDevices(c.EphemeralDevices),
+			DataVolumes:        dataVolumes(c.DataVolumes),
+			SnapshotTags:       c.SnapshotTags,
+			PrivateSnapshots:   c.Promotion != "",
 			Encrypted:          c.Encrypted,
 			KmsKeyId:           c.KmsKeyId,
 		},
@@ -38,43 +60,84 @@ func NewStandardRegionPublisher(logDest io.Writer, c Config) *StandardRegionPubl
 	}
 }
 
-func (p *StandardRegionPublisher) Publish(ds driverset.StandardRegionDriverSet, machineImageConfig MachineImageConfig) (*collection.Ami, error) {
+// Publish publishes the machine image to the region and its destinations, stopping before the next phase once ctx
+// is done
+func (p *StandardRegionPublisher) Publish(ctx context.Context, ds driverset.StandardRegionDriverSet, machineImageConfig MachineImageConfig) (*collection.Ami, error) {
 
 	createStartTime := time.Now()
 	defer func(startTime time.Time) {
 		p.logger.Printf("completed Publish() in %f minutes\n", time.Since(startTime).Minutes())
 	}(createStartTime)
+	defer heartbeat.Done(p.Region)
 
 	machineImageDriverConfig := resources.MachineImageDriverConfig{
 		MachineImagePath:     machineImageConfig.LocalPath,
 		FileFormat:           machineImageConfig.FileFormat,
 		BucketName:           p.BucketName,
 		ServerSideEncryption: p.ServerSideEncryption,
+		MaxUploadMemoryMB:    machineImageConfig.MaxUploadMemoryMB,
+		MaxUploadRate:        machineImageConfig.MaxUploadRate,
+		Namespace:            p.Namespace,
 	}
 
-	machineImageDriver := ds.MachineImageDriver()
-	machineImage, err := machineImageDriver.Create(machineImageDriverConfig)
-	if err != nil {
-		return nil, fmt.Errorf("creating machine image: %s", err)
+	snapshotDriverConfig := resources.SnapshotDriverConfig{
+		FileFormat:  machineImageConfig.FileFormat,
+		ImageDigest: machineImageConfig.ImageDigest,
+		Namespace:   p.Namespace,
+		Private:     p.AmiProperties.PrivateSnapshots,
 	}
-	defer func() {
-		err := machineImageDriver.Delete(machineImage)
+
+	// an import of the same image which another build already started is waited on without uploading the image
+	snapshotDriver := ds.CreateSnapshotDriver()
+	if finder, ok := snapshotDriver.(resources.InFlightImportFinder); ok {
+		importTaskID, err := finder.FindInFlightImport(snapshotDriverConfig)
 		if err != nil {
-			p.logger.Printf("Failed to delete machine image %s: %s", machineImage.GetURL, err)
+			return nil, &PublishError{Phase: SnapshotPhase, Err: fmt.Errorf("finding an import in flight: %s", err)}
 		}
-	}()
+		snapshotDriverConfig.ImportTaskID = importTaskID
+	}
 
-	snapshotDriverConfig := resources.SnapshotDriverConfig{
-		MachineImageURL: machineImage.GetURL,
-		FileFormat:      machineImageConfig.FileFormat,
+	if snapshotDriverConfig.ImportTaskID == "" {
+		p.logger.Printf("%s: uploading machine image to bucket %s\n", p.Region, p.BucketName)
+		heartbeat.Phase(p.Region, MachineImagePhase)
+		machineImageDriver := ds.MachineImageDriver()
+		machineImage, err := machineImageDriver.Create(machineImageDriverConfig)
+		if err != nil {
+			return nil, &PublishError{Phase: MachineImagePhase, Err: fmt.Errorf("creating machine image: %s", err)}
+		}
+		defer func() {
+			err := machineImageDriver.Delete(machineImage)
+			if err != nil {
+				p.logger.Printf("Failed to delete machine image %s: %s", machineImage.GetURL, err)
+			}
+		}()
+
+		err = verifyImageDigest(machineImageConfig, machineImage)
+		if err != nil {
+			return nil, &PublishError{Phase: MachineImagePhase, Err: err}
+		}
+		snapshotDriverConfig.MachineImageURL = machineImage.GetURL
 	}
 
-	snapshotDriver := ds.CreateSnapshotDriver()
+	if err := stopIfDone(ctx, SnapshotPhase, nil); err != nil {
+		return nil, err
+	}
+
+	p.logger.Printf("%s: creating snapshot\n", p.Region)
+	heartbeat.Phase(p.Region, SnapshotPhase)
 	snapshot, err := snapshotDriver.Create(snapshotDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating snapshot: %s", err)
+		return nil, &PublishError{Phase: SnapshotPhase, Err: fmt.Errorf("creating snapshot: %s", err)}
 	}
 
+	created := []report.Resource{{Type: SnapshotResource, ID: snapshot.ID, Region: p.Region}}
+
+	if err := stopIfDone(ctx, AmiPhase, created); err != nil {
+		return nil, err
+	}
+
+	p.logger.Printf("%s: creating AMI from snapshot %s\n", p.Region, snapshot.ID)
+	heartbeat.Phase(p.Region, AmiPhase)
 	createAmiDriver := ds.CreateAmiDriver()
 	createAmiDriverConfig := resources.AmiDriverConfig{
 		SnapshotID:    snapshot.ID,
@@ -83,29 +146,120 @@ func (p *StandardRegionPublisher) Publish(ds driverset.StandardRegionDriverSet,
 
 	sourceAmi, err := createAmiDriver.Create(createAmiDriverConfig)
 	if err != nil {
-		return nil, fmt.Errorf("creating ami: %s", err)
+		return nil, &PublishError{Phase: AmiPhase, Resources: created, Err: fmt.Errorf("creating ami: %s", err)}
 	}
 
+	created = append(created, report.Resource{Type: AmiResource, ID: sourceAmi.ID, Region: sourceAmi.Region})
+
 	amis := collection.Ami{
 		VirtualizationType: p.AmiProperties.VirtualizationType,
 	}
 	amis.Add(sourceAmi)
 
-	copyAmiDriver := ds.CopyAmiDriver()
+	// every copy records the source AMI and snapshot it descends from, even when copied from a hub
+	copyProperties := p.AmiProperties
+	copyProperties.SnapshotTags = resources.LineageTags(p.AmiProperties.SnapshotTags, sourceAmi.ID, snapshot.ID)
 
-	procGroup := sync.WaitGroup{}
-	procGroup.Add(len(p.CopyDestinations))
+	if len(p.CopyDestinations) > 0 {
+		if err := stopIfDone(ctx, CopyPhase, created); err != nil {
+			return &amis, err
+		}
+	}
 
+	p.logger.Printf("%s: created AMI %s, copying to %d destination regions\n", p.Region, sourceAmi.ID, len(p.CopyDestinations))
+	heartbeat.Phase(p.Region, CopyPhase)
+	copyAmiDriver := ds.CopyAmiDriver()
 	errCol := collection.Error{}
 
-	for i := range p.CopyDestinations {
-		go func(dstRegion string) {
+	// copies to priority destinations and hubs are made from the source AMI, priority destinations first,
+	// then every other destination copies from its nearest hub
+	prioritySources := map[string]resources.Ami{}
+	hubSources := map[string]resources.Ami{}
+	fanOutSources := map[string]resources.Ami{}
+	for _, dstRegion := range p.CopyDestinations {
+		switch {
+		case p.isPriorityDestination(dstRegion):
+			prioritySources[dstRegion] = sourceAmi
+		case p.isCopyHub(dstRegion):
+			hubSources[dstRegion] = sourceAmi
+		default:
+			fanOutSources[dstRegion] = sourceAmi
+		}
+	}
+
+	hubAmis := map[string]resources.Ami{}
+	if len(prioritySources) > 0 {
+		priorityAmis := p.copyAmis(copyAmiDriver, copyProperties, prioritySources, &amis, &errCol)
+		ready := collection.Ami{VirtualizationType: amis.VirtualizationType}
+		ready.Add(sourceAmi)
+		for _, dstRegion := range p.PriorityDestinations {
+			if priorityAmi, ok := priorityAmis[dstRegion]; ok {
+				p.logger.Printf("%s: priority destination %s is ready with AMI %s\n", p.Region, dstRegion, priorityAmi.ID)
+				ready.Add(priorityAmi)
+				if p.isCopyHub(dstRegion) {
+					hubAmis[dstRegion] = priorityAmi
+				}
+			}
+		}
+		if p.PriorityReady != nil && len(priorityAmis) > 0 {
+			p.PriorityReady(&ready)
+		}
+	}
+
+	for dstRegion, hubAmi := range p.copyAmis(copyAmiDriver, copyProperties, hubSources, &amis, &errCol) {
+		hubAmis[dstRegion] = hubAmi
+	}
+	if len(hubAmis) > 0 {
+		for dstRegion := range fanOutSources {
+			if hubAmi, ok := hubAmis[config.NearestHub(dstRegion, p.CopyHubs)]; ok {
+				fanOutSources[dstRegion] = hubAmi
+			}
+		}
+	}
+	p.copyAmis(copyAmiDriver, copyProperties, fanOutSources, &amis, &errCol)
+
+	copyErr := errCol.Error()
+	if copyErr != nil {
+		for _, ami := range amis.GetAll()[1:] {
+			created = append(created, report.Resource{Type: AmiResource, ID: ami.ID, Region: ami.Region})
+		}
+		return &amis, &PublishError{Phase: CopyPhase, Resources: created, Err: copyErr}
+	}
+
+	if p.ArchiveCopies > 0 {
+		archived, err := p.archiveSnapshots(ds, snapshot.ID, sourceAmi.ID, amis.GetAll()[1:])
+		if err != nil {
+			for _, ami := range amis.GetAll()[1:] {
+				created = append(created, report.Resource{Type: AmiResource, ID: ami.ID, Region: ami.Region})
+			}
+			return &amis, &PublishError{Phase: ArchivePhase, Resources: append(created, archived...), Err: err}
+		}
+	}
+
+	return &amis, nil
+}
+
+// copyAmis copies the source AMI of each destination region in parallel with the properties, adding the copies to
+// amis and returning them by region
+func (p *StandardRegionPublisher) copyAmis(copyAmiDriver resources.AmiDriver, properties resources.AmiProperties, sources map[string]resources.Ami, amis *collection.Ami, errCol *collection.Error) map[string]resources.Ami {
+	var copiedMutex sync.Mutex
+	copied := map[string]resources.Ami{}
+
+	procGroup := sync.WaitGroup{}
+	procGroup.Add(len(sources))
+
+	for dstRegion, sourceAmi := range sources {
+		go func(dstRegion string, sourceAmi resources.Ami) {
 			defer procGroup.Done()
 
 			copyAmiDriverConfig := resources.AmiDriverConfig{
 				ExistingAmiID:     sourceAmi.ID,
 				DestinationRegion: dstRegion,
-				AmiProperties:     p.AmiProperties,
+				LookupSnapshot:    p.ArchiveCopies > 0,
+				AmiProperties:     properties,
+			}
+			if sourceAmi.Region != p.Region {
+				copyAmiDriverConfig.SourceRegion = sourceAmi.Region
 			}
 
 			copiedAmi, copyErr := copyAmiDriver.Create(copyAmiDriverConfig)
@@ -114,11 +268,71 @@ func (p *StandardRegionPublisher) Publish(ds driverset.StandardRegionDriverSet,
 				return
 			}
 
+			p.logger.Printf("%s: copied AMI %s to %s as %s\n", p.Region, sourceAmi.ID, dstRegion, copiedAmi.ID)
 			amis.Add(copiedAmi)
-		}(p.CopyDestinations[i])
+
+			copiedMutex.Lock()
+			defer copiedMutex.Unlock()
+			copied[dstRegion] = copiedAmi
+		}(dstRegion, sourceAmi)
 	}
 
 	procGroup.Wait()
 
-	return &amis, errCol.Error()
+	return copied
+}
+
+// archiveSnapshots archives the source snapshot along with the snapshot behind each copied AMI, in the region of
+// the copy, returning every archive which was created even when some failed
+func (p *StandardRegionPublisher) archiveSnapshots(ds driverset.StandardRegionDriverSet, snapshotID string, amiID string, copiedAmis []resources.Ami) ([]report.Resource, error) {
+	var archivedMutex sync.Mutex
+	archived := []report.Resource{}
+	errCol := collection.Error{}
+
+	archive := func(snapshotDriver resources.SnapshotDriver, region string, snapshotID string, amiID string) {
+		regionArchived, err := archiveSnapshot(p.logger, snapshotDriver, region, snapshotID, amiID, p.ArchiveCopies, p.Namespace)
+		if err != nil {
+			errCol.Add(err)
+		}
+
+		archivedMutex.Lock()
+		defer archivedMutex.Unlock()
+		archived = append(archived, regionArchived...)
+	}
+
+	procGroup := sync.WaitGroup{}
+	procGroup.Add(1 + len(copiedAmis))
+
+	go func() {
+		defer procGroup.Done()
+		archive(ds.ArchiveSnapshotDriver(), p.Region, snapshotID, amiID)
+	}()
+	for _, copiedAmi := range copiedAmis {
+		go func(copiedAmi resources.Ami) {
+			defer procGroup.Done()
+			archive(ds.ArchiveSnapshotDriverFor(copiedAmi.Region), copiedAmi.Region, copiedAmi.SnapshotID, copiedAmi.ID)
+		}(copiedAmi)
+	}
+
+	procGroup.Wait()
+
+	return archived, errCol.Error()
+}
+
+func (p *StandardRegionPublisher) isCopyHub(region string) bool {
+	for _, hub := range p.CopyHubs {
+		if hub == region {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *StandardRegionPublisher) isPriorityDestination(region string) bool {
+	for _, priority := range p.PriorityDestinations {
+		if priority == region {
+			return true
+		}
+	}
+	return false
 }
@@ -1,10 +1,13 @@
 package publisher_test
 
 import (
+	"context"
 	"errors"
+	"light-stemcell-builder/collection"
 	"light-stemcell-builder/config"
 	fakeDriverset "light-stemcell-builder/driverset/fakes"
 	"light-stemcell-builder/publisher"
+	"light-stemcell-builder/report"
 	"light-stemcell-builder/resources"
 	fakeResources "light-stemcell-builder/resources/fakes"
 
@@ -15,14 +18,15 @@ import (
 var _ = Describe("StandardRegionPublisher", func() {
 
 	const (
-		fakeMachineImageURL  = "fake machine image url"
-		fakeSnapshotID       = "fake snapshot id"
-		fakeAmiID            = "fake AMI id"
-		fakeCopiedAmiID      = "fake copied AMI id"
-		fakeBucketName       = "fake bucket name"
-		fakeRegion           = "fake region"
-		fakeMachineImagePath = "fake machine image path"
-		fakeCopyDestination  = "fake copy destination"
+		fakeMachineImageURL   = "fake machine image url"
+		fakeSnapshotID        = "fake snapshot id"
+		fakeAmiID             = "fake AMI id"
+		fakeCopiedAmiID       = "fake copied AMI id"
+		fakeBucketName        = "fake bucket name"
+		fakeRegion            = "fake region"
+		fakeMachineImagePath  = "fake machine image path"
+		fakeCopyDestination   = "fake copy destination"
+		fakeMaxUploadMemoryMB = 64
 	)
 
 	var fakeAmiConfig = config.AmiConfiguration{
@@ -48,8 +52,9 @@ var _ = Describe("StandardRegionPublisher", func() {
 			AmiConfiguration: fakeAmiConfig,
 		}
 		machineImageConfig := publisher.MachineImageConfig{
-			LocalPath:  fakeMachineImagePath,
-			FileFormat: resources.VolumeRawFormat,
+			LocalPath:         fakeMachineImagePath,
+			FileFormat:        resources.VolumeRawFormat,
+			MaxUploadMemoryMB: fakeMaxUploadMemoryMB,
 		}
 
 		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
@@ -86,15 +91,16 @@ var _ = Describe("StandardRegionPublisher", func() {
 		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
 
 		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
-		amiCollection, err := p.Publish(fakeDs, machineImageConfig)
+		amiCollection, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(fakeDs.MachineImageDriverCallCount()).To(Equal(1), "Expected Driverset.MachineImageDriver to be called once")
 		Expect(fakeMachineImageDriver.CreateCallCount()).To(Equal(1), "Expected MachineImageDriver.Create to be called once")
 		Expect(fakeMachineImageDriver.CreateArgsForCall(0)).To(Equal(resources.MachineImageDriverConfig{
-			MachineImagePath: fakeMachineImagePath,
-			FileFormat:       resources.VolumeRawFormat,
-			BucketName:       fakeBucketName,
+			MachineImagePath:  fakeMachineImagePath,
+			FileFormat:        resources.VolumeRawFormat,
+			BucketName:        fakeBucketName,
+			MaxUploadMemoryMB: fakeMaxUploadMemoryMB,
 		}))
 
 		Expect(fakeDs.CreateSnapshotDriverCallCount()).To(Equal(1), "Expected Driverset.CreateSnapshotDriver to be called once")
@@ -114,10 +120,16 @@ var _ = Describe("StandardRegionPublisher", func() {
 		Expect(fakeDs.CopyAmiDriverCallCount()).To(Equal(1), "Expected Driverset.CopyAmiDriver to be called once")
 		Expect(fakeCopyAmiDriver.CreateCallCount()).To(Equal(1), "Expected CopyAmiDriver.Create to be called once")
 
+		// copies carry the lineage of the source AMI to their snapshots
+		copyProperties := fakeAmiProperties
+		copyProperties.SnapshotTags = map[string]string{
+			resources.SourceAmiTagKey:      fakeAmiID,
+			resources.SourceSnapshotTagKey: fakeSnapshotID,
+		}
 		Expect(fakeCopyAmiDriver.CreateArgsForCall(0)).To(Equal(resources.AmiDriverConfig{
 			ExistingAmiID:     fakeAmiID,
 			DestinationRegion: fakeCopyDestination,
-			AmiProperties:     fakeAmiProperties,
+			AmiProperties:     copyProperties,
 		}))
 
 		Expect(fakeMachineImageDriver.DeleteCallCount()).To(Equal(1), "Expected MachineImageDriver.Delete to be called once")
@@ -140,12 +152,59 @@ var _ = Describe("StandardRegionPublisher", func() {
 		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
 
 		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
 	})
 
+	It("fails without creating a snapshot when the uploaded image differs from the one planned", func() {
+		machineImageConfig := publisher.MachineImageConfig{LocalPath: fakeMachineImagePath, ImageDigest: "planned-sha256"}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		fakeMachineImage := resources.MachineImage{
+			GetURL:    fakeMachineImageURL,
+			Checksums: resources.MachineImageChecksums{Sha256: "uploaded-sha256"},
+		}
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(fakeMachineImage, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisher.Config{})
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+
+		Expect(err).To(MatchError("machine image fake machine image path changed after it was planned: uploaded with sha256 uploaded-sha256 rather than planned-sha256"))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.MachineImagePhase))
+		Expect(fakeSnapshotDriver.CreateCallCount()).To(Equal(0))
+		Expect(fakeMachineImageDriver.DeleteArgsForCall(0)).To(Equal(fakeMachineImage))
+	})
+
+	It("waits on an import already in flight without uploading the machine image", func() {
+		machineImageConfig := publisher.MachineImageConfig{FileFormat: resources.VolumeRawFormat, ImageDigest: "some-sha256"}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		fakeSnapshotDriver := &inFlightSnapshotDriver{FakeSnapshotDriver: &fakeResources.FakeSnapshotDriver{}, importTaskID: "import-snap-1"}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisher.Config{AmiRegion: config.AmiRegion{RegionName: fakeRegion}})
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeDs.MachineImageDriverCallCount()).To(Equal(0))
+		Expect(fakeSnapshotDriver.found).To(Equal(resources.SnapshotDriverConfig{FileFormat: resources.VolumeRawFormat, ImageDigest: "some-sha256"}))
+		Expect(fakeSnapshotDriver.CreateArgsForCall(0)).To(Equal(resources.SnapshotDriverConfig{
+			FileFormat:   resources.VolumeRawFormat,
+			ImageDigest:  "some-sha256",
+			ImportTaskID: "import-snap-1",
+		}))
+	})
+
 	It("returns a snapshot driver error if one was returned", func() {
 		publisherConfig := publisher.Config{}
 		machineImageConfig := publisher.MachineImageConfig{}
@@ -166,10 +225,13 @@ var _ = Describe("StandardRegionPublisher", func() {
 		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
 
 		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err).To(BeAssignableToTypeOf(&publisher.PublishError{}))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.SnapshotPhase))
+		Expect(err.(*publisher.PublishError).Resources).To(BeEmpty())
 	})
 
 	It("returns a create ami driver error if one was returned", func() {
@@ -199,10 +261,15 @@ var _ = Describe("StandardRegionPublisher", func() {
 		fakeDs.CreateAmiDriverReturns(fakeAmiDriver)
 
 		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.AmiPhase))
+		Expect(err.(*publisher.PublishError).Resources).To(ConsistOf(report.Resource{
+			Type: publisher.SnapshotResource,
+			ID:   fakeSnapshotID,
+		}))
 	})
 
 	It("returns a copy ami driver error if one was returned", func() {
@@ -246,9 +313,381 @@ var _ = Describe("StandardRegionPublisher", func() {
 		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
 
 		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
-		_, err := p.Publish(fakeDs, machineImageConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.CopyPhase))
+		Expect(err.(*publisher.PublishError).Resources).To(ConsistOf(
+			report.Resource{Type: publisher.SnapshotResource, ID: fakeSnapshotID},
+			report.Resource{Type: publisher.AmiResource, ID: fakeAmiID, Region: fakeRegion},
+		))
+	})
+
+	It("archives copies of the snapshot when archive copies are configured", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:            fakeRegion,
+				ArchiveSnapshotCopies: 2,
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		fakeArchiveDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeArchiveDriver.CreateReturns(resources.Snapshot{ID: "fake archived snapshot id"}, nil)
+		fakeDs.ArchiveSnapshotDriverReturns(fakeArchiveDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeArchiveDriver.CreateCallCount()).To(Equal(2), "Expected ArchiveSnapshotDriver.Create to be called twice")
+		for i := 0; i < 2; i++ {
+			Expect(fakeArchiveDriver.CreateArgsForCall(i).SnapshotID).To(Equal(fakeSnapshotID))
+			Expect(fakeArchiveDriver.CreateArgsForCall(i).Description).To(ContainSubstring(fakeAmiID))
+		}
+	})
+
+	It("archives the snapshot behind each copied AMI in the region it was copied to", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:            fakeRegion,
+				Destinations:          []string{fakeCopyDestination},
+				ArchiveSnapshotCopies: 1,
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateReturns(resources.Ami{ID: fakeCopiedAmiID, Region: fakeCopyDestination, SnapshotID: "fake copied snapshot id"}, nil)
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		fakeArchiveDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeArchiveDriver.CreateReturns(resources.Snapshot{ID: "fake archived snapshot id"}, nil)
+		fakeDs.ArchiveSnapshotDriverReturns(fakeArchiveDriver)
+
+		fakeDestinationArchiveDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeDestinationArchiveDriver.CreateReturns(resources.Snapshot{ID: "fake archived copied snapshot id"}, nil)
+		fakeDs.ArchiveSnapshotDriverForReturns(fakeDestinationArchiveDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeCopyAmiDriver.CreateArgsForCall(0).LookupSnapshot).To(BeTrue())
+		Expect(fakeArchiveDriver.CreateCallCount()).To(Equal(1))
+		Expect(fakeArchiveDriver.CreateArgsForCall(0).SnapshotID).To(Equal(fakeSnapshotID))
+
+		Expect(fakeDs.ArchiveSnapshotDriverForCallCount()).To(Equal(1))
+		Expect(fakeDs.ArchiveSnapshotDriverForArgsForCall(0)).To(Equal(fakeCopyDestination))
+		Expect(fakeDestinationArchiveDriver.CreateCallCount()).To(Equal(1))
+		Expect(fakeDestinationArchiveDriver.CreateArgsForCall(0).SnapshotID).To(Equal("fake copied snapshot id"))
+		Expect(fakeDestinationArchiveDriver.CreateArgsForCall(0).Description).To(ContainSubstring(fakeCopiedAmiID))
+	})
+
+	It("keeps the snapshot and the snapshots of copies private when the AMIs are promoted later", func() {
+		amiConfig := fakeAmiConfig
+		amiConfig.Promotion = config.AfterPublishPromotion
+		publisherConfig := publisher.Config{
+			AmiRegion:        config.AmiRegion{RegionName: fakeRegion, Destinations: []string{fakeCopyDestination}},
+			AmiConfiguration: amiConfig,
+		}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateReturns(resources.Ami{ID: fakeCopiedAmiID, Region: fakeCopyDestination}, nil)
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		_, err := p.Publish(context.Background(), fakeDs, publisher.MachineImageConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeSnapshotDriver.CreateArgsForCall(0).Private).To(BeTrue())
+		Expect(fakeCreateAmiDriver.CreateArgsForCall(0).PrivateSnapshots).To(BeTrue())
+		Expect(fakeCopyAmiDriver.CreateArgsForCall(0).PrivateSnapshots).To(BeTrue())
+	})
+
+	It("stops before the next phase once the context is done, reporting the resources it created", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateStub = func(resources.SnapshotDriverConfig) (resources.Snapshot, error) {
+			cancel()
+			return resources.Snapshot{ID: fakeSnapshotID}, nil
+		}
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisher.Config{AmiRegion: config.AmiRegion{RegionName: fakeRegion}})
+		_, err := p.Publish(ctx, fakeDs, publisher.MachineImageConfig{})
+
+		Expect(err).To(MatchError("not started before the publish was cancelled"))
+		publishErr := err.(*publisher.PublishError)
+		Expect(publishErr.Phase).To(Equal(publisher.AmiPhase))
+		Expect(publishErr.Resources).To(Equal([]report.Resource{{Type: publisher.SnapshotResource, ID: fakeSnapshotID, Region: fakeRegion}}))
+		Expect(fakeCreateAmiDriver.CreateCallCount()).To(Equal(0))
+		Expect(fakeMachineImageDriver.DeleteCallCount()).To(Equal(1))
+	})
+
+	It("passes the namespace to the drivers of intermediate resources", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:            fakeRegion,
+				ArchiveSnapshotCopies: 1,
+			},
+			AmiConfiguration: fakeAmiConfig,
+			Namespace:        "some-pipeline",
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: fakeRegion}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		fakeArchiveDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeArchiveDriver.CreateReturns(resources.Snapshot{ID: "fake archived snapshot id"}, nil)
+		fakeDs.ArchiveSnapshotDriverReturns(fakeArchiveDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		_, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(fakeMachineImageDriver.CreateArgsForCall(0).Namespace).To(Equal("some-pipeline"))
+		Expect(fakeSnapshotDriver.CreateArgsForCall(0).Namespace).To(Equal("some-pipeline"))
+		Expect(fakeArchiveDriver.CreateArgsForCall(0).Namespace).To(Equal("some-pipeline"))
+	})
+
+	It("returns an archive error along with the AMIs if archiving fails", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:            fakeRegion,
+				ArchiveSnapshotCopies: 1,
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeAmi := resources.Ami{ID: fakeAmiID, Region: fakeRegion}
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(fakeAmi, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		driverErr := errors.New("error in archive snapshot driver")
+		fakeArchiveDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeArchiveDriver.CreateReturns(resources.Snapshot{}, driverErr)
+		fakeDs.ArchiveSnapshotDriverReturns(fakeArchiveDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		amiCollection, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
 
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring(driverErr.Error()))
+		Expect(err.(*publisher.PublishError).Phase).To(Equal(publisher.ArchivePhase))
+		Expect(err.(*publisher.PublishError).Resources).To(ConsistOf(
+			report.Resource{Type: publisher.SnapshotResource, ID: fakeSnapshotID, Region: fakeRegion},
+			report.Resource{Type: publisher.AmiResource, ID: fakeAmiID, Region: fakeRegion},
+		))
+		Expect(amiCollection.GetAll()).To(ConsistOf(fakeAmi))
+	})
+
+	It("copies to hub regions first, then to other destinations from their nearest hub", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:   "us-east-1",
+				Destinations: []string{"us-west-1", "us-west-2", "eu-west-1", "eu-central-1"},
+				CopyHubs:     []string{"us-west-1", "eu-west-1"},
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: "us-east-1"}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateStub = func(driverConfig resources.AmiDriverConfig) (resources.Ami, error) {
+			return resources.Ami{ID: "copy in " + driverConfig.DestinationRegion, Region: driverConfig.DestinationRegion}, nil
+		}
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		amiCollection, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(amiCollection.GetAll()).To(HaveLen(5))
+
+		sources := map[string]resources.AmiDriverConfig{}
+		for i := 0; i < fakeCopyAmiDriver.CreateCallCount(); i++ {
+			driverConfig := fakeCopyAmiDriver.CreateArgsForCall(i)
+			sources[driverConfig.DestinationRegion] = driverConfig
+		}
+
+		Expect(sources["us-west-1"].ExistingAmiID).To(Equal(fakeAmiID))
+		Expect(sources["us-west-1"].SourceRegion).To(BeEmpty())
+		Expect(sources["eu-west-1"].ExistingAmiID).To(Equal(fakeAmiID))
+
+		Expect(sources["us-west-2"].ExistingAmiID).To(Equal("copy in us-west-1"))
+		Expect(sources["us-west-2"].SourceRegion).To(Equal("us-west-1"))
+		Expect(sources["eu-central-1"].ExistingAmiID).To(Equal("copy in eu-west-1"))
+		Expect(sources["eu-central-1"].SourceRegion).To(Equal("eu-west-1"))
+
+		// copies from a hub still trace back to the AMI registered in the source region
+		Expect(sources["us-west-2"].SnapshotTags).To(HaveKeyWithValue(resources.SourceAmiTagKey, fakeAmiID))
+		Expect(sources["us-west-2"].SnapshotTags).To(HaveKeyWithValue(resources.SourceSnapshotTagKey, fakeSnapshotID))
+	})
+
+	It("copies to priority destinations from the source AMI before any other destination", func() {
+		publisherConfig := publisher.Config{
+			AmiRegion: config.AmiRegion{
+				RegionName:           "us-east-1",
+				Destinations:         []string{"us-west-1", "us-west-2", "eu-west-1", "eu-central-1"},
+				CopyHubs:             []string{"us-west-1"},
+				PriorityDestinations: []string{"eu-central-1", "us-west-1"},
+			},
+			AmiConfiguration: fakeAmiConfig,
+		}
+		machineImageConfig := publisher.MachineImageConfig{}
+
+		fakeDs := &fakeDriverset.FakeStandardRegionDriverSet{}
+
+		fakeMachineImageDriver := &fakeResources.FakeMachineImageDriver{}
+		fakeMachineImageDriver.CreateReturns(resources.MachineImage{GetURL: fakeMachineImageURL}, nil)
+		fakeDs.MachineImageDriverReturns(fakeMachineImageDriver)
+
+		fakeSnapshotDriver := &fakeResources.FakeSnapshotDriver{}
+		fakeSnapshotDriver.CreateReturns(resources.Snapshot{ID: fakeSnapshotID}, nil)
+		fakeDs.CreateSnapshotDriverReturns(fakeSnapshotDriver)
+
+		fakeCreateAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCreateAmiDriver.CreateReturns(resources.Ami{ID: fakeAmiID, Region: "us-east-1"}, nil)
+		fakeDs.CreateAmiDriverReturns(fakeCreateAmiDriver)
+
+		fakeCopyAmiDriver := &fakeResources.FakeAmiDriver{}
+		fakeCopyAmiDriver.CreateStub = func(driverConfig resources.AmiDriverConfig) (resources.Ami, error) {
+			return resources.Ami{ID: "copy in " + driverConfig.DestinationRegion, Region: driverConfig.DestinationRegion}, nil
+		}
+		fakeDs.CopyAmiDriverReturns(fakeCopyAmiDriver)
+
+		p := publisher.NewStandardRegionPublisher(GinkgoWriter, publisherConfig)
+		readyRegions := []string{}
+		copiedWhenReady := 0
+		p.PriorityReady = func(amis *collection.Ami) {
+			for _, ami := range amis.GetAll() {
+				readyRegions = append(readyRegions, ami.Region)
+			}
+			copiedWhenReady = fakeCopyAmiDriver.CreateCallCount()
+		}
+
+		amiCollection, err := p.Publish(context.Background(), fakeDs, machineImageConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(amiCollection.GetAll()).To(HaveLen(5))
+		Expect(fakeCopyAmiDriver.CreateCallCount()).To(Equal(4))
+
+		// the priority AMIs are handed over before any other destination is copied to
+		Expect(readyRegions).To(ConsistOf("us-east-1", "eu-central-1", "us-west-1"))
+		Expect(copiedWhenReady).To(Equal(2))
+
+		first := []string{}
+		for i := 0; i < 2; i++ {
+			driverConfig := fakeCopyAmiDriver.CreateArgsForCall(i)
+			Expect(driverConfig.ExistingAmiID).To(Equal(fakeAmiID))
+			first = append(first, driverConfig.DestinationRegion)
+		}
+		Expect(first).To(ConsistOf("eu-central-1", "us-west-1"))
+
+		sources := map[string]resources.AmiDriverConfig{}
+		for i := 2; i < fakeCopyAmiDriver.CreateCallCount(); i++ {
+			driverConfig := fakeCopyAmiDriver.CreateArgsForCall(i)
+			sources[driverConfig.DestinationRegion] = driverConfig
+		}
+		Expect(sources["us-west-2"].ExistingAmiID).To(Equal("copy in us-west-1"))
+		Expect(sources["eu-west-1"].ExistingAmiID).To(Equal("copy in us-west-1"))
 	})
 })
+
+// inFlightSnapshotDriver finds importTaskID in flight for any machine image
+type inFlightSnapshotDriver struct {
+	*fakeResources.FakeSnapshotDriver
+	importTaskID string
+	found        resources.SnapshotDriverConfig
+}
+
+func (d *inFlightSnapshotDriver) FindInFlightImport(driverConfig resources.SnapshotDriverConfig) (string, error) {
+	d.found = driverConfig
+	return d.importTaskID, nil
+}
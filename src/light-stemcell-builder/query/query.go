@@ -0,0 +1,45 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Params returns the parameters of an AWS Query API request, such as those of EC2 and STS, which are form encoded
+// in the body of POSTs. The body is restored so the request can still be sent.
+func Params(req *http.Request) (url.Values, error) {
+	params := req.URL.Query()
+	if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return params, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %s", err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("parsing request body: %s", err)
+	}
+	for key, values := range form {
+		params[key] = values
+	}
+
+	return params, nil
+}
+
+// Action returns the operation of a Query API request, or an empty string for requests of other APIs
+func Action(req *http.Request) (string, error) {
+	params, err := Params(req)
+	if err != nil {
+		return "", err
+	}
+	return params.Get("Action"), nil
+}
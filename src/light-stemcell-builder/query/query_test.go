@@ -0,0 +1,46 @@
+package query_test
+
+import (
+	"io/ioutil"
+	"light-stemcell-builder/query"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query", func() {
+	It("reads the action from the form encoded body, restoring the body", func() {
+		req, err := http.NewRequest("POST", "https://ec2.us-east-1.amazonaws.com/", strings.NewReader("Action=DescribeImages&DryRun=true"))
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+		params, err := query.Params(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(params.Get("Action")).To(Equal("DescribeImages"))
+		Expect(params.Get("DryRun")).To(Equal("true"))
+
+		body, err := ioutil.ReadAll(req.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("Action=DescribeImages&DryRun=true"))
+	})
+
+	It("reads the action from the URL of GETs", func() {
+		req, err := http.NewRequest("GET", "https://sts.amazonaws.com/?Action=GetCallerIdentity", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		action, err := query.Action(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(Equal("GetCallerIdentity"))
+	})
+
+	It("has no action for requests of other APIs", func() {
+		req, err := http.NewRequest("PUT", "https://some-bucket.s3.amazonaws.com/some-key", strings.NewReader("some-content"))
+		Expect(err).ToNot(HaveOccurred())
+
+		action, err := query.Action(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(action).To(BeEmpty())
+	})
+})
@@ -0,0 +1,128 @@
+package readonly
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"light-stemcell-builder/query"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is the code of the AWS error returned for requests refused in read-only mode
+const ErrorCode = "ReadOnlyMode"
+
+// prefixes of the AWS operations which cannot modify resources
+var readOnlyPrefixes = []string{"Describe", "Get", "Head", "List", "Simulate"}
+
+// Transport refuses every request which could modify AWS resources before it is sent, passing the others to Base.
+// Refused requests receive a 403 response in the error format of the service, which the SDK does not retry.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// Enable makes every HTTP client of the process which uses the default transport read-only, including the AWS SDK's
+func Enable() {
+	http.DefaultTransport = &Transport{Base: http.DefaultTransport}
+}
+
+// RoundTrip sends req through Base unless it could modify AWS resources
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if target := req.Header.Get("X-Amz-Target"); target != "" {
+		operation := target[strings.LastIndex(target, ".")+1:]
+		if isReadOnly(operation) {
+			return t.Base.RoundTrip(req)
+		}
+		return refuse(req, operation, jsonError), nil
+	}
+
+	params, err := query.Params(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if operation := params.Get("Action"); operation != "" {
+		if isReadOnly(operation) || params.Get("DryRun") == "true" {
+			return t.Base.RoundTrip(req)
+		}
+
+		format := queryError
+		if strings.HasPrefix(req.URL.Host, "ec2.") {
+			format = ec2Error
+		}
+		return refuse(req, operation, format), nil
+	}
+
+	// S3 and presigned URLs identify the operation by the method alone
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.Base.RoundTrip(req)
+	}
+	return refuse(req, fmt.Sprintf("%s %s", req.Method, req.URL.Path), s3Error), nil
+}
+
+func isReadOnly(operation string) bool {
+	for _, prefix := range readOnlyPrefixes {
+		if strings.HasPrefix(operation, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type errorFormat func(message string) []byte
+
+func refuse(req *http.Request, operation string, format errorFormat) *http.Response {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	message := fmt.Sprintf("refusing to send %s to %s in read-only mode", operation, req.URL.Host)
+	body := format(message)
+	return &http.Response{
+		Status:        "403 Forbidden",
+		StatusCode:    http.StatusForbidden,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type xmlError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func ec2Error(message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name   `xml:"Response"`
+		Errors  []xmlError `xml:"Errors>Error"`
+	}{Errors: []xmlError{{Code: ErrorCode, Message: message}}})
+	return b
+}
+
+func queryError(message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ErrorResponse"`
+		Error   xmlError `xml:"Error"`
+	}{Error: xmlError{Code: ErrorCode, Message: message}})
+	return b
+}
+
+func s3Error(message string) []byte {
+	b, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		xmlError
+	}{xmlError: xmlError{Code: ErrorCode, Message: message}})
+	return b
+}
+
+func jsonError(message string) []byte {
+	b, _ := json.Marshal(map[string]string{"__type": ErrorCode, "message": message})
+	return b
+}
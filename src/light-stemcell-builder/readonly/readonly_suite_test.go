@@ -0,0 +1,13 @@
+package readonly_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestReadonly(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Readonly Suite")
+}
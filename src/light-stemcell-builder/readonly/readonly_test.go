@@ -0,0 +1,102 @@
+package readonly_test
+
+import (
+	"bytes"
+	"errors"
+	"light-stemcell-builder/readonly"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var errSent = errors.New("sent")
+
+type fakeTransport struct {
+	requests int
+}
+
+func (f *fakeTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	f.requests++
+	return nil, errSent
+}
+
+var _ = Describe("Transport", func() {
+	var base *fakeTransport
+	var sess *session.Session
+
+	BeforeEach(func() {
+		base = &fakeTransport{}
+		sess = session.New(aws.NewConfig().
+			WithHTTPClient(&http.Client{Transport: &readonly.Transport{Base: base}}).
+			WithCredentials(credentials.NewStaticCredentials("some-key", "some-secret", "")).
+			WithRegion("us-east-1").
+			WithMaxRetries(0))
+	})
+
+	expectRefused := func(err error) {
+		Expect(err).To(HaveOccurred())
+		awsErr, ok := err.(awserr.Error)
+		Expect(ok).To(BeTrue(), "expected an AWS error, got %s", err)
+		Expect(awsErr.Code()).To(Equal(readonly.ErrorCode))
+		Expect(awsErr.Message()).To(ContainSubstring("read-only mode"))
+		Expect(base.requests).To(Equal(0))
+	}
+
+	expectSent := func(err error) {
+		Expect(err).To(MatchError(ContainSubstring("sent")))
+		Expect(base.requests).To(Equal(1))
+	}
+
+	It("refuses mutating EC2 calls, but sends reads and dry runs", func() {
+		client := ec2.New(sess)
+
+		_, err := client.RegisterImage(&ec2.RegisterImageInput{Name: aws.String("some-ami")})
+		expectRefused(err)
+
+		_, err = client.RegisterImage(&ec2.RegisterImageInput{Name: aws.String("some-ami"), DryRun: aws.Bool(true)})
+		expectSent(err)
+
+		_, err = client.DescribeImages(&ec2.DescribeImagesInput{})
+		Expect(err).To(MatchError(ContainSubstring("sent")))
+		Expect(base.requests).To(Equal(2))
+	})
+
+	It("refuses mutating Query API calls", func() {
+		_, err := iam.New(sess).CreateUser(&iam.CreateUserInput{UserName: aws.String("some-user")})
+		expectRefused(err)
+	})
+
+	It("refuses mutating JSON API calls, but sends reads", func() {
+		client := kms.New(sess)
+
+		_, err := client.CreateGrant(&kms.CreateGrantInput{KeyId: aws.String("some-key"), GranteePrincipal: aws.String("some-principal")})
+		expectRefused(err)
+
+		_, err = client.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String("some-key")})
+		expectSent(err)
+	})
+
+	It("refuses S3 writes, but sends reads", func() {
+		client := s3.New(sess)
+
+		_, err := client.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("some-bucket"),
+			Key:    aws.String("some-key"),
+			Body:   bytes.NewReader([]byte("some content")),
+		})
+		expectRefused(err)
+
+		_, err = client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("some-bucket")})
+		expectSent(err)
+	})
+})
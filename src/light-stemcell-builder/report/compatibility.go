@@ -0,0 +1,53 @@
+package report
+
+import "light-stemcell-builder/resources"
+
+// Boot modes of an AMI. AMIs registered without a boot mode boot with legacy BIOS.
+const (
+	LegacyBIOSBootMode = "legacy-bios"
+	UEFIBootMode       = "uefi"
+)
+
+const arm64Architecture = "arm64"
+
+// Compatibility records the attributes of the AMIs published for a stemcell, along with the instance families they
+// can run on as derived from those attributes
+type Compatibility struct {
+	Architecture       string `json:"architecture"`
+	VirtualizationType string `json:"virtualization_type"`
+	BootMode           string `json:"boot_mode"`
+	EnaSupport         bool   `json:"ena_support"`
+	// Xen and Nitro are set when the AMI can run on instance types of the Xen or Nitro hypervisor
+	Xen   bool `json:"xen"`
+	Nitro bool `json:"nitro"`
+	// NitroOnly and RequiresEna are set when the AMI only runs on Nitro instance types, which all require ENA
+	NitroOnly   bool `json:"nitro_only"`
+	RequiresEna bool `json:"requires_ena"`
+	UefiOnly    bool `json:"uefi_only"`
+	Arm64       bool `json:"arm64"`
+}
+
+// NewCompatibility derives the instance families an AMI with the given attributes can run on. Nitro instance
+// types require HVM and ENA, while arm64 and UEFI AMIs cannot boot on Xen.
+func NewCompatibility(architecture string, virtualizationType string, bootMode string, enaSupport bool) Compatibility {
+	if bootMode == "" {
+		bootMode = LegacyBIOSBootMode
+	}
+
+	c := Compatibility{
+		Architecture:       architecture,
+		VirtualizationType: virtualizationType,
+		BootMode:           bootMode,
+		EnaSupport:         enaSupport,
+		UefiOnly:           bootMode == UEFIBootMode,
+		Arm64:              architecture == arm64Architecture,
+	}
+
+	hvm := virtualizationType == resources.HvmAmiVirtualization
+	c.Nitro = hvm && enaSupport
+	c.Xen = !c.UefiOnly && !c.Arm64
+	c.NitroOnly = c.Nitro && !c.Xen
+	c.RequiresEna = c.NitroOnly
+
+	return c
+}
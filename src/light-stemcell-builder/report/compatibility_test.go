@@ -0,0 +1,44 @@
+package report_test
+
+import (
+	"light-stemcell-builder/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewCompatibility", func() {
+	It("limits HVM AMIs without ENA support to Xen instance types", func() {
+		c := report.NewCompatibility("x86_64", "hvm", "", false)
+		Expect(c.BootMode).To(Equal(report.LegacyBIOSBootMode))
+		Expect(c.Xen).To(BeTrue())
+		Expect(c.Nitro).To(BeFalse())
+		Expect(c.NitroOnly).To(BeFalse())
+		Expect(c.RequiresEna).To(BeFalse())
+	})
+
+	It("allows HVM AMIs with ENA support on both Xen and Nitro instance types", func() {
+		c := report.NewCompatibility("x86_64", "hvm", report.LegacyBIOSBootMode, true)
+		Expect(c.Xen).To(BeTrue())
+		Expect(c.Nitro).To(BeTrue())
+		Expect(c.NitroOnly).To(BeFalse())
+	})
+
+	It("does not allow paravirtual AMIs on Nitro instance types", func() {
+		c := report.NewCompatibility("x86_64", "paravirtual", report.LegacyBIOSBootMode, true)
+		Expect(c.Xen).To(BeTrue())
+		Expect(c.Nitro).To(BeFalse())
+	})
+
+	It("limits arm64 and UEFI AMIs to Nitro instance types, which require ENA", func() {
+		c := report.NewCompatibility("arm64", "hvm", report.LegacyBIOSBootMode, true)
+		Expect(c.Arm64).To(BeTrue())
+		Expect(c.NitroOnly).To(BeTrue())
+		Expect(c.RequiresEna).To(BeTrue())
+
+		c = report.NewCompatibility("x86_64", "hvm", report.UEFIBootMode, true)
+		Expect(c.UefiOnly).To(BeTrue())
+		Expect(c.Xen).To(BeFalse())
+		Expect(c.NitroOnly).To(BeTrue())
+	})
+})
@@ -0,0 +1,230 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// SchemaVersion is the version of the report format written by this builder, described by schema.json.
+// Reports written before the format was versioned have no schema_version and are read as version 1.
+const SchemaVersion = 3
+
+// Report statuses. Reports written while a publish is still running have the in progress status.
+const (
+	SucceededStatus  = "succeeded"
+	FailedStatus     = "failed"
+	InProgressStatus = "in_progress"
+)
+
+// Report is the machine-readable summary of a publish run
+type Report struct {
+	SchemaVersion int        `json:"schema_version"`
+	Status        string     `json:"status"`
+	Builder       Builder    `json:"builder"`
+	Regions       []string   `json:"regions"`
+	Stemcells     []Stemcell `json:"stemcells"`
+	RetryCommand  string     `json:"retry_command,omitempty"`
+	// DegradedEndpoints lists the endpoints whose polling is backed off by the circuit breaker, it is only set
+	// while in progress
+	DegradedEndpoints []string `json:"degraded_endpoints,omitempty"`
+}
+
+// Builder identifies the build of the light stemcell builder which produced a report
+type Builder struct {
+	Version   string `json:"version"`
+	GitSHA    string `json:"git_sha"`
+	BuildDate string `json:"build_date"`
+}
+
+// Stemcell records the outcome of publishing a single stemcell
+type Stemcell struct {
+	Name     string            `json:"name"`
+	Version  string            `json:"version"`
+	Image    string            `json:"image"`
+	Plan     string            `json:"plan"`
+	Amis     map[string]string `json:"amis"`
+	Failures []Failure         `json:"failures,omitempty"`
+	// VirtualizationType tells apart the entries of a stemcell published as several virtualization types
+	VirtualizationType string `json:"virtualization_type,omitempty"`
+	// Pending lists the regions whose publish has not finished yet, it is only set while in progress
+	Pending       []string       `json:"pending,omitempty"`
+	Compatibility *Compatibility `json:"compatibility,omitempty"`
+}
+
+// Failure records the phase which failed in a region and the resources created before it failed
+type Failure struct {
+	Region    string     `json:"region"`
+	Phase     string     `json:"phase"`
+	Error     string     `json:"error"`
+	Resources []Resource `json:"resources,omitempty"`
+}
+
+// Resource identifies an AWS resource created during a publish
+type Resource struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Region string `json:"region"`
+}
+
+// FailedRegions returns the regions with at least one failure in a phase other than those excepted, in the order
+// they were first reported
+func (r *Report) FailedRegions(except ...string) []string {
+	excepted := map[string]bool{}
+	for _, phase := range except {
+		excepted[phase] = true
+	}
+
+	seen := map[string]bool{}
+	regions := []string{}
+	for _, stemcell := range r.Stemcells {
+		for _, failure := range stemcell.Failures {
+			if !seen[failure.Region] && !excepted[failure.Phase] {
+				seen[failure.Region] = true
+				regions = append(regions, failure.Region)
+			}
+		}
+	}
+	return regions
+}
+
+// Read reads a report of this or an earlier schema version, converting it to the current version and validating it
+func Read(reader io.Reader) (*Report, error) {
+	r := &Report{}
+	err := json.NewDecoder(reader).Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling report from JSON: %s", err)
+	}
+
+	switch r.SchemaVersion {
+	case 0, 1:
+		// version 1 reports predate the builder metadata and plan digests, which are left empty
+		r.SchemaVersion = SchemaVersion
+	case 2:
+		// version 2 reports were only written once a publish had finished, so they have no pending regions
+		r.SchemaVersion = SchemaVersion
+	case SchemaVersion:
+	default:
+		return nil, fmt.Errorf("report schema version %d is newer than the version %d this builder supports", r.SchemaVersion, SchemaVersion)
+	}
+
+	err = r.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Validate returns an error describing the first way in which the report does not conform to its schema
+func (r *Report) Validate() error {
+	if r.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("invalid report: schema_version must be %d", SchemaVersion)
+	}
+
+	if r.Status != SucceededStatus && r.Status != FailedStatus && r.Status != InProgressStatus {
+		return fmt.Errorf("invalid report: status must be one of: ['%s', '%s', '%s']", SucceededStatus, FailedStatus, InProgressStatus)
+	}
+
+	for i, stemcell := range r.Stemcells {
+		if stemcell.Name == "" || stemcell.Version == "" {
+			return fmt.Errorf("invalid report: name and version must be specified for stemcells[%d]", i)
+		}
+
+		for region, ami := range stemcell.Amis {
+			if !strings.HasPrefix(ami, "ami-") {
+				return fmt.Errorf("invalid report: %s is not an AMI ID for %s in stemcells[%d]", ami, region, i)
+			}
+		}
+
+		for j, failure := range stemcell.Failures {
+			if failure.Region == "" || failure.Phase == "" {
+				return fmt.Errorf("invalid report: region and phase must be specified for stemcells[%d].failures[%d]", i, j)
+			}
+		}
+
+		if len(stemcell.Pending) > 0 && r.Status != InProgressStatus {
+			return fmt.Errorf("invalid report: pending regions are only allowed in reports which are %s, see stemcells[%d]", InProgressStatus, i)
+		}
+	}
+
+	if len(r.DegradedEndpoints) > 0 && r.Status != InProgressStatus {
+		return fmt.Errorf("invalid report: degraded endpoints are only allowed in reports which are %s", InProgressStatus)
+	}
+
+	return nil
+}
+
+// Write writes the JSON representation of the report to the io.Writer
+func (r *Report) Write(writer io.Writer) error {
+	output, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report to JSON: %s", err)
+	}
+
+	_, err = writer.Write(append(output, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing report: %s", err)
+	}
+	return nil
+}
+
+// RetryCommand returns the command line, built from the original args, which retries publishing to only the given regions
+func RetryCommand(args []string, regions []string) string {
+	retryArgs := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-regions" || arg == "--regions":
+			i++ // skip the flag's value
+		case strings.HasPrefix(arg, "-regions=") || strings.HasPrefix(arg, "--regions="):
+		default:
+			retryArgs = append(retryArgs, shellQuote(arg))
+		}
+	}
+
+	if len(regions) > 0 {
+		retryArgs = append(retryArgs, "--regions", shellQuote(strings.Join(regions, ",")))
+	}
+
+	return strings.Join(retryArgs, " ")
+}
+
+// PromoteCommand returns the promote command line, built from the config and report flags of the original args,
+// which makes the AMIs of the report public
+func PromoteCommand(args []string) string {
+	configPath, reportPath := "CONFIG", "REPORT"
+	for i := 1; i < len(args); i++ {
+		name, value := args[i], ""
+		inline := strings.Contains(name, "=")
+		if inline {
+			name, value = name[:strings.Index(name, "=")], name[strings.Index(name, "=")+1:]
+		} else if i+1 < len(args) {
+			value = args[i+1]
+		}
+
+		switch strings.TrimLeft(name, "-") {
+		case "c":
+			configPath = value
+		case "report":
+			reportPath = value
+		default:
+			continue
+		}
+		if !inline {
+			i++ // skip the flag's value
+		}
+	}
+
+	return strings.Join([]string{shellQuote(args[0]), "promote", "-c", shellQuote(configPath), "--report", shellQuote(reportPath)}, " ")
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,167 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"light-stemcell-builder/report"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Report", func() {
+	It("writes the report as JSON", func() {
+		r := &report.Report{
+			SchemaVersion: report.SchemaVersion,
+			Status:        report.FailedStatus,
+			Builder: report.Builder{
+				Version:   "1.2.3",
+				GitSHA:    "abc123",
+				BuildDate: "2017-05-24T00:00:00Z",
+			},
+			Regions: []string{"us-east-1", "cn-north-1"},
+			Stemcells: []report.Stemcell{
+				{
+					Name:    "bosh-aws-xen-hvm-ubuntu-trusty-go_agent",
+					Version: "3312",
+					Image:   "root.img",
+					Plan:    "0123abcd",
+					Amis:    map[string]string{"us-east-1": "ami-1234"},
+					Failures: []report.Failure{
+						{
+							Region: "cn-north-1",
+							Phase:  "snapshot",
+							Error:  "creating snapshot: some error",
+							Resources: []report.Resource{
+								{Type: "volume", ID: "vol-1234", Region: "cn-north-1"},
+							},
+						},
+					},
+				},
+			},
+			RetryCommand: "light-stemcell-builder -c config.json --regions cn-north-1",
+		}
+
+		writer := &bytes.Buffer{}
+		err := r.Write(writer)
+		Expect(err).ToNot(HaveOccurred())
+
+		result := &report.Report{}
+		err = json.Unmarshal(writer.Bytes(), result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(r))
+	})
+
+	It("returns the regions which failed, without duplicates", func() {
+		r := &report.Report{
+			Stemcells: []report.Stemcell{
+				{Failures: []report.Failure{{Region: "cn-north-1"}, {Region: "us-east-1"}}},
+				{Failures: []report.Failure{{Region: "cn-north-1"}}},
+			},
+		}
+
+		Expect(r.FailedRegions()).To(Equal([]string{"cn-north-1", "us-east-1"}))
+	})
+
+	It("leaves out the regions which only failed in the excepted phases", func() {
+		r := &report.Report{
+			Stemcells: []report.Stemcell{
+				{Failures: []report.Failure{{Region: "eu-west-1", Phase: "promote"}, {Region: "us-east-1", Phase: "sweep"}}},
+			},
+		}
+
+		Expect(r.FailedRegions("promote")).To(Equal([]string{"us-east-1"}))
+	})
+
+	Describe("Read", func() {
+		It("reads a report of the current schema version", func() {
+			r, err := report.Read(strings.NewReader(`{
+				"schema_version": 3,
+				"status": "succeeded",
+				"builder": {"version": "1.2.3", "git_sha": "abc123", "build_date": "2017-05-24T00:00:00Z"},
+				"regions": ["us-east-1"],
+				"stemcells": [{"name": "some-stemcell", "version": "3312", "plan": "0123abcd", "amis": {"us-east-1": "ami-1234"}}]
+			}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Builder.Version).To(Equal("1.2.3"))
+			Expect(r.Stemcells[0].Amis).To(Equal(map[string]string{"us-east-1": "ami-1234"}))
+		})
+
+		It("converts reports written before the schema was versioned", func() {
+			r, err := report.Read(strings.NewReader(`{
+				"status": "failed",
+				"regions": ["cn-north-1"],
+				"stemcells": [{"name": "some-stemcell", "version": "3312", "amis": {}, "failures": [{"region": "cn-north-1", "phase": "snapshot", "error": "some error"}]}],
+				"retry_command": "light-stemcell-builder --regions cn-north-1"
+			}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.SchemaVersion).To(Equal(report.SchemaVersion))
+			Expect(r.FailedRegions()).To(Equal([]string{"cn-north-1"}))
+		})
+
+		It("converts reports of schema version 2", func() {
+			r, err := report.Read(strings.NewReader(`{"schema_version": 2, "status": "succeeded", "stemcells": [{"name": "some-stemcell", "version": "3312", "amis": {"us-east-1": "ami-1234"}}]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.SchemaVersion).To(Equal(report.SchemaVersion))
+		})
+
+		It("reads reports written while the publish was in progress", func() {
+			r, err := report.Read(strings.NewReader(`{"schema_version": 3, "status": "in_progress", "stemcells": [{"name": "some-stemcell", "version": "3312", "amis": {"us-east-1": "ami-1234"}, "pending": ["cn-north-1"]}], "degraded_endpoints": ["ec2.cn-north-1.amazonaws.com.cn"]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stemcells[0].Pending).To(Equal([]string{"cn-north-1"}))
+			Expect(r.DegradedEndpoints).To(Equal([]string{"ec2.cn-north-1.amazonaws.com.cn"}))
+		})
+
+		It("returns an error for reports of a newer schema version", func() {
+			_, err := report.Read(strings.NewReader(`{"schema_version": 4, "status": "succeeded"}`))
+			Expect(err).To(MatchError("report schema version 4 is newer than the version 3 this builder supports"))
+		})
+
+		It("returns an error for reports which do not conform to the schema", func() {
+			_, err := report.Read(strings.NewReader(`{"schema_version": 3, "status": "done"}`))
+			Expect(err).To(MatchError("invalid report: status must be one of: ['succeeded', 'failed', 'in_progress']"))
+
+			_, err = report.Read(strings.NewReader(`{"schema_version": 3, "status": "succeeded", "stemcells": [{"name": "some-stemcell", "version": "3312", "amis": {"us-east-1": "snap-1234"}}]}`))
+			Expect(err).To(MatchError("invalid report: snap-1234 is not an AMI ID for us-east-1 in stemcells[0]"))
+
+			_, err = report.Read(strings.NewReader(`{"schema_version": 3, "status": "failed", "stemcells": [{"name": "some-stemcell", "version": "3312", "amis": {}, "pending": ["us-east-1"]}]}`))
+			Expect(err).To(MatchError("invalid report: pending regions are only allowed in reports which are in_progress, see stemcells[0]"))
+
+			_, err = report.Read(strings.NewReader(`{"schema_version": 3, "status": "succeeded", "degraded_endpoints": ["ec2.us-east-1.amazonaws.com"]}`))
+			Expect(err).To(MatchError("invalid report: degraded endpoints are only allowed in reports which are in_progress"))
+		})
+	})
+
+	Describe("PromoteCommand", func() {
+		It("promotes with the config and report of the original command", func() {
+			args := []string{"light-stemcell-builder", "-c", "config.json", "--image", "root.img", "--report=my report.json"}
+			Expect(report.PromoteCommand(args)).To(Equal("light-stemcell-builder promote -c config.json --report 'my report.json'"))
+		})
+
+		It("leaves placeholders for the flags the original command did not give", func() {
+			Expect(report.PromoteCommand([]string{"light-stemcell-builder", "-c", "config.json"})).To(Equal(
+				"light-stemcell-builder promote -c config.json --report REPORT",
+			))
+		})
+	})
+
+	Describe("RetryCommand", func() {
+		It("appends the regions to retry to the original command", func() {
+			args := []string{"light-stemcell-builder", "-c", "config.json", "--image", "root.img"}
+			Expect(report.RetryCommand(args, []string{"us-east-1", "cn-north-1"})).To(Equal(
+				"light-stemcell-builder -c config.json --image root.img --regions us-east-1,cn-north-1",
+			))
+		})
+
+		It("replaces any regions given to the original command", func() {
+			args := []string{"light-stemcell-builder", "--regions", "a,b,c", "-c", "config.json", "-regions=d"}
+			Expect(report.RetryCommand(args, []string{"b"})).To(Equal("light-stemcell-builder -c config.json --regions b"))
+		})
+
+		It("quotes arguments which are not safe for the shell", func() {
+			args := []string{"light-stemcell-builder", "-c", "my config's.json"}
+			Expect(report.RetryCommand(args, nil)).To(Equal(`light-stemcell-builder -c 'my config'\''s.json'`))
+		})
+	})
+})
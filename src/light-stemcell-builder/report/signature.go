@@ -0,0 +1,72 @@
+package report
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// SignatureSuffix is appended to the location of a report to give the location of its detached signature
+const SignatureSuffix = ".sig"
+
+// Sign returns the detached signature of the report content, a base64 ed25519 signature on a single line
+func Sign(content []byte, key ed25519.PrivateKey) []byte {
+	encoded := base64.StdEncoding.EncodeToString(ed25519.Sign(key, content))
+	return []byte(encoded + "\n")
+}
+
+// VerifySignature returns an error unless signature is the signature of the report content made with the private
+// half of key. The content must be exactly the bytes which were signed, so it should not be decoded and re-encoded.
+func VerifySignature(content []byte, signature []byte, key ed25519.PublicKey) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %s", err)
+	}
+
+	if !ed25519.Verify(key, content, decoded) {
+		return errors.New("signature does not match the report and key")
+	}
+	return nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 ed25519 private key, as written by `openssl genpkey -algorithm ed25519`
+func ParsePrivateKey(pemBytes []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %s", err)
+	}
+
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("report signing keys must be ed25519 keys, not %T", key)
+	}
+	return ed25519Key, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX ed25519 public key, as written by `openssl pkey -pubout`
+func ParsePublicKey(pemBytes []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %s", err)
+	}
+
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("report signing keys must be ed25519 keys, not %T", key)
+	}
+	return ed25519Key, nil
+}
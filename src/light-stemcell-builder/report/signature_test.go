@@ -0,0 +1,72 @@
+package report_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"light-stemcell-builder/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signatures", func() {
+	content := []byte(`{"schema_version":3,"status":"succeeded"}`)
+
+	var (
+		publicKey  ed25519.PublicKey
+		privateKey ed25519.PrivateKey
+	)
+
+	BeforeEach(func() {
+		var err error
+		publicKey, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("verifies the signature of the signed content", func() {
+		signature := report.Sign(content, privateKey)
+		Expect(report.VerifySignature(content, signature, publicKey)).To(Succeed())
+	})
+
+	It("rejects signatures of other content or made with other keys", func() {
+		signature := report.Sign(content, privateKey)
+
+		err := report.VerifySignature([]byte(`{"schema_version":3,"status":"failed"}`), signature, publicKey)
+		Expect(err).To(MatchError("signature does not match the report and key"))
+
+		otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.VerifySignature(content, signature, otherKey)).To(MatchError("signature does not match the report and key"))
+	})
+
+	It("parses PEM encoded keys", func() {
+		privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		Expect(err).ToNot(HaveOccurred())
+		parsedPrivate, err := report.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsedPrivate.Equal(privateKey)).To(BeTrue())
+
+		publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+		Expect(err).ToNot(HaveOccurred())
+		parsedPublic, err := report.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsedPublic.Equal(publicKey)).To(BeTrue())
+	})
+
+	It("rejects keys which are not ed25519 keys", func() {
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		der, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = report.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		Expect(err).To(MatchError("report signing keys must be ed25519 keys, not *ecdsa.PublicKey"))
+
+		_, err = report.ParsePublicKey([]byte("not a key"))
+		Expect(err).To(MatchError("no PEM encoded key found"))
+	})
+})
@@ -15,11 +15,13 @@ type AmiDriver interface {
 	Create(AmiDriverConfig) (Ami, error)
 }
 
-// Ami represents an AMI resource in EC2
+// Ami represents an AMI resource in EC2. SnapshotID, the snapshot of its root device, is only set by copies made
+// with LookupSnapshot.
 type Ami struct {
 	ID                 string
 	Region             string
 	VirtualizationType string
+	SnapshotID         string
 }
 
 // AmiProperties describes what properties the published AMI should have
@@ -30,12 +32,41 @@ type AmiProperties struct {
 	VirtualizationType string
 	Encrypted          bool
 	KmsKeyId           string
+	Tags               map[string]string
+	VerifyCopies       bool
+	// RootDeviceName overrides the default root device name of the virtualization type when registering
+	RootDeviceName   string
+	EphemeralDevices []EphemeralDevice
+	DataVolumes      []DataVolume
+	// SnapshotTags are applied to the snapshot backing the AMI, see LineageTags
+	SnapshotTags map[string]string
+	// PrivateSnapshots withholds public permission to create volumes from the snapshots until the AMI is promoted
+	PrivateSnapshots bool
 }
 
-// AmiDriverConfig allows an AmiDriver to create an AMI from either a snapshot ID or an existing AMI (copy)
+// EphemeralDevice maps an instance store volume, such as ephemeral0, to a device name
+type EphemeralDevice struct {
+	VirtualName string
+	DeviceName  string
+}
+
+// DataVolume describes an empty EBS volume created with each instance, Iops is only set for io1 volumes
+type DataVolume struct {
+	DeviceName string
+	SizeGB     int64
+	VolumeType string
+	Iops       int64
+	Encrypted  bool
+}
+
+// AmiDriverConfig allows an AmiDriver to create an AMI from either a snapshot ID or an existing AMI (copy).
+// SourceRegion is set when the existing AMI is not in the driver's own region. LookupSnapshot has a copy return
+// the snapshot of its root device, so that it can be archived.
 type AmiDriverConfig struct {
 	SnapshotID        string
 	ExistingAmiID     string
+	SourceRegion      string
 	DestinationRegion string
+	LookupSnapshot    bool
 	AmiProperties
 }
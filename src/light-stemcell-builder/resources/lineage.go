@@ -0,0 +1,24 @@
+package resources
+
+// NameTagKey is the tag EC2 shows as the name of a resource
+const NameTagKey = "Name"
+
+// Lineage tags, which trace the snapshot of every AMI back to the build which published it
+const (
+	SourceAmiTagKey      = "light-stemcell-builder-source-ami"
+	SourceSnapshotTagKey = "light-stemcell-builder-source-snapshot"
+	ImageDigestTagKey    = "light-stemcell-builder-image-digest"
+	BuilderVersionTagKey = "light-stemcell-builder-version"
+)
+
+// LineageTags returns a copy of tags recording the AMI registered in the region the image was imported to, and the
+// snapshot it was registered from, as the source of a snapshot
+func LineageTags(tags map[string]string, sourceAmiID string, sourceSnapshotID string) map[string]string {
+	lineage := map[string]string{}
+	for key, value := range tags {
+		lineage[key] = value
+	}
+	lineage[SourceAmiTagKey] = sourceAmiID
+	lineage[SourceSnapshotTagKey] = sourceSnapshotID
+	return lineage
+}
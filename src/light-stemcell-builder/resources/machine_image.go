@@ -9,6 +9,14 @@ type MachineImageDriver interface {
 type MachineImage struct {
 	GetURL     string
 	DeleteURLs []string
+	Checksums  MachineImageChecksums
+}
+
+// MachineImageChecksums contains the digests of a machine image, computed while it was uploaded
+type MachineImageChecksums struct {
+	Sha1   string
+	Sha256 string
+	MD5    string
 }
 
 type MachineImageDriverConfig struct {
@@ -17,4 +25,7 @@ type MachineImageDriverConfig struct {
 	ServerSideEncryption string
 	FileFormat           string
 	VolumeSizeGB         int64
+	MaxUploadMemoryMB    int64
+	MaxUploadRate        int64
+	Namespace            string
 }
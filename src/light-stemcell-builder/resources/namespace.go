@@ -0,0 +1,4 @@
+package resources
+
+// NamespaceTagKey is the tag applied to intermediate EC2 resources created for a namespaced build
+const NamespaceTagKey = "light-stemcell-builder-namespace"
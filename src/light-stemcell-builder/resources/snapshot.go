@@ -6,15 +6,32 @@ type SnapshotDriver interface {
 	Create(SnapshotDriverConfig) (Snapshot, error)
 }
 
+// InFlightImportFinder is implemented by snapshot drivers which can find an import of the same machine image already
+// started by another build, checked before the machine image is uploaded so that it is not uploaded again
+type InFlightImportFinder interface {
+	FindInFlightImport(SnapshotDriverConfig) (string, error)
+}
+
 // Snapshot represents an EBS snapshot which can be used to create an AMI
 type Snapshot struct {
 	ID string
 }
 
-// SnapshotDriverConfig contains information used to create a snapshot from either an EBS volume or machine image
+// SnapshotDriverConfig contains information used to create a snapshot from an EBS volume, a machine image or an existing snapshot (copy)
 type SnapshotDriverConfig struct {
 	VolumeID string
 
 	MachineImageURL string
 	FileFormat      string
+	ImageDigest     string
+	// ImportTaskID is an import of the machine image already in flight, waited on instead of starting a new one
+	ImportTaskID string
+
+	SnapshotID  string
+	Description string
+
+	Namespace string
+
+	// Private withholds public permission to create volumes from the snapshot until its AMI is promoted
+	Private bool
 }
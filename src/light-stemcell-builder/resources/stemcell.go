@@ -0,0 +1,7 @@
+package resources
+
+// Tags recording the version and operating system of the stemcell each AMI is published from
+const (
+	StemcellVersionTagKey = "light-stemcell-builder-stemcell-version"
+	StemcellOSTagKey      = "light-stemcell-builder-stemcell-os"
+)
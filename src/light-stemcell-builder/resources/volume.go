@@ -1,5 +1,7 @@
 package resources
 
+import "time"
+
 // Volume properties which we do not expect to change
 const (
 	VolumeRawFormat    = "RAW"
@@ -17,6 +19,12 @@ type Volume struct {
 	ID string
 }
 
+// DeleteAfterTagKey is the tag recording when a volume kept for debugging may be deleted, as an RFC 3339 time
+const DeleteAfterTagKey = "light-stemcell-builder-delete-after"
+
 type VolumeDriverConfig struct {
 	MachineImageManifestURL string
+	Namespace               string
+	// DeleteAfter, when set, is recorded on the volume with DeleteAfterTagKey
+	DeleteAfter time.Time
 }
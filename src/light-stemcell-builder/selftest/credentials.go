@@ -0,0 +1,113 @@
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/policy"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// long-term IAM user access keys start with AKIA, all others are temporary
+const longTermKeyPrefix = "AKIA"
+
+// Identity is the principal a credentials block of the config resolved to
+type Identity struct {
+	Region    string
+	AccessKey string
+	Account   string
+	Partition string
+	Arn       string
+	Expires   string
+	Err       error
+}
+
+// ResolveIdentity calls GetCallerIdentity with the credentials of regionConfig, failing when the principal
+// belongs to a different partition than the region, as happens when credentials are pasted into the wrong entry
+func ResolveIdentity(client stsiface.STSAPI, regionConfig config.AmiRegion) Identity {
+	identity := Identity{
+		Region:    regionConfig.RegionName,
+		AccessKey: maskAccessKey(regionConfig.Credentials.AccessKey),
+		Expires:   "unknown",
+	}
+	if strings.HasPrefix(regionConfig.Credentials.AccessKey, longTermKeyPrefix) {
+		identity.Expires = "never"
+	}
+
+	output, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		identity.Err = err
+		return identity
+	}
+
+	identity.Account = aws.StringValue(output.Account)
+	identity.Arn = aws.StringValue(output.Arn)
+	if parts := strings.SplitN(identity.Arn, ":", 3); len(parts) == 3 {
+		identity.Partition = parts[1]
+	}
+
+	if expected := policy.Partition(identity.Region); identity.Partition != expected {
+		identity.Err = fmt.Errorf("credentials belong to the %s partition but %s is in %s", identity.Partition, identity.Region, expected)
+	}
+
+	return identity
+}
+
+// IdentitiesFailed returns true when any of the credentials could not be resolved
+func IdentitiesFailed(identities []Identity) bool {
+	for _, identity := range identities {
+		if identity.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteIdentities writes a table with a row per credentials block, followed by the details of any failures
+// and the number of distinct accounts, which is usually one
+func WriteIdentities(w io.Writer, identities []Identity) error {
+	accounts := map[string]bool{}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tACCESS_KEY\tACCOUNT\tPARTITION\tEXPIRES\tARN")
+	for _, identity := range identities {
+		if identity.Account != "" {
+			accounts[identity.Account] = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", identity.Region, identity.AccessKey, dash(identity.Account), dash(identity.Partition), identity.Expires, dash(identity.Arn))
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if identity.Err != nil {
+			fmt.Fprintf(w, "%s: %s\n", identity.Region, identity.Err)
+		}
+	}
+
+	fmt.Fprintf(w, "%d distinct accounts\n", len(accounts))
+	return nil
+}
+
+// maskAccessKey keeps enough of the key ID to tell keys apart without printing it in full
+func maskAccessKey(accessKey string) string {
+	if len(accessKey) <= 8 {
+		return strings.Repeat("*", len(accessKey))
+	}
+	return accessKey[:4] + strings.Repeat("*", len(accessKey)-8) + accessKey[len(accessKey)-4:]
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,158 @@
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// Capabilities checked for every region, in the order they are printed
+const (
+	IdentityCapability = "identity"
+	RegionsCapability  = "regions"
+	BucketCapability   = "bucket"
+	KmsKeyCapability   = "kms_key"
+)
+
+var capabilities = []string{IdentityCapability, RegionsCapability, BucketCapability, KmsKeyCapability}
+
+// Clients holds the AWS API clients used to check a single region
+type Clients struct {
+	STS stsiface.STSAPI
+	EC2 ec2iface.EC2API
+	S3  s3iface.S3API
+	KMS kmsiface.KMSAPI
+}
+
+// NewClients creates the AWS API clients for the region and credentials in creds
+func NewClients(creds config.Credentials) Clients {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region)
+
+	sess := session.New(awsConfig)
+	return Clients{
+		STS: sts.New(sess, dualstack.Config("sts", creds)),
+		EC2: ec2.New(sess, dualstack.Config("ec2", creds)),
+		S3:  s3.New(sess, dualstack.Config("s3", creds)),
+		KMS: kms.New(sess, dualstack.Config("kms", creds)),
+	}
+}
+
+// Check is the outcome of a single read-only call. Skipped checks were not applicable to the config.
+type Check struct {
+	Region     string
+	Capability string
+	Skipped    bool
+	Detail     string
+	Err        error
+}
+
+// Run exercises the credentials of regionConfig with read-only calls, returning one Check per capability
+func Run(clients Clients, regionConfig config.AmiRegion, amiConfig config.AmiConfiguration) []Check {
+	region := regionConfig.RegionName
+	checks := []Check{}
+
+	identity, err := clients.STS.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	check := Check{Region: region, Capability: IdentityCapability, Err: err}
+	if err == nil {
+		check.Detail = aws.StringValue(identity.Arn)
+	}
+	checks = append(checks, check)
+
+	regionsOutput, err := clients.EC2.DescribeRegions(&ec2.DescribeRegionsInput{})
+	check = Check{Region: region, Capability: RegionsCapability, Err: err}
+	if err == nil {
+		check.Detail = fmt.Sprintf("%d regions visible", len(regionsOutput.Regions))
+	}
+	checks = append(checks, check)
+
+	_, err = clients.S3.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(regionConfig.BucketName)})
+	checks = append(checks, Check{Region: region, Capability: BucketCapability, Detail: regionConfig.BucketName, Err: err})
+
+	if amiConfig.KmsKeyId == "" {
+		checks = append(checks, Check{Region: region, Capability: KmsKeyCapability, Skipped: true, Detail: "no kms_key_id configured"})
+	} else {
+		keyOutput, err := clients.KMS.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(amiConfig.KmsKeyId)})
+		check = Check{Region: region, Capability: KmsKeyCapability, Detail: amiConfig.KmsKeyId, Err: err}
+		if err == nil && !aws.BoolValue(keyOutput.KeyMetadata.Enabled) {
+			check.Err = fmt.Errorf("key %s is not enabled", amiConfig.KmsKeyId)
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// Failed returns true when any of the checks failed
+func Failed(checks []Check) bool {
+	for _, check := range checks {
+		if check.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMatrix writes a table with a row per region and a column per capability, followed by the details of any failures
+func WriteMatrix(w io.Writer, checks []Check) error {
+	regions := []string{}
+	results := map[string]map[string]Check{}
+	for _, check := range checks {
+		if results[check.Region] == nil {
+			regions = append(regions, check.Region)
+			results[check.Region] = map[string]Check{}
+		}
+		results[check.Region][check.Capability] = check
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "REGION")
+	for _, capability := range capabilities {
+		fmt.Fprintf(tw, "\t%s", capability)
+	}
+	fmt.Fprintln(tw)
+
+	for _, region := range regions {
+		fmt.Fprint(tw, region)
+		for _, capability := range capabilities {
+			check, ok := results[region][capability]
+			switch {
+			case !ok || check.Skipped:
+				fmt.Fprint(tw, "\t-")
+			case check.Err != nil:
+				fmt.Fprint(tw, "\tFAILED")
+			default:
+				fmt.Fprint(tw, "\tok")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, check := range checks {
+		if check.Err != nil {
+			fmt.Fprintf(w, "%s %s: %s\n", check.Region, check.Capability, check.Err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+package selftest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSelftest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Selftest Suite")
+}
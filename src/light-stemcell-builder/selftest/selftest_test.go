@@ -0,0 +1,115 @@
+package selftest_test
+
+import (
+	"bytes"
+	"errors"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/selftest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSTS struct {
+	stsiface.STSAPI
+	err error
+}
+
+func (f *fakeSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::123456789012:user/builder")}, f.err
+}
+
+type fakeEC2 struct {
+	ec2iface.EC2API
+}
+
+func (f *fakeEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	return &ec2.DescribeRegionsOutput{Regions: []*ec2.Region{{}, {}}}, nil
+}
+
+type fakeS3 struct {
+	s3iface.S3API
+	err          error
+	headedBucket string
+}
+
+func (f *fakeS3) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	f.headedBucket = aws.StringValue(input.Bucket)
+	return &s3.HeadBucketOutput{}, f.err
+}
+
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	enabled bool
+}
+
+func (f *fakeKMS) DescribeKey(*kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Enabled: aws.Bool(f.enabled)}}, nil
+}
+
+var _ = Describe("Selftest", func() {
+	var (
+		s3Client     *fakeS3
+		clients      selftest.Clients
+		regionConfig config.AmiRegion
+	)
+
+	BeforeEach(func() {
+		s3Client = &fakeS3{}
+		clients = selftest.Clients{
+			STS: &fakeSTS{},
+			EC2: &fakeEC2{},
+			S3:  s3Client,
+			KMS: &fakeKMS{enabled: true},
+		}
+		regionConfig = config.AmiRegion{RegionName: "us-east-1", BucketName: "some-bucket"}
+	})
+
+	It("checks every capability with read-only calls", func() {
+		checks := selftest.Run(clients, regionConfig, config.AmiConfiguration{KmsKeyId: "some-key"})
+
+		Expect(checks).To(HaveLen(4))
+		Expect(selftest.Failed(checks)).To(BeFalse())
+		Expect(checks[0].Detail).To(Equal("arn:aws:iam::123456789012:user/builder"))
+		Expect(s3Client.headedBucket).To(Equal("some-bucket"))
+	})
+
+	It("skips the KMS check when no key is configured", func() {
+		checks := selftest.Run(clients, regionConfig, config.AmiConfiguration{})
+
+		Expect(checks[3].Capability).To(Equal(selftest.KmsKeyCapability))
+		Expect(checks[3].Skipped).To(BeTrue())
+	})
+
+	It("fails the KMS check when the key is disabled", func() {
+		clients.KMS = &fakeKMS{enabled: false}
+		checks := selftest.Run(clients, regionConfig, config.AmiConfiguration{KmsKeyId: "some-key"})
+
+		Expect(checks[3].Err).To(MatchError("key some-key is not enabled"))
+	})
+
+	It("writes a capability matrix with the reason for each failure", func() {
+		s3Client.err = errors.New("Forbidden")
+		checks := selftest.Run(clients, regionConfig, config.AmiConfiguration{})
+		Expect(selftest.Failed(checks)).To(BeTrue())
+
+		output := &bytes.Buffer{}
+		err := selftest.WriteMatrix(output, checks)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output.String()).To(Equal(
+			"REGION     identity  regions  bucket  kms_key\n" +
+				"us-east-1  ok        ok       FAILED  -\n" +
+				"us-east-1 bucket: Forbidden\n",
+		))
+	})
+})
@@ -0,0 +1,205 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"light-stemcell-builder/breaker"
+	"light-stemcell-builder/builder"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Build states
+const (
+	PublishingState = "publishing"
+	SucceededState  = "succeeded"
+	FailedState     = "failed"
+)
+
+// PendingRegion is the state of a region which has not started publishing, the other states are the builder's
+// progress events
+const PendingRegion = "pending"
+
+// Events of an endpoint whose Describe calls are backed off by the polling circuit breaker, or no longer are
+const (
+	DegradedEvent  = "degraded"
+	RecoveredEvent = "recovered"
+)
+
+// maxEvents is how many of the most recent events are kept
+const maxEvents = 100
+
+// State is the state of a build as served by the progress endpoint. Degraded lists the endpoints which are
+// currently backed off.
+type State struct {
+	State     string     `json:"state"`
+	StartedAt time.Time  `json:"started_at"`
+	Stemcells []Stemcell `json:"stemcells"`
+	Degraded  []string   `json:"degraded"`
+	Events    []Event    `json:"events"`
+}
+
+// Stemcell holds the state of each region of a stemcell, by region name
+type Stemcell struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Regions map[string]string `json:"regions"`
+}
+
+// Event is a progress event of a region publish, or the transition of an endpoint, which has no stemcell or region
+type Event struct {
+	Time     time.Time `json:"time"`
+	Stemcell string    `json:"stemcell,omitempty"`
+	Region   string    `json:"region,omitempty"`
+	Endpoint string    `json:"endpoint,omitempty"`
+	Event    string    `json:"event"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Tracker keeps the state of a running build from the progress events of its publishes
+type Tracker struct {
+	mutex sync.Mutex
+	state State
+}
+
+// NewTracker creates a Tracker for a build of stemcellCount stemcells, started now
+func NewTracker(stemcellCount int) *Tracker {
+	return &Tracker{state: State{
+		State:     PublishingState,
+		StartedAt: time.Now().UTC(),
+		Stemcells: make([]Stemcell, stemcellCount),
+		Degraded:  []string{},
+		Events:    []Event{},
+	}}
+}
+
+// Begin records the i-th stemcell, with the regions it has left to publish as pending and those published by an
+// earlier run as published
+func (t *Tracker) Begin(i int, name string, version string, pending []string, published []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stemcell := Stemcell{Name: name, Version: version, Regions: map[string]string{}}
+	for _, region := range pending {
+		stemcell.Regions[region] = PendingRegion
+	}
+	for _, region := range published {
+		stemcell.Regions[region] = builder.PublishedEvent
+	}
+	t.state.Stemcells[i] = stemcell
+}
+
+// Record updates the region of the i-th stemcell with a progress event, keeping it among the recent events
+func (t *Tracker) Record(i int, progress builder.Progress) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stemcell := &t.state.Stemcells[i]
+	if stemcell.Regions == nil {
+		stemcell.Regions = map[string]string{}
+	}
+	stemcell.Regions[progress.Region] = progress.Event
+
+	event := Event{
+		Time:     time.Now().UTC(),
+		Stemcell: fmt.Sprintf("%s %s", stemcell.Name, stemcell.Version),
+		Region:   progress.Region,
+		Event:    progress.Event,
+	}
+	if progress.Err != nil {
+		event.Error = progress.Err.Error()
+	}
+	t.addEvent(event)
+}
+
+// RecordTransition updates the degraded endpoints with a transition of the polling circuit breaker, keeping it
+// among the recent events
+func (t *Tracker) RecordTransition(transition breaker.Transition) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	degraded := []string{}
+	for _, endpoint := range t.state.Degraded {
+		if endpoint != transition.Endpoint {
+			degraded = append(degraded, endpoint)
+		}
+	}
+
+	event := Event{Time: time.Now().UTC(), Endpoint: transition.Endpoint, Event: RecoveredEvent}
+	if transition.Degraded {
+		degraded = append(degraded, transition.Endpoint)
+		event.Event = DegradedEvent
+	}
+	t.state.Degraded = degraded
+	t.addEvent(event)
+}
+
+// addEvent must be called with the mutex held
+func (t *Tracker) addEvent(event Event) {
+	t.state.Events = append(t.state.Events, event)
+	if len(t.state.Events) > maxEvents {
+		t.state.Events = t.state.Events[len(t.state.Events)-maxEvents:]
+	}
+}
+
+// Finish records the build as succeeded or failed once every publish has finished
+func (t *Tracker) Finish(succeeded bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.state.State = FailedState
+	if succeeded {
+		t.state.State = SucceededState
+	}
+}
+
+// State returns a copy of the current state of the build
+func (t *Tracker) State() State {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state
+	state.Stemcells = make([]Stemcell, len(t.state.Stemcells))
+	for i, stemcell := range t.state.Stemcells {
+		regions := map[string]string{}
+		for region, regionState := range stemcell.Regions {
+			regions[region] = regionState
+		}
+		stemcell.Regions = regions
+		state.Stemcells[i] = stemcell
+	}
+	state.Degraded = append([]string{}, t.state.Degraded...)
+	state.Events = append([]Event{}, t.state.Events...)
+	return state
+}
+
+// ServeHTTP responds to any GET request with the current state of the build as JSON
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	output, err := json.MarshalIndent(t.State(), "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("encoding build state: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+}
+
+// Serve serves the tracker on port of the loopback interface in the background, returning once it is listening
+func Serve(t *Tracker, port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("listening for progress requests: %s", err)
+	}
+
+	go http.Serve(listener, t)
+	return nil
+}
@@ -0,0 +1,88 @@
+package status_test
+
+import (
+	"encoding/json"
+	"errors"
+	"light-stemcell-builder/breaker"
+	"light-stemcell-builder/builder"
+	"light-stemcell-builder/status"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracker", func() {
+	var tracker *status.Tracker
+
+	BeforeEach(func() {
+		tracker = status.NewTracker(1)
+		tracker.Begin(0, "bosh-aws-xen-hvm-ubuntu-trusty-go_agent", "3312.1", []string{"us-east-1", "eu-west-1"}, []string{"ap-south-1"})
+	})
+
+	It("keeps the state of each region of each stemcell along with their events", func() {
+		tracker.Record(0, builder.Progress{Region: "us-east-1", Event: builder.StartedEvent})
+		tracker.Record(0, builder.Progress{Region: "us-east-1", Event: builder.PublishedEvent})
+		tracker.Record(0, builder.Progress{Region: "eu-west-1", Event: builder.FailedEvent, Err: errors.New("import failed")})
+		tracker.Finish(false)
+
+		state := tracker.State()
+		Expect(state.State).To(Equal(status.FailedState))
+		Expect(state.Stemcells).To(HaveLen(1))
+		Expect(state.Stemcells[0].Regions).To(Equal(map[string]string{
+			"us-east-1":  builder.PublishedEvent,
+			"eu-west-1":  builder.FailedEvent,
+			"ap-south-1": builder.PublishedEvent,
+		}))
+
+		Expect(state.Events).To(HaveLen(3))
+		Expect(state.Events[2].Stemcell).To(Equal("bosh-aws-xen-hvm-ubuntu-trusty-go_agent 3312.1"))
+		Expect(state.Events[2].Region).To(Equal("eu-west-1"))
+		Expect(state.Events[2].Error).To(Equal("import failed"))
+	})
+
+	It("keeps the degraded endpoints along with their transitions", func() {
+		tracker.RecordTransition(breaker.Transition{Endpoint: "ec2.us-east-1.amazonaws.com", Degraded: true})
+		tracker.RecordTransition(breaker.Transition{Endpoint: "ec2.eu-west-1.amazonaws.com", Degraded: true})
+		tracker.RecordTransition(breaker.Transition{Endpoint: "ec2.us-east-1.amazonaws.com"})
+
+		state := tracker.State()
+		Expect(state.Degraded).To(Equal([]string{"ec2.eu-west-1.amazonaws.com"}))
+		Expect(state.Events).To(HaveLen(3))
+		Expect(state.Events[0].Event).To(Equal(status.DegradedEvent))
+		Expect(state.Events[2].Endpoint).To(Equal("ec2.us-east-1.amazonaws.com"))
+		Expect(state.Events[2].Event).To(Equal(status.RecoveredEvent))
+		Expect(state.Events[2].Stemcell).To(BeEmpty())
+	})
+
+	It("keeps only the most recent events", func() {
+		for i := 0; i < 150; i++ {
+			tracker.Record(0, builder.Progress{Region: "us-east-1", Event: builder.StartedEvent})
+		}
+
+		Expect(tracker.State().Events).To(HaveLen(100))
+	})
+
+	It("serves the state of the build as JSON", func() {
+		tracker.Record(0, builder.Progress{Region: "us-east-1", Event: builder.StartedEvent})
+
+		recorder := httptest.NewRecorder()
+		tracker.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var state status.State
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &state)).To(Succeed())
+		Expect(state.State).To(Equal(status.PublishingState))
+		Expect(state.Stemcells[0].Regions["us-east-1"]).To(Equal(builder.StartedEvent))
+		Expect(state.Stemcells[0].Regions["eu-west-1"]).To(Equal(status.PendingRegion))
+		Expect(state.Events).To(HaveLen(1))
+	})
+
+	It("refuses requests other than GET", func() {
+		recorder := httptest.NewRecorder()
+		tracker.ServeHTTP(recorder, httptest.NewRequest("POST", "/", nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
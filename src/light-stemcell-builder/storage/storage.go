@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/dualstack"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const s3Scheme = "s3://"
+
+// Storage persists the files the builder produces, such as publish reports, under a key
+type Storage interface {
+	Put(key string, content []byte) error
+}
+
+// LocalStorage writes each key as a path on the local filesystem
+type LocalStorage struct{}
+
+// Put writes content to the file at key, replacing any existing file
+func (LocalStorage) Put(key string, content []byte) error {
+	err := ioutil.WriteFile(key, content, 0644)
+	if err != nil {
+		return fmt.Errorf("writing %s: %s", key, err)
+	}
+	return nil
+}
+
+// S3Storage writes each key as an object in a bucket
+type S3Storage struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// NewS3Storage creates an S3Storage for bucket using the region and credentials in creds
+func NewS3Storage(creds config.Credentials, bucket string) *S3Storage {
+	awsConfig := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, "")).
+		WithRegion(creds.Region)
+
+	return NewS3StorageWithClient(s3.New(session.New(awsConfig), dualstack.Config("s3", creds)), bucket)
+}
+
+// NewS3StorageWithClient creates an S3Storage for bucket using the provided client
+func NewS3StorageWithClient(client s3iface.S3API, bucket string) *S3Storage {
+	return &S3Storage{client: client, bucket: bucket}
+}
+
+// Put uploads content to the object at key, replacing any existing object
+func (s *S3Storage) Put(key string, content []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s to bucket %s: %s", key, s.bucket, err)
+	}
+	return nil
+}
+
+// HTTPStorage uploads each key with a PUT request under the URL of an artifact repository
+type HTTPStorage struct {
+	client     *http.Client
+	repository config.ArtifactRepository
+}
+
+// NewHTTPStorage creates an HTTPStorage for the repository using the default HTTP client
+func NewHTTPStorage(repository config.ArtifactRepository) *HTTPStorage {
+	return NewHTTPStorageWithClient(http.DefaultClient, repository)
+}
+
+// NewHTTPStorageWithClient creates an HTTPStorage for the repository using the provided client
+func NewHTTPStorageWithClient(client *http.Client, repository config.ArtifactRepository) *HTTPStorage {
+	return &HTTPStorage{client: client, repository: repository}
+}
+
+// Put uploads content to key under the URL of the repository, replacing any existing artifact
+func (s *HTTPStorage) Put(key string, content []byte) error {
+	artifactURL := strings.TrimSuffix(s.repository.URL, "/") + "/" + key
+	req, err := http.NewRequest("PUT", artifactURL, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("uploading %s: %s", artifactURL, err)
+	}
+
+	switch {
+	case s.repository.Username != "":
+		req.SetBasicAuth(s.repository.Username, s.repository.Password)
+	case s.repository.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+s.repository.BearerToken)
+	}
+	for name, value := range s.repository.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %s", artifactURL, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("uploading %s: unexpected status %s", artifactURL, resp.Status)
+	}
+	return nil
+}
+
+// ForLocation returns the Storage and key for an s3://bucket/key URL or a local path. S3 buckets are
+// written with the credentials of the ami_regions entry which uses the same bucket_name.
+func ForLocation(location string, amiRegions []config.AmiRegion) (Storage, string, error) {
+	if !strings.HasPrefix(location, s3Scheme) {
+		return LocalStorage{}, location, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(location, s3Scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", fmt.Errorf("%s must be of the form s3://bucket/key", location)
+	}
+	bucket, key := parts[0], parts[1]
+
+	for _, regionConfig := range amiRegions {
+		if regionConfig.BucketName == bucket {
+			return NewS3Storage(regionConfig.Credentials, bucket), key, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("no ami_regions entry has bucket_name %s to provide credentials for %s", bucket, location)
+}
@@ -0,0 +1,139 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"light-stemcell-builder/config"
+	"light-stemcell-builder/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeS3 struct {
+	s3iface.S3API
+	input   *s3.PutObjectInput
+	content []byte
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	f.input = input
+	f.content, _ = ioutil.ReadAll(input.Body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+var _ = Describe("Storage", func() {
+	amiRegions := []config.AmiRegion{
+		{RegionName: "us-east-1", BucketName: "some-bucket"},
+	}
+
+	It("writes local paths to the filesystem", func() {
+		dir, err := ioutil.TempDir("", "storage")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "report.json")
+		s, key, err := storage.ForLocation(path, amiRegions)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s).To(BeAssignableToTypeOf(storage.LocalStorage{}))
+		Expect(key).To(Equal(path))
+
+		err = s.Put(key, []byte("some report"))
+		Expect(err).ToNot(HaveOccurred())
+
+		content, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("some report"))
+	})
+
+	It("writes s3 URLs to the bucket of the matching region", func() {
+		s, key, err := storage.ForLocation("s3://some-bucket/reports/report.json", amiRegions)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s).To(BeAssignableToTypeOf(&storage.S3Storage{}))
+		Expect(key).To(Equal("reports/report.json"))
+	})
+
+	It("uploads the content to the bucket", func() {
+		client := &fakeS3{}
+		s := storage.NewS3StorageWithClient(client, "some-bucket")
+
+		err := s.Put("reports/report.json", []byte("some report"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aws.StringValue(client.input.Bucket)).To(Equal("some-bucket"))
+		Expect(aws.StringValue(client.input.Key)).To(Equal("reports/report.json"))
+		Expect(string(client.content)).To(Equal("some report"))
+	})
+
+	It("returns an error when no region uses the bucket", func() {
+		_, _, err := storage.ForLocation("s3://other-bucket/report.json", amiRegions)
+		Expect(err).To(MatchError("no ami_regions entry has bucket_name other-bucket to provide credentials for s3://other-bucket/report.json"))
+	})
+
+	Context("given an artifact repository", func() {
+		var server *httptest.Server
+		var requests []*http.Request
+		var bodies []string
+		var status int
+
+		BeforeEach(func() {
+			requests, bodies, status = nil, nil, http.StatusCreated
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				requests = append(requests, r)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(status)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("puts the content under the repository URL with its authentication headers", func() {
+			s := storage.NewHTTPStorage(config.ArtifactRepository{
+				URL:      server.URL + "/stemcells/",
+				Username: "some-user",
+				Password: "some-password",
+				Headers:  map[string]string{"X-JFrog-Art-Api": "some-api-key"},
+			})
+
+			err := s.Put("light-stemcell.tgz", []byte("some tarball"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Method).To(Equal("PUT"))
+			Expect(requests[0].URL.Path).To(Equal("/stemcells/light-stemcell.tgz"))
+			Expect(requests[0].Header.Get("Authorization")).To(Equal("Basic c29tZS11c2VyOnNvbWUtcGFzc3dvcmQ="))
+			Expect(requests[0].Header.Get("X-JFrog-Art-Api")).To(Equal("some-api-key"))
+			Expect(bodies[0]).To(Equal("some tarball"))
+		})
+
+		It("authenticates with a bearer token", func() {
+			s := storage.NewHTTPStorage(config.ArtifactRepository{URL: server.URL, BearerToken: "some-token"})
+
+			err := s.Put("report.json", []byte("some report"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(requests[0].URL.Path).To(Equal("/report.json"))
+			Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer some-token"))
+		})
+
+		It("returns an error when the repository does not accept the artifact", func() {
+			status = http.StatusForbidden
+			s := storage.NewHTTPStorage(config.ArtifactRepository{URL: server.URL})
+
+			err := s.Put("report.json", []byte("some report"))
+			Expect(err).To(MatchError("uploading " + server.URL + "/report.json: unexpected status 403 Forbidden"))
+		})
+	})
+
+	It("returns an error for s3 URLs without a key", func() {
+		_, _, err := storage.ForLocation("s3://some-bucket", amiRegions)
+		Expect(err).To(MatchError("s3://some-bucket must be of the form s3://bucket/key"))
+	})
+})